@@ -207,13 +207,14 @@ func NewAgent() (*Agent, error) {
 	tr.AddTraversalExtension(ge.NewMetricsTraversalExtension())
 	tr.AddTraversalExtension(ge.NewSocketsTraversalExtension())
 	tr.AddTraversalExtension(ge.NewDescendantsTraversalExtension())
+	tr.EnableCache(config.GetInt("agent.gremlin.cache_size"))
 
 	rootNode, err := createRootNode(g)
 	if err != nil {
 		return nil, err
 	}
 
-	api.RegisterTopologyAPI(hserver, g, tr, apiAuthBackend)
+	api.RegisterTopologyAPI(hserver, g, tr, nil, apiAuthBackend)
 
 	clusterAuthOptions := &shttp.AuthenticationOpts{
 		Username: config.GetString("agent.auth.cluster.username"),
@@ -240,6 +241,8 @@ func NewAgent() (*Agent, error) {
 
 	flowTableAllocator := flow.NewTableAllocator(updateTime, expireTime)
 
+	api.RegisterPcapNgAPI(hserver, g, flowTableAllocator, apiAuthBackend)
+
 	// exposes a flow server through the client connections
 	flow.NewWSTableServer(flowTableAllocator, analyzerClientPool)
 
@@ -249,7 +252,7 @@ func NewAgent() (*Agent, error) {
 
 	flowProbeBundle := fprobes.NewFlowProbeBundle(topologyProbeBundle, g, flowTableAllocator, flowClientPool)
 
-	onDemandProbeServer, err := ondemand.NewOnDemandProbeServer(flowProbeBundle, g, analyzerClientPool)
+	onDemandProbeServer, err := ondemand.NewOnDemandProbeServer(flowProbeBundle, g, flowTableAllocator, analyzerClientPool)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to initialize on-demand flow probe %s", err)
 	}
@@ -272,5 +275,13 @@ func NewAgent() (*Agent, error) {
 
 	api.RegisterStatusAPI(hserver, agent, apiAuthBackend)
 
+	wsReporters := map[string]api.WSStatusReporter{
+		"analyzers":   analyzerClientPool,
+		"subscribers": wsServer,
+	}
+	if err := api.RegisterMetricsAPI(hserver, apiAuthBackend, api.NewGraphCollector(g), api.NewFlowTableCollector(flowTableAllocator), api.NewWebSocketCollector(wsReporters)); err != nil {
+		return nil, err
+	}
+
 	return agent, nil
 }
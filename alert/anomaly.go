@@ -0,0 +1,259 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package alert
+
+import (
+	"math"
+	"sort"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+)
+
+const (
+	// weight of a new sample in the moving average of the baselines
+	baselineSmoothing = 0.1
+)
+
+// baseline tracks the exponentially weighted moving mean and variance of a metric
+type baseline struct {
+	mean     float64
+	variance float64
+	samples  int64
+}
+
+func (b *baseline) update(value float64) {
+	if b.samples == 0 {
+		b.mean = value
+	} else {
+		diff := value - b.mean
+		incr := baselineSmoothing * diff
+		b.mean += incr
+		b.variance = (1 - baselineSmoothing) * (b.variance + diff*incr)
+	}
+	b.samples++
+}
+
+// deviation returns by how many standard deviations the value exceeds the
+// baseline, 0 if the baseline is not learnt yet or if the value is within it
+func (b *baseline) deviation(value float64, threshold float64, minSamples int64) float64 {
+	if b.samples < minSamples || value <= b.mean {
+		return 0
+	}
+
+	stddev := math.Sqrt(b.variance)
+	if value <= b.mean+threshold*stddev {
+		return 0
+	}
+
+	if stddev == 0 {
+		return math.Inf(1)
+	}
+	return (value - b.mean) / stddev
+}
+
+// nodeBaselines holds the baselines learnt for the flows of a node
+type nodeBaselines struct {
+	rstRatio     baseline
+	segmentRatio baseline
+	rtt          baseline
+}
+
+// flowState remembers what was already learnt from a flow
+type flowState struct {
+	last int64
+	rtt  bool
+}
+
+// Anomaly describes a node or some flows of a node going outside of the
+// learnt baseline of a TCP metric
+type Anomaly struct {
+	NodeTID   string
+	Metric    string
+	Value     float64
+	Mean      float64
+	Deviation float64 `json:",omitempty"`
+	Flows     []*flow.Flow
+}
+
+// AnomalyDetector learns per node baselines of the RST rate, the out of
+// order/skipped segments ratio and the RTT of TCP flows and reports the
+// flows and nodes outside of them
+type AnomalyDetector struct {
+	threshold  float64
+	minSamples int64
+	nodes      map[string]*nodeBaselines
+	flows      map[string]*flowState
+}
+
+func flowSegmentRatio(f *flow.Flow) (float64, bool) {
+	if f.Metric == nil {
+		return 0, false
+	}
+
+	packets := f.Metric.ABPackets + f.Metric.BAPackets
+	if packets == 0 {
+		return 0, false
+	}
+
+	tm := f.TCPMetric
+	segments := tm.ABSegmentOutOfOrder + tm.ABSegmentSkipped + tm.BASegmentOutOfOrder + tm.BASegmentSkipped
+	return float64(segments) / float64(packets), true
+}
+
+func flowReset(f *flow.Flow) bool {
+	return f.TCPMetric.ABRstStart != 0 || f.TCPMetric.BARstStart != 0
+}
+
+func newAnomaly(nodeTID, metric string, value float64, b *baseline, deviation float64, flows []*flow.Flow) *Anomaly {
+	a := &Anomaly{
+		NodeTID: nodeTID,
+		Metric:  metric,
+		Value:   value,
+		Mean:    b.mean,
+		Flows:   flows,
+	}
+
+	// an infinite deviation can't be marshaled in JSON
+	if !math.IsInf(deviation, 0) {
+		a.Deviation = deviation
+	}
+
+	return a
+}
+
+// Detect learns from the TCP flows updated since the previous call and
+// returns the anomalies found, the flows outside of the baselines are not
+// learnt
+func (d *AnomalyDetector) Detect(flows []*flow.Flow) (anomalies []*Anomaly) {
+	updated := make(map[string][]*flow.Flow)
+	states := make(map[string]*flowState, len(flows))
+	for _, f := range flows {
+		if f.TCPMetric == nil {
+			continue
+		}
+
+		state, found := d.flows[f.UUID]
+		if !found {
+			state = &flowState{}
+		}
+		states[f.UUID] = state
+
+		if state.last < f.Last {
+			updated[f.NodeTID] = append(updated[f.NodeTID], f)
+		}
+	}
+	// forget the expired flows
+	d.flows = states
+
+	nodeTIDs := make([]string, 0, len(updated))
+	for nodeTID := range updated {
+		nodeTIDs = append(nodeTIDs, nodeTID)
+	}
+	sort.Strings(nodeTIDs)
+
+	for _, nodeTID := range nodeTIDs {
+		nb, found := d.nodes[nodeTID]
+		if !found {
+			nb = &nodeBaselines{}
+			d.nodes[nodeTID] = nb
+		}
+
+		var segments, rtts, resets []*flow.Flow
+		var maxSegmentRatio, maxRTT float64
+		var segmentDeviation, rttDeviation float64
+
+		for _, f := range updated[nodeTID] {
+			state := d.flows[f.UUID]
+
+			if ratio, ok := flowSegmentRatio(f); ok {
+				if deviation := nb.segmentRatio.deviation(ratio, d.threshold, d.minSamples); deviation > 0 {
+					segments = append(segments, f)
+					if ratio > maxSegmentRatio {
+						maxSegmentRatio, segmentDeviation = ratio, deviation
+					}
+				} else {
+					nb.segmentRatio.update(ratio)
+				}
+			}
+
+			// the RTT of a flow is only computed once
+			if f.RTT > 0 && !state.rtt {
+				rtt := float64(f.RTT)
+				if deviation := nb.rtt.deviation(rtt, d.threshold, d.minSamples); deviation > 0 {
+					rtts = append(rtts, f)
+					if rtt > maxRTT {
+						maxRTT, rttDeviation = rtt, deviation
+					}
+				} else {
+					nb.rtt.update(rtt)
+				}
+				state.rtt = true
+			}
+
+			if flowReset(f) {
+				resets = append(resets, f)
+			}
+
+			state.last = f.Last
+		}
+
+		if len(segments) > 0 {
+			anomalies = append(anomalies, newAnomaly(nodeTID, "SegmentRatio", maxSegmentRatio, &nb.segmentRatio, segmentDeviation, segments))
+		}
+
+		if len(rtts) > 0 {
+			anomalies = append(anomalies, newAnomaly(nodeTID, "RTT", maxRTT, &nb.rtt, rttDeviation, rtts))
+		}
+
+		// the RST rate is the ratio of the flows updated since the last
+		// evaluation that were reset
+		ratio := float64(len(resets)) / float64(len(updated[nodeTID]))
+		if deviation := nb.rstRatio.deviation(ratio, d.threshold, d.minSamples); deviation > 0 {
+			anomalies = append(anomalies, newAnomaly(nodeTID, "RstRatio", ratio, &nb.rstRatio, deviation, resets))
+		} else {
+			nb.rstRatio.update(ratio)
+		}
+	}
+
+	return
+}
+
+// NewAnomalyDetector returns a new detector reporting the values exceeding
+// the baselines by threshold standard deviations, once learnt from minSamples
+func NewAnomalyDetector(threshold float64, minSamples int64) *AnomalyDetector {
+	return &AnomalyDetector{
+		threshold:  threshold,
+		minSamples: minSamples,
+		nodes:      make(map[string]*nodeBaselines),
+		flows:      make(map[string]*flowState),
+	}
+}
+
+// NewAnomalyDetectorFromConfig returns a new detector using the thresholds of
+// the configuration
+func NewAnomalyDetectorFromConfig() *AnomalyDetector {
+	threshold := config.GetConfig().GetFloat64("analyzer.alert.anomaly.threshold")
+	minSamples := config.GetInt("analyzer.alert.anomaly.min_samples")
+	return NewAnomalyDetector(threshold, int64(minSamples))
+}
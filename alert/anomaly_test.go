@@ -0,0 +1,108 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package alert
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/skydive-project/skydive/flow"
+)
+
+func newTCPFlow(id int, nodeTID string, last int64, rtt int64) *flow.Flow {
+	f := flow.NewFlow()
+	f.UUID = strconv.Itoa(id)
+	f.NodeTID = nodeTID
+	f.Last = last
+	f.RTT = rtt
+	f.Metric.ABPackets = 100
+	f.TCPMetric = &flow.TCPMetric{}
+	return f
+}
+
+func TestAnomalyDetectorRTT(t *testing.T) {
+	d := NewAnomalyDetector(3, 10)
+
+	var flows []*flow.Flow
+	for i := 0; i < 20; i++ {
+		flows = append(flows, newTCPFlow(i, "node1", 1, int64(1000000+i%2*100000)))
+		if anomalies := d.Detect(flows); len(anomalies) != 0 {
+			t.Fatalf("No anomaly expected while learning, got: %+v", anomalies[0])
+		}
+	}
+
+	slow := newTCPFlow(100, "node1", 1, 50000000)
+	flows = append(flows, slow)
+
+	anomalies := d.Detect(flows)
+	if len(anomalies) != 1 {
+		t.Fatalf("One anomaly expected, got: %d", len(anomalies))
+	}
+
+	if a := anomalies[0]; a.Metric != "RTT" || a.NodeTID != "node1" || len(a.Flows) != 1 || a.Flows[0] != slow {
+		t.Fatalf("Wrong anomaly reported: %+v", a)
+	}
+
+	// the RTT of a flow is learnt only once
+	if anomalies := d.Detect(flows); len(anomalies) != 0 {
+		t.Fatalf("Anomaly reported twice: %+v", anomalies[0])
+	}
+
+	// baselines are per node
+	flows = append(flows, newTCPFlow(101, "node2", 1, 50000000))
+	if anomalies := d.Detect(flows); len(anomalies) != 0 {
+		t.Fatalf("No anomaly expected for another node, got: %+v", anomalies[0])
+	}
+}
+
+func TestAnomalyDetectorSegmentsAndResets(t *testing.T) {
+	d := NewAnomalyDetector(3, 10)
+
+	var flows []*flow.Flow
+	for i := 0; i < 20; i++ {
+		f := newTCPFlow(i, "node1", int64(i+1), 0)
+		f.TCPMetric.ABSegmentOutOfOrder = int64(i % 2)
+		flows = append(flows, f)
+
+		if anomalies := d.Detect(flows); len(anomalies) != 0 {
+			t.Fatalf("No anomaly expected while learning, got: %+v", anomalies[0])
+		}
+	}
+
+	lossy := newTCPFlow(100, "node1", 100, 0)
+	lossy.TCPMetric.ABSegmentSkipped = 30
+	lossy.TCPMetric.BARstStart = 100
+
+	anomalies := d.Detect(append(flows, lossy))
+	if len(anomalies) != 2 {
+		t.Fatalf("Two anomalies expected, got: %d", len(anomalies))
+	}
+
+	if a := anomalies[0]; a.Metric != "SegmentRatio" || len(a.Flows) != 1 || a.Flows[0] != lossy {
+		t.Fatalf("Wrong segment ratio anomaly reported: %+v", a)
+	}
+
+	if a := anomalies[1]; a.Metric != "RstRatio" || a.Value != 1 || len(a.Flows) != 1 || a.Flows[0] != lossy {
+		t.Fatalf("Wrong RST ratio anomaly reported: %+v", a)
+	}
+}
@@ -36,7 +36,9 @@ import (
 	api "github.com/skydive-project/skydive/api/server"
 	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/etcd"
+	"github.com/skydive-project/skydive/flow"
 	"github.com/skydive-project/skydive/js"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/topology/graph"
@@ -64,9 +66,36 @@ type GremlinAlert struct {
 	data              string
 	traversalSequence *traversal.GremlinTraversalSequence
 	gremlinParser     *traversal.GremlinTraversalParser
+	detector          *AnomalyDetector
+}
+
+// detectAnomalies feeds the anomaly detector with the flows returned by the
+// Gremlin expression and returns the anomalies found
+func (ga *GremlinAlert) detectAnomalies(lockGraph bool) (interface{}, error) {
+	result, err := ga.traversalSequence.Exec(ga.graph, lockGraph)
+	if err != nil {
+		return nil, err
+	}
+
+	var flows []*flow.Flow
+	for _, value := range result.Values() {
+		if f, ok := value.(*flow.Flow); ok {
+			flows = append(flows, f)
+		}
+	}
+
+	if anomalies := ga.detector.Detect(flows); len(anomalies) > 0 {
+		return anomalies, nil
+	}
+
+	return nil, nil
 }
 
 func (ga *GremlinAlert) evaluate(server *api.Server, vm *js.Runtime, lockGraph bool) (interface{}, error) {
+	if ga.detector != nil {
+		return ga.detectAnomalies(lockGraph)
+	}
+
 	// If the alert is a simple Gremlin query, avoid
 	// converting to JavaScript
 	if ga.traversalSequence != nil {
@@ -167,7 +196,13 @@ func (ga *GremlinAlert) trigger(payload []byte) error {
 
 // NewGremlinAlert returns a new gremlin based alert
 func NewGremlinAlert(alert *types.Alert, g *graph.Graph, p *traversal.GremlinTraversalParser) (*GremlinAlert, error) {
-	ts, _ := p.Parse(strings.NewReader(alert.Expression))
+	var ts *traversal.GremlinTraversalSequence
+	if traversal.IsQuery(alert.Expression) {
+		var err error
+		if ts, err = p.ParseQuery(alert.Expression, alert.GremlinBindings); err != nil {
+			return nil, fmt.Errorf("Invalid Gremlin expression '%s': %s", alert.Expression, err)
+		}
+	}
 
 	ga := &GremlinAlert{
 		Alert:             alert,
@@ -176,6 +211,13 @@ func NewGremlinAlert(alert *types.Alert, g *graph.Graph, p *traversal.GremlinTra
 		graph:             g,
 	}
 
+	if trigger, _ := parseTrigger(alert.Trigger); trigger == "anomaly" {
+		if ts == nil {
+			return nil, fmt.Errorf("Anomaly alert expression has to be a Gremlin flow query: %s", alert.Expression)
+		}
+		ga.detector = NewAnomalyDetectorFromConfig()
+	}
+
 	if strings.HasPrefix(alert.Action, "http://") || strings.HasPrefix(alert.Action, "https://") {
 		ga.kind = actionWebHook
 		ga.data = alert.Action
@@ -317,7 +359,66 @@ func parseTrigger(trigger string) (string, string) {
 	return splits[0], ""
 }
 
+// startAlertTimer evaluates periodically the alert until it gets unregistered
+func (a *Server) startAlertTimer(alert *GremlinAlert, duration time.Duration) {
+	done := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(duration)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := a.evaluateAlert(alert, true); err != nil {
+					logging.GetLogger().Warning(err.Error())
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	a.Lock()
+	a.alertTimers[alert.UUID] = done
+	a.Unlock()
+}
+
+// alertInterval returns the interval at which an alert is evaluated, 0 for
+// the alerts evaluated on graph events
+func alertInterval(trigger string) (time.Duration, error) {
+	var interval time.Duration
+
+	kind, data := parseTrigger(trigger)
+	switch kind {
+	case "anomaly":
+		interval = time.Duration(config.GetInt("analyzer.alert.anomaly.interval")) * time.Second
+		if data != "" {
+			var err error
+			if interval, err = time.ParseDuration(data); err != nil {
+				return 0, err
+			}
+		}
+	case "duration":
+		var err error
+		if interval, err = time.ParseDuration(data); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, nil
+	}
+
+	if interval <= 0 {
+		return 0, fmt.Errorf("Interval of %s alerts has to be positive: %s", kind, interval)
+	}
+
+	return interval, nil
+}
+
 func (a *Server) registerAlert(apiAlert *types.Alert) error {
+	interval, err := alertInterval(apiAlert.Trigger)
+	if err != nil {
+		return err
+	}
+
 	alert, err := NewGremlinAlert(apiAlert, a.Graph, a.gremlinParser)
 	if err != nil {
 		return err
@@ -327,36 +428,9 @@ func (a *Server) registerAlert(apiAlert *types.Alert) error {
 
 	a.evaluateAlert(alert, true)
 
-	trigger, data := parseTrigger(apiAlert.Trigger)
-	switch trigger {
-	case "duration":
-		duration, err := time.ParseDuration(data)
-		if err != nil {
-			return err
-		}
-
-		done := make(chan bool)
-		go func() {
-			ticker := time.NewTicker(duration)
-			defer ticker.Stop()
-
-			for {
-				select {
-				case <-ticker.C:
-					if err := a.evaluateAlert(alert, true); err != nil {
-						logging.GetLogger().Warning(err.Error())
-					}
-				case <-done:
-					return
-				}
-			}
-		}()
-		a.Lock()
-		a.alertTimers[apiAlert.UUID] = done
-		a.Unlock()
-	case "graph":
-		fallthrough
-	default:
+	if interval > 0 {
+		a.startAlertTimer(alert, interval)
+	} else {
 		a.Lock()
 		a.graphAlerts[apiAlert.UUID] = alert
 		a.Unlock()
@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+func TestAlertInterval(t *testing.T) {
+	for trigger, expected := range map[string]time.Duration{
+		"":             0,
+		"graph":        0,
+		"duration:10s": 10 * time.Second,
+		"anomaly:1m":   time.Minute,
+		"anomaly":      30 * time.Second,
+	} {
+		interval, err := alertInterval(trigger)
+		if err != nil {
+			t.Errorf("%s: should not return an error: %s", trigger, err)
+		} else if interval != expected {
+			t.Errorf("%s: expected interval %s, got %s", trigger, expected, interval)
+		}
+	}
+
+	for _, trigger := range []string{"duration:0s", "duration:-1s", "anomaly:0s", "duration:abc"} {
+		if _, err := alertInterval(trigger); err == nil {
+			t.Errorf("%s: should return an error", trigger)
+		}
+	}
+
+	config.GetConfig().Set("analyzer.alert.anomaly.interval", 0)
+	defer config.GetConfig().Set("analyzer.alert.anomaly.interval", 30)
+
+	if _, err := alertInterval("anomaly"); err == nil {
+		t.Error("Should return an error as the configured interval is 0")
+	}
+}
+
+func TestGremlinAlertExpression(t *testing.T) {
+	p := traversal.NewGremlinTraversalParser()
+
+	alert := &types.Alert{
+		Expression:      "G.V().Has('Name', $name)",
+		GremlinBindings: map[string]interface{}{"name": "eth0"},
+	}
+	ga, err := NewGremlinAlert(alert, nil, p)
+	if err != nil {
+		t.Fatalf("Should not return an error: %s", err)
+	}
+	if ga.traversalSequence == nil {
+		t.Error("Gremlin expression should be parsed")
+	}
+
+	// a variable without value is an error, not a JavaScript expression
+	alert.GremlinBindings = nil
+	if _, err = NewGremlinAlert(alert, nil, p); err == nil {
+		t.Error("Should return an error")
+	}
+
+	alert.Expression = "Gremlin(\"G.V()\").length > 0"
+	if ga, err = NewGremlinAlert(alert, nil, p); err != nil || ga.traversalSequence != nil {
+		t.Errorf("JavaScript expression should be kept, got %+v, %v", ga, err)
+	}
+}
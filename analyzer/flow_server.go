@@ -32,11 +32,15 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/sink"
 	"github.com/skydive-project/skydive/flow/storage"
 	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/ipfix"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/probe"
 	"github.com/skydive-project/skydive/topology/graph"
@@ -54,6 +58,14 @@ const (
 	FlowBulkMaxDelayDefault int = 5
 )
 
+// StorageBulkDuration measures the time spent storing a bulk of flows
+var StorageBulkDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "skydive",
+	Subsystem: "storage",
+	Name:      "bulk_duration_seconds",
+	Help:      "Time spent storing a bulk of flows",
+})
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -88,6 +100,7 @@ type FlowServerWebSocketConn struct {
 // FlowServer describes a flow server
 type FlowServer struct {
 	storage            storage.Storage
+	sinks              []sink.Sink
 	conn               FlowServerConn
 	state              int64
 	wgServer           sync.WaitGroup
@@ -201,11 +214,25 @@ func NewFlowServerUDPConn(addr string, port int) (*FlowServerUDPConn, error) {
 }
 
 func (s *FlowServer) storeFlows(flows []*flow.Flow) {
-	if s.storage != nil && len(flows) > 0 {
+	if len(flows) == 0 {
+		return
+	}
+
+	if s.storage != nil {
+		start := time.Now()
 		s.storage.StoreFlows(flows)
+		StorageBulkDuration.Observe(time.Since(start).Seconds())
 
 		logging.GetLogger().Debugf("%d flows stored", len(flows))
 	}
+
+	for _, fs := range s.sinks {
+		fs.ExportFlows(flows)
+	}
+
+	if len(s.sinks) > 0 {
+		logging.GetLogger().Debugf("%d flows exported to %d sinks", len(flows), len(s.sinks))
+	}
 }
 
 // Start the flow server
@@ -247,6 +274,10 @@ func (s *FlowServer) Stop() {
 		s.quit <- struct{}{}
 		s.quit <- struct{}{}
 		s.wgServer.Wait()
+
+		for _, fs := range s.sinks {
+			fs.Close()
+		}
 	}
 }
 
@@ -291,8 +322,27 @@ func NewFlowServer(s *shttp.Server, g *graph.Graph, store storage.Storage, probe
 		return nil, err
 	}
 
+	sinks, err := sink.NewSinksFromConfig(g)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := ipfix.NewExporterFromConfig()
+	if err != nil {
+		for _, fs := range sinks {
+			fs.Close()
+		}
+		return nil, err
+	}
+
+	// the IPFIX exporter is not a named sink as it has its own configuration
+	if exporter != nil {
+		sinks = append(sinks, exporter)
+	}
+
 	fs := &FlowServer{
 		storage: store,
+		sinks:   sinks,
 		conn:    conn,
 		quit:    make(chan struct{}, 2),
 		auth:    auth,
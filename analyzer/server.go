@@ -37,6 +37,7 @@ import (
 	"github.com/skydive-project/skydive/etcd"
 	"github.com/skydive-project/skydive/flow"
 	ondemand "github.com/skydive-project/skydive/flow/ondemand/client"
+	"github.com/skydive-project/skydive/flow/sink"
 	"github.com/skydive-project/skydive/flow/storage"
 	ge "github.com/skydive-project/skydive/gremlin/traversal"
 	shttp "github.com/skydive-project/skydive/http"
@@ -307,6 +308,7 @@ func NewServerFromConfig() (*Server, error) {
 	tr.AddTraversalExtension(ge.NewFlowTraversalExtension(tableClient, storage))
 	tr.AddTraversalExtension(ge.NewSocketsTraversalExtension())
 	tr.AddTraversalExtension(ge.NewDescendantsTraversalExtension())
+	tr.EnableCache(config.GetInt("analyzer.gremlin.cache_size"))
 
 	subscriberWSServer := ws.NewStructServer(config.NewWSServer(hserver, "/ws/subscriber", apiAuthBackend))
 	topology.NewSubscriberEndpoint(subscriberWSServer, g, tr)
@@ -382,11 +384,24 @@ func NewServerFromConfig() (*Server, error) {
 
 	s.createStartupCapture(captureAPIHandler)
 
-	api.RegisterTopologyAPI(hserver, g, tr, apiAuthBackend)
-	api.RegisterPcapAPI(hserver, storage, apiAuthBackend)
+	api.RegisterTopologyAPI(hserver, g, tr, nodeAPIHandler, apiAuthBackend)
+	api.RegisterPcapAPI(hserver, storage, tableClient, g, tr, apiAuthBackend)
+	api.RegisterAnalyzerPcapNgAPI(hserver, g, agentWSServer, apiAuthBackend)
 	api.RegisterConfigAPI(hserver, apiAuthBackend)
 	api.RegisterStatusAPI(hserver, s, apiAuthBackend)
 
+	wsReporters := map[string]api.WSStatusReporter{
+		"agents":         agentWSServer,
+		"publishers":     publisherWSServer,
+		"subscribers":    subscriberWSServer,
+		"incoming_peers": replicationWSServer,
+		"outgoing_peers": replicationEndpoint.out,
+	}
+	collectors := append(sink.Collectors(), api.NewGraphCollector(g), api.NewWebSocketCollector(wsReporters), StorageBulkDuration)
+	if err := api.RegisterMetricsAPI(hserver, apiAuthBackend, collectors...); err != nil {
+		return nil, err
+	}
+
 	if config.GetBool("analyzer.ssh_enabled") {
 		if err := dede.RegisterHandler("terminal", "/dede", hserver.Router); err != nil {
 			return nil, err
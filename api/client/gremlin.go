@@ -44,6 +44,16 @@ import (
 // GremlinQueryHelper describes a gremlin query request query helper mechanism
 type GremlinQueryHelper struct {
 	authOptions *shttp.AuthenticationOpts
+	bindings    map[string]interface{}
+}
+
+// WithBindings returns a helper sending the given values of the bind
+// variables, like $name, along with the queries
+func (g *GremlinQueryHelper) WithBindings(bindings map[string]interface{}) *GremlinQueryHelper {
+	return &GremlinQueryHelper{
+		authOptions: g.authOptions,
+		bindings:    bindings,
+	}
 }
 
 // Request send a Gremlin request to the topology API
@@ -53,7 +63,10 @@ func (g *GremlinQueryHelper) Request(query interface{}, header http.Header) (*ht
 		return nil, err
 	}
 
-	gq := types.TopologyParam{GremlinQuery: gremlin.NewQueryStringFromArgument(query).String()}
+	gq := types.TopologyParam{
+		GremlinQuery:    gremlin.NewQueryStringFromArgument(query).String(),
+		GremlinBindings: g.bindings,
+	}
 	s, err := json.Marshal(gq)
 	if err != nil {
 		return nil, err
@@ -27,6 +27,8 @@ import (
 
 	"github.com/skydive-project/skydive/api/types"
 	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+	"github.com/skydive-project/skydive/validator"
 )
 
 // AlertResourceHandler aims to creates and manage a new Alert.
@@ -51,6 +53,20 @@ func (a *AlertResourceHandler) Name() string {
 	return "alert"
 }
 
+// Create checks that the Gremlin expression of the alert can be parsed with
+// its bindings, the other expressions being evaluated as JavaScript
+func (a *AlertAPIHandler) Create(r types.Resource) error {
+	alert := r.(*types.Alert)
+
+	if traversal.IsQuery(alert.Expression) {
+		if err := validator.ValidateGremlinQuery(alert.Expression, alert.GremlinBindings); err != nil {
+			return err
+		}
+	}
+
+	return a.BasicAPIHandler.Create(r)
+}
+
 // RegisterAlertAPI registers an Alert's API to a designated API Server
 func RegisterAlertAPI(apiServer *Server, authBackend shttp.AuthenticationBackend) (*AlertAPIHandler, error) {
 	alertAPIHandler := &AlertAPIHandler{
@@ -23,7 +23,9 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 
 	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/common"
@@ -32,6 +34,7 @@ import (
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/validator"
 )
 
 // CaptureResourceHandler describes a capture ressouce handler
@@ -67,7 +70,7 @@ func (c *CaptureAPIHandler) Decorate(resource types.Resource) {
 	c.Graph.RLock()
 	defer c.Graph.RUnlock()
 
-	res, err := ge.TopologyGremlinQuery(c.Graph, capture.GremlinQuery)
+	res, err := ge.TopologyGremlinQueryWithBindings(c.Graph, capture.GremlinQuery, capture.GremlinBindings)
 	if err != nil {
 		logging.GetLogger().Errorf("Gremlin error: %s", err)
 		return
@@ -105,6 +108,14 @@ func (c *CaptureAPIHandler) Decorate(resource types.Resource) {
 func (c *CaptureAPIHandler) Create(r types.Resource) error {
 	capture := r.(*types.Capture)
 
+	if capture.SamplingRate > 1 && capture.FlowsPerSecond > 0 {
+		return errors.New("Sampling rate and flows per second are exclusive")
+	}
+
+	if err := validator.ValidateGremlinQuery(capture.GremlinQuery, capture.GremlinBindings); err != nil {
+		return err
+	}
+
 	// check capabilities
 	if capture.Type != "" {
 		if capture.BPFFilter != "" {
@@ -122,12 +133,22 @@ func (c *CaptureAPIHandler) Create(r types.Resource) error {
 				return fmt.Errorf("%s capture doesn't support extra TCP metrics capture", capture.Type)
 			}
 		}
+		if capture.SamplingRate > 1 || capture.FlowsPerSecond > 0 {
+			if !common.CheckProbeCapabilities(capture.Type, common.SamplingCapability) {
+				return fmt.Errorf("%s capture doesn't support sampling", capture.Type)
+			}
+		}
+		if capture.PcapRingSize != 0 || capture.PcapRingDuration != 0 {
+			if !common.CheckProbeCapabilities(capture.Type, common.PcapRingCapability) {
+				return fmt.Errorf("%s capture doesn't support pcap ring buffer", capture.Type)
+			}
+		}
 	}
 
 	resources := c.Index()
 	for _, resource := range resources {
 		resource := resource.(*types.Capture)
-		if resource.GremlinQuery == capture.GremlinQuery {
+		if resource.GremlinQuery == capture.GremlinQuery && reflect.DeepEqual(resource.GremlinBindings, capture.GremlinBindings) {
 			return fmt.Errorf("Duplicate capture, uuid=%s", capture.UUID)
 		}
 	}
@@ -0,0 +1,315 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"unicode"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/mitchellh/mapstructure"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+	ws "github.com/skydive-project/skydive/websocket"
+)
+
+// MetricsNamespace prefixes the name of all the exported metrics
+const MetricsNamespace = "skydive"
+
+type metricsAPI struct {
+	registry *prometheus.Registry
+}
+
+func (m *metricsAPI) metricsGet(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "metrics", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	families, err := m.registry.Gather()
+	if err != nil {
+		// Gather returns as many metrics as possible along with the error
+		logging.GetLogger().Warningf("Error while gathering metrics: %s", err)
+		if len(families) == 0 {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	format := expfmt.Negotiate(r.Header)
+	w.Header().Set("Content-Type", string(format))
+	w.WriteHeader(http.StatusOK)
+
+	encoder := expfmt.NewEncoder(w, format)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			logging.GetLogger().Warningf("Error while writing metrics: %s", err)
+			return
+		}
+	}
+}
+
+func (m *metricsAPI) registerEndpoints(r *shttp.Server, authBackend shttp.AuthenticationBackend) {
+	routes := []shttp.Route{
+		{
+			Name:        "Metrics",
+			Method:      "GET",
+			Path:        "/metrics",
+			HandlerFunc: m.metricsGet,
+		},
+	}
+
+	r.RegisterRoutes(routes, authBackend)
+}
+
+// RegisterMetricsAPI registers the endpoint exposing the given collectors,
+// along with the process and Go runtime metrics, in the Prometheus format
+func RegisterMetricsAPI(r *shttp.Server, authBackend shttp.AuthenticationBackend, collectors ...prometheus.Collector) error {
+	registry := prometheus.NewRegistry()
+
+	collectors = append(collectors, prometheus.NewGoCollector(), prometheus.NewProcessCollector(os.Getpid(), MetricsNamespace))
+	for _, collector := range collectors {
+		if err := registry.Register(collector); err != nil {
+			return err
+		}
+	}
+
+	m := &metricsAPI{registry: registry}
+	m.registerEndpoints(r, authBackend)
+
+	return nil
+}
+
+// snakeCase converts a metadata key like RxCrcErrors to rx_crc_errors
+func snakeCase(s string) string {
+	var b bytes.Buffer
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var (
+	interfaceLabels = []string{"name", "host", "type", "namespace"}
+	captureLabels   = []string{"name", "host", "type", "capture_id"}
+
+	// capture statistics reported by the probes in the Capture metadata
+	captureStats = []string{"PacketsReceived", "PacketsDropped", "PacketsIfDropped"}
+)
+
+type graphCollector struct {
+	graph          *graph.Graph
+	interfaceDescs map[string]*prometheus.Desc
+	captureDescs   map[string]*prometheus.Desc
+}
+
+// NewGraphCollector returns a Prometheus collector of the interface counters
+// and of the capture statistics found in the metadata of the graph nodes
+func NewGraphCollector(g *graph.Graph) prometheus.Collector {
+	c := &graphCollector{
+		graph:          g,
+		interfaceDescs: make(map[string]*prometheus.Desc),
+		captureDescs:   make(map[string]*prometheus.Desc),
+	}
+
+	for _, field := range (&topology.InterfaceMetric{}).GetFields() {
+		if field == "Start" || field == "Last" {
+			continue
+		}
+		c.interfaceDescs[field] = prometheus.NewDesc(
+			prometheus.BuildFQName(MetricsNamespace, "interface", snakeCase(field)+"_total"),
+			fmt.Sprintf("%s counter of the interface", field),
+			interfaceLabels, nil,
+		)
+	}
+
+	for _, stat := range captureStats {
+		c.captureDescs[stat] = prometheus.NewDesc(
+			prometheus.BuildFQName(MetricsNamespace, "capture", snakeCase(stat)+"_total"),
+			fmt.Sprintf("%s counter of the capture", stat),
+			captureLabels, nil,
+		)
+	}
+
+	return c
+}
+
+// Describe implements the prometheus.Collector interface
+func (c *graphCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.interfaceDescs {
+		ch <- desc
+	}
+	for _, desc := range c.captureDescs {
+		ch <- desc
+	}
+}
+
+func (c *graphCollector) namespace(n *graph.Node) string {
+	for _, parent := range c.graph.LookupParents(n, graph.Metadata{"Type": "netns"}, topology.OwnershipMetadata()) {
+		name, _ := parent.GetFieldString("Name")
+		return name
+	}
+	return ""
+}
+
+func (c *graphCollector) collectInterface(ch chan<- prometheus.Metric, n *graph.Node, name, tp string) {
+	m, _ := n.GetField("Metric")
+	if m == nil {
+		return
+	}
+
+	metric, ok := m.(*topology.InterfaceMetric)
+	if !ok {
+		// metrics of the nodes received from the agents are decoded as maps
+		metric = &topology.InterfaceMetric{}
+		if err := mapstructure.WeakDecode(m, metric); err != nil {
+			return
+		}
+	}
+
+	namespace := c.namespace(n)
+	for field, desc := range c.interfaceDescs {
+		value, _ := metric.GetFieldInt64(field)
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), name, n.Host(), tp, namespace)
+	}
+}
+
+func (c *graphCollector) collectCapture(ch chan<- prometheus.Metric, n *graph.Node, name, tp string) {
+	id, _ := n.GetFieldString("Capture.ID")
+	if id == "" {
+		return
+	}
+
+	for stat, desc := range c.captureDescs {
+		v, err := n.GetField("Capture." + stat)
+		if err != nil {
+			continue
+		}
+
+		value, err := common.ToFloat64(v)
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value, name, n.Host(), tp, id)
+	}
+}
+
+// Collect implements the prometheus.Collector interface
+func (c *graphCollector) Collect(ch chan<- prometheus.Metric) {
+	c.graph.RLock()
+	defer c.graph.RUnlock()
+
+	for _, n := range c.graph.GetNodes(nil) {
+		name, _ := n.GetFieldString("Name")
+		tp, _ := n.GetFieldString("Type")
+
+		c.collectInterface(ch, n, name, tp)
+		c.collectCapture(ch, n, name, tp)
+	}
+}
+
+type flowTableCollector struct {
+	allocator *flow.TableAllocator
+	desc      *prometheus.Desc
+}
+
+// NewFlowTableCollector returns a Prometheus collector of the number of flows
+// of the flow tables
+func NewFlowTableCollector(allocator *flow.TableAllocator) prometheus.Collector {
+	return &flowTableCollector{
+		allocator: allocator,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(MetricsNamespace, "flow_table", "flows"),
+			"Number of flows in the flow table of a capture",
+			[]string{"node_tid"}, nil,
+		),
+	}
+}
+
+// Describe implements the prometheus.Collector interface
+func (c *flowTableCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements the prometheus.Collector interface
+func (c *flowTableCollector) Collect(ch chan<- prometheus.Metric) {
+	for tid, size := range c.allocator.TableSizes() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(size), tid)
+	}
+}
+
+// WSStatusReporter describes the WebSocket pools and servers reporting the
+// status of their connections
+type WSStatusReporter interface {
+	GetStatus() map[string]ws.ConnStatus
+}
+
+type wsCollector struct {
+	reporters map[string]WSStatusReporter
+	desc      *prometheus.Desc
+}
+
+// NewWebSocketCollector returns a Prometheus collector of the length of the
+// sending queue of the WebSocket connections, the reporters being indexed by
+// the name used as pool label
+func NewWebSocketCollector(reporters map[string]WSStatusReporter) prometheus.Collector {
+	return &wsCollector{
+		reporters: reporters,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(MetricsNamespace, "websocket", "queue_length"),
+			"Number of messages waiting to be sent to a WebSocket peer",
+			[]string{"pool", "remote_host"}, nil,
+		),
+	}
+}
+
+// Describe implements the prometheus.Collector interface
+func (c *wsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements the prometheus.Collector interface
+func (c *wsCollector) Collect(ch chan<- prometheus.Metric) {
+	for pool, reporter := range c.reporters {
+		for host, status := range reporter.GetStatus() {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(status.QueueLength), pool, host)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology"
+	"github.com/skydive-project/skydive/topology/graph"
+	ws "github.com/skydive-project/skydive/websocket"
+)
+
+type fakeStatusReporter map[string]ws.ConnStatus
+
+func (r fakeStatusReporter) GetStatus() map[string]ws.ConnStatus {
+	return r
+}
+
+// series returns the value of the metric of the family having the given
+// labels, and whether such a metric was found
+func series(families []*dto.MetricFamily, name string, labels map[string]string) (float64, bool) {
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+	metrics:
+		for _, metric := range family.GetMetric() {
+			if len(metric.GetLabel()) != len(labels) {
+				continue
+			}
+
+			for _, label := range metric.GetLabel() {
+				if value, found := labels[label.GetName()]; !found || value != label.GetValue() {
+					continue metrics
+				}
+			}
+
+			if metric.Counter != nil {
+				return metric.GetCounter().GetValue(), true
+			}
+			return metric.GetGauge().GetValue(), true
+		}
+	}
+
+	return 0, false
+}
+
+func TestMetricsCollectors(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraphFromConfig(b, common.AgentService)
+
+	ns := g.NewNode(graph.GenID(), graph.Metadata{"Name": "ns1", "Type": "netns"}, "host1")
+	eth0 := g.NewNode(graph.GenID(), graph.Metadata{
+		"Name":   "eth0",
+		"Type":   "veth",
+		"Metric": &topology.InterfaceMetric{RxPackets: 10, TxBytes: 2048},
+		"Capture": map[string]interface{}{
+			"ID":              "capture-1",
+			"PacketsReceived": int64(100),
+			"PacketsDropped":  int64(2),
+		},
+	}, "host1")
+	topology.AddOwnershipLink(g, ns, eth0, nil, "host1")
+
+	// metrics of the nodes received from the agents are decoded as maps
+	g.NewNode(graph.GenID(), graph.Metadata{
+		"Name":   "eth1",
+		"Type":   "device",
+		"Metric": map[string]interface{}{"RxPackets": float64(5)},
+	}, "host2")
+
+	allocator := flow.NewTableAllocator(time.Second, time.Second)
+	allocator.Alloc(func(f []*flow.Flow) {}, "tid1", flow.TableOpts{})
+
+	reporters := map[string]WSStatusReporter{
+		"subscriber": fakeStatusReporter{"client1": ws.ConnStatus{QueueLength: 3}},
+	}
+
+	registry := prometheus.NewRegistry()
+	for _, collector := range []prometheus.Collector{
+		NewGraphCollector(g),
+		NewFlowTableCollector(allocator),
+		NewWebSocketCollector(reporters),
+	} {
+		if err := registry.Register(collector); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected float64
+	}{
+		{"skydive_interface_rx_packets_total", map[string]string{"name": "eth0", "host": "host1", "type": "veth", "namespace": "ns1"}, 10},
+		{"skydive_interface_tx_bytes_total", map[string]string{"name": "eth0", "host": "host1", "type": "veth", "namespace": "ns1"}, 2048},
+		{"skydive_interface_rx_packets_total", map[string]string{"name": "eth1", "host": "host2", "type": "device", "namespace": ""}, 5},
+		{"skydive_capture_packets_received_total", map[string]string{"name": "eth0", "host": "host1", "type": "veth", "capture_id": "capture-1"}, 100},
+		{"skydive_capture_packets_dropped_total", map[string]string{"name": "eth0", "host": "host1", "type": "veth", "capture_id": "capture-1"}, 2},
+		{"skydive_flow_table_flows", map[string]string{"node_tid": "tid1"}, 0},
+		{"skydive_websocket_queue_length", map[string]string{"pool": "subscriber", "remote_host": "client1"}, 3},
+	}
+
+	for _, test := range tests {
+		value, found := series(families, test.name, test.labels)
+		if !found {
+			t.Errorf("Series %s%v not found", test.name, test.labels)
+		} else if value != test.expected {
+			t.Errorf("Series %s%v: expected %f, got %f", test.name, test.labels, test.expected, value)
+		}
+	}
+
+	// the statistics not reported by the capture probe are not exposed
+	labels := map[string]string{"name": "eth0", "host": "host1", "type": "veth", "capture_id": "capture-1"}
+	if _, found := series(families, "skydive_capture_packets_if_dropped_total", labels); found {
+		t.Error("Series skydive_capture_packets_if_dropped_total should not be exposed")
+	}
+
+	// netns and eth1 nodes have no capture
+	if _, found := series(families, "skydive_capture_packets_received_total", map[string]string{"name": "eth1", "host": "host2", "type": "device", "capture_id": ""}); found {
+		t.Error("Nodes without capture should not have capture series")
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for s, expected := range map[string]string{
+		"RxCrcErrors":     "rx_crc_errors",
+		"PacketsReceived": "packets_received",
+		"collisions":      "collisions",
+	} {
+		if value := snakeCase(s); value != expected {
+			t.Errorf("%s: expected %s, got %s", s, expected, value)
+		}
+	}
+}
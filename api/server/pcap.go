@@ -23,21 +23,34 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/abbot/go-http-auth"
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
 	"github.com/skydive-project/skydive/flow/storage"
+	ge "github.com/skydive-project/skydive/gremlin/traversal"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/rbac"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+	"github.com/skydive-project/skydive/validator"
 )
 
-// PcapAPI exposes the pcap injector API
+// PcapAPI exposes the pcap injector and download API
 type PcapAPI struct {
-	Storage storage.Storage
+	Storage       storage.Storage
+	TableClient   *flow.WSTableClient
+	graph         *graph.Graph
+	gremlinParser *traversal.GremlinTraversalParser
 }
 
 func (p *PcapAPI) flowExpireUpdate(flows []*flow.Flow) {
@@ -78,6 +91,110 @@ func (p *PcapAPI) injectPcap(w http.ResponseWriter, r *auth.AuthenticatedRequest
 	w.WriteHeader(http.StatusOK)
 }
 
+// gremlinToPcapQuery restricts the pcap query to the flows or the nodes
+// returned by the Gremlin query
+func (p *PcapAPI) gremlinToPcapQuery(r *auth.AuthenticatedRequest, gremlinQuery string, query *flow.PcapQuery) error {
+	ts, err := p.gremlinParser.Parse(strings.NewReader(gremlinQuery))
+	if err != nil {
+		return err
+	}
+
+	res, err := ts.ExecContext(r.Context(), p.graph, true, queryLimits(r.Username))
+	if err != nil {
+		return err
+	}
+
+	switch res := res.(type) {
+	case *ge.FlowTraversalStep:
+		tids := make(map[string]bool)
+		for _, value := range res.Values() {
+			f := value.(*flow.Flow)
+			query.TrackingIDs = append(query.TrackingIDs, f.TrackingID)
+			if !tids[f.NodeTID] {
+				tids[f.NodeTID] = true
+				query.NodeTIDs = append(query.NodeTIDs, f.NodeTID)
+			}
+		}
+	case *traversal.GraphTraversalV:
+		p.graph.RLock()
+		for _, node := range res.GetNodes() {
+			if tid, _ := node.GetFieldString("TID"); tid != "" {
+				query.NodeTIDs = append(query.NodeTIDs, tid)
+			}
+		}
+		p.graph.RUnlock()
+	default:
+		return errors.New("Gremlin query has to return flows or nodes")
+	}
+
+	if len(query.NodeTIDs) == 0 {
+		return common.ErrNotFound
+	}
+
+	return nil
+}
+
+func (p *PcapAPI) downloadPcap(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "pcap", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if p.TableClient == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("No agent to download packets from"))
+		return
+	}
+
+	resource := types.PcapParam{}
+	data, _ := ioutil.ReadAll(r.Body)
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, &resource); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := validator.Validate(resource); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	query := &flow.PcapQuery{BPFFilter: resource.BPFFilter}
+	if !resource.From.IsZero() {
+		query.From = common.UnixMillis(resource.From)
+	}
+	if !resource.To.IsZero() {
+		query.To = common.UnixMillis(resource.To)
+	}
+
+	if resource.GremlinQuery != "" {
+		if err := p.gremlinToPcapQuery(r, resource.GremlinQuery, query); err == common.ErrNotFound {
+			writeError(w, http.StatusNotFound, errors.New("No flow or node matching the Gremlin query"))
+			return
+		} else if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	readers, err := p.TableClient.LookupPcap(query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(readers) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("No packet found, please check the time range and that the captures have a pcap ring buffer"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.WriteHeader(http.StatusOK)
+
+	if err := flow.MergePcaps(w, readers); err != nil {
+		logging.GetLogger().Warningf("Error while writing pcap response: %s", err)
+	}
+}
+
 func (p *PcapAPI) registerEndpoints(r *shttp.Server, authBackend shttp.AuthenticationBackend) {
 	routes := []shttp.Route{
 		{
@@ -86,15 +203,24 @@ func (p *PcapAPI) registerEndpoints(r *shttp.Server, authBackend shttp.Authentic
 			Path:        "/api/pcap",
 			HandlerFunc: p.injectPcap,
 		},
+		{
+			Name:        "PCAPDownload",
+			Method:      "POST",
+			Path:        "/api/pcap/download",
+			HandlerFunc: p.downloadPcap,
+		},
 	}
 
 	r.RegisterRoutes(routes, authBackend)
 }
 
-// RegisterPcapAPI registers a new pcap injector API
-func RegisterPcapAPI(r *shttp.Server, store storage.Storage, authBackend shttp.AuthenticationBackend) {
+// RegisterPcapAPI registers a new pcap injector and download API
+func RegisterPcapAPI(r *shttp.Server, store storage.Storage, tableClient *flow.WSTableClient, g *graph.Graph, parser *traversal.GremlinTraversalParser, authBackend shttp.AuthenticationBackend) {
 	p := &PcapAPI{
-		Storage: store,
+		Storage:       store,
+		TableClient:   tableClient,
+		graph:         g,
+		gremlinParser: parser,
 	}
 
 	p.registerEndpoints(r, authBackend)
@@ -0,0 +1,370 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/abbot/go-http-auth"
+	"github.com/google/gopacket"
+	"github.com/gorilla/mux"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/ondemand"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+	"github.com/skydive-project/skydive/topology/graph"
+	ws "github.com/skydive-project/skydive/websocket"
+)
+
+// number of packets buffered per stream, packets are dropped when the client
+// doesn't read fast enough
+const pcapNgStreamBufferSize = 1000
+
+// PcapNgAPI streams the packets of the active captures, read from the flow
+// tables on the agents, and requested to the agents on the analyzers
+type PcapNgAPI struct {
+	common.RWMutex
+	ws.DefaultSpeakerEventHandler
+	graph          *graph.Graph
+	tableAllocator *flow.TableAllocator
+	agentPool      ws.StructSpeakerPool
+	agentStreams   map[string]*pcapNgAgentStream
+}
+
+// pcapNgAgentStream holds the packets streamed by the agents to an analyzer
+type pcapNgAgentStream struct {
+	packets *flow.PacketChannel
+	hosts   map[string]bool
+}
+
+// pcapNgSource describes the packets to stream, the interfaces they are
+// captured on and the number of sources, i.e. tables or agents, expected to
+// notify their stop
+type pcapNgSource struct {
+	packets    *flow.PacketChannel
+	interfaces []pcapNgInterface
+	sources    int
+	stop       func()
+}
+
+type pcapNgInterface struct {
+	name     string
+	tid      string
+	host     string
+	linkType string
+}
+
+func (p *PcapNgAPI) captureInterfaces(captureID string) []pcapNgInterface {
+	p.graph.RLock()
+	defer p.graph.RUnlock()
+
+	var interfaces []pcapNgInterface
+	for _, node := range p.graph.GetNodes(graph.Metadata{"Capture.ID": captureID}) {
+		tid, _ := node.GetFieldString("TID")
+		if tid == "" {
+			continue
+		}
+		name, _ := node.GetFieldString("Name")
+		encapType, _ := node.GetFieldString("EncapType")
+
+		interfaces = append(interfaces, pcapNgInterface{name: name, tid: tid, host: node.Host(), linkType: encapType})
+	}
+
+	return interfaces
+}
+
+// listenTables listens to the packets of the flow tables of the interfaces
+func (p *PcapNgAPI) listenTables(interfaces []pcapNgInterface) *pcapNgSource {
+	source := &pcapNgSource{}
+
+	var tables []*flow.Table
+	for _, intf := range interfaces {
+		if table := p.tableAllocator.FindTable(intf.tid); table != nil {
+			tables = append(tables, table)
+			source.interfaces = append(source.interfaces, intf)
+		}
+	}
+
+	// register the listeners once the number of tables is known so that
+	// stopping a table never blocks
+	source.packets = flow.NewPacketChannel(pcapNgStreamBufferSize, len(tables))
+	for _, table := range tables {
+		table.AddPacketListener(source.packets)
+	}
+
+	source.sources = len(tables)
+	source.stop = func() {
+		for _, table := range tables {
+			table.RemovePacketListener(source.packets)
+		}
+	}
+
+	return source
+}
+
+// stopAgentStream notifies that an agent doesn't stream packets anymore
+func (p *PcapNgAPI) stopAgentStream(streamID string, host string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if stream, found := p.agentStreams[streamID]; found && stream.hosts[host] {
+		delete(stream.hosts, host)
+		stream.packets.OnTableStopped(host)
+	}
+}
+
+// listenAgents requests the agents hosting the interfaces to stream their
+// packets through the ondemand probe server
+func (p *PcapNgAPI) listenAgents(captureID string, interfaces []pcapNgInterface) *pcapNgSource {
+	hosts := make(map[string]bool)
+	for _, intf := range interfaces {
+		hosts[intf.host] = true
+	}
+
+	query := ondemand.PacketStreamQuery{StreamID: string(graph.GenID()), CaptureID: captureID}
+	stream := &pcapNgAgentStream{
+		packets: flow.NewPacketChannel(pcapNgStreamBufferSize, len(hosts)),
+		hosts:   make(map[string]bool),
+	}
+	for host := range hosts {
+		stream.hosts[host] = true
+	}
+
+	p.Lock()
+	p.agentStreams[query.StreamID] = stream
+	p.Unlock()
+
+	source := &pcapNgSource{packets: stream.packets, sources: len(hosts)}
+
+	var started []string
+	for host := range hosts {
+		msg := ws.NewStructMessage(ondemand.Namespace, "PacketStreamStart", query)
+		resp, err := p.agentPool.Request(host, msg, ws.DefaultRequestTimeout)
+		if err != nil || resp == nil || resp.Status != http.StatusOK {
+			logging.GetLogger().Debugf("Unable to stream the packets of capture %s from %s", captureID, host)
+			p.stopAgentStream(query.StreamID, host)
+			continue
+		}
+		started = append(started, host)
+	}
+
+	for _, intf := range interfaces {
+		for _, host := range started {
+			if intf.host == host {
+				source.interfaces = append(source.interfaces, intf)
+			}
+		}
+	}
+
+	source.stop = func() {
+		for _, host := range started {
+			p.agentPool.SendMessageTo(ws.NewStructMessage(ondemand.Namespace, "PacketStreamStop", query), host)
+		}
+
+		p.Lock()
+		delete(p.agentStreams, query.StreamID)
+		p.Unlock()
+	}
+
+	return source
+}
+
+// OnStructMessage websocket message, valid message type are Packet and
+// PacketStreamStopped, sent by the agents
+func (p *PcapNgAPI) OnStructMessage(c ws.Speaker, msg *ws.StructMessage) {
+	switch msg.Type {
+	case "Packet":
+		var packet ondemand.StreamedPacket
+		if err := msg.UnmarshalObj(&packet); err != nil {
+			logging.GetLogger().Errorf("Unable to decode streamed packet %v", msg)
+			return
+		}
+
+		p.RLock()
+		stream, found := p.agentStreams[packet.StreamID]
+		p.RUnlock()
+
+		if found {
+			stream.packets.OnPacket(&flow.TablePacket{
+				NodeTID: packet.NodeTID,
+				CaptureInfo: gopacket.CaptureInfo{
+					Timestamp:     time.Unix(0, packet.Timestamp),
+					CaptureLength: len(packet.Data),
+					Length:        packet.Length,
+				},
+				Data:      packet.Data,
+				FlowUUIDs: packet.FlowUUIDs,
+			})
+		}
+	case "PacketStreamStopped":
+		var query ondemand.PacketStreamQuery
+		if err := msg.UnmarshalObj(&query); err != nil {
+			logging.GetLogger().Errorf("Unable to decode packet stream query %v", msg)
+			return
+		}
+
+		p.stopAgentStream(query.StreamID, c.GetRemoteHost())
+	}
+}
+
+// OnDisconnected websocket event, the streams of the agent are stopped
+func (p *PcapNgAPI) OnDisconnected(c ws.Speaker) {
+	p.RLock()
+	var streams []string
+	for id := range p.agentStreams {
+		streams = append(streams, id)
+	}
+	p.RUnlock()
+
+	for _, id := range streams {
+		p.stopAgentStream(id, c.GetRemoteHost())
+	}
+}
+
+func (p *PcapNgAPI) streamCapture(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "pcap", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	captureID := mux.Vars(&r.Request)["id"]
+
+	var source *pcapNgSource
+	if p.tableAllocator != nil {
+		source = p.listenTables(p.captureInterfaces(captureID))
+	} else {
+		source = p.listenAgents(captureID, p.captureInterfaces(captureID))
+	}
+	defer source.stop()
+
+	if len(source.interfaces) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("No active capture with this ID"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pcapng")
+	w.WriteHeader(http.StatusOK)
+
+	writer, err := flow.NewPcapNgWriter(w)
+	if err != nil {
+		logging.GetLogger().Warningf("Error while writing pcap-ng stream: %s", err)
+		return
+	}
+
+	interfaces := make(map[string]int)
+	for _, intf := range source.interfaces {
+		_, linkType := flow.GetFirstLayerType(intf.linkType)
+		index, err := writer.AddInterface(intf.name, intf.tid, linkType)
+		if err != nil {
+			logging.GetLogger().Warningf("Error while writing pcap-ng stream: %s", err)
+			return
+		}
+		interfaces[intf.tid] = index
+	}
+
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	defer func() {
+		if dropped := source.packets.Dropped(); dropped > 0 {
+			logging.GetLogger().Warningf("%d packets of capture %s dropped while streaming", dropped, captureID)
+		}
+	}()
+
+	active := source.sources
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-source.packets.Stopped:
+			if active--; active == 0 {
+				return
+			}
+		case packet := <-source.packets.Packets:
+			ci := packet.CaptureInfo
+			ci.InterfaceIndex = interfaces[packet.NodeTID]
+
+			comments := make([]string, len(packet.FlowUUIDs))
+			for i, uuid := range packet.FlowUUIDs {
+				comments[i] = fmt.Sprintf("Flow UUID: %s", uuid)
+			}
+
+			if err := writer.WritePacket(ci, packet.Data, comments...); err != nil {
+				logging.GetLogger().Debugf("Stop streaming capture %s: %s", captureID, err)
+				return
+			}
+
+			if flusher != nil && len(source.packets.Packets) == 0 {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func (p *PcapNgAPI) registerEndpoints(r *shttp.Server, authBackend shttp.AuthenticationBackend) {
+	routes := []shttp.Route{
+		{
+			Name:        "PcapNgStream",
+			Method:      "GET",
+			Path:        "/api/pcapng/{id}",
+			HandlerFunc: p.streamCapture,
+		},
+	}
+
+	r.RegisterRoutes(routes, authBackend)
+}
+
+// RegisterPcapNgAPI registers the API streaming as pcap-ng the packets of the
+// active captures of an agent, given the capture ID
+func RegisterPcapNgAPI(r *shttp.Server, g *graph.Graph, tableAllocator *flow.TableAllocator, authBackend shttp.AuthenticationBackend) {
+	p := &PcapNgAPI{
+		graph:          g,
+		tableAllocator: tableAllocator,
+	}
+
+	p.registerEndpoints(r, authBackend)
+}
+
+// RegisterAnalyzerPcapNgAPI registers the API streaming as pcap-ng the packets
+// of the active captures, the packets being streamed by the agents through
+// their ondemand probe server
+func RegisterAnalyzerPcapNgAPI(r *shttp.Server, g *graph.Graph, agentPool ws.StructSpeakerPool, authBackend shttp.AuthenticationBackend) {
+	p := &PcapNgAPI{
+		graph:        g,
+		agentPool:    agentPool,
+		agentStreams: make(map[string]*pcapNgAgentStream),
+	}
+
+	agentPool.AddStructMessageHandler(p, []string{ondemand.PacketNamespace})
+	agentPool.AddEventHandler(p)
+
+	p.registerEndpoints(r, authBackend)
+}
@@ -23,15 +23,18 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/abbot/go-http-auth"
 	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
 	ge "github.com/skydive-project/skydive/gremlin/traversal"
 	shttp "github.com/skydive-project/skydive/http"
@@ -46,6 +49,7 @@ import (
 type TopologyAPI struct {
 	graph         *graph.Graph
 	gremlinParser *traversal.GremlinTraversalParser
+	nodeRules     *NodeRuleAPI
 }
 
 func shortID(s graph.Identifier) graph.Identifier {
@@ -123,6 +127,129 @@ func (t *TopologyAPI) topologyIndex(w http.ResponseWriter, r *auth.Authenticated
 	}
 }
 
+// roleQueryLimit returns the highest limit among the ones of the roles, the
+// limit of the default entry being used for the roles not having their own
+func roleQueryLimit(roles []string, key string) int64 {
+	limit := int64(-1)
+	for _, role := range roles {
+		path := "rbac.query_limits." + role + "." + key
+		if !config.IsSet(path) {
+			path = "rbac.query_limits.default." + key
+		}
+
+		// 0 meaning no limit, it takes precedence over any other value
+		value := int64(config.GetInt(path))
+		if limit == -1 || value == 0 || (limit != 0 && value > limit) {
+			limit = value
+		}
+	}
+	return limit
+}
+
+// queryLimits returns the execution limits of the Gremlin queries of a user
+// according to its roles
+func queryLimits(username string) traversal.Limits {
+	roles := rbac.GetUserRoles(username)
+	if len(roles) == 0 {
+		roles = []string{"default"}
+	}
+
+	return traversal.Limits{
+		Timeout:      time.Duration(roleQueryLimit(roles, "timeout")) * time.Second,
+		MaxTraversed: roleQueryLimit(roles, "max_traversed"),
+		MaxResults:   roleQueryLimit(roles, "max_results"),
+	}
+}
+
+// createdNodes returns the node rules having created the nodes, by node ID
+func (t *TopologyAPI) createdNodes() map[graph.Identifier]string {
+	rules := make(map[graph.Identifier]string)
+	for id, resource := range t.nodeRules.Index() {
+		rule, ok := resource.(*types.NodeRule)
+		if !ok || strings.ToLower(rule.Action) != "create" {
+			continue
+		}
+
+		nodeType, _ := rule.Metadata["Type"].(string)
+		name, _ := rule.Metadata["Name"].(string)
+		rules[graph.GenID(nodeType, name)] = id
+	}
+	return rules
+}
+
+// persistMutation stores the change made by a Property or Drop step to the
+// selected nodes as node rules so that the topology manager applies it again
+// after a restart
+func (t *TopologyAPI) persistMutation(step traversal.GremlinTraversalStep, selection string, nodes *traversal.GraphTraversalV) error {
+	switch step := step.(type) {
+	case *traversal.GremlinTraversalStepProperty:
+		// the query of the rule is stored without the values of the variables
+		if _, err := t.gremlinParser.Parse(strings.NewReader(selection)); err != nil {
+			if _, ok := err.(*traversal.UnboundVariableError); ok {
+				return errors.New("Bind variables can only be used by the Property step of a mutation")
+			}
+			return err
+		}
+
+		rule := &types.NodeRule{
+			Action:   "update",
+			Query:    selection,
+			Metadata: graph.Metadata{step.Params[0].(string): step.Params[1]},
+		}
+		return t.nodeRules.Create(rule)
+	case *traversal.GremlinTraversalStepDrop:
+		rules := t.createdNodes()
+
+		var ids []string
+		for _, value := range nodes.Values() {
+			node := value.(*graph.Node)
+
+			id, found := rules[node.ID]
+			if !found {
+				return fmt.Errorf("Node %s was not created through the API and can't be dropped", node.ID)
+			}
+			ids = append(ids, id)
+		}
+
+		for _, id := range ids {
+			if err := t.nodeRules.Delete(id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// mutate selects the nodes to be modified by a Property or Drop step within
+// the limits of the user, persists the change and then applies it
+func (t *TopologyAPI) mutate(ctx context.Context, step traversal.GremlinTraversalStep, selection string, bindings map[string]interface{}, limits traversal.Limits) (traversal.GraphTraversalStep, error) {
+	if t.nodeRules == nil {
+		return nil, errors.New("Topology mutations are only supported by the analyzer")
+	}
+
+	ts, err := t.gremlinParser.ParseQuery(selection, bindings)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ts.ExecContext(ctx, t.graph, true, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, ok := res.(*traversal.GraphTraversalV)
+	if !ok {
+		return nil, errors.New("Property and Drop steps can only be applied to nodes")
+	}
+
+	if err := t.persistMutation(step, selection, nodes); err != nil {
+		return nil, err
+	}
+
+	return step.Exec(nodes)
+}
+
 func (t *TopologyAPI) topologySearch(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
 	if !rbac.Enforce(r.Username, "topology", "read") {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -147,13 +274,24 @@ func (t *TopologyAPI) topologySearch(w http.ResponseWriter, r *auth.Authenticate
 		return
 	}
 
-	ts, err := t.gremlinParser.Parse(strings.NewReader(resource.GremlinQuery))
+	ts, err := t.gremlinParser.ParseMutation(resource.GremlinQuery, resource.GremlinBindings)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	res, err := ts.Exec(t.graph, true)
+	var res traversal.GraphTraversalStep
+	if step, selection := ts.Mutation(resource.GremlinQuery); step != nil {
+		if !rbac.Enforce(r.Username, "topology", "write") {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		res, err = t.mutate(r.Context(), step, selection, resource.GremlinBindings, queryLimits(r.Username))
+	} else {
+		res, err = ts.ExecContext(r.Context(), t.graph, true, queryLimits(r.Username))
+	}
+
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
@@ -217,11 +355,13 @@ func (t *TopologyAPI) registerEndpoints(r *shttp.Server, authBackend shttp.Authe
 	r.RegisterRoutes(routes, authBackend)
 }
 
-// RegisterTopologyAPI registers a new topology query API
-func RegisterTopologyAPI(r *shttp.Server, g *graph.Graph, parser *traversal.GremlinTraversalParser, authBackend shttp.AuthenticationBackend) {
+// RegisterTopologyAPI registers a new topology query API, node rules being
+// used to persist the changes made by the Property and Drop steps
+func RegisterTopologyAPI(r *shttp.Server, g *graph.Graph, parser *traversal.GremlinTraversalParser, nodeRules *NodeRuleAPI, authBackend shttp.AuthenticationBackend) {
 	t := &TopologyAPI{
 		gremlinParser: parser,
 		graph:         g,
+		nodeRules:     nodeRules,
 	}
 
 	t.registerEndpoints(r, authBackend)
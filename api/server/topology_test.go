@@ -0,0 +1,249 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/abbot/go-http-auth"
+	etcd "github.com/coreos/etcd/client"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/topology/graph"
+	"github.com/skydive-project/skydive/topology/graph/traversal"
+)
+
+// memKeysAPI is an in memory etcd keys API, enough for the API handlers
+type memKeysAPI struct {
+	sync.Mutex
+	values map[string]string
+}
+
+type memWatcher struct{}
+
+func (w *memWatcher) Next(ctx context.Context) (*etcd.Response, error) {
+	return nil, errors.New("watch not supported")
+}
+
+func newMemKeysAPI() *memKeysAPI {
+	return &memKeysAPI{values: make(map[string]string)}
+}
+
+func (m *memKeysAPI) Get(ctx context.Context, key string, opts *etcd.GetOptions) (*etcd.Response, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if opts != nil && opts.Recursive {
+		dir := &etcd.Node{Key: key, Dir: true}
+		for k, v := range m.values {
+			if strings.HasPrefix(k, key) {
+				dir.Nodes = append(dir.Nodes, &etcd.Node{Key: k, Value: v})
+			}
+		}
+		return &etcd.Response{Action: "get", Node: dir}, nil
+	}
+
+	value, found := m.values[key]
+	if !found {
+		return nil, etcd.Error{Code: etcd.ErrorCodeKeyNotFound, Message: "Key not found", Cause: key}
+	}
+	return &etcd.Response{Action: "get", Node: &etcd.Node{Key: key, Value: value}}, nil
+}
+
+func (m *memKeysAPI) Set(ctx context.Context, key, value string, opts *etcd.SetOptions) (*etcd.Response, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.values[key] = value
+	return &etcd.Response{Action: "set", Node: &etcd.Node{Key: key, Value: value}}, nil
+}
+
+func (m *memKeysAPI) Delete(ctx context.Context, key string, opts *etcd.DeleteOptions) (*etcd.Response, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	value, found := m.values[key]
+	if !found {
+		return nil, etcd.Error{Code: etcd.ErrorCodeKeyNotFound, Message: "Key not found", Cause: key}
+	}
+	delete(m.values, key)
+	return &etcd.Response{Action: "delete", PrevNode: &etcd.Node{Key: key, Value: value}}, nil
+}
+
+func (m *memKeysAPI) Create(ctx context.Context, key, value string) (*etcd.Response, error) {
+	return m.Set(ctx, key, value, nil)
+}
+
+func (m *memKeysAPI) CreateInOrder(ctx context.Context, dir, value string, opts *etcd.CreateInOrderOptions) (*etcd.Response, error) {
+	return nil, errors.New("not supported")
+}
+
+func (m *memKeysAPI) Update(ctx context.Context, key, value string) (*etcd.Response, error) {
+	return m.Set(ctx, key, value, nil)
+}
+
+func (m *memKeysAPI) Watcher(key string, opts *etcd.WatcherOptions) etcd.Watcher {
+	return &memWatcher{}
+}
+
+func newTestTopologyAPI(t *testing.T) (*TopologyAPI, *graph.Graph) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraphFromConfig(b, common.AnalyzerService)
+
+	nodeRules := &NodeRuleAPI{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &NodeRuleResourceHandler{},
+			EtcdKeyAPI:      newMemKeysAPI(),
+		},
+		Graph: g,
+	}
+
+	return &TopologyAPI{
+		graph:         g,
+		gremlinParser: traversal.NewGremlinTraversalParser(),
+		nodeRules:     nodeRules,
+	}, g
+}
+
+func topologyQuery(t *testing.T, api *TopologyAPI, username, query string) *httptest.ResponseRecorder {
+	data, err := json.Marshal(types.TopologyParam{GremlinQuery: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/api/topology", strings.NewReader(string(data)))
+	w := httptest.NewRecorder()
+	api.topologySearch(w, &auth.AuthenticatedRequest{Request: *r, Username: username})
+
+	return w
+}
+
+func listNodeRules(api *TopologyAPI) (rules []*types.NodeRule) {
+	for _, resource := range api.nodeRules.Index() {
+		rules = append(rules, resource.(*types.NodeRule))
+	}
+	return
+}
+
+func TestTopologyMutationPermission(t *testing.T) {
+	kapi := newMemKeysAPI()
+	kapi.Set(context.Background(), "/casbinPolicy", "", nil)
+	if err := config.InitRBAC(kapi); err != nil {
+		t.Fatal(err)
+	}
+
+	api, g := newTestTopologyAPI(t)
+	n := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0", "Type": "device"})
+
+	query := `G.V().Has('Name', 'eth0').Property('Owner', 'team-x')`
+
+	// the guest role is only allowed to read the topology
+	if w := topologyQuery(t, api, "guest", `G.V().Has('Name', 'eth0')`); w.Code != http.StatusOK {
+		t.Fatalf("Read should be allowed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := topologyQuery(t, api, "guest", query); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Write should be denied, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if _, err := n.GetFieldString("Owner"); err == nil {
+		t.Error("Node should not be modified by a denied query")
+	}
+
+	if rules := listNodeRules(api); len(rules) != 0 {
+		t.Errorf("No node rule should be created by a denied query, got %+v", rules)
+	}
+
+	if w := topologyQuery(t, api, "admin", query); w.Code != http.StatusOK {
+		t.Fatalf("Write should be allowed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if owner, _ := n.GetFieldString("Owner"); owner != "team-x" {
+		t.Errorf("Node should be modified, got %+v", n.Metadata())
+	}
+}
+
+func TestTopologyPropertyPersistence(t *testing.T) {
+	api, g := newTestTopologyAPI(t)
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0", "Type": "device"})
+
+	if w := topologyQuery(t, api, "admin", `G.V().Has('Name', 'eth0').Property('Owner', 'team-x')`); w.Code != http.StatusOK {
+		t.Fatalf("Query should succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// the change is stored as a node rule applied again by the topology manager
+	rules := listNodeRules(api)
+	if len(rules) != 1 {
+		t.Fatalf("Expected one node rule, got %+v", rules)
+	}
+
+	rule := rules[0]
+	if rule.Action != "update" || rule.Query != `G.V().Has('Name', 'eth0')` || rule.Metadata["Owner"] != "team-x" {
+		t.Errorf("Wrong node rule: %+v", rule)
+	}
+}
+
+func TestTopologyDrop(t *testing.T) {
+	api, g := newTestTopologyAPI(t)
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0", "Type": "device"})
+
+	// a node of the probes can't be dropped
+	if w := topologyQuery(t, api, "admin", `G.V().Has('Name', 'eth0').Drop()`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Drop should be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if len(g.GetNodes(graph.Metadata{"Name": "eth0"})) != 1 {
+		t.Error("Node should not be dropped")
+	}
+
+	// a node created by a node rule is dropped along with its rule
+	rule := &types.NodeRule{Action: "create", Metadata: graph.Metadata{"Name": "tap0", "Type": "tap"}}
+	if err := api.nodeRules.Create(rule); err != nil {
+		t.Fatal(err)
+	}
+	g.NewNode(graph.GenID("tap", "tap0"), graph.Metadata{"Name": "tap0", "Type": "tap"})
+
+	if w := topologyQuery(t, api, "admin", `G.V().Has('Name', 'tap0').Drop()`); w.Code != http.StatusOK {
+		t.Fatalf("Drop should succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if len(g.GetNodes(graph.Metadata{"Name": "tap0"})) != 0 {
+		t.Error("Node should be dropped")
+	}
+
+	if rules := listNodeRules(api); len(rules) != 0 {
+		t.Errorf("Node rule should be deleted, got %+v", rules)
+	}
+}
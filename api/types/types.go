@@ -58,12 +58,13 @@ func (b *BasicResource) SetID(i string) {
 // Alert is a set of parameters, the Alert Action will Trigger according to its Expression.
 type Alert struct {
 	BasicResource
-	Name        string `json:",omitempty"`
-	Description string `json:",omitempty"`
-	Expression  string `json:",omitempty" valid:"nonzero"`
-	Action      string `json:",omitempty" valid:"regexp=^(|http://|https://|file://).*$"`
-	Trigger     string `json:",omitempty" valid:"regexp=^(graph|duration:.+|)$"`
-	CreateTime  time.Time
+	Name            string                 `json:",omitempty"`
+	Description     string                 `json:",omitempty"`
+	Expression      string                 `json:",omitempty" valid:"nonzero"`
+	GremlinBindings map[string]interface{} `json:",omitempty"`
+	Action          string                 `json:",omitempty" valid:"regexp=^(|http://|https://|file://).*$"`
+	Trigger         string                 `json:",omitempty" valid:"regexp=^(graph|duration:.+|anomaly(:.+)?|)$"`
+	CreateTime      time.Time
 }
 
 // NewAlert creates a New empty Alert, only UUID and CreateTime are set.
@@ -76,21 +77,26 @@ func NewAlert() *Alert {
 // Capture describes a capture API
 type Capture struct {
 	BasicResource
-	GremlinQuery   string           `json:"GremlinQuery,omitempty" valid:"isGremlinExpr"`
-	BPFFilter      string           `json:"BPFFilter,omitempty" valid:"isBPFFilter"`
-	Name           string           `json:"Name,omitempty"`
-	Description    string           `json:"Description,omitempty"`
-	Type           string           `json:"Type,omitempty"`
-	Count          int              `json:"Count"`
-	PCAPSocket     string           `json:"PCAPSocket,omitempty"`
-	Port           int              `json:"Port,omitempty"`
-	RawPacketLimit int              `json:"RawPacketLimit,omitempty" valid:"isValidRawPacketLimit"`
-	HeaderSize     int              `json:"HeaderSize,omitempty" valid:"isValidCaptureHeaderSize"`
-	ExtraTCPMetric bool             `json:"ExtraTCPMetric"`
-	IPDefrag       bool             `json:"IPDefrag"`
-	ReassembleTCP  bool             `json:"ReassembleTCP"`
-	LayerKeyMode   string           `json:"LayerKeyMode,omitempty" valid:"isValidLayerKeyMode"`
-	ExtraLayers    flow.ExtraLayers `json:"ExtraLayers,omitempty"`
+	GremlinQuery     string                 `json:"GremlinQuery,omitempty" valid:"isGremlinExpr"`
+	GremlinBindings  map[string]interface{} `json:"GremlinBindings,omitempty"`
+	BPFFilter        string                 `json:"BPFFilter,omitempty" valid:"isBPFFilter"`
+	Name             string                 `json:"Name,omitempty"`
+	Description      string                 `json:"Description,omitempty"`
+	Type             string                 `json:"Type,omitempty"`
+	Count            int                    `json:"Count"`
+	PCAPSocket       string                 `json:"PCAPSocket,omitempty"`
+	Port             int                    `json:"Port,omitempty"`
+	RawPacketLimit   int                    `json:"RawPacketLimit,omitempty" valid:"isValidRawPacketLimit"`
+	HeaderSize       int                    `json:"HeaderSize,omitempty" valid:"isValidCaptureHeaderSize"`
+	ExtraTCPMetric   bool                   `json:"ExtraTCPMetric"`
+	IPDefrag         bool                   `json:"IPDefrag"`
+	ReassembleTCP    bool                   `json:"ReassembleTCP"`
+	LayerKeyMode     string                 `json:"LayerKeyMode,omitempty" valid:"isValidLayerKeyMode"`
+	ExtraLayers      flow.ExtraLayers       `json:"ExtraLayers,omitempty"`
+	SamplingRate     int                    `json:"SamplingRate,omitempty" valid:"min=0"`
+	FlowsPerSecond   int                    `json:"FlowsPerSecond,omitempty" valid:"min=0"`
+	PcapRingSize     int                    `json:"PcapRingSize,omitempty" valid:"min=0"`
+	PcapRingDuration int                    `json:"PcapRingDuration,omitempty" valid:"min=0"`
 }
 
 // NewCapture creates a new capture
@@ -198,7 +204,16 @@ func (pi *PacketInjection) Validate() error {
 
 // TopologyParam topology API parameter
 type TopologyParam struct {
-	GremlinQuery string `json:"GremlinQuery,omitempty" valid:"isGremlinExpr"`
+	GremlinQuery    string                 `json:"GremlinQuery,omitempty" valid:"isGremlinMutationExpr"`
+	GremlinBindings map[string]interface{} `json:"GremlinBindings,omitempty"`
+}
+
+// PcapParam pcap download API parameter
+type PcapParam struct {
+	From         time.Time `json:"From,omitempty"`
+	To           time.Time `json:"To,omitempty"`
+	BPFFilter    string    `json:"BPFFilter,omitempty" valid:"isBPFFilter"`
+	GremlinQuery string    `json:"GremlinQuery,omitempty" valid:"isGremlinExpr"`
 }
 
 // WorkflowChoice describes one value within a choice
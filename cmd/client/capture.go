@@ -50,6 +50,10 @@ var (
 	reassembleTCP      bool
 	layerKeyMode       string
 	extraLayers        []string
+	samplingRate       int
+	flowsPerSecond     int
+	pcapRingSize       int
+	pcapRingDuration   int
 )
 
 // CaptureCmd skdyive capture root command
@@ -96,6 +100,10 @@ var CaptureCreate = &cobra.Command{
 		capture.LayerKeyMode = layerKeyMode
 		capture.RawPacketLimit = rawPacketLimit
 		capture.ExtraLayers = layers
+		capture.SamplingRate = samplingRate
+		capture.FlowsPerSecond = flowsPerSecond
+		capture.PcapRingSize = pcapRingSize
+		capture.PcapRingDuration = pcapRingDuration
 
 		if err := validator.Validate(capture); err != nil {
 			exitOnError(err)
@@ -202,6 +210,10 @@ func addCaptureFlags(cmd *cobra.Command) {
 	cmd.Flags().BoolVarP(&ipDefrag, "ip-defrag", "", false, "Defragment IPv4 packets, default: false")
 	cmd.Flags().BoolVarP(&reassembleTCP, "reassamble-tcp", "", false, "Reassemble TCP packets, default: false")
 	cmd.Flags().StringVarP(&layerKeyMode, "layer-key-mode", "", "L2", "Defines the first layer used by flow key calculation, L2 or L3")
+	cmd.Flags().IntVarP(&samplingRate, "sampling-rate", "", 0, "Capture 1 packet out of N, metrics being scaled accordingly, default: 0 no sampling")
+	cmd.Flags().IntVarP(&flowsPerSecond, "flows-per-second", "", 0, "Budget of new flows per second, sampling the flows above it, default: 0 no limit")
+	cmd.Flags().IntVarP(&pcapRingSize, "pcap-ring-size", "", 0, "Keep the last captured packets on disk in a pcap ring buffer of this size in MB, default: 0 no ring buffer")
+	cmd.Flags().IntVarP(&pcapRingDuration, "pcap-ring-duration", "", 0, "Keep the packets captured during the last N seconds on disk in a pcap ring buffer, default: 0 no duration limit")
 	cmd.Flags().StringArrayVarP(&extraLayers, "extra-layer", "", []string{}, fmt.Sprintf("List of extra layers to be added to the flow, available: %s", flow.ExtraLayers(flow.ALLLayer)))
 }
 
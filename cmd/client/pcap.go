@@ -23,19 +23,30 @@
 package client
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/skydive-project/skydive/api/client"
+	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/validator"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	pcapTrace string
+	pcapTrace   string
+	pcapFrom    string
+	pcapTo      string
+	pcapBPF     string
+	pcapGremlin string
+	pcapOutput  string
 )
 
 // PcapCmd skydive pcap root command
@@ -76,6 +87,88 @@ var PcapCmd = &cobra.Command{
 	},
 }
 
+// parsePcapTime parses either a RFC3339 time or a duration before now
+func parsePcapTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time '%s', expected a RFC3339 time or a duration", s)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// PcapDownload skydive pcap download command
+var PcapDownload = &cobra.Command{
+	Use:   "download",
+	Short: "Download packets from the capture pcap ring buffers",
+	Long:  "Download packets from the capture pcap ring buffers",
+	Run: func(cmd *cobra.Command, args []string) {
+		var param types.PcapParam
+		var err error
+
+		if param.From, err = parsePcapTime(pcapFrom); err != nil {
+			exitOnError(err)
+		}
+		if param.To, err = parsePcapTime(pcapTo); err != nil {
+			exitOnError(err)
+		}
+		param.BPFFilter = pcapBPF
+		param.GremlinQuery = pcapGremlin
+
+		if err := validator.Validate(param); err != nil {
+			exitOnError(err)
+		}
+
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		body, err := json.Marshal(param)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		resp, err := client.Request("POST", "pcap/download", bytes.NewReader(body), nil)
+		if err != nil {
+			exitOnError(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			content, _ := ioutil.ReadAll(resp.Body)
+			exitOnError(fmt.Errorf("Failed to download packets: %s", string(content)))
+		}
+
+		output := os.Stdout
+		if pcapOutput != "" {
+			if output, err = os.Create(pcapOutput); err != nil {
+				exitOnError(err)
+			}
+			defer output.Close()
+		}
+
+		if _, err := io.Copy(output, resp.Body); err != nil {
+			exitOnError(err)
+		}
+	},
+}
+
 func init() {
 	PcapCmd.Flags().StringVarP(&pcapTrace, "trace", "t", "", "PCAP trace file to read")
+
+	PcapDownload.Flags().StringVarP(&pcapFrom, "from", "", "", "start of the time range, RFC3339 time or duration before now, ex: 10m")
+	PcapDownload.Flags().StringVarP(&pcapTo, "to", "", "", "end of the time range, RFC3339 time or duration before now, default: now")
+	PcapDownload.Flags().StringVarP(&pcapBPF, "bpf", "", "", "BPF filter applied to the packets")
+	PcapDownload.Flags().StringVarP(&pcapGremlin, "gremlin", "", "", "Gremlin query returning the flows or the nodes to get the packets of")
+	PcapDownload.Flags().StringVarP(&pcapOutput, "output", "o", "", "pcap file to write, default: standard output")
+
+	PcapCmd.AddCommand(PcapDownload)
 }
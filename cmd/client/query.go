@@ -30,6 +30,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -52,6 +53,14 @@ var QueryCmd = &cobra.Command{
 		gremlinQuery = args[0]
 		queryHelper := client.NewGremlinQueryHelper(&AuthenticationOpts)
 
+		if len(queryBindings) > 0 {
+			bindings, err := parseBindings(queryBindings)
+			if err != nil {
+				exitOnError(err)
+			}
+			queryHelper = queryHelper.WithBindings(bindings)
+		}
+
 		switch outputFormat {
 		case "json":
 			data, err := queryHelper.QueryRaw(gremlinQuery)
@@ -62,6 +71,15 @@ var QueryCmd = &cobra.Command{
 			var out bytes.Buffer
 			json.Indent(&out, data, "", "\t")
 			out.WriteTo(os.Stdout)
+		case "table":
+			data, err := queryHelper.QueryRaw(gremlinQuery)
+			if err != nil {
+				exitOnError(err)
+			}
+
+			if err := printTable(os.Stdout, data); err != nil {
+				exitOnError(err)
+			}
 		case "dot":
 			header := make(http.Header)
 			header.Set("Accept", "vnd.graphviz")
@@ -98,6 +116,28 @@ var QueryCmd = &cobra.Command{
 	},
 }
 
+var queryBindings []string
+
+// parseBindings returns the values of the bind variables given as name=value,
+// values being decoded as JSON when possible, as strings otherwise
+func parseBindings(defs []string) (map[string]interface{}, error) {
+	bindings := make(map[string]interface{})
+	for _, def := range defs {
+		kv := strings.SplitN(def, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("bind variables must be defined as name=value: %s", def)
+		}
+
+		var value interface{}
+		if err := json.Unmarshal([]byte(kv[1]), &value); err != nil {
+			value = kv[1]
+		}
+		bindings[strings.TrimPrefix(kv[0], "$")] = value
+	}
+	return bindings, nil
+}
+
 func init() {
-	QueryCmd.Flags().StringVarP(&outputFormat, "format", "", "json", "Output format (json, dot or pcap)")
+	QueryCmd.Flags().StringVarP(&outputFormat, "format", "", "json", "Output format (json, dot, pcap or table)")
+	QueryCmd.Flags().StringArrayVarP(&queryBindings, "bind", "", []string{}, "Value of a bind variable of the query, as name=value")
 }
@@ -0,0 +1,245 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// orderedObject is a JSON object keeping the order of its keys, so that
+// the columns follow the keys given to the Project step
+type orderedObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// MarshalJSON serialize in JSON
+func (o *orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func decodeOrdered(d *json.Decoder) (interface{}, error) {
+	token, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		obj := &orderedObject{values: make(map[string]interface{})}
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := decodeOrdered(d)
+			if err != nil {
+				return nil, err
+			}
+
+			obj.keys = append(obj.keys, key.(string))
+			obj.values[key.(string)] = value
+		}
+		_, err = d.Token()
+		return obj, err
+	case json.Delim('['):
+		array := []interface{}{}
+		for d.More() {
+			value, err := decodeOrdered(d)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = d.Token()
+		return array, err
+	}
+
+	return token, nil
+}
+
+func cell(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return fmt.Sprintf("%t", value)
+	}
+
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func writeRow(w io.Writer, cells []string) {
+	fmt.Fprintln(w, strings.Join(cells, "\t"))
+}
+
+// leaf is a value of nested objects along with the keys leading to it
+type leaf struct {
+	path  []string
+	value interface{}
+}
+
+func leaves(obj *orderedObject, path []string) (l []leaf) {
+	for _, key := range obj.keys {
+		p := append(append([]string{}, path...), key)
+		if sub, ok := obj.values[key].(*orderedObject); ok && len(sub.keys) > 0 {
+			l = append(l, leaves(sub, p)...)
+		} else {
+			l = append(l, leaf{path: p, value: obj.values[key]})
+		}
+	}
+	return
+}
+
+// writeObjectsTable writes one row per object, the columns being the keys
+// in the order of their first appearance
+func writeObjectsTable(w io.Writer, objects []*orderedObject) {
+	var columns []string
+	seen := make(map[string]bool)
+	for _, obj := range objects {
+		for _, key := range obj.keys {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+	}
+
+	writeRow(w, columns)
+	for _, obj := range objects {
+		cells := make([]string, len(columns))
+		for i, column := range columns {
+			cells[i] = cell(obj.values[column])
+		}
+		writeRow(w, cells)
+	}
+}
+
+// writeGroupsTable writes one row per leaf of nested objects, like the
+// ones returned by the GroupCount step, with a column per level of keys
+func writeGroupsTable(w io.Writer, obj *orderedObject) {
+	l := leaves(obj, nil)
+
+	depth, counts := 0, true
+	for _, leaf := range l {
+		if len(leaf.path) > depth {
+			depth = len(leaf.path)
+		}
+		if _, ok := leaf.value.(json.Number); !ok {
+			counts = false
+		}
+	}
+
+	header := make([]string, depth+1)
+	for i := 0; i < depth; i++ {
+		header[i] = "KEY"
+		if depth > 1 {
+			header[i] = fmt.Sprintf("KEY%d", i+1)
+		}
+	}
+	header[depth] = "VALUE"
+	if counts {
+		header[depth] = "COUNT"
+	}
+
+	writeRow(w, header)
+	for _, leaf := range l {
+		cells := make([]string, depth+1)
+		copy(cells, leaf.path)
+		cells[depth] = cell(leaf.value)
+		writeRow(w, cells)
+	}
+}
+
+// printTable writes the JSON result of a query as a table. Arrays of
+// objects, like the result of the Project step, are written with a column
+// per key, objects with a row per value and other values in a single column.
+func printTable(w io.Writer, data []byte) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	result, err := decodeOrdered(d)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	switch result := result.(type) {
+	case *orderedObject:
+		writeGroupsTable(tw, result)
+	case []interface{}:
+		var objects []*orderedObject
+		for _, value := range result {
+			if obj, ok := value.(*orderedObject); ok {
+				objects = append(objects, obj)
+			}
+		}
+
+		if len(objects) > 0 && len(objects) == len(result) {
+			writeObjectsTable(tw, objects)
+			break
+		}
+
+		writeRow(tw, []string{"VALUE"})
+		for _, value := range result {
+			writeRow(tw, []string{cell(value)})
+		}
+	default:
+		writeRow(tw, []string{"VALUE"})
+		writeRow(tw, []string{cell(result)})
+	}
+
+	return tw.Flush()
+}
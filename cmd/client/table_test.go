@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func table(t *testing.T, data string) string {
+	var buf bytes.Buffer
+	if err := printTable(&buf, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the padding of the last column doesn't matter
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDecodeOrdered(t *testing.T) {
+	data := `{"zeta":1,"alpha":{"y":[1,"a"],"b":null}}`
+
+	d := json.NewDecoder(strings.NewReader(data))
+	d.UseNumber()
+
+	value, err := decodeOrdered(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, ok := value.(*orderedObject)
+	if !ok || strings.Join(obj.keys, ",") != "zeta,alpha" {
+		t.Fatalf("Keys should keep their order, got %+v", value)
+	}
+
+	encoded, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(encoded) != data {
+		t.Errorf("Expected %s, got %s", data, encoded)
+	}
+}
+
+func TestPrintTable(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{
+			"projections",
+			`[{"name":"eth0","mtu":1500,"up":true},{"name":"eth1","mtu":9000}]`,
+			"name  mtu   up\n" +
+				"eth0  1500  true\n" +
+				"eth1  9000",
+		},
+		{
+			"nested group counts",
+			`{"ovs":{"eth0":2,"eth1":1},"docker":{"veth0":3}}`,
+			"KEY1    KEY2   COUNT\n" +
+				"ovs     eth0   2\n" +
+				"ovs     eth1   1\n" +
+				"docker  veth0  3",
+		},
+		{
+			"group counts",
+			`{"veth":3,"device":1}`,
+			"KEY     COUNT\n" +
+				"veth    3\n" +
+				"device  1",
+		},
+		{
+			"values",
+			`["a",{"b":1}]`,
+			"VALUE\n" +
+				"a\n" +
+				`{"b":1}`,
+		},
+		{
+			"scalar",
+			`42`,
+			"VALUE\n" +
+				"42",
+		},
+	}
+
+	for _, test := range tests {
+		if output := table(t, test.data); output != test.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.name, test.expected, output)
+		}
+	}
+}
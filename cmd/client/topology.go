@@ -56,5 +56,5 @@ var TopologyRequest = &cobra.Command{
 func init() {
 	TopologyCmd.AddCommand(TopologyRequest)
 	TopologyRequest.Flags().StringVarP(&gremlinQuery, "gremlin", "", "G", "Gremlin Query")
-	TopologyRequest.Flags().StringVarP(&outputFormat, "format", "", "json", "Output format (json, dot or pcap)")
+	TopologyRequest.Flags().StringVarP(&outputFormat, "format", "", "json", "Output format (json, dot, pcap or table)")
 }
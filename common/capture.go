@@ -42,6 +42,10 @@ const (
 	RawPacketsCapability = 2
 	// ExtraTCPMetricCapability the probe can report TCP metrics
 	ExtraTCPMetricCapability = 4
+	// SamplingCapability the probe can sample the captured packets
+	SamplingCapability = 8
+	// PcapRingCapability the probe can keep the captured packets in a pcap ring buffer
+	PcapRingCapability = 16
 )
 
 var (
@@ -66,7 +70,7 @@ func initCaptureTypes() {
 	}
 
 	for _, t := range types {
-		CaptureTypes[t] = CaptureType{Allowed: []string{"afpacket", "pcap", "pcapsocket", "sflow", "ebpf"}, Default: "afpacket"}
+		CaptureTypes[t] = CaptureType{Allowed: []string{"afpacket", "pcap", "pcapsocket", "sflow", "ipfix", "netflow", "ebpf"}, Default: "afpacket"}
 	}
 }
 
@@ -77,12 +81,12 @@ func IsCaptureAllowed(nodeType string) bool {
 }
 
 func initProbeCapabilities() {
-	ProbeCapabilities["afpacket"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
-	ProbeCapabilities["pcap"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
+	ProbeCapabilities["afpacket"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability | SamplingCapability | PcapRingCapability
+	ProbeCapabilities["pcap"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability | SamplingCapability | PcapRingCapability
 	ProbeCapabilities["pcapsocket"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
 	ProbeCapabilities["sflow"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
 	ProbeCapabilities["ovssflow"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
-	ProbeCapabilities["afpacket"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
+	ProbeCapabilities["afpacket"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability | SamplingCapability | PcapRingCapability
 	ProbeCapabilities["dpdk"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
 	ProbeCapabilities["ovsmirror"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
 }
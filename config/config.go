@@ -73,11 +73,14 @@ func init() {
 	cfg = viper.New()
 
 	cfg.SetDefault("agent.auth.api.backend", "noauth")
+	cfg.SetDefault("agent.capture.pcap_ring.max_size", 100)
+	cfg.SetDefault("agent.capture.pcap_ring.path", "/var/lib/skydive/pcap")
 	cfg.SetDefault("agent.capture.stats_update", 1)
 	cfg.SetDefault("agent.flow.probes", []string{"gopacket", "pcapsocket"})
 	cfg.SetDefault("agent.flow.pcapsocket.bind_address", "127.0.0.1")
 	cfg.SetDefault("agent.flow.pcapsocket.min_port", 8100)
 	cfg.SetDefault("agent.flow.pcapsocket.max_port", 8132)
+	cfg.SetDefault("agent.gremlin.cache_size", 1000)
 	cfg.SetDefault("agent.listen", "127.0.0.1:8081")
 	cfg.SetDefault("agent.topology.probes", []string{"ovsdb"})
 	cfg.SetDefault("agent.topology.netlink.metrics_update", 30)
@@ -90,10 +93,18 @@ func init() {
 	cfg.SetDefault("agent.topology.socketinfo.host_update", 10)
 	cfg.SetDefault("agent.X509_servername", "")
 
+	cfg.SetDefault("analyzer.alert.anomaly.interval", 30)
+	cfg.SetDefault("analyzer.alert.anomaly.min_samples", 20)
+	cfg.SetDefault("analyzer.alert.anomaly.threshold", 3.0)
 	cfg.SetDefault("analyzer.auth.cluster.backend", "noauth")
 	cfg.SetDefault("analyzer.auth.api.backend", "noauth")
 	cfg.SetDefault("analyzer.flow.backend", "memory")
+	cfg.SetDefault("analyzer.flow.ipfix.enterprise_id", 2312)
+	cfg.SetDefault("analyzer.flow.ipfix.max_pending", 100)
+	cfg.SetDefault("analyzer.flow.ipfix.observation_domain", 0)
+	cfg.SetDefault("analyzer.flow.ipfix.template_refresh", 60)
 	cfg.SetDefault("analyzer.flow.max_buffer_size", 100000)
+	cfg.SetDefault("analyzer.gremlin.cache_size", 1000)
 	cfg.SetDefault("analyzer.listen", "127.0.0.1:8082")
 	cfg.SetDefault("analyzer.replication.debug", false)
 	cfg.SetDefault("analyzer.topology.backend", "memory")
@@ -118,6 +129,7 @@ func init() {
 	cfg.SetDefault("etcd.name", host)
 	cfg.SetDefault("etcd.listen", fmt.Sprintf("127.0.0.1:%d", etcdDefaultPort))
 
+	cfg.SetDefault("flow.dns_cache_size", 10000)
 	cfg.SetDefault("flow.expire", 600)
 	cfg.SetDefault("flow.update", 60)
 	cfg.SetDefault("flow.protocol", "udp")
@@ -130,6 +142,10 @@ func init() {
 	cfg.SetDefault("http.ws.queue_size", 10000)
 	cfg.SetDefault("http.ws.enable_write_compression", true)
 
+	cfg.SetDefault("ipfix.bind_address", "0.0.0.0")
+	cfg.SetDefault("ipfix.port_min", 4739)
+	cfg.SetDefault("ipfix.port_max", 4749)
+
 	cfg.SetDefault("logging.backends", []string{"stderr"})
 	cfg.SetDefault("logging.color", true)
 	cfg.SetDefault("logging.encoder", "")
@@ -156,6 +172,10 @@ func init() {
 	cfg.SetDefault("rbac.model.policy_effect", []string{"some(where (p_eft == allow)) && !some(where (p_eft == deny))"})
 	cfg.SetDefault("rbac.model.matchers", []string{"g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act"})
 
+	cfg.SetDefault("storage.boltdb.driver", "boltdb")                // defined for backward compatibility and to set defaults
+	cfg.SetDefault("storage.boltdb.path", "/var/lib/skydive")        // defined for backward compatibility and to set defaults
+	cfg.SetDefault("storage.boltdb.max_age", 0)                      // defined for backward compatibility and to set defaults
+	cfg.SetDefault("storage.boltdb.max_size", 0)                     // defined for backward compatibility and to set defaults
 	cfg.SetDefault("storage.elasticsearch.driver", "elasticsearch")  // defined for backward compatibility and to set defaults
 	cfg.SetDefault("storage.elasticsearch.host", "127.0.0.1:9200")   // defined for backward compatibility and to set defaults
 	cfg.SetDefault("storage.elasticsearch.bulk_maxdelay", 5)         // defined for backward compatibility and to set defaults
@@ -168,6 +188,12 @@ func init() {
 	cfg.SetDefault("storage.orientdb.database", "Skydive")           // defined for backward compatibility and to set defaults
 	cfg.SetDefault("storage.orientdb.username", "root")              // defined for backward compatibility and to set defaults
 	cfg.SetDefault("storage.orientdb.password", "root")              // defined for backward compatibility and to set defaults
+	cfg.SetDefault("storage.postgres.driver", "postgres")            // defined for backward compatibility and to set defaults
+	cfg.SetDefault("storage.postgres.addr", "127.0.0.1:5432")        // defined for backward compatibility and to set defaults
+	cfg.SetDefault("storage.postgres.database", "skydive")           // defined for backward compatibility and to set defaults
+	cfg.SetDefault("storage.postgres.username", "skydive")           // defined for backward compatibility and to set defaults
+	cfg.SetDefault("storage.postgres.password", "")                  // defined for backward compatibility and to set defaults
+	cfg.SetDefault("storage.postgres.ssl_mode", "disable")           // defined for backward compatibility and to set defaults
 
 	cfg.SetDefault("ui", map[string]interface{}{})
 
@@ -81,6 +81,34 @@ func (a *TableAllocator) QueryTable(query *TableQuery) *TableReply {
 	return a.aggregateReplies(query, replies)
 }
 
+// TableSizes returns the number of flows of the tables indexed by the TID of
+// the node they capture on
+func (a *TableAllocator) TableSizes() map[string]int64 {
+	a.RLock()
+	defer a.RUnlock()
+
+	sizes := make(map[string]int64)
+	for table := range a.tables {
+		sizes[table.nodeTID] = table.Size()
+	}
+
+	return sizes
+}
+
+// FindTable returns the table capturing on the node of the given TID
+func (a *TableAllocator) FindTable(nodeTID string) *Table {
+	a.RLock()
+	defer a.RUnlock()
+
+	for table := range a.tables {
+		if table.nodeTID == nodeTID {
+			return table
+		}
+	}
+
+	return nil
+}
+
 // Alloc instanciate/allocate a new table
 func (a *TableAllocator) Alloc(flowCallBack ExpireUpdateFunc, nodeTID string, opts TableOpts) *Table {
 	a.Lock()
@@ -47,14 +47,16 @@ func (a *ApplicationPortMap) application(srcPort, dstPort int, protoMap map[int]
 	return "", false
 }
 
-func (a *ApplicationPortMap) tcpApplication(srcPort, dstPort int) (string, bool) {
+// TCPApplication returns the name of the service of the TCP ports
+func (a *ApplicationPortMap) TCPApplication(srcPort, dstPort int) (string, bool) {
 	if a == nil {
 		return "", false
 	}
 	return a.application(srcPort, dstPort, a.TCP)
 }
 
-func (a *ApplicationPortMap) udpApplication(srcPort, dstPort int) (string, bool) {
+// UDPApplication returns the name of the service of the UDP ports
+func (a *ApplicationPortMap) UDPApplication(srcPort, dstPort int) (string, bool) {
 	if a == nil {
 		return "", false
 	}
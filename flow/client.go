@@ -23,7 +23,15 @@
 package flow
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
 	"github.com/golang/protobuf/proto"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/filters"
@@ -138,6 +146,140 @@ func (f *WSTableClient) LookupFlowsByNodes(hnmap topology.HostNodeTIDMap, flowSe
 	return flowset, nil
 }
 
+// pcapPageSize is the maximum size of the pages of packets requested to the
+// agents, the packets being merged as the pages are received
+const pcapPageSize = 1024 * 1024
+
+// pcapStream reads the packets of the pcap ring buffer of a capture, the
+// next page being requested to the agent once the current one is read
+type pcapStream struct {
+	client *WSTableClient
+	host   string
+	query  PcapQuery
+	page   *PcapPage
+	reader *pcapgo.Reader
+}
+
+func newPcapStream(client *WSTableClient, host string, query PcapQuery, page *PcapPage) (*pcapStream, error) {
+	reader, err := pcapgo.NewReader(bytes.NewReader(page.Pcap))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pcap page from %s: %s", host, err)
+	}
+
+	query.NodeTIDs = []string{page.NodeTID}
+	return &pcapStream{client: client, host: host, query: query, page: page, reader: reader}, nil
+}
+
+// LinkType returns the link type of the packets
+func (s *pcapStream) LinkType() layers.LinkType {
+	return s.reader.LinkType()
+}
+
+// ReadPacketData returns the next packet, io.EOF once all were read
+func (s *pcapStream) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		data, ci, err := s.reader.ReadPacketData()
+		if err != io.EOF {
+			return data, ci, err
+		}
+
+		if s.page.Next == nil {
+			return nil, gopacket.CaptureInfo{}, io.EOF
+		}
+
+		s.query.Cursor = s.page.Next
+		pages, err := s.client.queryPcap(s.host, &s.query)
+		if err != nil {
+			return nil, gopacket.CaptureInfo{}, err
+		}
+
+		// the remaining packets may have been removed from the ring meanwhile
+		if len(pages) == 0 {
+			return nil, gopacket.CaptureInfo{}, io.EOF
+		}
+
+		if s.reader, err = pcapgo.NewReader(bytes.NewReader(pages[0].Pcap)); err != nil {
+			return nil, gopacket.CaptureInfo{}, fmt.Errorf("Invalid pcap page from %s: %s", s.host, err)
+		}
+		s.page = pages[0]
+	}
+}
+
+func (f *WSTableClient) queryPcap(host string, query *PcapQuery) ([]*PcapPage, error) {
+	obj, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	tq := TableQuery{Type: "PcapQuery", Obj: obj}
+	msg := ws.NewStructMessage(Namespace, "TableQuery", tq)
+
+	resp, err := f.structServer.Request(host, msg, ws.DefaultRequestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to send message to agent %s: %s", host, err)
+	}
+
+	var reply TableReply
+	if resp == nil || resp.UnmarshalObj(&reply) != nil {
+		return nil, fmt.Errorf("Error returned while reading TableReply from: %s", host)
+	}
+
+	var pages []*PcapPage
+	for _, obj := range reply.Obj {
+		var page PcapPage
+		if err := json.Unmarshal(obj, &page); err != nil {
+			return nil, fmt.Errorf("Unable to decode pcap page from %s: %s", host, err)
+		}
+		pages = append(pages, &page)
+	}
+
+	return pages, nil
+}
+
+func (f *WSTableClient) lookupPcap(streams chan []PcapReader, host string, query PcapQuery) {
+	pages, err := f.queryPcap(host, &query)
+	if err != nil {
+		logging.GetLogger().Error(err)
+		streams <- nil
+		return
+	}
+
+	var readers []PcapReader
+	for _, page := range pages {
+		stream, err := newPcapStream(f, host, query, page)
+		if err != nil {
+			logging.GetLogger().Error(err)
+			continue
+		}
+		readers = append(readers, stream)
+	}
+
+	streams <- readers
+}
+
+// LookupPcap retrieves from the pcap ring buffers of the agents the packets
+// matching the query, as one reader per capture. Only the first page of
+// packets of every capture is retrieved, the next ones being requested while
+// the packets are read.
+func (f *WSTableClient) LookupPcap(query *PcapQuery) ([]PcapReader, error) {
+	q := *query
+	q.MaxSize = pcapPageSize
+
+	speakers := f.structServer.GetSpeakersByType(common.AgentService)
+	ch := make(chan []PcapReader, len(speakers))
+
+	for _, c := range speakers {
+		go f.lookupPcap(ch, c.GetRemoteHost(), q)
+	}
+
+	var readers []PcapReader
+	for i := 0; i != len(speakers); i++ {
+		readers = append(readers, <-ch...)
+	}
+
+	return readers, nil
+}
+
 // NewWSTableClient creates a new table client based on websocket
 func NewWSTableClient(w *ws.StructServer) *WSTableClient {
 	return &WSTableClient{structServer: w}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/config"
+)
+
+type dnsCacheEntry struct {
+	name   string
+	expire time.Time
+}
+
+// DNSCache keeps the addresses resolved by the DNS answers seen by a
+// capture so that the following flows can be annotated with the names
+type DNSCache struct {
+	entries map[string]*dnsCacheEntry
+	maxSize int
+}
+
+// NewDNSCache returns a new DNS cache holding up to maxSize addresses
+func NewDNSCache(maxSize int) *DNSCache {
+	return &DNSCache{
+		entries: make(map[string]*dnsCacheEntry),
+		maxSize: maxSize,
+	}
+}
+
+// NewDNSCacheFromConfig returns a new DNS cache sized according to the
+// configuration
+func NewDNSCacheFromConfig() *DNSCache {
+	return NewDNSCache(config.GetInt("flow.dns_cache_size"))
+}
+
+// expire removes the entries expired at the given time
+func (c *DNSCache) expire(now time.Time) {
+	for addr, entry := range c.entries {
+		if now.After(entry.expire) {
+			delete(c.entries, addr)
+		}
+	}
+}
+
+// Update adds the A and AAAA answers of a DNS response, the addresses
+// being associated with the queried name
+func (c *DNSCache) Update(dns *layers.DNS, now time.Time) {
+	if !dns.QR || dns.ResponseCode != layers.DNSResponseCodeNoErr {
+		return
+	}
+
+	for _, answer := range dns.Answers {
+		if answer.Type != layers.DNSTypeA && answer.Type != layers.DNSTypeAAAA {
+			continue
+		}
+
+		name := string(answer.Name)
+		if len(dns.Questions) > 0 {
+			name = string(dns.Questions[0].Name)
+		}
+		name = strings.TrimSuffix(name, ".")
+
+		addr := answer.IP.String()
+		if _, ok := c.entries[addr]; !ok && len(c.entries) >= c.maxSize {
+			c.expire(now)
+			if len(c.entries) >= c.maxSize {
+				continue
+			}
+		}
+
+		c.entries[addr] = &dnsCacheEntry{
+			name:   name,
+			expire: now.Add(time.Duration(answer.TTL) * time.Second),
+		}
+	}
+}
+
+// Lookup returns the name resolved for the given address
+func (c *DNSCache) Lookup(addr string, now time.Time) (string, bool) {
+	entry, ok := c.entries[addr]
+	if !ok || now.After(entry.expire) {
+		return "", false
+	}
+	return entry.name, true
+}
+
+// annotate sets the names of the network endpoints of a flow
+func (c *DNSCache) annotate(f *Flow, now time.Time) {
+	if f.Network == nil {
+		return
+	}
+
+	if name, ok := c.Lookup(f.Network.A, now); ok {
+		f.Network.AName = name
+	}
+	if name, ok := c.Lookup(f.Network.B, now); ok {
+		f.Network.BName = name
+	}
+}
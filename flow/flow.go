@@ -80,6 +80,9 @@ type Packet struct {
 	Length   int64            // length of the original packet meaning layers + payload
 	IPMetric *IPMetric
 
+	SamplingRate     int64 // sampling rate applied by the capture, metrics are scaled accordingly
+	FlowSamplingRate int64 // rate of the flows kept by the capture, metrics are exact
+
 	linkLayer      gopacket.LinkLayer      // fast access to link layer
 	networkLayer   gopacket.NetworkLayer   // fast access to network layer
 	transportLayer gopacket.TransportLayer // fast access to transport layer
@@ -116,6 +119,10 @@ const (
 	DNSLayer ExtraLayers = 2
 	// DHCPv4Layer extra layer
 	DHCPv4Layer ExtraLayers = 4
+	// HTTPLayer extra layer, decoded from the reassembled TCP streams
+	HTTPLayer ExtraLayers = 8
+	// TLSLayer extra layer, decoded from the TLS handshake
+	TLSLayer ExtraLayers = 16
 	// ALLLayer all extra layers
 	ALLLayer ExtraLayers = 255
 )
@@ -124,6 +131,8 @@ var extraLayersMap = map[string]ExtraLayers{
 	"VRRP":   VRRPLayer,
 	"DNS":    DNSLayer,
 	"DHCPv4": DHCPv4Layer,
+	"HTTP":   HTTPLayer,
+	"TLS":    TLSLayer,
 }
 
 // Parse set the ExtraLayers struct with the given list of protocol strings
@@ -588,6 +597,15 @@ func (f *Flow) Update(packet *Packet, opts Opts) {
 	f.Last = now
 	f.Metric.Last = now
 
+	var prev *FlowMetric
+	if packet.SamplingRate > 1 {
+		prev = f.Metric.Copy()
+		f.SamplingRate = packet.SamplingRate
+	}
+	if packet.FlowSamplingRate > 0 {
+		f.FlowSamplingRate = packet.FlowSamplingRate
+	}
+
 	if opts.LayerKeyMode == L3PreferedKeyMode {
 		// use the ethernet length as we want to get the full size and we want to
 		// rely on the l3 address order.
@@ -604,9 +622,16 @@ func (f *Flow) Update(packet *Packet, opts Opts) {
 			f.updateMetricsWithNetworkLayer(packet, 0)
 		}
 	}
+
+	if prev != nil {
+		f.Metric.scale(prev, packet.SamplingRate)
+	}
 	if f.TCPMetric != nil {
 		f.updateTCPMetrics(packet)
 	}
+	if (opts.ExtraLayers & TLSLayer) != 0 {
+		f.updateTLSLayer(packet)
+	}
 }
 
 func (f *Flow) newLinkLayer(packet *Packet) error {
@@ -865,7 +890,7 @@ func (f *Flow) newTransportLayer(packet *Packet, opts Opts) error {
 		srcPort, dstPort := int(transportPacket.SrcPort), int(transportPacket.DstPort)
 		f.Transport.A, f.Transport.B = int64(srcPort), int64(dstPort)
 
-		if app, ok := opts.AppPortMap.tcpApplication(srcPort, dstPort); ok {
+		if app, ok := opts.AppPortMap.TCPApplication(srcPort, dstPort); ok {
 			f.Application = app
 		}
 
@@ -879,7 +904,7 @@ func (f *Flow) newTransportLayer(packet *Packet, opts Opts) error {
 		srcPort, dstPort := int(transportPacket.SrcPort), int(transportPacket.DstPort)
 		f.Transport.A, f.Transport.B = int64(srcPort), int64(dstPort)
 
-		if app, ok := opts.AppPortMap.udpApplication(srcPort, dstPort); ok {
+		if app, ok := opts.AppPortMap.UDPApplication(srcPort, dstPort); ok {
 			f.Application = app
 		}
 	} else if layer := packet.Layer(layers.LayerTypeSCTP); layer != nil {
@@ -1090,6 +1115,10 @@ func (f *FlowLayer) GetStringField(field string) (string, error) {
 		return f.A, nil
 	case "B":
 		return f.B, nil
+	case "AName":
+		return f.AName, nil
+	case "BName":
+		return f.BName, nil
 	case "Protocol":
 		return f.Protocol.String(), nil
 	}
@@ -1305,6 +1334,10 @@ func (f *Flow) GetFieldInt64(field string) (_ int64, err error) {
 		return f.Start, nil
 	case "RTT":
 		return f.RTT, nil
+	case "SamplingRate":
+		return f.SamplingRate, nil
+	case "FlowSamplingRate":
+		return f.FlowSamplingRate, nil
 	}
 
 	fields := strings.Split(field, ".")
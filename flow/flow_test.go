@@ -169,6 +169,60 @@ func TestFlowTCPSegments(t *testing.T) {
 	}
 }
 
+func TestFlowHTTPLayer(t *testing.T) {
+	opt := TableOpts{ExtraLayers: HTTPLayer}
+
+	table := NewTable(nil, nil, "", opt)
+	fillTableFromPCAP(t, table, "pcaptraces/eth-ip4-arp-dns-req-http-google.pcap", layers.LinkTypeEthernet, nil)
+	table.tcpAssembler.FlushAll()
+
+	searchQuery := &filters.SearchQuery{
+		Filter: filters.NewGteInt64Filter("HTTP.StatusCode", 300),
+	}
+
+	flows := table.getFlows(searchQuery).GetFlows()
+	if len(flows) != 1 {
+		t.Fatalf("A single flow with a 3xx status code expected, got : %v", flows)
+	}
+
+	h := flows[0].HTTP
+	if h.Method != "GET" || h.Host != "www.google.com" || h.Path != "/" || h.StatusCode != 302 {
+		t.Errorf("Wrong HTTP layer, got : %+v", h)
+	}
+	if h.Latency != 2171000 {
+		t.Errorf("HTTP latency must be 2171000 got : %d", h.Latency)
+	}
+
+	searchQuery = &filters.SearchQuery{
+		Filter: filters.NewTermStringFilter("HTTP.Host", "www.google.fr"),
+	}
+
+	flows = table.getFlows(searchQuery).GetFlows()
+	if len(flows) != 1 || flows[0].HTTP.StatusCode != 200 || flows[0].HTTP.Latency != 35669000 {
+		t.Errorf("A single flow with a 200 status code expected, got : %v", flows)
+	}
+}
+
+func TestFlowDNSCache(t *testing.T) {
+	opt := TableOpts{ExtraLayers: DNSLayer}
+	flows := flowsFromPCAP(t, "pcaptraces/eth-ip4-arp-dns-req-http-google.pcap", layers.LinkTypeEthernet, nil, opt)
+
+	names := make(map[string]string)
+	for _, f := range flows {
+		if f.Transport != nil && f.Transport.B == 80 {
+			names[f.Network.B] = f.Network.BName
+		}
+	}
+
+	expected := map[string]string{
+		"173.194.40.147": "www.google.com",
+		"216.58.211.67":  "www.google.fr",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Flows must be annotated with the resolved names, expected %v got : %v", expected, names)
+	}
+}
+
 func TestBPFFilter(t *testing.T) {
 	bpf, err := NewBPF(layers.LinkTypeEthernet, DefaultCaptureLength, "port 53 or port 80")
 	if err != nil {
@@ -0,0 +1,240 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// maximum size of the HTTP headers buffered before giving up decoding
+	httpMaxHeaderSize = 8192
+	// maximum number of requests of a connection waiting for their response
+	httpMaxPendingRequests = 64
+)
+
+var httpMethods = [][]byte{
+	[]byte("GET "),
+	[]byte("POST "),
+	[]byte("PUT "),
+	[]byte("DELETE "),
+	[]byte("HEAD "),
+	[]byte("OPTIONS "),
+	[]byte("PATCH "),
+	[]byte("CONNECT "),
+	[]byte("TRACE "),
+}
+
+var (
+	httpVersionPrefix = []byte("HTTP/")
+	crlf              = []byte("\r\n")
+)
+
+// httpMessage describes a decoded HTTP request or response header
+type httpMessage struct {
+	request  *http.Request
+	response *http.Response
+	chunked  bool
+	seen     time.Time
+}
+
+// httpStream decodes the HTTP/1.x messages of one direction of a TCP stream
+type httpStream struct {
+	buf      []byte
+	seen     time.Time
+	skip     int64
+	chunked  bool // reading the chunks of a body, see RFC 7230 section 4.1
+	trailer  bool // reading the trailer following the last chunk
+	disabled bool
+}
+
+func hasPrefix(b, prefix []byte) bool {
+	if len(b) < len(prefix) {
+		return bytes.HasPrefix(prefix, b)
+	}
+	return bytes.HasPrefix(b, prefix)
+}
+
+// isHTTPMessage returns whether the data may be the start of a HTTP message
+func isHTTPMessage(b []byte) bool {
+	if hasPrefix(b, httpVersionPrefix) {
+		return true
+	}
+
+	for _, method := range httpMethods {
+		if hasPrefix(b, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHTTPMessage decodes a HTTP header and returns the length of the body
+// following it, -1 if unknown
+func parseHTTPMessage(header []byte) (*httpMessage, int64, bool) {
+	reader := bufio.NewReader(bytes.NewReader(header))
+
+	if bytes.HasPrefix(header, httpVersionPrefix) {
+		response, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			return nil, 0, false
+		}
+		msg := &httpMessage{response: response, chunked: isChunked(response.TransferEncoding)}
+		return msg, response.ContentLength, true
+	}
+
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		return nil, 0, false
+	}
+	msg := &httpMessage{request: request, chunked: isChunked(request.TransferEncoding)}
+	return msg, request.ContentLength, true
+}
+
+func isChunked(encodings []string) bool {
+	return len(encodings) > 0 && encodings[len(encodings)-1] == "chunked"
+}
+
+// parseChunkSize decodes the size of a chunk, ignoring its extensions
+func parseChunkSize(line []byte) (int64, error) {
+	if i := bytes.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+
+	size, err := strconv.ParseInt(string(bytes.TrimSpace(line)), 16, 64)
+	if err != nil {
+		return 0, err
+	}
+	if size < 0 {
+		return 0, errors.New("negative chunk size")
+	}
+	return size, nil
+}
+
+// feedChunked decodes the line starting a chunk or of the trailer of a
+// chunked body, it returns false if more data is needed
+func (h *httpStream) feedChunked() ([]byte, bool) {
+	end := bytes.Index(h.buf, crlf)
+	if end < 0 {
+		if len(h.buf) > httpMaxHeaderSize {
+			h.disabled = true
+		}
+		return nil, false
+	}
+
+	line, data := h.buf[:end], h.buf[end+2:]
+	h.buf = nil
+
+	if h.trailer {
+		// the trailer ends with an empty line
+		if len(line) == 0 {
+			h.chunked, h.trailer = false, false
+		}
+		return data, true
+	}
+
+	size, err := parseChunkSize(line)
+	if err != nil {
+		h.disabled = true
+		return nil, false
+	}
+
+	if size == 0 {
+		h.trailer = true
+	} else {
+		// chunk data followed by CRLF
+		h.skip = size + 2
+	}
+	return data, true
+}
+
+// feed decodes the given data and returns the HTTP messages it completes.
+// Bodies are skipped according to their content length or their chunks,
+// decoding stops as soon as the stream can not be followed anymore.
+func (h *httpStream) feed(data []byte, seen time.Time) (msgs []*httpMessage) {
+	for len(data) > 0 && !h.disabled {
+		if h.skip > 0 {
+			n := int64(len(data))
+			if n > h.skip {
+				n = h.skip
+			}
+			h.skip -= n
+			data = data[n:]
+			continue
+		}
+
+		if len(h.buf) == 0 {
+			h.seen = seen
+		}
+		h.buf = append(h.buf, data...)
+
+		if h.chunked {
+			var ok bool
+			if data, ok = h.feedChunked(); !ok {
+				return
+			}
+			continue
+		}
+
+		if !isHTTPMessage(h.buf) {
+			h.disabled = true
+			return
+		}
+
+		end := bytes.Index(h.buf, []byte("\r\n\r\n"))
+		if end < 0 {
+			if len(h.buf) > httpMaxHeaderSize {
+				h.disabled = true
+			}
+			return
+		}
+
+		msg, length, ok := parseHTTPMessage(h.buf[:end+4])
+		if !ok {
+			h.disabled = true
+			return
+		}
+		msg.seen = h.seen
+		msgs = append(msgs, msg)
+
+		data, h.buf = h.buf[end+4:], nil
+
+		if msg.chunked {
+			h.chunked = true
+			continue
+		}
+
+		// body until the end of the stream
+		if length < 0 {
+			h.disabled = true
+			return
+		}
+		h.skip = length
+	}
+	return
+}
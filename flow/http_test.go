@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/tcpassembly"
+)
+
+func TestHTTPChunkedBody(t *testing.T) {
+	h := &httpStream{}
+	now := time.Now()
+
+	responses := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"7;ext=1\r\nskydive\r\n" +
+		"10\r\n0123456789abcdef\r\n" +
+		"0\r\nX-Trailer: 1\r\n\r\n" +
+		"HTTP/1.1 404 Not Found\r\nContent-Length: 3\r\n\r\nnot" +
+		"HTTP/1.1 204 No Content\r\n\r\n"
+
+	// feed the responses by small pieces to split the chunk lines
+	var msgs []*httpMessage
+	for data := []byte(responses); len(data) > 0; {
+		n := 5
+		if n > len(data) {
+			n = len(data)
+		}
+		msgs = append(msgs, h.feed(data[:n], now)...)
+		data = data[n:]
+	}
+
+	if h.disabled || len(msgs) != 3 {
+		t.Fatalf("3 responses expected, got %d, disabled: %v", len(msgs), h.disabled)
+	}
+
+	for i, code := range []int{200, 404, 204} {
+		if msgs[i].response.StatusCode != code {
+			t.Errorf("Status code %d expected, got : %d", code, msgs[i].response.StatusCode)
+		}
+	}
+
+	h = &httpStream{}
+	h.feed([]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"), now)
+	if !h.disabled {
+		t.Error("Decoding should stop on an invalid chunk size")
+	}
+}
+
+func newHTTPTestStreams() (*Flow, *TCPAssemblerStream, *TCPAssemblerStream) {
+	f := &Flow{}
+	conn := &httpConn{}
+	return f, &TCPAssemblerStream{flow: f, http: &httpStream{}, httpConn: conn},
+		&TCPAssemblerStream{flow: f, http: &httpStream{}, httpConn: conn}
+}
+
+func TestHTTPPendingRequests(t *testing.T) {
+	f, requests, responses := newHTTPTestStreams()
+	now := time.Now()
+
+	request := []byte("GET / HTTP/1.1\r\nHost: skydive\r\n\r\n")
+	requests.Reassembled([]tcpassembly.Reassembly{{Bytes: request, Seen: now}})
+	responses.Reassembled([]tcpassembly.Reassembly{{Bytes: []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"), Seen: now.Add(time.Millisecond)}})
+
+	if f.HTTP.StatusCode != 200 || f.HTTP.Latency != int64(time.Millisecond) {
+		t.Errorf("Wrong HTTP layer, got : %+v", f.HTTP)
+	}
+
+	// responses not decoded anymore, the pending requests are dropped
+	requests.Reassembled([]tcpassembly.Reassembly{{Bytes: request, Seen: now}})
+	responses.Reassembled([]tcpassembly.Reassembly{{Bytes: []byte("HTTP/1.0 200 OK\r\n\r\n"), Seen: now}})
+
+	for i := 0; i < 10; i++ {
+		requests.Reassembled([]tcpassembly.Reassembly{{Bytes: request, Seen: now}})
+	}
+
+	if responses.http != nil || len(requests.httpConn.requests) != 0 {
+		t.Errorf("No request should be pending, got : %d", len(requests.httpConn.requests))
+	}
+
+	// the number of pending requests is bounded
+	_, requests, _ = newHTTPTestStreams()
+	for i := 0; i < httpMaxPendingRequests*2; i++ {
+		requests.Reassembled([]tcpassembly.Reassembly{{Bytes: request, Seen: now}})
+	}
+
+	if len(requests.httpConn.requests) != 0 || !requests.httpConn.disabled {
+		t.Errorf("Latencies should not be measured anymore, %d requests pending", len(requests.httpConn.requests))
+	}
+}
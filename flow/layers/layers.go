@@ -41,3 +41,24 @@ type LayerDNS struct {
 type LayerVRRPv2 struct {
 	*layers.VRRPv2
 }
+
+// LayerHTTP extra layer holding the last HTTP/1.x transaction of a flow
+//proteus:generate
+type LayerHTTP struct {
+	Method     string
+	Host       string
+	Path       string
+	StatusCode int64
+	Latency    int64
+}
+
+// LayerTLS extra layer holding the TLS handshake metadata of a flow
+//proteus:generate
+type LayerTLS struct {
+	ServerName  string
+	Version     string
+	CipherSuite string
+	ALPN        string
+	JA3         string
+	JA3S        string
+}
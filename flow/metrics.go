@@ -139,6 +139,14 @@ func (fm *FlowMetric) applyRatio(ratio float64) *FlowMetric {
 	}
 }
 
+// scale multiplies by rate the counters increased since prev
+func (fm *FlowMetric) scale(prev *FlowMetric, rate int64) {
+	fm.ABBytes = prev.ABBytes + (fm.ABBytes-prev.ABBytes)*rate
+	fm.ABPackets = prev.ABPackets + (fm.ABPackets-prev.ABPackets)*rate
+	fm.BABytes = prev.BABytes + (fm.BABytes-prev.BABytes)*rate
+	fm.BAPackets = prev.BAPackets + (fm.BAPackets-prev.BAPackets)*rate
+}
+
 // Split a metric into two parts
 func (fm *FlowMetric) Split(cut int64) (common.Metric, common.Metric) {
 	if cut < fm.Start {
@@ -190,8 +190,8 @@ func (o *OnDemandProbeClient) unregisterProbe(node *graph.Node, capture *types.C
 	return true
 }
 
-func (o *OnDemandProbeClient) applyGremlinExpr(query string) []interface{} {
-	res, err := ge.TopologyGremlinQuery(o.graph, query)
+func (o *OnDemandProbeClient) applyGremlinExpr(query string, bindings map[string]interface{}) []interface{} {
+	res, err := ge.TopologyGremlinQueryWithBindings(o.graph, query, bindings)
 	if err != nil {
 		logging.GetLogger().Errorf("Gremlin %s error: %s", query, err)
 		return nil
@@ -213,7 +213,7 @@ func (o *OnDemandProbeClient) checkForRegistrationCallback() {
 	defer o.RUnlock()
 
 	for _, capture := range o.captures {
-		res := o.applyGremlinExpr(capture.GremlinQuery)
+		res := o.applyGremlinExpr(capture.GremlinQuery, capture.GremlinBindings)
 		if len(res) > 0 {
 			go o.registerProbes(res, capture)
 		}
@@ -272,7 +272,7 @@ func (o *OnDemandProbeClient) registerCapture(capture *types.Capture) {
 	o.captures[capture.UUID] = capture
 	o.Unlock()
 
-	nodes := o.applyGremlinExpr(capture.GremlinQuery)
+	nodes := o.applyGremlinExpr(capture.GremlinQuery, capture.GremlinBindings)
 	if len(nodes) > 0 {
 		go o.registerProbes(nodes, capture)
 	}
@@ -30,6 +30,7 @@ import (
 const (
 	Namespace             = "OnDemand"
 	NotificationNamespace = "OnDemandNotification"
+	PacketNamespace       = "OnDemandPacket"
 )
 
 // CaptureQuery describes a query for the capture API
@@ -37,3 +38,20 @@ type CaptureQuery struct {
 	NodeID  string
 	Capture api.Capture
 }
+
+// PacketStreamQuery describes a query to stream the packets of a capture
+type PacketStreamQuery struct {
+	StreamID  string
+	CaptureID string
+}
+
+// StreamedPacket describes a packet of a capture streamed by an agent,
+// Timestamp being in nanoseconds
+type StreamedPacket struct {
+	StreamID  string
+	NodeTID   string
+	Timestamp int64
+	Length    int
+	Data      []byte
+	FlowUUIDs []string
+}
@@ -28,6 +28,7 @@ import (
 
 	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
 	"github.com/skydive-project/skydive/flow/ondemand"
 	"github.com/skydive-project/skydive/flow/probes"
 	"github.com/skydive-project/skydive/logging"
@@ -36,6 +37,10 @@ import (
 	ws "github.com/skydive-project/skydive/websocket"
 )
 
+// number of packets buffered per stream, packets are dropped when the
+// analyzer doesn't read fast enough
+const packetStreamBufferSize = 1000
+
 type activeProbe struct {
 	graph   *graph.Graph
 	node    *graph.Node
@@ -48,10 +53,21 @@ type OnDemandProbeServer struct {
 	common.RWMutex
 	graph.DefaultGraphListener
 	ws.DefaultSpeakerEventHandler
-	Graph        *graph.Graph
-	Probes       *probe.Bundle
-	clientPool   *ws.StructClientPool
-	activeProbes map[graph.Identifier]*activeProbe
+	Graph          *graph.Graph
+	Probes         *probe.Bundle
+	tableAllocator *flow.TableAllocator
+	clientPool     *ws.StructClientPool
+	activeProbes   map[graph.Identifier]*activeProbe
+	packetStreams  map[string]*packetStream
+}
+
+// packetStream forwards to an analyzer the packets of the tables of a capture
+type packetStream struct {
+	query   ondemand.PacketStreamQuery
+	speaker ws.Speaker
+	tables  []*flow.Table
+	packets *flow.PacketChannel
+	quit    chan bool
 }
 
 func (o *OnDemandProbeServer) getProbe(n *graph.Node, capture *types.Capture) (probes.FlowProbe, error) {
@@ -180,8 +196,143 @@ func (p *activeProbe) OnStopped() {
 	p.graph.Unlock()
 }
 
-// OnStructMessage websocket message, valid message type are CaptureStart, CaptureStop
+func (o *OnDemandProbeServer) runPacketStream(s *packetStream) {
+	defer func() {
+		for _, table := range s.tables {
+			table.RemovePacketListener(s.packets)
+		}
+
+		o.Lock()
+		if o.packetStreams[s.query.StreamID] == s {
+			delete(o.packetStreams, s.query.StreamID)
+		}
+		o.Unlock()
+
+		if dropped := s.packets.Dropped(); dropped > 0 {
+			logging.GetLogger().Warningf("%d packets of capture %s dropped while streaming", dropped, s.query.CaptureID)
+		}
+	}()
+
+	active := len(s.tables)
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-s.packets.Stopped:
+			if active--; active == 0 {
+				s.speaker.SendMessage(ws.NewStructMessage(ondemand.PacketNamespace, "PacketStreamStopped", s.query))
+				return
+			}
+		case packet := <-s.packets.Packets:
+			sp := ondemand.StreamedPacket{
+				StreamID:  s.query.StreamID,
+				NodeTID:   packet.NodeTID,
+				Timestamp: packet.CaptureInfo.Timestamp.UnixNano(),
+				Length:    packet.CaptureInfo.Length,
+				Data:      packet.Data,
+				FlowUUIDs: packet.FlowUUIDs,
+			}
+			if err := s.speaker.SendMessage(ws.NewStructMessage(ondemand.PacketNamespace, "Packet", sp)); err != nil {
+				logging.GetLogger().Debugf("Stop streaming capture %s: %s", s.query.CaptureID, err)
+				return
+			}
+		}
+	}
+}
+
+// startPacketStream registers a packet stream on the tables of the nodes of
+// the capture, returns false if the capture is not active on this agent
+func (o *OnDemandProbeServer) startPacketStream(c ws.Speaker, query ondemand.PacketStreamQuery) bool {
+	var tables []*flow.Table
+
+	o.Graph.RLock()
+	for _, n := range o.Graph.GetNodes(graph.Metadata{"Capture.ID": query.CaptureID}) {
+		if tid, _ := n.GetFieldString("TID"); tid != "" {
+			if table := o.tableAllocator.FindTable(tid); table != nil {
+				tables = append(tables, table)
+			}
+		}
+	}
+	o.Graph.RUnlock()
+
+	if len(tables) == 0 {
+		return false
+	}
+
+	s := &packetStream{
+		query:   query,
+		speaker: c,
+		tables:  tables,
+		packets: flow.NewPacketChannel(packetStreamBufferSize, len(tables)),
+		quit:    make(chan bool),
+	}
+
+	o.Lock()
+	o.packetStreams[query.StreamID] = s
+	o.Unlock()
+
+	for _, table := range tables {
+		table.AddPacketListener(s.packets)
+	}
+
+	go o.runPacketStream(s)
+
+	return true
+}
+
+func (o *OnDemandProbeServer) stopPacketStream(streamID string) {
+	o.Lock()
+	defer o.Unlock()
+
+	if s, found := o.packetStreams[streamID]; found {
+		delete(o.packetStreams, streamID)
+		close(s.quit)
+	}
+}
+
+func (o *OnDemandProbeServer) onPacketStreamMessage(c ws.Speaker, msg *ws.StructMessage) {
+	var query ondemand.PacketStreamQuery
+	if err := msg.UnmarshalObj(&query); err != nil {
+		logging.GetLogger().Errorf("Unable to decode packet stream query %v", msg)
+		return
+	}
+
+	switch msg.Type {
+	case "PacketStreamStart":
+		status := http.StatusOK
+		if !o.startPacketStream(c, query) {
+			status = http.StatusNotFound
+		}
+		c.SendMessage(msg.Reply(&query, msg.Type+"Reply", status))
+	case "PacketStreamStop":
+		o.stopPacketStream(query.StreamID)
+	}
+}
+
+// OnDisconnected websocket event, stops the packet streams of the analyzer
+func (o *OnDemandProbeServer) OnDisconnected(c ws.Speaker) {
+	o.RLock()
+	var streams []string
+	for id, s := range o.packetStreams {
+		if s.speaker.GetRemoteHost() == c.GetRemoteHost() {
+			streams = append(streams, id)
+		}
+	}
+	o.RUnlock()
+
+	for _, id := range streams {
+		o.stopPacketStream(id)
+	}
+}
+
+// OnStructMessage websocket message, valid message type are CaptureStart,
+// CaptureStop, PacketStreamStart and PacketStreamStop
 func (o *OnDemandProbeServer) OnStructMessage(c ws.Speaker, msg *ws.StructMessage) {
+	if msg.Type == "PacketStreamStart" || msg.Type == "PacketStreamStop" {
+		o.onPacketStreamMessage(c, msg)
+		return
+	}
+
 	var query ondemand.CaptureQuery
 	if err := msg.UnmarshalObj(&query); err != nil {
 		logging.GetLogger().Errorf("Unable to decode capture %v", msg)
@@ -251,6 +402,7 @@ func (o *OnDemandProbeServer) OnNodeDeleted(n *graph.Node) {
 func (o *OnDemandProbeServer) Start() error {
 	o.Graph.AddEventListener(o)
 	o.clientPool.AddStructMessageHandler(o, []string{ondemand.Namespace})
+	o.clientPool.AddEventHandler(o)
 
 	return nil
 }
@@ -265,14 +417,28 @@ func (o *OnDemandProbeServer) Stop() {
 	for _, p := range o.activeProbes {
 		o.unregisterProbe(p.node)
 	}
+
+	o.RLock()
+	var streams []string
+	for id := range o.packetStreams {
+		streams = append(streams, id)
+	}
+	o.RUnlock()
+
+	for _, id := range streams {
+		o.stopPacketStream(id)
+	}
 }
 
-// NewOnDemandProbeServer creates a new Ondemand probes server based on graph and websocket
-func NewOnDemandProbeServer(fb *probe.Bundle, g *graph.Graph, pool *ws.StructClientPool) (*OnDemandProbeServer, error) {
+// NewOnDemandProbeServer creates a new Ondemand probes server based on graph and websocket,
+// streaming to the analyzers the packets of the given flow tables
+func NewOnDemandProbeServer(fb *probe.Bundle, g *graph.Graph, tableAllocator *flow.TableAllocator, pool *ws.StructClientPool) (*OnDemandProbeServer, error) {
 	return &OnDemandProbeServer{
-		Graph:        g,
-		Probes:       fb,
-		clientPool:   pool,
-		activeProbes: make(map[graph.Identifier]*activeProbe),
+		Graph:          g,
+		Probes:         fb,
+		tableAllocator: tableAllocator,
+		clientPool:     pool,
+		activeProbes:   make(map[graph.Identifier]*activeProbe),
+		packetStreams:  make(map[string]*packetStream),
 	}, nil
 }
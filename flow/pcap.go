@@ -23,6 +23,7 @@
 package flow
 
 import (
+	"encoding/binary"
 	"errors"
 	"io"
 	"sync"
@@ -37,9 +38,34 @@ import (
 	"github.com/skydive-project/skydive/logging"
 )
 
+// pcap-ng block types and options, see
+// https://github.com/pcapng/pcapng/blob/master/draft-tuexen-opsawg-pcapng.xml
+const (
+	pcapNgSectionHeaderBlock      uint32 = 0x0A0D0D0A
+	pcapNgInterfaceDescBlock      uint32 = 0x00000001
+	pcapNgEnhancedPacketBlock     uint32 = 0x00000006
+	pcapNgByteOrderMagic          uint32 = 0x1A2B3C4D
+	pcapNgOptionEndOfOpt          uint16 = 0
+	pcapNgOptionComment           uint16 = 1
+	pcapNgOptionShbUserAppl       uint16 = 4
+	pcapNgOptionIfName            uint16 = 2
+	pcapNgOptionIfDescription     uint16 = 3
+	pcapNgOptionIfTsResol         uint16 = 9
+	pcapNgTimestampNanoResolution uint8  = 9
+)
+
+type pcapNgOption struct {
+	code  uint16
+	value []byte
+}
+
 // PcapWriter provides helpers on top of gopacket pcap to write pcap files.
+// In pcap-ng, packets of several interfaces can be mixed and comments can be
+// attached to the packets.
 type PcapWriter struct {
-	writer *pcapgo.Writer
+	writer     *pcapgo.Writer
+	ng         io.Writer
+	interfaces []layers.LinkType
 }
 
 // PcapTableFeeder replaies a pcap file
@@ -144,29 +170,37 @@ func NewPcapTableFeeder(r io.ReadCloser, packetsChan chan *PacketSequence, repla
 	}, nil
 }
 
-// WriteRawPacket writes a RawPacket
-func (p *PcapWriter) WriteRawPacket(r *RawPacket) error {
-	ci := gopacket.CaptureInfo{
+func rawPacketCaptureInfo(r *RawPacket, index int) gopacket.CaptureInfo {
+	return gopacket.CaptureInfo{
 		Length:         int(MaxCaptureLength),
 		CaptureLength:  len(r.Data),
-		InterfaceIndex: 1,
+		InterfaceIndex: index,
 		Timestamp:      time.Unix(0, r.Timestamp*int64(time.Millisecond)),
 	}
+}
 
-	p.writer.WritePacket(ci, r.Data)
-
-	return nil
+// WriteRawPacket writes a RawPacket, on the first interface in pcap-ng
+func (p *PcapWriter) WriteRawPacket(r *RawPacket) error {
+	return p.WritePacket(rawPacketCaptureInfo(r, 0), r.Data)
 }
 
-// WriteRawPackets writes a RawPackets iterating over the RawPackets and using
-// WriteRawPacket for each.
+// WriteRawPackets writes a RawPackets iterating over the RawPackets. In
+// pcap-ng, an interface is added for each link type.
 func (p *PcapWriter) WriteRawPackets(fr *RawPackets) error {
-	if fr.LinkType != layers.LinkTypeEthernet {
-		return errors.New("Support only Ethernet link type for the moment")
+	index := 0
+	if p.ng == nil {
+		if fr.LinkType != layers.LinkTypeEthernet {
+			return errors.New("Support only Ethernet link type for the moment")
+		}
+	} else {
+		var err error
+		if index, err = p.linkTypeInterface(fr.LinkType); err != nil {
+			return err
+		}
 	}
 
 	for _, r := range fr.RawPackets {
-		if err := p.WriteRawPacket(r); err != nil {
+		if err := p.WritePacket(rawPacketCaptureInfo(r, index), r.Data); err != nil {
 			return err
 		}
 	}
@@ -174,6 +208,133 @@ func (p *PcapWriter) WriteRawPackets(fr *RawPackets) error {
 	return nil
 }
 
+// WritePacket writes a packet. In pcap-ng, the packet is written on the
+// interface of index ci.InterfaceIndex, each comment being added as a packet
+// comment. Comments are not supported in pcap.
+func (p *PcapWriter) WritePacket(ci gopacket.CaptureInfo, data []byte, comments ...string) error {
+	if p.ng == nil {
+		return p.writer.WritePacket(ci, data)
+	}
+
+	if ci.InterfaceIndex < 0 || ci.InterfaceIndex >= len(p.interfaces) {
+		return errors.New("Unknown pcap-ng interface index")
+	}
+
+	if len(data) > int(MaxCaptureLength) {
+		data = data[:MaxCaptureLength]
+	}
+
+	length := ci.Length
+	if length < len(data) {
+		length = len(data)
+	}
+
+	ts := uint64(ci.Timestamp.UnixNano())
+
+	body := make([]byte, 20)
+	binary.LittleEndian.PutUint32(body[0:4], uint32(ci.InterfaceIndex))
+	binary.LittleEndian.PutUint32(body[4:8], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(data)))
+	binary.LittleEndian.PutUint32(body[16:20], uint32(length))
+
+	var options []pcapNgOption
+	for _, comment := range comments {
+		options = append(options, pcapNgOption{code: pcapNgOptionComment, value: []byte(comment)})
+	}
+
+	return p.writeBlock(pcapNgEnhancedPacketBlock, body, data, options)
+}
+
+// AddInterface writes in pcap-ng the description of an interface and returns
+// its index to be used as InterfaceIndex of the packets captured on it
+func (p *PcapWriter) AddInterface(name, description string, linkType layers.LinkType) (int, error) {
+	if p.ng == nil {
+		return 0, errors.New("Interfaces are only supported in pcap-ng")
+	}
+
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:2], uint16(linkType))
+	binary.LittleEndian.PutUint32(body[4:8], MaxCaptureLength)
+
+	var options []pcapNgOption
+	if name != "" {
+		options = append(options, pcapNgOption{code: pcapNgOptionIfName, value: []byte(name)})
+	}
+	if description != "" {
+		options = append(options, pcapNgOption{code: pcapNgOptionIfDescription, value: []byte(description)})
+	}
+	options = append(options, pcapNgOption{code: pcapNgOptionIfTsResol, value: []byte{pcapNgTimestampNanoResolution}})
+
+	if err := p.writeBlock(pcapNgInterfaceDescBlock, body, nil, options); err != nil {
+		return 0, err
+	}
+
+	p.interfaces = append(p.interfaces, linkType)
+	return len(p.interfaces) - 1, nil
+}
+
+// linkTypeInterface returns the first interface of the given link type,
+// adding an unnamed one if needed
+func (p *PcapWriter) linkTypeInterface(linkType layers.LinkType) (int, error) {
+	for index, lt := range p.interfaces {
+		if lt == linkType {
+			return index, nil
+		}
+	}
+	return p.AddInterface("", "", linkType)
+}
+
+func pcapNgPadding(length int) int {
+	return (4 - length%4) % 4
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// writeBlock writes a pcap-ng block made of a fixed size body, a variable
+// size data and options
+func (p *PcapWriter) writeBlock(blockType uint32, body []byte, data []byte, options []pcapNgOption) error {
+	dataPadding := pcapNgPadding(len(data))
+
+	length := 12 + len(body) + len(data) + dataPadding
+	if len(options) > 0 {
+		// end of option
+		length += 4
+		for _, option := range options {
+			length += 4 + len(option.value) + pcapNgPadding(len(option.value))
+		}
+	}
+
+	buffer := make([]byte, 0, length)
+	buffer = appendUint32(buffer, blockType)
+	buffer = appendUint32(buffer, uint32(length))
+	buffer = append(buffer, body...)
+	buffer = append(buffer, data...)
+	buffer = append(buffer, make([]byte, dataPadding)...)
+
+	if len(options) > 0 {
+		for _, option := range options {
+			buffer = appendUint16(buffer, option.code)
+			buffer = appendUint16(buffer, uint16(len(option.value)))
+			buffer = append(buffer, option.value...)
+			buffer = append(buffer, make([]byte, pcapNgPadding(len(option.value)))...)
+		}
+		buffer = appendUint16(buffer, pcapNgOptionEndOfOpt)
+		buffer = appendUint16(buffer, 0)
+	}
+
+	buffer = appendUint32(buffer, uint32(length))
+
+	_, err := p.ng.Write(buffer)
+	return err
+}
+
 // NewPcapWriter returns a new PcapWriter based on the given io.Writer.
 // Due to the current limitation of the gopacket pcap implementation only
 // RawPacket with Ethernet link type are supported.
@@ -186,3 +347,24 @@ func NewPcapWriter(w io.Writer) *PcapWriter {
 		writer: writer,
 	}
 }
+
+// NewPcapNgWriter returns a new PcapWriter writing pcap-ng to the given
+// io.Writer, the section header being written right away. Interfaces have to
+// be added before writing their packets.
+func NewPcapNgWriter(w io.Writer) (*PcapWriter, error) {
+	p := &PcapWriter{ng: w}
+
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint32(body[0:4], pcapNgByteOrderMagic)
+	binary.LittleEndian.PutUint16(body[4:6], 1)
+	binary.LittleEndian.PutUint16(body[6:8], 0)
+	// unspecified section length
+	binary.LittleEndian.PutUint64(body[8:16], 0xFFFFFFFFFFFFFFFF)
+
+	options := []pcapNgOption{{code: pcapNgOptionShbUserAppl, value: []byte("skydive")}}
+	if err := p.writeBlock(pcapNgSectionHeaderBlock, body, nil, options); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
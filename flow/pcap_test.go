@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+type pcapTestPacket struct {
+	index int
+	ts    time.Time
+	data  []byte
+}
+
+func TestPcapNgWriter(t *testing.T) {
+	var buffer bytes.Buffer
+
+	writer, err := NewPcapNgWriter(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eth0, err := writer.AddInterface("eth0", "tid-eth0", layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun0, err := writer.AddInterface("tun0", "tid-tun0", layers.LinkTypeIPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1500000000, 123456789)
+	packets := []pcapTestPacket{
+		{eth0, start, []byte{1, 2, 3, 4, 5}},
+		{tun0, start.Add(time.Millisecond), []byte{6, 7, 8, 9}},
+		{eth0, start.Add(2 * time.Millisecond), []byte{10, 11, 12, 13, 14, 15, 16}},
+	}
+
+	for _, p := range packets {
+		ci := gopacket.CaptureInfo{
+			Timestamp:      p.ts,
+			CaptureLength:  len(p.data),
+			Length:         len(p.data),
+			InterfaceIndex: p.index,
+		}
+		if err := writer.WritePacket(ci, p.data, "Flow UUID: 123"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.WritePacket(gopacket.CaptureInfo{InterfaceIndex: 2}, []byte{1}); err == nil {
+		t.Error("Expected an error when writing a packet of an unknown interface")
+	}
+
+	// raw packets are written on the first interface of their link type, an
+	// interface being added for the other link types
+	for i, linkType := range []layers.LinkType{layers.LinkTypeIPv4, layers.LinkTypeLinuxSLL} {
+		r := &RawPacket{Timestamp: 1500000001000 + int64(i), Data: []byte{17, 18, 19}}
+		if err := writer.WriteRawPackets(&RawPackets{LinkType: linkType, RawPackets: []*RawPacket{r}}); err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, pcapTestPacket{tun0 + i, time.Unix(0, r.Timestamp*int64(time.Millisecond)), r.Data})
+	}
+
+	if !bytes.Contains(buffer.Bytes(), []byte("Flow UUID: 123")) {
+		t.Error("Packet comment not found")
+	}
+
+	reader, err := pcapgo.NewNgReader(&buffer, pcapgo.NgReaderOptions{WantMixedLinkType: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, p := range packets {
+		data, ci, err := reader.ReadPacketData()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, p.data) {
+			t.Errorf("Expected packet %d data %v, got %v", i, p.data, data)
+		}
+
+		if ci.InterfaceIndex != p.index {
+			t.Errorf("Expected packet %d on interface %d, got %d", i, p.index, ci.InterfaceIndex)
+		}
+
+		if !ci.Timestamp.Equal(p.ts) {
+			t.Errorf("Expected packet %d timestamp %s, got %s", i, p.ts, ci.Timestamp)
+		}
+	}
+
+	if _, _, err := reader.ReadPacketData(); err != io.EOF {
+		t.Errorf("Expected end of file, got %v", err)
+	}
+
+	if reader.NInterfaces() != 3 {
+		t.Fatalf("Expected 3 interfaces, got %d", reader.NInterfaces())
+	}
+
+	intf, _ := reader.Interface(tun0)
+	if intf.Name != "tun0" || intf.Description != "tid-tun0" || intf.LinkType != layers.LinkTypeIPv4 {
+		t.Errorf("Wrong interface description: %+v", intf)
+	}
+
+	if intf, _ = reader.Interface(2); intf.LinkType != layers.LinkTypeLinuxSLL {
+		t.Errorf("Wrong interface description: %+v", intf)
+	}
+}
+
+func TestPcapWriter(t *testing.T) {
+	var buffer bytes.Buffer
+
+	writer := NewPcapWriter(&buffer)
+
+	if _, err := writer.AddInterface("eth0", "", layers.LinkTypeEthernet); err == nil {
+		t.Error("Expected an error when adding an interface in pcap")
+	}
+
+	if err := writer.WriteRawPackets(&RawPackets{LinkType: layers.LinkTypeIPv4}); err == nil {
+		t.Error("Expected an error when writing non Ethernet packets in pcap")
+	}
+
+	r := &RawPacket{Timestamp: 1500000001000, Data: []byte{1, 2, 3}}
+	if err := writer.WriteRawPackets(&RawPackets{LinkType: layers.LinkTypeEthernet, RawPackets: []*RawPacket{r}}); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := pcapgo.NewReader(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, ci, err := reader.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, r.Data) || !ci.Timestamp.Equal(time.Unix(1500000001, 0)) {
+		t.Errorf("Wrong packet %v: %+v", data, ci)
+	}
+}
@@ -0,0 +1,466 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/skydive-project/skydive/logging"
+)
+
+const (
+	// number of files a ring buffer is split into, the oldest one being
+	// removed when the size or the duration limit is reached
+	pcapRingSegments = 8
+
+	pcapFileHeaderSize   = 24
+	pcapPacketHeaderSize = 16
+)
+
+// errPcapPageFull stops reading a ring buffer once a page is full
+var errPcapPageFull = errors.New("pcap page full")
+
+// PcapQuery describes the packets to retrieve from the pcap ring buffers of
+// the captures. From and To are in milliseconds, empty fields match all. The
+// packets are returned by pages of at most MaxSize bytes, if not zero, the
+// next page being retrieved by giving the cursor of the previous one.
+type PcapQuery struct {
+	From        int64       `json:",omitempty"`
+	To          int64       `json:",omitempty"`
+	BPFFilter   string      `json:",omitempty"`
+	NodeTIDs    []string    `json:",omitempty"`
+	TrackingIDs []string    `json:",omitempty"`
+	MaxSize     int64       `json:",omitempty"`
+	Cursor      *PcapCursor `json:",omitempty"`
+}
+
+// PcapCursor is the position in a ring buffer of the next packet to read
+type PcapCursor struct {
+	Segment int64 // start time of the segment in nanoseconds
+	Offset  int64 // offset of the packet in the segment file
+}
+
+// PcapPage holds a page of the packets of a ring buffer as a pcap file, Next
+// being nil for the last page
+type PcapPage struct {
+	NodeTID string
+	Pcap    []byte
+	Next    *PcapCursor `json:",omitempty"`
+}
+
+// PcapReader reads the packets of a pcap file
+type PcapReader interface {
+	LinkType() layers.LinkType
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+}
+
+type pcapSegment struct {
+	path  string
+	start time.Time
+	last  time.Time
+	size  int64
+}
+
+// PcapRing stores the packets of a capture on disk in a ring of pcap files
+// bounded by a maximum size and a maximum duration
+type PcapRing struct {
+	sync.Mutex
+	path        string
+	linkType    layers.LinkType
+	maxSize     int64
+	maxDuration time.Duration
+	segments    []*pcapSegment
+	file        *os.File
+	buffer      *bufio.Writer
+	writer      *pcapgo.Writer
+}
+
+// LinkType returns the link type of the packets stored in the ring
+func (r *PcapRing) LinkType() layers.LinkType {
+	return r.linkType
+}
+
+func (r *PcapRing) closeSegment() error {
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.buffer.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.file, r.buffer, r.writer = nil, nil, nil
+
+	return err
+}
+
+func (r *PcapRing) rotate(ts time.Time) error {
+	if err := r.closeSegment(); err != nil {
+		return err
+	}
+
+	path := filepath.Join(r.path, fmt.Sprintf("%d.pcap", ts.UnixNano()))
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	r.file = file
+	r.buffer = bufio.NewWriter(file)
+	r.writer = pcapgo.NewWriter(r.buffer)
+	if err := r.writer.WriteFileHeader(MaxCaptureLength, r.linkType); err != nil {
+		return err
+	}
+
+	r.segments = append(r.segments, &pcapSegment{path: path, start: ts, last: ts, size: pcapFileHeaderSize})
+
+	return nil
+}
+
+// evict removes the oldest segments while the limits are exceeded, the
+// segment being written is always kept
+func (r *PcapRing) evict(now time.Time) {
+	var size int64
+	for _, segment := range r.segments {
+		size += segment.size
+	}
+
+	for len(r.segments) > 1 {
+		oldest := r.segments[0]
+		if size <= r.maxSize && (r.maxDuration == 0 || now.Sub(oldest.last) <= r.maxDuration) {
+			break
+		}
+
+		os.Remove(oldest.path)
+		size -= oldest.size
+		r.segments = r.segments[1:]
+	}
+}
+
+// Write stores a packet in the ring
+func (r *PcapRing) Write(ci gopacket.CaptureInfo, data []byte) error {
+	// the snapshot length of the files
+	if len(data) > int(MaxCaptureLength) {
+		data = data[:MaxCaptureLength]
+		ci.CaptureLength = len(data)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	var current *pcapSegment
+	if r.file != nil {
+		current = r.segments[len(r.segments)-1]
+	}
+
+	if current == nil || current.size >= r.maxSize/pcapRingSegments ||
+		(r.maxDuration != 0 && ci.Timestamp.Sub(current.start) >= r.maxDuration/pcapRingSegments) {
+		if err := r.rotate(ci.Timestamp); err != nil {
+			return err
+		}
+		current = r.segments[len(r.segments)-1]
+	}
+
+	if err := r.writer.WritePacket(ci, data); err != nil {
+		return err
+	}
+	current.size += int64(pcapPacketHeaderSize + len(data))
+	current.last = ci.Timestamp
+
+	r.evict(ci.Timestamp)
+
+	return nil
+}
+
+// ReadPackets calls fnc for every packet stored in the ring captured between
+// from and to, a zero time meaning no bound, starting at the given cursor if
+// not nil. If fnc returns errPcapPageFull, reading stops and the cursor of the
+// packet is returned to resume reading from it, nil once all were read.
+func (r *PcapRing) ReadPackets(cursor *PcapCursor, from, to time.Time, fnc func(ci gopacket.CaptureInfo, data []byte) error) (*PcapCursor, error) {
+	type segmentReader struct {
+		id   int64
+		file *os.File
+		size int64
+	}
+
+	var readers []segmentReader
+	defer func() {
+		for _, sr := range readers {
+			sr.file.Close()
+		}
+	}()
+
+	// open the segments while locked, so that they can be read up to their
+	// current size even if removed meanwhile
+	r.Lock()
+	if r.buffer != nil {
+		if err := r.buffer.Flush(); err != nil {
+			r.Unlock()
+			return nil, err
+		}
+	}
+
+	for _, segment := range r.segments {
+		id := segment.start.UnixNano()
+		if cursor != nil && id < cursor.Segment {
+			continue
+		}
+
+		if segment.last.Before(from) || (!to.IsZero() && segment.start.After(to)) {
+			continue
+		}
+
+		file, err := os.Open(segment.path)
+		if err != nil {
+			r.Unlock()
+			return nil, err
+		}
+		readers = append(readers, segmentReader{id: id, file: file, size: segment.size})
+	}
+	r.Unlock()
+
+	for _, sr := range readers {
+		header := make([]byte, pcapFileHeaderSize)
+		if _, err := io.ReadFull(sr.file, header); err != nil {
+			return nil, err
+		}
+
+		offset := int64(pcapFileHeaderSize)
+		if cursor != nil && sr.id == cursor.Segment && cursor.Offset > offset {
+			offset = cursor.Offset
+			if _, err := sr.file.Seek(offset, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+
+		reader, err := pcapgo.NewReader(io.MultiReader(bytes.NewReader(header), io.LimitReader(sr.file, sr.size-offset)))
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			data, ci, err := reader.ReadPacketData()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+
+			position := &PcapCursor{Segment: sr.id, Offset: offset}
+			offset += int64(pcapPacketHeaderSize + len(data))
+
+			if ci.Timestamp.Before(from) || (!to.IsZero() && ci.Timestamp.After(to)) {
+				continue
+			}
+
+			if err := fnc(ci, data); err == errPcapPageFull {
+				return position, nil
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// Close stops the ring and removes its files
+func (r *PcapRing) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	err := r.closeSegment()
+	r.segments = nil
+
+	if rerr := os.RemoveAll(r.path); err == nil {
+		err = rerr
+	}
+
+	return err
+}
+
+// NewPcapRing returns a new ring of pcap files stored in the given directory,
+// removing the oldest packets once maxSize bytes are used or once older than
+// maxDuration if not zero
+func NewPcapRing(path string, linkType layers.LinkType, maxSize int64, maxDuration time.Duration) (*PcapRing, error) {
+	// remove the files of a previous run
+	if err := os.RemoveAll(path); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+
+	return &PcapRing{
+		path:        path,
+		linkType:    linkType,
+		maxSize:     maxSize,
+		maxDuration: maxDuration,
+	}, nil
+}
+
+func (pq *PcapQuery) from() time.Time {
+	if pq.From == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, pq.From*int64(time.Millisecond))
+}
+
+func (pq *PcapQuery) to() time.Time {
+	if pq.To == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, pq.To*int64(time.Millisecond))
+}
+
+func (pq *PcapQuery) matchNodeTID(tid string) bool {
+	if len(pq.NodeTIDs) == 0 {
+		return true
+	}
+
+	for _, t := range pq.NodeTIDs {
+		if t == tid {
+			return true
+		}
+	}
+	return false
+}
+
+// packetMatchesTrackingIDs returns whether one of the flows of a packet,
+// encapsulated ones included, has one of the given tracking IDs
+func (ft *Table) packetMatchesTrackingIDs(data []byte, linkType layers.LinkType, trackingIDs map[string]bool) bool {
+	packet := gopacket.NewPacket(data, linkType, gopacket.DecodeOptions{NoCopy: true})
+
+	ps := PacketSeqFromGoPacket(packet, 0, nil, nil)
+	for _, p := range ps.Packets {
+		f := NewFlow()
+		f.newLinkLayer(p)
+		f.LayersPath, f.Application = LayersPath(p.Layers)
+		if err := f.newNetworkLayer(p); err == nil {
+			f.newTransportLayer(p, ft.flowOpts)
+		}
+		f.UpdateUUID("", ft.flowOpts)
+
+		if trackingIDs[f.TrackingID] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pcapHead is the next packet of a pcap file being merged
+type pcapHead struct {
+	reader PcapReader
+	index  int
+	ci     gopacket.CaptureInfo
+	data   []byte
+}
+
+// next reads the next packet, the remaining packets being skipped on error
+func (h *pcapHead) next() bool {
+	data, ci, err := h.reader.ReadPacketData()
+	if err != nil {
+		if err != io.EOF {
+			logging.GetLogger().Warningf("Skipping the remaining packets of pcap %d: %s", h.index, err)
+		}
+		return false
+	}
+	h.ci, h.data = ci, data
+	return true
+}
+
+// pcapHeap sorts the pcap files being merged by the time of their next packet
+type pcapHeap []*pcapHead
+
+func (h pcapHeap) Len() int { return len(h) }
+func (h pcapHeap) Less(i, j int) bool {
+	if h[i].ci.Timestamp.Equal(h[j].ci.Timestamp) {
+		return h[i].index < h[j].index
+	}
+	return h[i].ci.Timestamp.Before(h[j].ci.Timestamp)
+}
+func (h pcapHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *pcapHeap) Push(x interface{}) { *h = append(*h, x.(*pcapHead)) }
+func (h *pcapHeap) Pop() interface{} {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
+
+// MergePcaps writes to w a pcap file holding the packets of the given pcap
+// files sorted by time, the packets being read as they are written. The files
+// with a link type different from the one of the first file are skipped.
+func MergePcaps(w io.Writer, readers []PcapReader) error {
+	var linkType layers.LinkType
+	heads := &pcapHeap{}
+
+	for i, reader := range readers {
+		if i == 0 {
+			linkType = reader.LinkType()
+		} else if reader.LinkType() != linkType {
+			logging.GetLogger().Warningf("Skipping packets of link type %s, expected %s", reader.LinkType(), linkType)
+			continue
+		}
+
+		head := &pcapHead{reader: reader, index: i}
+		if head.next() {
+			heap.Push(heads, head)
+		}
+	}
+
+	writer := pcapgo.NewWriter(w)
+	if err := writer.WriteFileHeader(MaxCaptureLength, linkType); err != nil {
+		return err
+	}
+
+	for heads.Len() > 0 {
+		head := (*heads)[0]
+		if err := writer.WritePacket(head.ci, head.data); err != nil {
+			return err
+		}
+
+		if head.next() {
+			heap.Fix(heads, 0)
+		} else {
+			heap.Pop(heads)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func writeRingPackets(t *testing.T, ring *PcapRing, start time.Time, count int, interval time.Duration) {
+	data := make([]byte, 100)
+	for i := 0; i < count; i++ {
+		ci := gopacket.CaptureInfo{
+			Timestamp:     start.Add(time.Duration(i) * interval),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}
+		if err := ring.Write(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readRingTimestamps(t *testing.T, ring *PcapRing, from, to time.Time) (timestamps []time.Time) {
+	next, err := ring.ReadPackets(nil, from, to, func(ci gopacket.CaptureInfo, data []byte) error {
+		timestamps = append(timestamps, ci.Timestamp)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != nil {
+		t.Fatalf("No cursor expected once all packets are read, got %+v", next)
+	}
+	return
+}
+
+func TestPcapRingSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcapring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "capture")
+
+	// 8 segments of 1000 bytes, about 8 packets per segment
+	ring, err := NewPcapRing(path, layers.LinkTypeEthernet, 8000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1500000000, 0)
+	writeRingPackets(t, ring, start, 1000, time.Millisecond)
+
+	files, _ := ioutil.ReadDir(path)
+	if len(files) > pcapRingSegments+1 {
+		t.Errorf("Expected at most %d files, got %d", pcapRingSegments+1, len(files))
+	}
+
+	timestamps := readRingTimestamps(t, ring, time.Time{}, time.Time{})
+	if len(timestamps) == 0 || len(timestamps) > 8000/116 {
+		t.Fatalf("Unexpected number of packets kept: %d", len(timestamps))
+	}
+
+	// the last packets are kept, in order
+	last := start.Add(999 * time.Millisecond)
+	if !timestamps[len(timestamps)-1].Equal(last) {
+		t.Errorf("Last packet should be at %s, got %s", last, timestamps[len(timestamps)-1])
+	}
+	for i := 1; i < len(timestamps); i++ {
+		if timestamps[i].Sub(timestamps[i-1]) != time.Millisecond {
+			t.Fatalf("Packets are not contiguous: %s, %s", timestamps[i-1], timestamps[i])
+		}
+	}
+
+	// time range
+	from, to := last.Add(-9*time.Millisecond), last.Add(-5*time.Millisecond)
+	if timestamps := readRingTimestamps(t, ring, from, to); len(timestamps) != 5 {
+		t.Errorf("Expected 5 packets in the time range, got %d", len(timestamps))
+	}
+
+	if err := ring.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Ring directory should be removed: %v", err)
+	}
+}
+
+func TestPcapRingDuration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcapring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ring, err := NewPcapRing(filepath.Join(dir, "capture"), layers.LinkTypeEthernet, 1024*1024, 8*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ring.Close()
+
+	start := time.Unix(1500000000, 0)
+	writeRingPackets(t, ring, start, 60, time.Second)
+
+	timestamps := readRingTimestamps(t, ring, time.Time{}, time.Time{})
+	if len(timestamps) == 0 {
+		t.Fatal("No packet kept")
+	}
+
+	// one extra segment may be kept while partially expired
+	oldest := start.Add(59 * time.Second).Sub(timestamps[0])
+	if oldest < 8*time.Second || oldest > 10*time.Second {
+		t.Errorf("Unexpected age of the oldest packet kept: %s", oldest)
+	}
+}
+
+func TestPcapRingCursor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcapring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ring, err := NewPcapRing(filepath.Join(dir, "capture"), layers.LinkTypeEthernet, 8000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ring.Close()
+
+	start := time.Unix(1500000000, 0)
+	writeRingPackets(t, ring, start, 50, time.Millisecond)
+
+	expected := readRingTimestamps(t, ring, time.Time{}, time.Time{})
+
+	// read by pages of 3 packets, spanning several segments
+	var timestamps []time.Time
+	var cursor *PcapCursor
+	for pages := 0; ; pages++ {
+		if pages > len(expected) {
+			t.Fatal("Reading by pages doesn't progress")
+		}
+
+		var count int
+		cursor, err = ring.ReadPackets(cursor, time.Time{}, time.Time{}, func(ci gopacket.CaptureInfo, data []byte) error {
+			if count == 3 {
+				return errPcapPageFull
+			}
+			count++
+			timestamps = append(timestamps, ci.Timestamp)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cursor == nil {
+			break
+		}
+	}
+
+	if len(timestamps) != len(expected) {
+		t.Fatalf("Expected %d packets, got %d", len(expected), len(timestamps))
+	}
+	for i := range expected {
+		if !timestamps[i].Equal(expected[i]) {
+			t.Fatalf("Packet %d at %s, expected %s", i, timestamps[i], expected[i])
+		}
+	}
+}
+
+func TestMergePcaps(t *testing.T) {
+	var readers []PcapReader
+	for i := 0; i < 2; i++ {
+		var buffer bytes.Buffer
+		writer := pcapgo.NewWriter(&buffer)
+		writer.WriteFileHeader(MaxCaptureLength, layers.LinkTypeEthernet)
+
+		for j := 0; j < 3; j++ {
+			data := []byte{byte(i), byte(j)}
+			ci := gopacket.CaptureInfo{
+				Timestamp:     time.Unix(int64(2*j+i), 0),
+				CaptureLength: len(data),
+				Length:        len(data),
+			}
+			writer.WritePacket(ci, data)
+		}
+
+		reader, err := pcapgo.NewReader(&buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readers = append(readers, reader)
+	}
+
+	var buffer bytes.Buffer
+	if err := MergePcaps(&buffer, readers); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := pcapgo.NewReader(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; ; i++ {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			if i != 6 {
+				t.Errorf("Expected 6 packets, got %d", i)
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		if ci.Timestamp.Unix() != int64(i) || data[0] != byte(i%2) {
+			t.Errorf("Packet %d out of order: %v at %s", i, data, ci.Timestamp)
+		}
+	}
+}
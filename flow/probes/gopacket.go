@@ -269,9 +269,6 @@ func (p *GoPacketProbesHandler) registerProbe(n *graph.Node, capture *types.Capt
 		logging.GetLogger().Infof("MPLSUDP port: %v", port)
 	}
 
-	opts := tableOptsFromCapture(capture)
-	flowTable := p.fpta.Alloc(tid, opts)
-
 	headerSize := flow.DefaultCaptureLength
 	if capture.HeaderSize != 0 {
 		headerSize = uint32(capture.HeaderSize)
@@ -292,6 +289,17 @@ func (p *GoPacketProbesHandler) registerProbe(n *graph.Node, capture *types.Capt
 		}
 	}
 
+	ring, err := newPcapRingFromCapture(capture, tid, probe.linkType)
+	if err != nil {
+		return err
+	}
+
+	opts := tableOptsFromCapture(capture)
+	opts.PcapRing = ring
+	flowTable := p.fpta.Alloc(tid, opts)
+
+	sampler := flow.NewPacketSampler(int64(capture.SamplingRate), int64(capture.FlowsPerSecond))
+
 	p.probesLock.Lock()
 	p.probes[id] = &ftProbe{probe: probe, flowTable: flowTable}
 	p.probesLock.Unlock()
@@ -303,9 +311,29 @@ func (p *GoPacketProbesHandler) registerProbe(n *graph.Node, capture *types.Capt
 		flowTable.Start()
 		defer flowTable.Stop()
 
+		if ring != nil {
+			defer ring.Close()
+		}
+
 		count := 0
+		ringFailed := false
 		err := probe.Run(func(packet gopacket.Packet) {
-			flowTable.FeedWithGoPacket(packet, bpfFilter)
+			if ring != nil && (bpfFilter == nil || bpfFilter.Matches(packet.Data())) {
+				if err := ring.Write(packet.Metadata().CaptureInfo, packet.Data()); err != nil && !ringFailed {
+					logging.GetLogger().Errorf("Failed to write to the pcap ring buffer of %s: %s", name, err)
+					ringFailed = true
+				}
+			}
+
+			if sampler == nil {
+				flowTable.FeedWithGoPacket(packet, bpfFilter)
+			} else if rate, ok := sampler.Sample(packet); ok {
+				if sampler.SamplesFlows() {
+					flowTable.FeedWithFlowSampledGoPacket(packet, bpfFilter, rate)
+				} else {
+					flowTable.FeedWithSampledGoPacket(packet, bpfFilter, rate)
+				}
+			}
 			// NOTE: bpf usperspace filter is applied to the few first packets in order to avoid
 			// to get unexpected packets between capture start and bpf applying
 			if count > 50 {
@@ -0,0 +1,149 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package probes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/ipfix"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// IPFIXProbesHandler describes an IPFIX/NetFlow v9 collector probe in the graph
+type IPFIXProbesHandler struct {
+	Graph      *graph.Graph
+	fpta       *FlowProbeTableAllocator
+	probes     map[string]*flow.Table
+	probesLock common.RWMutex
+	allocator  *ipfix.AgentAllocator
+}
+
+// UnregisterProbe unregisters a probe from the graph
+func (d *IPFIXProbesHandler) UnregisterProbe(n *graph.Node, e FlowProbeEventHandler) error {
+	d.probesLock.Lock()
+	defer d.probesLock.Unlock()
+
+	var tid string
+	if tid, _ = n.GetFieldString("TID"); tid == "" {
+		return fmt.Errorf("No TID for node %v", n)
+	}
+
+	ft, ok := d.probes[tid]
+	if !ok {
+		return fmt.Errorf("No registered probe for %s", tid)
+	}
+	d.fpta.Release(ft)
+
+	d.allocator.Release(tid)
+
+	delete(d.probes, tid)
+
+	if e != nil {
+		go e.OnStopped()
+	}
+
+	return nil
+}
+
+func (d *IPFIXProbesHandler) registerProbe(n *graph.Node, capture *types.Capture, e FlowProbeEventHandler) error {
+	var tid string
+	if tid, _ = n.GetFieldString("TID"); tid == "" {
+		return fmt.Errorf("No TID for node %v", n)
+	}
+
+	d.probesLock.RLock()
+	_, ok := d.probes[tid]
+	d.probesLock.RUnlock()
+	if ok {
+		return fmt.Errorf("Already registered %s", tid)
+	}
+
+	// only accept records exported from the addresses of the node if any
+	var exporters []string
+	addresses, _ := n.GetFieldStringList("IPV4")
+	for _, address := range addresses {
+		exporters = append(exporters, strings.Split(address, "/")[0])
+	}
+
+	opts := tableOptsFromCapture(capture)
+	ft := d.fpta.Alloc(tid, opts)
+
+	addr := common.ServiceAddress{Addr: config.GetString("ipfix.bind_address"), Port: capture.Port}
+	if _, err := d.allocator.Alloc(tid, ft, exporters, &addr); err != nil {
+		d.fpta.Release(ft)
+		return err
+	}
+
+	d.probesLock.Lock()
+	d.probes[tid] = ft
+	d.probesLock.Unlock()
+
+	go e.OnStarted()
+
+	d.Graph.AddMetadata(n, "Capture.IPFIXSocket", addr.String())
+
+	return nil
+}
+
+// RegisterProbe registers a probe in the graph
+func (d *IPFIXProbesHandler) RegisterProbe(n *graph.Node, capture *types.Capture, e FlowProbeEventHandler) error {
+	err := d.registerProbe(n, capture, e)
+	if err != nil {
+		go e.OnError(err)
+	}
+	return err
+}
+
+// Start a probe
+func (d *IPFIXProbesHandler) Start() {
+}
+
+// Stop a probe
+func (d *IPFIXProbesHandler) Stop() {
+	d.probesLock.Lock()
+	for _, ft := range d.probes {
+		d.fpta.Release(ft)
+	}
+	d.probesLock.Unlock()
+	d.allocator.ReleaseAll()
+}
+
+// NewIPFIXProbesHandler creates a new IPFIX/NetFlow v9 probe in the graph
+func NewIPFIXProbesHandler(g *graph.Graph, fpta *FlowProbeTableAllocator) (*IPFIXProbesHandler, error) {
+	allocator, err := ipfix.NewAgentAllocator(fpta.Expire())
+	if err != nil {
+		return nil, err
+	}
+
+	return &IPFIXProbesHandler{
+		Graph:     g,
+		fpta:      fpta,
+		allocator: allocator,
+		probes:    make(map[string]*flow.Table),
+	}, nil
+}
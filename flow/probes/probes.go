@@ -24,9 +24,14 @@ package probes
 
 import (
 	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/google/gopacket/layers"
 
 	"github.com/skydive-project/skydive/analyzer"
 	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/probe"
@@ -63,7 +68,7 @@ func (a *FlowProbeTableAllocator) Alloc(nodeTID string, opts flow.TableOpts) *fl
 
 // NewFlowProbeBundle returns a new bundle of flow probes
 func NewFlowProbeBundle(tb *probe.Bundle, g *graph.Graph, fta *flow.TableAllocator, fcpool *analyzer.FlowClientPool) *probe.Bundle {
-	list := []string{"pcapsocket", "ovssflow", "sflow", "gopacket", "dpdk", "ebpf", "ovsmirror"}
+	list := []string{"pcapsocket", "ovssflow", "sflow", "gopacket", "dpdk", "ebpf", "ovsmirror", "ipfix"}
 	logging.GetLogger().Infof("Flow probes: %v", list)
 
 	var captureTypes []string
@@ -98,6 +103,9 @@ func NewFlowProbeBundle(tb *probe.Bundle, g *graph.Graph, fta *flow.TableAllocat
 		case "sflow":
 			fp, err = NewSFlowProbesHandler(g, fpta)
 			captureTypes = []string{"sflow"}
+		case "ipfix":
+			fp, err = NewIPFIXProbesHandler(g, fpta)
+			captureTypes = []string{"ipfix", "netflow"}
 		case "dpdk":
 			if fp, err = NewDPDKProbesHandler(g, fpta); err == nil {
 				captureTypes = []string{"dpdk"}
@@ -139,3 +147,19 @@ func tableOptsFromCapture(capture *types.Capture) flow.TableOpts {
 		ExtraLayers:    capture.ExtraLayers,
 	}
 }
+
+// newPcapRingFromCapture returns the pcap ring buffer requested by the capture
+// if any, the size limit defaulting to the configured one
+func newPcapRingFromCapture(capture *types.Capture, tid string, linkType layers.LinkType) (*flow.PcapRing, error) {
+	if capture.PcapRingSize == 0 && capture.PcapRingDuration == 0 {
+		return nil, nil
+	}
+
+	maxSize := int64(capture.PcapRingSize)
+	if maxSize == 0 {
+		maxSize = int64(config.GetInt("agent.capture.pcap_ring.max_size"))
+	}
+
+	path := filepath.Join(config.GetString("agent.capture.pcap_ring.path"), capture.UUID, tid)
+	return flow.NewPcapRing(path, linkType, maxSize*1024*1024, time.Duration(capture.PcapRingDuration)*time.Second)
+}
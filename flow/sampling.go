@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// maximum sampling rate applied to keep the flows within the budget
+const maxFlowSamplingRate = 1024
+
+// PacketSampler selects the packets of a capture fed to the flow table,
+// either one packet out of N or, with a flows per second budget, all the
+// packets of a subset of the flows selected by hash
+type PacketSampler struct {
+	rate   int64
+	count  int64
+	budget int64
+	window time.Time
+	flows  map[uint64]struct{}
+}
+
+// NewPacketSampler returns a sampler keeping one packet out of rate or, if
+// flowsPerSecond is set, adapting every second the rate of the flows kept.
+// It returns nil if no sampling is requested.
+func NewPacketSampler(rate int64, flowsPerSecond int64) *PacketSampler {
+	if flowsPerSecond > 0 {
+		return &PacketSampler{
+			rate:   1,
+			budget: flowsPerSecond,
+			flows:  make(map[uint64]struct{}),
+		}
+	}
+
+	if rate > 1 {
+		return &PacketSampler{rate: rate}
+	}
+
+	return nil
+}
+
+// packetFlowHash returns a direction independent hash of the packet flow
+func packetFlowHash(packet gopacket.Packet) uint64 {
+	var h uint64
+	if layer := packet.NetworkLayer(); layer != nil {
+		h = layer.NetworkFlow().FastHash()
+	} else if layer := packet.LinkLayer(); layer != nil {
+		h = layer.LinkFlow().FastHash()
+	}
+	if layer := packet.TransportLayer(); layer != nil {
+		h ^= layer.TransportFlow().FastHash()
+	}
+
+	// mix the bits as the selection relies on the lowest ones
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+
+	return h
+}
+
+// SamplesFlows returns whether the sampler keeps all the packets of a subset
+// of the flows, the metrics of these flows being exact, rather than one
+// packet out of N
+func (s *PacketSampler) SamplesFlows() bool {
+	return s.budget > 0
+}
+
+// Sample returns whether the packet is selected along with the sampling
+// rate, of the packets or of the flows according to SamplesFlows
+func (s *PacketSampler) Sample(packet gopacket.Packet) (int64, bool) {
+	if s.budget == 0 {
+		s.count++
+		return s.rate, s.count%s.rate == 0
+	}
+
+	now := packet.Metadata().Timestamp
+	if now.Sub(s.window) >= time.Second {
+		// fit the flows seen during the last window in the budget, using a
+		// power of two so that the flows kept when the rate increases were
+		// already kept before
+		rate := int64(1)
+		for int64(len(s.flows)) > s.budget*rate && rate < maxFlowSamplingRate {
+			rate <<= 1
+		}
+
+		s.rate = rate
+		s.flows = make(map[uint64]struct{})
+		s.window = now
+	}
+
+	h := packetFlowHash(packet)
+	if int64(len(s.flows)) < s.budget*maxFlowSamplingRate {
+		s.flows[h] = struct{}{}
+	}
+
+	return s.rate, h&uint64(s.rate-1) == 0
+}
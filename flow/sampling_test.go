@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+
+	"github.com/skydive-project/skydive/filters"
+)
+
+func newSamplingTestPacket(t *testing.T, port int, ts time.Time) gopacket.Packet {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x05},
+		DstMAC:       net.HardwareAddr{0x00, 0x01, 0x02, 0x03, 0x04, 0x06},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 2},
+	}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(port), DstPort: 4789}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload([]byte("skydive"))); err != nil {
+		t.Fatal(err)
+	}
+
+	p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	p.Metadata().Timestamp = ts
+	return p
+}
+
+func TestPacketSamplerRate(t *testing.T) {
+	if NewPacketSampler(1, 0) != nil {
+		t.Error("No sampler expected for a rate of 1")
+	}
+
+	handleRead, err := pcap.OpenOffline("pcaptraces/eth-ip4-arp-dns-req-http-google.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handleRead.Close()
+
+	table := NewTable(nil, nil, "")
+	sampler := NewPacketSampler(3, 0)
+
+	var count int64
+	for {
+		data, ci, err := handleRead.ReadPacketData()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		p := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+		p.Metadata().CaptureInfo = ci
+
+		if rate, ok := sampler.Sample(p); ok {
+			ps := PacketSeqFromGoPacket(p, 0, nil, nil)
+			for _, packet := range ps.Packets {
+				packet.SamplingRate = rate
+			}
+			table.processPacketSeq(ps)
+			count += int64(len(ps.Packets))
+		}
+	}
+
+	var packets int64
+	for _, f := range table.getFlows(&filters.SearchQuery{}).GetFlows() {
+		if f.SamplingRate != 3 {
+			t.Errorf("Flow sampling rate must be 3 got : %d", f.SamplingRate)
+		}
+		packets += f.Metric.ABPackets + f.Metric.BAPackets
+	}
+
+	if packets != count*3 {
+		t.Errorf("Flow metrics must be scaled, expected %d packets got : %d", count*3, packets)
+	}
+}
+
+func TestPacketSamplerFlowsPerSecond(t *testing.T) {
+	sampler := NewPacketSampler(0, 10)
+	if !sampler.SamplesFlows() {
+		t.Fatal("The sampler should keep a subset of the flows")
+	}
+	now := time.Now()
+
+	// the budget is unknown until the end of the first window
+	for port := 0; port < 100; port++ {
+		if _, ok := sampler.Sample(newSamplingTestPacket(t, 10000+port, now)); !ok {
+			t.Fatal("All the flows must be selected during the first window")
+		}
+	}
+
+	now = now.Add(time.Second)
+
+	selected := make(map[int]bool)
+	for port := 0; port < 1000; port++ {
+		rate, ok := sampler.Sample(newSamplingTestPacket(t, 20000+port, now))
+		if rate != 16 {
+			t.Fatalf("Sampling rate must be 16 got : %d", rate)
+		}
+		selected[port] = ok
+	}
+
+	var count int
+	for port, ok := range selected {
+		if ok {
+			count++
+		}
+
+		// the packets of a flow are all selected or all dropped
+		if _, again := sampler.Sample(newSamplingTestPacket(t, 20000+port, now)); again != ok {
+			t.Errorf("Inconsistent selection for the flow of port %d", port)
+		}
+	}
+
+	if count < 1000/16/2 || count > 1000/16*2 {
+		t.Errorf("About %d flows should be selected got : %d", 1000/16, count)
+	}
+}
+
+func TestFlowSamplingMetrics(t *testing.T) {
+	table := NewTable(nil, nil, "")
+	now := time.Now()
+
+	// all the packets of a flow kept by the flows per second budget are fed
+	for i := 0; i < 5; i++ {
+		ps := PacketSeqFromGoPacket(newSamplingTestPacket(t, 10000, now), 0, nil, nil)
+		for _, packet := range ps.Packets {
+			packet.FlowSamplingRate = 16
+		}
+		table.processPacketSeq(ps)
+	}
+
+	flows := table.getFlows(&filters.SearchQuery{}).GetFlows()
+	if len(flows) != 1 {
+		t.Fatalf("Should get one flow got : %v", flows)
+	}
+
+	f := flows[0]
+	if f.FlowSamplingRate != 16 || f.SamplingRate != 0 {
+		t.Errorf("Wrong sampling rates, flow %d, packet %d", f.FlowSamplingRate, f.SamplingRate)
+	}
+
+	if packets := f.Metric.ABPackets + f.Metric.BAPackets; packets != 5 {
+		t.Errorf("Flow metrics must not be scaled, expected 5 packets got : %d", packets)
+	}
+}
@@ -0,0 +1,283 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+	ws "github.com/skydive-project/skydive/websocket"
+)
+
+const (
+	defaultBrokers      = "127.0.0.1:9092"
+	defaultFlowTopic    = "skydive-flows"
+	defaultGraphTopic   = "skydive-topology"
+	defaultBatchSize    = 100
+	defaultBatchTimeout = 500 // in milliseconds
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 100 // in milliseconds
+	defaultMaxPending   = 10000
+)
+
+var (
+	messagesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "skydive",
+		Subsystem: "sink_kafka",
+		Name:      "messages_sent_total",
+		Help:      "Number of messages delivered to the Kafka brokers",
+	}, []string{"sink", "topic"})
+
+	messagesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "skydive",
+		Subsystem: "sink_kafka",
+		Name:      "messages_failed_total",
+		Help:      "Number of messages that could not be delivered after all the retries",
+	}, []string{"sink", "topic"})
+
+	messagesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "skydive",
+		Subsystem: "sink_kafka",
+		Name:      "messages_dropped_total",
+		Help:      "Number of messages dropped because the producer queue was full",
+	}, []string{"sink", "topic"})
+
+	messagesPending = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "skydive",
+		Subsystem: "sink_kafka",
+		Name:      "messages_pending",
+		Help:      "Number of messages queued or waiting for the acknowledgement of the brokers",
+	}, []string{"sink", "topic"})
+)
+
+// Sink publishes the flows, and optionally the topology events, to Kafka
+// topics. Messages are batched and retried by the producer, and dropped
+// when the producer queue is full so that the analyzer is never blocked.
+type Sink struct {
+	graph.DefaultGraphListener
+	name            string
+	producer        sarama.AsyncProducer
+	graph           *graph.Graph
+	flowTopic       string
+	graphTopic      string
+	protocol        string
+	wg              sync.WaitGroup
+	dropLock        sync.Mutex
+	timeOfLastDrop  time.Time
+	numOfLostEvents int
+}
+
+func (s *Sink) send(topic string, key string, value []byte) {
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.ByteEncoder(value),
+	}
+
+	select {
+	case s.producer.Input() <- msg:
+		messagesPending.WithLabelValues(s.name, topic).Inc()
+	default:
+		messagesDropped.WithLabelValues(s.name, topic).Inc()
+
+		s.dropLock.Lock()
+		s.numOfLostEvents++
+		if s.timeOfLastDrop.IsZero() || time.Now().Sub(s.timeOfLastDrop) >= time.Second {
+			logging.GetLogger().Errorf("Kafka producer queue of %s full, messages dropped: %d", s.name, s.numOfLostEvents)
+			s.timeOfLastDrop = time.Now()
+			s.numOfLostEvents = 0
+		}
+		s.dropLock.Unlock()
+	}
+}
+
+// ExportFlows publishes the flows keyed by the TID of their capture node
+func (s *Sink) ExportFlows(flows []*flow.Flow) {
+	for _, f := range flows {
+		var (
+			data []byte
+			err  error
+		)
+
+		if s.protocol == ws.ProtobufProtocol {
+			data, err = f.GetData()
+		} else {
+			data, err = json.Marshal(f)
+		}
+
+		if err != nil {
+			logging.GetLogger().Errorf("Error while encoding flow %s: %s", f.UUID, err)
+			continue
+		}
+
+		s.send(s.flowTopic, f.NodeTID, data)
+	}
+}
+
+func (s *Sink) sendGraphEvent(msgType string, id graph.Identifier, obj interface{}) {
+	s.send(s.graphTopic, string(id), ws.NewStructMessage(graph.Namespace, msgType, obj).Bytes(s.protocol))
+}
+
+// OnNodeUpdated event
+func (s *Sink) OnNodeUpdated(n *graph.Node) {
+	s.sendGraphEvent(graph.NodeUpdatedMsgType, n.ID, n)
+}
+
+// OnNodeAdded event
+func (s *Sink) OnNodeAdded(n *graph.Node) {
+	s.sendGraphEvent(graph.NodeAddedMsgType, n.ID, n)
+}
+
+// OnNodeDeleted event
+func (s *Sink) OnNodeDeleted(n *graph.Node) {
+	s.sendGraphEvent(graph.NodeDeletedMsgType, n.ID, n)
+}
+
+// OnEdgeUpdated event
+func (s *Sink) OnEdgeUpdated(e *graph.Edge) {
+	s.sendGraphEvent(graph.EdgeUpdatedMsgType, e.ID, e)
+}
+
+// OnEdgeAdded event
+func (s *Sink) OnEdgeAdded(e *graph.Edge) {
+	s.sendGraphEvent(graph.EdgeAddedMsgType, e.ID, e)
+}
+
+// OnEdgeDeleted event
+func (s *Sink) OnEdgeDeleted(e *graph.Edge) {
+	s.sendGraphEvent(graph.EdgeDeletedMsgType, e.ID, e)
+}
+
+// Close flushes the pending messages and closes the producer
+func (s *Sink) Close() {
+	if s.graph != nil && s.graphTopic != "" {
+		s.graph.RemoveEventListener(s)
+	}
+
+	s.producer.AsyncClose()
+	s.wg.Wait()
+}
+
+func (s *Sink) start() {
+	s.wg.Add(2)
+
+	go func() {
+		defer s.wg.Done()
+
+		for msg := range s.producer.Successes() {
+			messagesPending.WithLabelValues(s.name, msg.Topic).Dec()
+			messagesSent.WithLabelValues(s.name, msg.Topic).Inc()
+		}
+	}()
+
+	go func() {
+		defer s.wg.Done()
+
+		for err := range s.producer.Errors() {
+			messagesPending.WithLabelValues(s.name, err.Msg.Topic).Dec()
+			messagesFailed.WithLabelValues(s.name, err.Msg.Topic).Inc()
+
+			logging.GetLogger().Errorf("Failed to deliver message to topic %s: %s", err.Msg.Topic, err.Err)
+		}
+	}()
+
+	if s.graph != nil && s.graphTopic != "" {
+		s.graph.AddEventListener(s)
+	}
+}
+
+func newSink(name string, producer sarama.AsyncProducer, g *graph.Graph, flowTopic, graphTopic, protocol string) *Sink {
+	s := &Sink{
+		name:       name,
+		producer:   producer,
+		graph:      g,
+		flowTopic:  flowTopic,
+		graphTopic: graphTopic,
+		protocol:   protocol,
+	}
+	s.start()
+
+	return s
+}
+
+func getInt(key string, defaultValue int) int {
+	if config.IsSet(key) {
+		return config.GetInt(key)
+	}
+	return defaultValue
+}
+
+func getString(key string, defaultValue string) string {
+	if config.IsSet(key) {
+		return config.GetString(key)
+	}
+	return defaultValue
+}
+
+// Collectors returns the Prometheus collectors of the Kafka sinks
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{messagesSent, messagesFailed, messagesDropped, messagesPending}
+}
+
+// New creates a new Kafka sink based on the sink definition
+func New(name string, g *graph.Graph) (*Sink, error) {
+	path := "sinks." + name + "."
+
+	protocol := getString(path+"encoding", ws.JSONProtocol)
+	if protocol != ws.JSONProtocol && protocol != ws.ProtobufProtocol {
+		return nil, fmt.Errorf("Unsupported encoding '%s', should be %s or %s", protocol, ws.JSONProtocol, ws.ProtobufProtocol)
+	}
+
+	brokers := config.GetStringSlice(path + "brokers")
+	if len(brokers) == 0 {
+		brokers = []string{defaultBrokers}
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.ClientID = "skydive"
+	cfg.ChannelBufferSize = getInt(path+"max_pending", defaultMaxPending)
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Flush.Messages = getInt(path+"batch_size", defaultBatchSize)
+	cfg.Producer.Flush.Frequency = time.Duration(getInt(path+"batch_timeout", defaultBatchTimeout)) * time.Millisecond
+	cfg.Producer.Retry.Max = getInt(path+"max_retries", defaultMaxRetries)
+	cfg.Producer.Retry.Backoff = time.Duration(getInt(path+"retry_backoff", defaultRetryBackoff)) * time.Millisecond
+
+	producer, err := sarama.NewAsyncProducer(brokers, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	flowTopic := getString(path+"flow_topic", defaultFlowTopic)
+	graphTopic := getString(path+"graph_topic", defaultGraphTopic)
+
+	return newSink(name, producer, g, flowTopic, graphTopic, protocol), nil
+}
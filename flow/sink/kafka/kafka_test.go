@@ -0,0 +1,191 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package kafka
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/topology/graph"
+	ws "github.com/skydive-project/skydive/websocket"
+)
+
+type fakeProducer struct {
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func (p *fakeProducer) AsyncClose() {
+	close(p.successes)
+	close(p.errors)
+}
+
+func (p *fakeProducer) Close() error {
+	p.AsyncClose()
+	return nil
+}
+
+func (p *fakeProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func (p *fakeProducer) Successes() <-chan *sarama.ProducerMessage {
+	return p.successes
+}
+
+func (p *fakeProducer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
+
+func newFakeProducer(size int) *fakeProducer {
+	return &fakeProducer{
+		input:     make(chan *sarama.ProducerMessage, size),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+}
+
+func testFlows() []*flow.Flow {
+	return []*flow.Flow{
+		{UUID: "flow1", NodeTID: "node1"},
+		{UUID: "flow2", NodeTID: "node2"},
+	}
+}
+
+func TestExportFlowsJSON(t *testing.T) {
+	producer := newFakeProducer(10)
+	s := newSink("test", producer, nil, "flows", "", ws.JSONProtocol)
+	defer s.Close()
+
+	s.ExportFlows(testFlows())
+
+	if len(producer.input) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(producer.input))
+	}
+
+	for _, expected := range testFlows() {
+		msg := <-producer.input
+		if msg.Topic != "flows" {
+			t.Errorf("Wrong topic: %s", msg.Topic)
+		}
+
+		if key, _ := msg.Key.Encode(); string(key) != expected.NodeTID {
+			t.Errorf("Expected key %s, got %s", expected.NodeTID, string(key))
+		}
+
+		value, _ := msg.Value.Encode()
+
+		var f flow.Flow
+		if err := json.Unmarshal(value, &f); err != nil {
+			t.Fatal(err)
+		}
+
+		if f.UUID != expected.UUID {
+			t.Errorf("Expected flow %s, got %s", expected.UUID, f.UUID)
+		}
+	}
+}
+
+func TestExportFlowsProtobuf(t *testing.T) {
+	producer := newFakeProducer(10)
+	s := newSink("test", producer, nil, "flows", "", ws.ProtobufProtocol)
+	defer s.Close()
+
+	s.ExportFlows(testFlows()[:1])
+
+	msg := <-producer.input
+	value, _ := msg.Value.Encode()
+
+	f, err := flow.FromData(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.UUID != "flow1" {
+		t.Errorf("Expected flow flow1, got %s", f.UUID)
+	}
+}
+
+func TestExportFlowsQueueFull(t *testing.T) {
+	producer := newFakeProducer(1)
+	s := newSink("test", producer, nil, "flows", "", ws.JSONProtocol)
+	defer s.Close()
+
+	// the flows exceeding the producer queue are dropped, not waited for
+	s.ExportFlows(testFlows())
+
+	if len(producer.input) != 1 {
+		t.Fatalf("Expected one queued message, got %d", len(producer.input))
+	}
+}
+
+func TestGraphEvents(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("host1", b, common.AnalyzerService)
+
+	producer := newFakeProducer(10)
+	s := newSink("test", producer, g, "flows", "topology", ws.JSONProtocol)
+
+	g.Lock()
+	g.NewNode(graph.GenID(), graph.Metadata{"Type": "device"})
+	g.Unlock()
+
+	s.Close()
+
+	// no more events once the sink is closed
+	g.Lock()
+	g.NewNode(graph.GenID(), graph.Metadata{"Type": "device"})
+	g.Unlock()
+
+	if len(producer.input) != 1 {
+		t.Fatalf("Expected one message, got %d", len(producer.input))
+	}
+
+	msg := <-producer.input
+	if msg.Topic != "topology" {
+		t.Errorf("Wrong topic: %s", msg.Topic)
+	}
+
+	value, _ := msg.Value.Encode()
+
+	var event struct {
+		Namespace string
+		Type      string
+		Obj       struct{ ID string }
+	}
+	if err := json.Unmarshal(value, &event); err != nil {
+		t.Fatal(err)
+	}
+
+	if key, _ := msg.Key.Encode(); event.Type != graph.NodeAddedMsgType || event.Obj.ID != string(key) {
+		t.Errorf("Wrong graph event, key %s: %s", string(key), string(value))
+	}
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package sink
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/sink/kafka"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/topology/graph"
+)
+
+// Sink describes a destination the flows received by the analyzer are
+// forwarded to, along with the flow storage
+type Sink interface {
+	ExportFlows(flows []*flow.Flow)
+	Close()
+}
+
+// NewSink creates a new flow sink based on the sink definition
+func NewSink(name string, g *graph.Graph) (s Sink, err error) {
+	driver := config.GetString("sinks." + name + ".driver")
+	switch driver {
+	case "kafka":
+		s, err = kafka.New(name, g)
+		if err != nil {
+			err = fmt.Errorf("Can't connect to Kafka brokers: %v", err)
+			return
+		}
+	default:
+		err = fmt.Errorf("Flow sink driver '%s' not supported", driver)
+		return
+	}
+
+	logging.GetLogger().Infof("Using %s as flow sink", name)
+	return
+}
+
+// NewSinksFromConfig creates the flow sinks listed in the configuration
+func NewSinksFromConfig(g *graph.Graph) ([]Sink, error) {
+	var sinks []Sink
+	for _, name := range config.GetStringSlice("analyzer.flow.sinks") {
+		s, err := NewSink(name, g)
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	return sinks, nil
+}
+
+// Collectors returns the Prometheus collectors of the flow sinks
+func Collectors() []prometheus.Collector {
+	return kafka.Collectors()
+}
@@ -0,0 +1,589 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+)
+
+var (
+	// flow UUID => flow
+	flowBucket = []byte("flows")
+	// flow UUID => last update of the flow, used to maintain the index
+	flowLastBucket = []byte("flows_last")
+	// last update + flow UUID => nil, flows sorted by last update time
+	flowIndexBucket = []byte("flows_by_last")
+	// flow UUID + start + last => flow metric
+	metricBucket = []byte("metrics")
+	// flow UUID + index => raw packet
+	rawPacketBucket = []byte("rawpackets")
+)
+
+const (
+	// interval between two retention checks
+	retentionInterval = time.Minute
+	// number of flows removed at once when the size limit is exceeded
+	retentionBatchSize = 1000
+)
+
+// Storage describes a flow storage embedded in the analyzer, relying on
+// BoltDB, with a retention by age and by size
+type Storage struct {
+	db      *bolt.DB
+	maxAge  time.Duration
+	maxSize int64
+	quit    chan bool
+	wg      sync.WaitGroup
+}
+
+func int64ToBytes(i int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(i))
+	return b
+}
+
+// flowKey returns the prefix of the keys of the metrics and of the raw packets
+// of a flow
+func flowKey(uuid string) []byte {
+	return append([]byte(uuid), 0)
+}
+
+func metricKey(uuid string, m *flow.FlowMetric) []byte {
+	key := flowKey(uuid)
+	key = append(key, int64ToBytes(m.Start)...)
+	return append(key, int64ToBytes(m.Last)...)
+}
+
+func rawPacketKey(uuid string, r *flow.RawPacket) []byte {
+	return append(flowKey(uuid), int64ToBytes(r.Index)...)
+}
+
+func indexKey(last int64, uuid string) []byte {
+	return append(int64ToBytes(last), uuid...)
+}
+
+// metricGetter allows to evaluate filters on a flow metric
+type metricGetter struct {
+	*flow.FlowMetric
+}
+
+func (m metricGetter) GetFieldInt64(field string) (int64, error) {
+	switch field {
+	case "Start":
+		return m.Start, nil
+	case "Last":
+		return m.Last, nil
+	}
+	return m.FlowMetric.GetFieldInt64(field)
+}
+
+func (m metricGetter) GetField(field string) (interface{}, error) {
+	return m.GetFieldInt64(field)
+}
+
+func (m metricGetter) GetFieldString(field string) (string, error) {
+	return "", common.ErrFieldNotFound
+}
+
+// rawPacketGetter allows to evaluate filters on a raw packet
+type rawPacketGetter struct {
+	*flow.RawPacket
+}
+
+func (r rawPacketGetter) GetFieldInt64(field string) (int64, error) {
+	switch field {
+	case "Timestamp":
+		return r.Timestamp, nil
+	case "Index":
+		return r.Index, nil
+	}
+	return 0, common.ErrFieldNotFound
+}
+
+func (r rawPacketGetter) GetField(field string) (interface{}, error) {
+	return r.GetFieldInt64(field)
+}
+
+func (r rawPacketGetter) GetFieldString(field string) (string, error) {
+	return "", common.ErrFieldNotFound
+}
+
+// queryPlan describes how the flows matching a filter are looked up, either
+// by their UUIDs or by scanning the flows updated since minLast. The filter
+// is then evaluated on every flow found.
+type queryPlan struct {
+	uuids   []string
+	minLast int64
+}
+
+func newQueryPlan(f *filters.Filter) (plan queryPlan) {
+	if f == nil {
+		return
+	}
+
+	switch {
+	case f.BoolFilter != nil && f.BoolFilter.Op == filters.BoolFilterOp_AND:
+		for _, sub := range f.BoolFilter.Filters {
+			p := newQueryPlan(sub)
+			if p.minLast > plan.minLast {
+				plan.minLast = p.minLast
+			}
+			if p.uuids != nil && (plan.uuids == nil || len(p.uuids) < len(plan.uuids)) {
+				plan.uuids = p.uuids
+			}
+		}
+	case f.BoolFilter != nil && f.BoolFilter.Op == filters.BoolFilterOp_OR && len(f.BoolFilter.Filters) > 0:
+		// only a disjunction of UUIDs can be resolved without scanning
+		uuids := make([]string, 0, len(f.BoolFilter.Filters))
+		for _, sub := range f.BoolFilter.Filters {
+			if sub.TermStringFilter == nil || sub.TermStringFilter.Key != "UUID" {
+				return queryPlan{}
+			}
+			uuids = append(uuids, sub.TermStringFilter.Value)
+		}
+		plan.uuids = uuids
+	case f.TermStringFilter != nil && f.TermStringFilter.Key == "UUID":
+		plan.uuids = []string{f.TermStringFilter.Value}
+	case f.GteInt64Filter != nil && f.GteInt64Filter.Key == "Last":
+		plan.minLast = f.GteInt64Filter.Value
+	case f.GtInt64Filter != nil && f.GtInt64Filter.Key == "Last":
+		plan.minLast = f.GtInt64Filter.Value + 1
+	}
+
+	return
+}
+
+// lookupFlows returns the stored flows matching the filter
+func lookupFlows(tx *bolt.Tx, filter *filters.Filter) ([]*flow.Flow, error) {
+	var flows []*flow.Flow
+
+	bucket := tx.Bucket(flowBucket)
+	match := func(data []byte) error {
+		if data == nil {
+			return nil
+		}
+
+		f := new(flow.Flow)
+		if err := proto.Unmarshal(data, f); err != nil {
+			return err
+		}
+
+		if filter == nil || filter.Eval(f) {
+			flows = append(flows, f)
+		}
+		return nil
+	}
+
+	plan := newQueryPlan(filter)
+	if plan.uuids != nil {
+		for _, uuid := range plan.uuids {
+			if err := match(bucket.Get([]byte(uuid))); err != nil {
+				return nil, err
+			}
+		}
+		return flows, nil
+	}
+
+	c := tx.Bucket(flowIndexBucket).Cursor()
+	for k, _ := c.Seek(int64ToBytes(plan.minLast)); k != nil; k, _ = c.Next() {
+		if err := match(bucket.Get(k[8:])); err != nil {
+			return nil, err
+		}
+	}
+
+	return flows, nil
+}
+
+func deleteFlow(tx *bolt.Tx, uuid []byte) error {
+	lastBucket := tx.Bucket(flowLastBucket)
+	if last := lastBucket.Get(uuid); last != nil {
+		if err := tx.Bucket(flowIndexBucket).Delete(append(append([]byte{}, last...), uuid...)); err != nil {
+			return err
+		}
+	}
+
+	if err := lastBucket.Delete(uuid); err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(flowBucket).Delete(uuid); err != nil {
+		return err
+	}
+
+	prefix := flowKey(string(uuid))
+	for _, name := range [][]byte{metricBucket, rawPacketBucket} {
+		bucket := tx.Bucket(name)
+
+		var keys [][]byte
+		c := bucket.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func storeFlow(tx *bolt.Tx, f *flow.Flow) error {
+	uuid := []byte(f.UUID)
+
+	// raw packets are stored apart, the flow only keeps the last ones
+	stored := *f
+	stored.LastRawPackets = nil
+
+	data, err := proto.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(flowBucket).Put(uuid, data); err != nil {
+		return err
+	}
+
+	lastBucket, indexBucket := tx.Bucket(flowLastBucket), tx.Bucket(flowIndexBucket)
+	if prev := lastBucket.Get(uuid); prev != nil {
+		if err := indexBucket.Delete(append(append([]byte{}, prev...), uuid...)); err != nil {
+			return err
+		}
+	}
+
+	if err := lastBucket.Put(uuid, int64ToBytes(f.Last)); err != nil {
+		return err
+	}
+
+	if err := indexBucket.Put(indexKey(f.Last, f.UUID), nil); err != nil {
+		return err
+	}
+
+	if f.LastUpdateMetric != nil {
+		data, err := proto.Marshal(f.LastUpdateMetric)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(metricBucket).Put(metricKey(f.UUID, f.LastUpdateMetric), data); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range f.LastRawPackets {
+		data, err := proto.Marshal(r)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(rawPacketBucket).Put(rawPacketKey(f.UUID, r), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StoreFlows pushes a set of flows in the database
+func (s *Storage) StoreFlows(flows []*flow.Flow) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, f := range flows {
+			if err := storeFlow(tx, f); err != nil {
+				logging.GetLogger().Errorf("Error while storing flow %s: %s", f.UUID, err)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SearchFlows search flow matching filters in the database
+func (s *Storage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowSet, error) {
+	flowset := flow.NewFlowSet()
+
+	err := s.db.View(func(tx *bolt.Tx) (err error) {
+		flowset.Flows, err = lookupFlows(tx, fsq.Filter)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if fsq.Sort {
+		flowset.Sort(common.SortOrder(fsq.SortOrder), fsq.SortBy)
+	}
+
+	if fsq.Dedup {
+		if err := flowset.Dedup(fsq.DedupBy); err != nil {
+			return nil, err
+		}
+	}
+
+	if fsq.PaginationRange != nil {
+		flowset.Slice(int(fsq.PaginationRange.From), int(fsq.PaginationRange.To))
+	}
+
+	return flowset, nil
+}
+
+// SearchMetrics searches flow metrics matching filters in the database
+func (s *Storage) SearchMetrics(fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]common.Metric, error) {
+	metrics := make(map[string][]common.Metric)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		flows, err := lookupFlows(tx, fsq.Filter)
+		if err != nil {
+			return err
+		}
+
+		c := tx.Bucket(metricBucket).Cursor()
+		for _, f := range flows {
+			prefix := flowKey(f.UUID)
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				m := new(flow.FlowMetric)
+				if err := proto.Unmarshal(v, m); err != nil {
+					return err
+				}
+
+				if metricFilter == nil || metricFilter.Eval(metricGetter{m}) {
+					metrics[f.UUID] = append(metrics[f.UUID], m)
+				}
+			}
+
+			// metrics are stored sorted by start time
+			if common.SortOrder(fsq.SortOrder) == common.SortDescending {
+				fm := metrics[f.UUID]
+				for i, j := 0, len(fm)-1; i < j; i, j = i+1, j-1 {
+					fm[i], fm[j] = fm[j], fm[i]
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return metrics, err
+}
+
+// SearchRawPackets searches flow raw packets matching filters in the database
+func (s *Storage) SearchRawPackets(fsq filters.SearchQuery, packetFilter *filters.Filter) (map[string]*flow.RawPackets, error) {
+	rawpackets := make(map[string]*flow.RawPackets)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		flows, err := lookupFlows(tx, fsq.Filter)
+		if err != nil {
+			return err
+		}
+
+		c := tx.Bucket(rawPacketBucket).Cursor()
+		for _, f := range flows {
+			linkType, err := f.LinkType()
+			if err != nil {
+				continue
+			}
+
+			var packets []*flow.RawPacket
+			prefix := flowKey(f.UUID)
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				r := new(flow.RawPacket)
+				if err := proto.Unmarshal(v, r); err != nil {
+					return err
+				}
+
+				if packetFilter == nil || packetFilter.Eval(rawPacketGetter{r}) {
+					packets = append(packets, r)
+				}
+			}
+
+			if len(packets) == 0 {
+				continue
+			}
+
+			// raw packets are stored sorted by index
+			if common.SortOrder(fsq.SortOrder) == common.SortDescending {
+				for i, j := 0, len(packets)-1; i < j; i, j = i+1, j-1 {
+					packets[i], packets[j] = packets[j], packets[i]
+				}
+			}
+
+			rawpackets[f.UUID] = &flow.RawPackets{LinkType: linkType, RawPackets: packets}
+		}
+
+		return nil
+	})
+
+	return rawpackets, err
+}
+
+// deleteOldestFlows removes up to count flows, the oldest ones and those last
+// updated before the given time, returning the number of flows removed
+func (s *Storage) deleteOldestFlows(count int, before int64) (deleted int, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		var uuids [][]byte
+
+		c := tx.Bucket(flowIndexBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if len(uuids) >= count && int64(binary.BigEndian.Uint64(k[:8])) >= before {
+				break
+			}
+			uuids = append(uuids, append([]byte{}, k[8:]...))
+		}
+
+		for _, uuid := range uuids {
+			if err := deleteFlow(tx, uuid); err != nil {
+				return err
+			}
+		}
+
+		deleted = len(uuids)
+		return nil
+	})
+
+	return
+}
+
+// usedSize returns the size of the database file minus the size of its free
+// pages that will be reused
+func (s *Storage) usedSize() (size int64) {
+	s.db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	})
+
+	stats := s.db.Stats()
+	return size - int64(stats.FreePageN+stats.PendingPageN)*int64(s.db.Info().PageSize)
+}
+
+func (s *Storage) applyRetention(now time.Time) {
+	if s.maxAge > 0 {
+		before := common.UnixMillis(now.Add(-s.maxAge))
+		if deleted, err := s.deleteOldestFlows(0, before); err != nil {
+			logging.GetLogger().Errorf("Error while removing flows older than %s: %s", s.maxAge, err)
+		} else if deleted > 0 {
+			logging.GetLogger().Debugf("%d flows older than %s removed", deleted, s.maxAge)
+		}
+	}
+
+	for s.maxSize > 0 && s.usedSize() > s.maxSize {
+		deleted, err := s.deleteOldestFlows(retentionBatchSize, 0)
+		if err != nil {
+			logging.GetLogger().Errorf("Error while removing flows to free space: %s", err)
+			return
+		}
+		if deleted == 0 {
+			return
+		}
+		logging.GetLogger().Debugf("%d flows removed to free space", deleted)
+	}
+}
+
+// Start the retention of the database
+func (s *Storage) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		s.applyRetention(time.Now())
+
+		ticker := time.NewTicker(retentionInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				s.applyRetention(now)
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the retention and close the database
+func (s *Storage) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+
+	if err := s.db.Close(); err != nil {
+		logging.GetLogger().Errorf("Error while closing flow database: %s", err)
+	}
+}
+
+// NewStorage returns a new BoltDB storage using the database file path, the
+// flows older than maxAge or exceeding maxSize bytes being removed if not zero
+func NewStorage(path string, maxAge time.Duration, maxSize int64) (*Storage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{flowBucket, flowLastBucket, flowIndexBucket, metricBucket, rawPacketBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Storage{
+		db:      db,
+		maxAge:  maxAge,
+		maxSize: maxSize,
+		quit:    make(chan bool),
+	}, nil
+}
+
+// New creates a new BoltDB storage from the configuration of the backend, the
+// database being stored in the backend directory
+func New(backend string) (*Storage, error) {
+	path := "storage." + backend
+	maxAge := time.Duration(config.GetInt(path+".max_age")) * time.Minute
+	maxSize := int64(config.GetInt(path+".max_size")) * 1024 * 1024
+
+	return NewStorage(filepath.Join(config.GetString(path+".path"), "flows.db"), maxAge, maxSize)
+}
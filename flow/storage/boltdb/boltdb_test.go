@@ -0,0 +1,340 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package boltdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+)
+
+// newTestStorage creates a storage in a temporary directory, the database
+// directory being created by the storage
+func newTestStorage(t *testing.T) (*Storage, func()) {
+	dir, err := ioutil.TempDir("", "boltdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStorage(filepath.Join(dir, "flows", "flows.db"), 0, 0)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return s, func() {
+		s.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func newTestFlow(uuid string, a string, last int64) *flow.Flow {
+	return &flow.Flow{
+		UUID:  uuid,
+		Start: last - 1000,
+		Last:  last,
+		Network: &flow.FlowLayer{
+			Protocol: flow.FlowProtocol_IPV4,
+			A:        a,
+			B:        "10.0.1.1",
+		},
+	}
+}
+
+// storeTestFlows stores count flows, the flow i being last updated i minutes
+// before now and its network layer A being alternatively 10.0.0.1 and
+// 10.0.0.2
+func storeTestFlows(t *testing.T, s *Storage, now int64, count int) []*flow.Flow {
+	var flows []*flow.Flow
+	for i := 0; i < count; i++ {
+		last := now - int64(i)*60000
+
+		f := newTestFlow(fmt.Sprintf("flow%02d", i), fmt.Sprintf("10.0.0.%d", i%2+1), last)
+		f.LastUpdateMetric = &flow.FlowMetric{Start: last - 1000, Last: last, ABPackets: int64(i)}
+		f.LastRawPackets = []*flow.RawPacket{
+			{Timestamp: last - 1000, Index: 1, Data: []byte{1}},
+			{Timestamp: last, Index: 2, Data: []byte{2}},
+		}
+		flows = append(flows, f)
+	}
+
+	if err := s.StoreFlows(flows); err != nil {
+		t.Fatal(err)
+	}
+
+	return flows
+}
+
+func searchFlowUUIDs(t *testing.T, s *Storage, fsq filters.SearchQuery) (uuids []string) {
+	flowset, err := s.SearchFlows(fsq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range flowset.Flows {
+		uuids = append(uuids, f.UUID)
+	}
+	return
+}
+
+func TestSearchFlows(t *testing.T) {
+	s, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	now := common.UnixMillis(time.Now())
+	flows := storeTestFlows(t, s, now, 50)
+
+	// an update of a flow replaces it and moves it in the index
+	flows[10].Last = now + 1000
+	if err := s.StoreFlows(flows[10:11]); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		filter   *filters.Filter
+		expected int
+	}{
+		{"all", nil, 50},
+		{"uuid", filters.NewTermStringFilter("UUID", "flow03"), 1},
+		{"unknown uuid", filters.NewTermStringFilter("UUID", "nope"), 0},
+		{"uuids", filters.NewOrFilter(
+			filters.NewTermStringFilter("UUID", "flow03"),
+			filters.NewTermStringFilter("UUID", "flow04"),
+			filters.NewTermStringFilter("UUID", "nope"),
+		), 2},
+		// the filter is still evaluated on the flows looked up by UUID
+		{"uuid and field", filters.NewAndFilter(
+			filters.NewTermStringFilter("UUID", "flow03"),
+			filters.NewTermStringFilter("Network.A", "10.0.0.1"),
+		), 0},
+		{"last gte", filters.NewGteInt64Filter("Last", now-9*60000), 11},
+		{"last gt", filters.NewGtInt64Filter("Last", now-9*60000), 10},
+		{"updated", filters.NewGtInt64Filter("Last", now), 1},
+		{"last and field", filters.NewAndFilter(
+			filters.NewGteInt64Filter("Last", now-9*60000),
+			filters.NewTermStringFilter("Network.A", "10.0.0.2"),
+		), 5},
+		{"full scan", filters.NewTermStringFilter("Network.A", "10.0.0.1"), 25},
+		{"full scan or", filters.NewOrFilter(
+			filters.NewTermStringFilter("UUID", "flow03"),
+			filters.NewLteInt64Filter("Last", now-48*60000),
+		), 3},
+	}
+
+	for _, test := range tests {
+		uuids := searchFlowUUIDs(t, s, filters.SearchQuery{Filter: test.filter})
+		if len(uuids) != test.expected {
+			t.Errorf("%s: expected %d flows, got %v", test.name, test.expected, uuids)
+		}
+	}
+
+	if uuids := searchFlowUUIDs(t, s, filters.SearchQuery{Filter: filters.NewGtInt64Filter("Last", now)}); len(uuids) != 1 || uuids[0] != "flow10" {
+		t.Errorf("Expected the updated flow, got %v", uuids)
+	}
+
+	fsq := filters.SearchQuery{
+		Filter:          filters.NewTermStringFilter("Network.A", "10.0.0.1"),
+		Sort:            true,
+		SortBy:          "Last",
+		SortOrder:       string(common.SortDescending),
+		PaginationRange: &filters.Range{From: 1, To: 4},
+	}
+	uuids := searchFlowUUIDs(t, s, fsq)
+	if len(uuids) != 3 || uuids[0] != "flow00" || uuids[1] != "flow02" || uuids[2] != "flow04" {
+		t.Errorf("Expected flows flow00, flow02, flow04, got %v", uuids)
+	}
+}
+
+func TestSearchMetrics(t *testing.T) {
+	s, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	now := common.UnixMillis(time.Now())
+	flows := storeTestFlows(t, s, now, 10)
+
+	f := flows[0]
+	f.Last = now + 1000
+	f.LastUpdateMetric = &flow.FlowMetric{Start: now, Last: now + 1000, ABPackets: 100}
+	if err := s.StoreFlows([]*flow.Flow{f}); err != nil {
+		t.Fatal(err)
+	}
+
+	fsq := filters.SearchQuery{
+		Filter:    filters.NewTermStringFilter("UUID", "flow00"),
+		SortOrder: string(common.SortDescending),
+	}
+	metrics, err := s.SearchMetrics(fsq, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 1 || len(metrics["flow00"]) != 2 {
+		t.Fatalf("Expected 2 metrics of flow00, got %+v", metrics)
+	}
+
+	if m := metrics["flow00"][0].(*flow.FlowMetric); m.ABPackets != 100 {
+		t.Errorf("Expected the last metric first, got %+v", m)
+	}
+
+	metrics, err = s.SearchMetrics(filters.SearchQuery{}, filters.NewGteInt64Filter("Start", now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 1 || len(metrics["flow00"]) != 1 {
+		t.Errorf("Expected only the last metric of flow00, got %+v", metrics)
+	}
+
+	metrics, err = s.SearchMetrics(filters.SearchQuery{Filter: filters.NewTermStringFilter("Network.A", "10.0.0.2")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 5 {
+		t.Errorf("Expected metrics of 5 flows, got %+v", metrics)
+	}
+}
+
+func TestSearchRawPackets(t *testing.T) {
+	s, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	now := common.UnixMillis(time.Now())
+	flows := storeTestFlows(t, s, now, 10)
+
+	// the raw packets of the updates are added to the stored ones
+	f := flows[0]
+	f.LastRawPackets = []*flow.RawPacket{{Timestamp: now, Index: 3, Data: []byte{3}}}
+	if err := s.StoreFlows([]*flow.Flow{f}); err != nil {
+		t.Fatal(err)
+	}
+
+	fsq := filters.SearchQuery{Filter: filters.NewTermStringFilter("UUID", "flow00")}
+	rawpackets, err := s.SearchRawPackets(fsq, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fr, ok := rawpackets["flow00"]
+	if len(rawpackets) != 1 || !ok || len(fr.RawPackets) != 3 {
+		t.Fatalf("Expected 3 raw packets of flow00, got %+v", rawpackets)
+	}
+
+	for i, r := range fr.RawPackets {
+		if r.Index != int64(i+1) {
+			t.Errorf("Expected raw packets sorted by index, got %+v", fr.RawPackets)
+		}
+	}
+
+	if linkType, _ := f.LinkType(); fr.LinkType != linkType {
+		t.Errorf("Expected link type %s, got %s", linkType, fr.LinkType)
+	}
+
+	// flows without matching packets are omitted
+	rawpackets, err = s.SearchRawPackets(filters.SearchQuery{}, filters.NewGteInt64Filter("Index", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rawpackets) != 1 || len(rawpackets["flow00"].RawPackets) != 1 {
+		t.Errorf("Expected one raw packet of flow00, got %+v", rawpackets)
+	}
+}
+
+func TestRetentionAge(t *testing.T) {
+	s, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	now := time.Now()
+	storeTestFlows(t, s, common.UnixMillis(now), 50)
+
+	s.maxAge = 20*time.Minute + 30*time.Second
+	s.applyRetention(now)
+
+	if uuids := searchFlowUUIDs(t, s, filters.SearchQuery{}); len(uuids) != 21 {
+		t.Errorf("Expected 21 flows kept, got %d", len(uuids))
+	}
+
+	// metrics and raw packets are removed along with the flows
+	metrics, err := s.SearchMetrics(filters.SearchQuery{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := metrics["flow21"]; found || len(metrics) != 21 {
+		t.Errorf("Expected metrics of 21 flows, got %d", len(metrics))
+	}
+
+	rawpackets, err := s.SearchRawPackets(filters.SearchQuery{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rawpackets) != 21 {
+		t.Errorf("Expected raw packets of 21 flows, got %d", len(rawpackets))
+	}
+}
+
+func TestRetentionSize(t *testing.T) {
+	s, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	now := common.UnixMillis(time.Now())
+
+	var flows []*flow.Flow
+	for i := 0; i < 3000; i++ {
+		f := newTestFlow(fmt.Sprintf("flow%04d", i), "10.0.0.1", now+int64(i))
+		f.LastRawPackets = []*flow.RawPacket{{Timestamp: now, Index: 1, Data: make([]byte, 1000)}}
+		flows = append(flows, f)
+	}
+
+	if err := s.StoreFlows(flows); err != nil {
+		t.Fatal(err)
+	}
+
+	s.maxSize = s.usedSize() / 2
+	s.applyRetention(time.Now())
+
+	if size := s.usedSize(); size > s.maxSize {
+		t.Errorf("Expected at most %d bytes used, got %d", s.maxSize, size)
+	}
+
+	uuids := searchFlowUUIDs(t, s, filters.SearchQuery{})
+	if len(uuids) == 0 || len(uuids) == len(flows) {
+		t.Fatalf("Expected part of the flows to be removed, got %d flows", len(uuids))
+	}
+
+	// the oldest flows are removed first
+	kept := searchFlowUUIDs(t, s, filters.SearchQuery{Filter: filters.NewGteInt64Filter("Last", now+int64(len(flows)-len(uuids)))})
+	if len(kept) != len(uuids) {
+		t.Errorf("Expected the %d newest flows to be kept, got %d of them", len(uuids), len(kept))
+	}
+}
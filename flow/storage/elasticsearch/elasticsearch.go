@@ -33,6 +33,7 @@ import (
 	"github.com/skydive-project/skydive/etcd"
 	"github.com/skydive-project/skydive/filters"
 	"github.com/skydive-project/skydive/flow"
+	fl "github.com/skydive-project/skydive/flow/layers"
 	"github.com/skydive-project/skydive/logging"
 	es "github.com/skydive-project/skydive/storage/elasticsearch"
 )
@@ -138,6 +139,7 @@ type embeddedFlow struct {
 	Network      *flow.FlowLayer      `json:"Network,omitempty"`
 	Transport    *flow.TransportLayer `json:"Transport,omitempty"`
 	ICMP         *flow.ICMPLayer      `json:"ICMP,omitempty"`
+	TLS          *fl.TLS              `json:"TLS,omitempty"`
 	TrackingID   *string
 	L3TrackingID *string
 	ParentUUID   *string
@@ -155,6 +157,7 @@ func flowToEmbbedFlow(f *flow.Flow) *embeddedFlow {
 		Network:      f.Network,
 		Transport:    f.Transport,
 		ICMP:         f.ICMP,
+		TLS:          f.TLS,
 		TrackingID:   &f.TrackingID,
 		L3TrackingID: &f.L3TrackingID,
 		ParentUUID:   &f.ParentUUID,
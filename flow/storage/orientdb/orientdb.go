@@ -159,6 +159,8 @@ func flowToDocument(flow *flow.Flow) orient.Document {
 			"A":        flow.Network.A,
 			"B":        flow.Network.B,
 			"ID":       flow.Network.ID,
+			"AName":    flow.Network.AName,
+			"BName":    flow.Network.BName,
 		}
 	}
 	if flow.ICMP != nil {
@@ -176,6 +178,16 @@ func flowToDocument(flow *flow.Flow) orient.Document {
 			"ID":       flow.Transport.ID,
 		}
 	}
+	if flow.TLS != nil {
+		flowDoc["TLS"] = orient.Document{
+			"ServerName":  flow.TLS.ServerName,
+			"Version":     flow.TLS.Version,
+			"CipherSuite": flow.TLS.CipherSuite,
+			"ALPN":        flow.TLS.ALPN,
+			"JA3":         flow.TLS.JA3,
+			"JA3S":        flow.TLS.JA3S,
+		}
+	}
 	return flowDoc
 }
 
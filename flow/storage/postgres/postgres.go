@@ -0,0 +1,305 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package postgres
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+	pg "github.com/skydive-project/skydive/storage/postgres"
+)
+
+// migrations of the flow schema, only new migrations can be appended
+var migrations = [][]string{
+	{
+		`CREATE TABLE flows (
+			"UUID" TEXT PRIMARY KEY,
+			"NodeTID" TEXT NOT NULL,
+			"Start" BIGINT NOT NULL,
+			"Last" BIGINT NOT NULL,
+			"Flow" JSONB NOT NULL
+		)`,
+		`CREATE INDEX flows_last ON flows ("Last")`,
+		`CREATE INDEX flows_node_tid ON flows ("NodeTID")`,
+		`CREATE TABLE flow_metrics (
+			"FlowUUID" TEXT NOT NULL REFERENCES flows ("UUID") ON DELETE CASCADE,
+			"Start" BIGINT NOT NULL,
+			"Last" BIGINT NOT NULL,
+			"ABPackets" BIGINT NOT NULL,
+			"ABBytes" BIGINT NOT NULL,
+			"BAPackets" BIGINT NOT NULL,
+			"BABytes" BIGINT NOT NULL
+		)`,
+		`CREATE INDEX flow_metrics_flow ON flow_metrics ("FlowUUID", "Start")`,
+		`CREATE TABLE flow_rawpackets (
+			"FlowUUID" TEXT NOT NULL REFERENCES flows ("UUID") ON DELETE CASCADE,
+			"LinkType" INTEGER NOT NULL,
+			"Timestamp" BIGINT NOT NULL,
+			"Index" BIGINT NOT NULL,
+			"Data" BYTEA NOT NULL,
+			PRIMARY KEY ("FlowUUID", "Index")
+		)`,
+	},
+}
+
+// fields of the flows stored as columns, the other ones being looked up in
+// the JSON document of the flow
+var flowColumns = map[string]bool{
+	"UUID":    true,
+	"NodeTID": true,
+	"Start":   true,
+	"Last":    true,
+}
+
+// Storage describes a PostgreSQL flow backend
+type Storage struct {
+	client *pg.Client
+}
+
+func flowField(key string, args *pg.Args) pg.Field {
+	if flowColumns[key] {
+		return pg.ColumnFormatter("f")(key, args)
+	}
+	return pg.JSONField(`f."Flow"`, key, args)
+}
+
+func where(conditions ...string) string {
+	var exprs []string
+	for _, condition := range conditions {
+		if condition != "" {
+			exprs = append(exprs, "("+condition+")")
+		}
+	}
+
+	if len(exprs) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(exprs, " AND ")
+}
+
+// StoreFlows pushes a set of flows in the database
+func (c *Storage) StoreFlows(flows []*flow.Flow) error {
+	tx, err := c.client.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, f := range flows {
+		// raw packets are stored apart
+		stored := *f
+		stored.LastRawPackets = nil
+
+		data, err := json.Marshal(&stored)
+		if err != nil {
+			logging.GetLogger().Error(err)
+			continue
+		}
+
+		_, err = tx.Exec(`INSERT INTO flows ("UUID", "NodeTID", "Start", "Last", "Flow") VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT ("UUID") DO UPDATE SET "Last" = EXCLUDED."Last", "Flow" = EXCLUDED."Flow"`,
+			f.UUID, f.NodeTID, f.Start, f.Last, string(data))
+		if err != nil {
+			logging.GetLogger().Errorf("Error while pushing flow %s: %s", f.UUID, err)
+			return err
+		}
+
+		if m := f.LastUpdateMetric; m != nil {
+			_, err = tx.Exec(`INSERT INTO flow_metrics ("FlowUUID", "Start", "Last", "ABPackets", "ABBytes", "BAPackets", "BABytes")
+				VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				f.UUID, m.Start, m.Last, m.ABPackets, m.ABBytes, m.BAPackets, m.BABytes)
+			if err != nil {
+				logging.GetLogger().Errorf("Error while pushing metric %+v: %s", m, err)
+				return err
+			}
+		}
+
+		linkType, err := f.LinkType()
+		if err != nil {
+			logging.GetLogger().Errorf("Error while indexing: %s", err)
+			continue
+		}
+		for _, r := range f.LastRawPackets {
+			_, err = tx.Exec(`INSERT INTO flow_rawpackets ("FlowUUID", "LinkType", "Timestamp", "Index", "Data")
+				VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`,
+				f.UUID, int(linkType), r.Timestamp, r.Index, r.Data)
+			if err != nil {
+				logging.GetLogger().Errorf("Error while pushing raw packet %+v: %s", r, err)
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SearchFlows search flow matching filters in the database
+func (c *Storage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowSet, error) {
+	var args pg.Args
+	query := `SELECT f."Flow" FROM flows f` + where(pg.FilterToExpression(fsq.Filter, flowField, &args))
+	query += pg.SearchQueryToClauses(fsq, flowField, &args)
+
+	rows, err := c.client.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flowset := flow.NewFlowSet()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		f := new(flow.Flow)
+		if err := json.Unmarshal(data, f); err != nil {
+			return nil, err
+		}
+		flowset.Flows = append(flowset.Flows, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if fsq.Dedup {
+		if err := flowset.Dedup(fsq.DedupBy); err != nil {
+			return nil, err
+		}
+	}
+
+	return flowset, nil
+}
+
+// SearchMetrics searches flow metrics matching filters in the database
+func (c *Storage) SearchMetrics(fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]common.Metric, error) {
+	var args pg.Args
+	query := `SELECT m."FlowUUID", m."Start", m."Last", m."ABPackets", m."ABBytes", m."BAPackets", m."BABytes"
+		FROM flow_metrics m JOIN flows f ON f."UUID" = m."FlowUUID"`
+	query += where(
+		pg.FilterToExpression(fsq.Filter, flowField, &args),
+		pg.FilterToExpression(metricFilter, pg.ColumnFormatter("m"), &args),
+	)
+	query += pg.SearchQueryToClauses(filters.SearchQuery{Sort: fsq.Sort, SortBy: fsq.SortBy, SortOrder: fsq.SortOrder}, pg.ColumnFormatter("m"), &args)
+
+	rows, err := c.client.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := make(map[string][]common.Metric)
+	for rows.Next() {
+		var uuid string
+		m := new(flow.FlowMetric)
+		if err := rows.Scan(&uuid, &m.Start, &m.Last, &m.ABPackets, &m.ABBytes, &m.BAPackets, &m.BABytes); err != nil {
+			return nil, err
+		}
+		metrics[uuid] = append(metrics[uuid], m)
+	}
+
+	return metrics, rows.Err()
+}
+
+// SearchRawPackets searches flow raw packets matching filters in the database
+func (c *Storage) SearchRawPackets(fsq filters.SearchQuery, packetFilter *filters.Filter) (map[string]*flow.RawPackets, error) {
+	var args pg.Args
+	query := `SELECT r."FlowUUID", r."LinkType", r."Timestamp", r."Index", r."Data"
+		FROM flow_rawpackets r JOIN flows f ON f."UUID" = r."FlowUUID"`
+	query += where(
+		pg.FilterToExpression(fsq.Filter, flowField, &args),
+		pg.FilterToExpression(packetFilter, pg.ColumnFormatter("r"), &args),
+	)
+	query += pg.SearchQueryToClauses(filters.SearchQuery{Sort: fsq.Sort, SortBy: fsq.SortBy, SortOrder: fsq.SortOrder}, pg.ColumnFormatter("r"), &args)
+
+	rows, err := c.client.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rawpackets := make(map[string]*flow.RawPackets)
+	for rows.Next() {
+		var (
+			uuid     string
+			linkType int
+		)
+		r := new(flow.RawPacket)
+		if err := rows.Scan(&uuid, &linkType, &r.Timestamp, &r.Index, &r.Data); err != nil {
+			return nil, err
+		}
+
+		if fr, ok := rawpackets[uuid]; ok {
+			fr.RawPackets = append(fr.RawPackets, r)
+		} else {
+			rawpackets[uuid] = &flow.RawPackets{
+				LinkType:   layers.LinkType(linkType),
+				RawPackets: []*flow.RawPacket{r},
+			}
+		}
+	}
+
+	return rawpackets, rows.Err()
+}
+
+// Start the database client
+func (c *Storage) Start() {
+}
+
+// Stop the database client
+func (c *Storage) Stop() {
+	c.client.Close()
+}
+
+// NewStorage creates a new PostgreSQL flow storage using the given client,
+// migrating the schema if needed
+func NewStorage(client *pg.Client) (*Storage, error) {
+	if err := client.Migrate("flow", migrations); err != nil {
+		return nil, err
+	}
+
+	return &Storage{client: client}, nil
+}
+
+// New creates a new PostgreSQL database client
+func New(backend string) (*Storage, error) {
+	client, err := pg.NewClient(pg.NewConfig(backend))
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := NewStorage(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
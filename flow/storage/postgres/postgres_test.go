@@ -0,0 +1,315 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package postgres
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	pg "github.com/skydive-project/skydive/storage/postgres"
+)
+
+// newPostgresStorage creates a flow storage backed by a throwaway database
+// of the PostgreSQL server given by the POSTGRES_ADDR, POSTGRES_USER and
+// POSTGRES_PASSWORD environment variables
+func newPostgresStorage(t *testing.T) (*Storage, func()) {
+	addr := os.Getenv("POSTGRES_ADDR")
+	if addr == "" {
+		t.Skip("POSTGRES_ADDR not set, skipping PostgreSQL tests")
+	}
+
+	cfg := pg.Config{
+		Addr:     addr,
+		Database: "postgres",
+		Username: os.Getenv("POSTGRES_USER"),
+		Password: os.Getenv("POSTGRES_PASSWORD"),
+		SSLMode:  "disable",
+	}
+
+	admin, err := pg.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Database = fmt.Sprintf("skydive_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE DATABASE " + cfg.Database); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+
+	client, err := pg.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cleanup := func() {
+		client.Close()
+		admin.Exec("DROP DATABASE " + cfg.Database)
+		admin.Close()
+	}
+
+	s, err := NewStorage(client)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	// migrations already applied have to be skipped
+	if _, err := NewStorage(client); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return s, cleanup
+}
+
+func newTestFlow(uuid, nodeTID, a, b string, start, last int64) *flow.Flow {
+	return &flow.Flow{
+		UUID:    uuid,
+		NodeTID: nodeTID,
+		Start:   start,
+		Last:    last,
+		Network: &flow.FlowLayer{
+			Protocol: flow.FlowProtocol_IPV4,
+			A:        a,
+			B:        b,
+		},
+		Metric: &flow.FlowMetric{Start: start, Last: last},
+	}
+}
+
+func searchFlowUUIDs(t *testing.T, s *Storage, fsq filters.SearchQuery) (uuids []string) {
+	flowset, err := s.SearchFlows(fsq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range flowset.Flows {
+		uuids = append(uuids, f.UUID)
+	}
+	return
+}
+
+func TestPostgresStoreFlowsUpsert(t *testing.T) {
+	s, cleanup := newPostgresStorage(t)
+	defer cleanup()
+
+	f := newTestFlow("aaa", "node1", "10.0.0.1", "10.0.0.2", 1000, 2000)
+	if err := s.StoreFlows([]*flow.Flow{f}); err != nil {
+		t.Fatal(err)
+	}
+
+	// an update of the flow replaces the stored one
+	f = newTestFlow("aaa", "node1", "10.0.0.1", "10.0.0.2", 1000, 5000)
+	f.Metric.ABPackets = 12
+	if err := s.StoreFlows([]*flow.Flow{f}); err != nil {
+		t.Fatal(err)
+	}
+
+	flowset, err := s.SearchFlows(filters.SearchQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(flowset.Flows) != 1 {
+		t.Fatalf("Expected one flow, got %+v", flowset.Flows)
+	}
+
+	stored := flowset.Flows[0]
+	if stored.Last != 5000 || stored.Metric.ABPackets != 12 {
+		t.Errorf("Expected the last update of the flow, got %+v", stored)
+	}
+
+	if stored.Network.Protocol != flow.FlowProtocol_IPV4 || stored.Network.A != "10.0.0.1" {
+		t.Errorf("Network layer not stored: %+v", stored.Network)
+	}
+
+	// the Last column is updated as well
+	fsq := filters.SearchQuery{Filter: filters.NewGteInt64Filter("Last", 5000)}
+	if uuids := searchFlowUUIDs(t, s, fsq); len(uuids) != 1 {
+		t.Errorf("Expected the flow to match its last update, got %v", uuids)
+	}
+}
+
+func TestPostgresSearchFlows(t *testing.T) {
+	s, cleanup := newPostgresStorage(t)
+	defer cleanup()
+
+	flows := []*flow.Flow{
+		newTestFlow("aaa", "node1", "10.0.0.1", "10.0.0.2", 1000, 2000),
+		newTestFlow("bbb", "node1", "10.0.0.1", "10.0.0.3", 1000, 3000),
+		newTestFlow("ccc", "node2", "10.0.1.1", "10.0.0.2", 1000, 4000),
+	}
+	if err := s.StoreFlows(flows); err != nil {
+		t.Fatal(err)
+	}
+
+	regex, _ := filters.NewRegexFilter("Network.B", "^10\\.0\\.0\\.[23]$")
+
+	tests := []struct {
+		name     string
+		filter   *filters.Filter
+		expected int
+	}{
+		{"column", filters.NewTermStringFilter("NodeTID", "node1"), 2},
+		{"json path", filters.NewTermStringFilter("Network.A", "10.0.0.1"), 2},
+		{"json enum", filters.NewTermStringFilter("Network.Protocol", "IPV4"), 3},
+		{"json number", filters.NewGtInt64Filter("Metric.Last", 2000), 2},
+		{"json regex", &filters.Filter{RegexFilter: regex}, 3},
+		{"unknown path", filters.NewTermStringFilter("Network.C", "10.0.0.1"), 0},
+		{"and", filters.NewAndFilter(
+			filters.NewTermStringFilter("Network.B", "10.0.0.2"),
+			filters.NewLtInt64Filter("Last", 3000),
+		), 1},
+	}
+
+	for _, test := range tests {
+		uuids := searchFlowUUIDs(t, s, filters.SearchQuery{Filter: test.filter})
+		if len(uuids) != test.expected {
+			t.Errorf("%s: expected %d flows, got %v", test.name, test.expected, uuids)
+		}
+	}
+
+	// sort and pagination are applied by the database
+	fsq := filters.SearchQuery{
+		Sort:            true,
+		SortBy:          "Last",
+		SortOrder:       "desc",
+		PaginationRange: &filters.Range{From: 1, To: 3},
+	}
+	if uuids := searchFlowUUIDs(t, s, fsq); len(uuids) != 2 || uuids[0] != "bbb" || uuids[1] != "aaa" {
+		t.Errorf("Expected flows bbb, aaa, got %v", uuids)
+	}
+
+	// sort on a field of the JSON document
+	fsq = filters.SearchQuery{Sort: true, SortBy: "Network.B", SortOrder: "asc"}
+	if uuids := searchFlowUUIDs(t, s, fsq); len(uuids) != 3 || uuids[2] != "bbb" {
+		t.Errorf("Expected flow bbb last, got %v", uuids)
+	}
+}
+
+func TestPostgresSearchMetrics(t *testing.T) {
+	s, cleanup := newPostgresStorage(t)
+	defer cleanup()
+
+	for _, last := range []int64{2000, 3000, 4000} {
+		a := newTestFlow("aaa", "node1", "10.0.0.1", "10.0.0.2", 1000, last)
+		a.LastUpdateMetric = &flow.FlowMetric{Start: last - 1000, Last: last, ABPackets: 1, ABBytes: 100}
+
+		b := newTestFlow("bbb", "node2", "10.0.1.1", "10.0.1.2", 1000, last)
+		b.LastUpdateMetric = &flow.FlowMetric{Start: last - 1000, Last: last, BAPackets: 2, BABytes: 200}
+
+		if err := s.StoreFlows([]*flow.Flow{a, b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsq := filters.SearchQuery{
+		Filter:    filters.NewTermStringFilter("Network.A", "10.0.0.1"),
+		Sort:      true,
+		SortBy:    "Last",
+		SortOrder: "asc",
+	}
+	metrics, err := s.SearchMetrics(fsq, filters.NewGteInt64Filter("Start", 2000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 1 || len(metrics["aaa"]) != 2 {
+		t.Fatalf("Expected 2 metrics of flow aaa, got %+v", metrics)
+	}
+
+	first, second := metrics["aaa"][0].(*flow.FlowMetric), metrics["aaa"][1].(*flow.FlowMetric)
+	if first.Last != 3000 || second.Last != 4000 {
+		t.Errorf("Expected metrics sorted by Last, got %+v, %+v", first, second)
+	}
+
+	if first.ABPackets != 1 || first.ABBytes != 100 || first.BAPackets != 0 {
+		t.Errorf("Unexpected metric values: %+v", first)
+	}
+}
+
+func TestPostgresSearchRawPackets(t *testing.T) {
+	s, cleanup := newPostgresStorage(t)
+	defer cleanup()
+
+	a := newTestFlow("aaa", "node1", "10.0.0.1", "10.0.0.2", 1000, 2000)
+	a.LastRawPackets = []*flow.RawPacket{
+		{Timestamp: 1000, Index: 0, Data: []byte{1, 2, 3}},
+		{Timestamp: 1500, Index: 1, Data: []byte{4, 5, 6}},
+	}
+
+	b := newTestFlow("bbb", "node2", "10.0.1.1", "10.0.1.2", 1000, 2000)
+	b.LastRawPackets = []*flow.RawPacket{
+		{Timestamp: 1200, Index: 0, Data: []byte{7, 8, 9}},
+	}
+
+	if err := s.StoreFlows([]*flow.Flow{a, b}); err != nil {
+		t.Fatal(err)
+	}
+
+	// packets already stored are skipped
+	a.LastRawPackets = a.LastRawPackets[1:]
+	if err := s.StoreFlows([]*flow.Flow{a}); err != nil {
+		t.Fatal(err)
+	}
+
+	fsq := filters.SearchQuery{
+		Filter:    filters.NewTermStringFilter("Network.A", "10.0.0.1"),
+		Sort:      true,
+		SortBy:    "Index",
+		SortOrder: "asc",
+	}
+	rawpackets, err := s.SearchRawPackets(fsq, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fr, ok := rawpackets["aaa"]
+	if len(rawpackets) != 1 || !ok || len(fr.RawPackets) != 2 {
+		t.Fatalf("Expected 2 raw packets of flow aaa, got %+v", rawpackets)
+	}
+
+	if fr.LinkType != layers.LinkTypeIPv4 {
+		t.Errorf("Expected link type %s, got %s", layers.LinkTypeIPv4, fr.LinkType)
+	}
+
+	if fr.RawPackets[0].Timestamp != 1000 || fr.RawPackets[1].Data[0] != 4 {
+		t.Errorf("Unexpected raw packets: %+v", fr.RawPackets)
+	}
+
+	rawpackets, err = s.SearchRawPackets(filters.SearchQuery{}, filters.NewGteInt64Filter("Timestamp", 1200))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rawpackets) != 2 || len(rawpackets["aaa"].RawPackets) != 1 || len(rawpackets["bbb"].RawPackets) != 1 {
+		t.Errorf("Expected one raw packet per flow from 1200, got %+v", rawpackets)
+	}
+}
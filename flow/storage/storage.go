@@ -31,8 +31,10 @@ import (
 	"github.com/skydive-project/skydive/etcd"
 	"github.com/skydive-project/skydive/filters"
 	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/storage/boltdb"
 	"github.com/skydive-project/skydive/flow/storage/elasticsearch"
 	"github.com/skydive-project/skydive/flow/storage/orientdb"
+	"github.com/skydive-project/skydive/flow/storage/postgres"
 	"github.com/skydive-project/skydive/logging"
 )
 
@@ -67,6 +69,18 @@ func NewStorage(backend string, etcdClient *etcd.Client) (s Storage, err error)
 			err = fmt.Errorf("Can't connect to OrientDB server: %v", err)
 			return
 		}
+	case "postgres":
+		s, err = postgres.New(backend)
+		if err != nil {
+			err = fmt.Errorf("Can't connect to PostgreSQL server: %v", err)
+			return
+		}
+	case "boltdb":
+		s, err = boltdb.New(backend)
+		if err != nil {
+			err = fmt.Errorf("Can't open BoltDB database: %v", err)
+			return
+		}
 	case "memory":
 		return
 	default:
@@ -23,6 +23,8 @@
 package flow
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"sync"
 	"sync/atomic"
@@ -31,6 +33,7 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/filters"
@@ -54,6 +57,59 @@ type TableReply struct {
 // ExpireUpdateFunc defines expire and updates callback
 type ExpireUpdateFunc func(f []*Flow)
 
+// TablePacket describes a packet processed by a flow table along with the
+// UUIDs of the flows it belongs to, the encapsulated ones included
+type TablePacket struct {
+	NodeTID     string
+	CaptureInfo gopacket.CaptureInfo
+	Data        []byte
+	FlowUUIDs   []string
+}
+
+// PacketListener is notified of the packets processed by a flow table. As
+// called from the table goroutine, the handlers must not block.
+type PacketListener interface {
+	OnPacket(packet *TablePacket)
+	OnTableStopped(nodeTID string)
+}
+
+// PacketChannel is a PacketListener queuing the packets in a buffered
+// channel, the packets being dropped when the channel is full so that the
+// tables are never blocked
+type PacketChannel struct {
+	Packets chan *TablePacket
+	Stopped chan string
+	dropped int64
+}
+
+// OnPacket PacketListener implementation
+func (c *PacketChannel) OnPacket(packet *TablePacket) {
+	select {
+	case c.Packets <- packet:
+	default:
+		atomic.AddInt64(&c.dropped, 1)
+	}
+}
+
+// OnTableStopped PacketListener implementation
+func (c *PacketChannel) OnTableStopped(nodeTID string) {
+	c.Stopped <- nodeTID
+}
+
+// Dropped returns the number of packets dropped
+func (c *PacketChannel) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+// NewPacketChannel returns a new PacketChannel buffering up to size packets,
+// to be registered on at most the given number of tables
+func NewPacketChannel(size int, tables int) *PacketChannel {
+	return &PacketChannel{
+		Packets: make(chan *TablePacket, size),
+		Stopped: make(chan string, tables),
+	}
+}
+
 // Handler defines a flow callback called every time
 type Handler struct {
 	callback ExpireUpdateFunc
@@ -76,6 +132,7 @@ type TableOpts struct {
 	ReassembleTCP  bool
 	LayerKeyMode   LayerKeyMode
 	ExtraLayers    ExtraLayers
+	PcapRing       *PcapRing // ring buffer of the capture packets, queried with PcapQuery
 }
 
 // Table store the flow table and related metrics mechanism
@@ -100,8 +157,12 @@ type Table struct {
 	nodeTID       string
 	ipDefragger   *IPDefragger
 	tcpAssembler  *TCPAssembler
+	dnsCache      *DNSCache
 	flowOpts      Opts
 	appPortMap    *ApplicationPortMap
+	listenersLock common.RWMutex
+	listeners     []PacketListener
+	size          int64 // number of flows, updated atomically
 }
 
 // NewTable creates a new flow table
@@ -118,7 +179,6 @@ func NewTable(updateHandler *Handler, expireHandler *Handler, nodeTID string, op
 		expireHandler: expireHandler,
 		nodeTID:       nodeTID,
 		ipDefragger:   NewIPDefragger(),
-		tcpAssembler:  NewTCPAssembler(),
 		appPortMap:    NewApplicationPortMapFromConfig(),
 	}
 	if len(opts) > 0 {
@@ -132,6 +192,11 @@ func NewTable(updateHandler *Handler, expireHandler *Handler, nodeTID string, op
 		AppPortMap:   t.appPortMap,
 		ExtraLayers:  t.Opts.ExtraLayers,
 	}
+	t.tcpAssembler = NewTCPAssembler(t.Opts.ExtraLayers)
+
+	if (t.Opts.ExtraLayers & DNSLayer) != 0 {
+		t.dnsCache = NewDNSCacheFromConfig()
+	}
 
 	t.updateVersion = 0
 	return t
@@ -177,6 +242,7 @@ func (ft *Table) getOrCreateFlow(key string) (*Flow, bool) {
 
 	new := NewFlow()
 	ft.table[key] = new
+	atomic.StoreInt64(&ft.size, int64(len(ft.table)))
 
 	return new, true
 }
@@ -184,6 +250,7 @@ func (ft *Table) getOrCreateFlow(key string) (*Flow, bool) {
 func (ft *Table) replaceFlow(key string, f *Flow) *Flow {
 	prev, _ := ft.table[key]
 	ft.table[key] = f
+	atomic.StoreInt64(&ft.size, int64(len(ft.table)))
 
 	return prev
 }
@@ -210,6 +277,7 @@ func (ft *Table) expire(expireBefore int64) {
 	ft.expireHandler.callback(expiredFlows)
 
 	flowTableSz := len(ft.table)
+	atomic.StoreInt64(&ft.size, int64(flowTableSz))
 	logging.GetLogger().Debugf("Expire Flow : removed %v ; new size %v", flowTableSzBefore-flowTableSz, flowTableSz)
 }
 
@@ -327,8 +395,92 @@ func (ft *Table) onQuery(query *TableQuery) *TableReply {
 	return reply
 }
 
+func (ft *Table) onPcapQuery(query *TableQuery) *TableReply {
+	reply := &TableReply{
+		status: http.StatusBadRequest,
+		Obj:    make([][]byte, 0),
+	}
+
+	var pq PcapQuery
+	if err := json.Unmarshal(query.Obj, &pq); err != nil {
+		logging.GetLogger().Errorf("Unable to decode the pcap query: %s", err)
+		return reply
+	}
+
+	ring := ft.Opts.PcapRing
+	if ring == nil || !pq.matchNodeTID(ft.nodeTID) {
+		reply.status = http.StatusNoContent
+		return reply
+	}
+
+	var bpf *BPF
+	if pq.BPFFilter != "" {
+		var err error
+		if bpf, err = NewBPF(ring.LinkType(), MaxCaptureLength, pq.BPFFilter); err != nil {
+			logging.GetLogger().Errorf("Invalid pcap query BPF filter: %s", err)
+			return reply
+		}
+	}
+
+	trackingIDs := make(map[string]bool, len(pq.TrackingIDs))
+	for _, id := range pq.TrackingIDs {
+		trackingIDs[id] = true
+	}
+
+	var buffer bytes.Buffer
+	writer := pcapgo.NewWriter(&buffer)
+	writer.WriteFileHeader(MaxCaptureLength, ring.LinkType())
+
+	var count int
+	next, err := ring.ReadPackets(pq.Cursor, pq.from(), pq.to(), func(ci gopacket.CaptureInfo, data []byte) error {
+		if bpf != nil && !bpf.Matches(data) {
+			return nil
+		}
+
+		if len(trackingIDs) > 0 && !ft.packetMatchesTrackingIDs(data, ring.LinkType(), trackingIDs) {
+			return nil
+		}
+
+		// a page holds at least one packet so that reading always progresses
+		if pq.MaxSize > 0 && count > 0 && int64(buffer.Len()+pcapPacketHeaderSize+len(data)) > pq.MaxSize {
+			return errPcapPageFull
+		}
+
+		count++
+		return writer.WritePacket(ci, data)
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to read the pcap ring buffer: %s", err)
+		reply.status = http.StatusInternalServerError
+		return reply
+	}
+
+	if count == 0 {
+		reply.status = http.StatusNoContent
+		return reply
+	}
+
+	page, err := json.Marshal(&PcapPage{NodeTID: ft.nodeTID, Pcap: buffer.Bytes(), Next: next})
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to encode the pcap page: %s", err)
+		reply.status = http.StatusInternalServerError
+		return reply
+	}
+
+	reply.Obj = append(reply.Obj, page)
+	reply.status = http.StatusOK
+
+	return reply
+}
+
 // Query a flow table
 func (ft *Table) Query(query *TableQuery) *TableReply {
+	// the ring buffer is safe to read concurrently, no need to hold the
+	// packet processing
+	if query.Type == "PcapQuery" {
+		return ft.onPcapQuery(query)
+	}
+
 	ft.lockState.Lock()
 	defer ft.lockState.Unlock()
 
@@ -352,6 +504,12 @@ func (ft *Table) Query(query *TableQuery) *TableReply {
 	return nil
 }
 
+// reassembleTCP returns whether the TCP streams have to be reassembled, either
+// requested explicitly or to decode an application layer
+func (ft *Table) reassembleTCP() bool {
+	return ft.Opts.ReassembleTCP || (ft.Opts.ExtraLayers&HTTPLayer) != 0
+}
+
 func (ft *Table) packetToFlow(packet *Packet, parentUUID string) *Flow {
 	key := packet.Key(parentUUID, ft.flowOpts)
 	flow, new := ft.getOrCreateFlow(key)
@@ -360,15 +518,19 @@ func (ft *Table) packetToFlow(packet *Packet, parentUUID string) *Flow {
 			ParentUUID: parentUUID,
 		}
 
-		if ft.Opts.ReassembleTCP {
+		if ft.reassembleTCP() {
 			if layer := packet.GoPacket.TransportLayer(); layer != nil && layer.LayerType() == layers.LayerTypeTCP {
 				ft.tcpAssembler.RegisterFlow(flow, packet.GoPacket)
 			}
 		}
 
 		flow.initFromPacket(key, packet, ft.nodeTID, uuids, ft.flowOpts)
+
+		if ft.dnsCache != nil {
+			ft.dnsCache.annotate(flow, packet.GoPacket.Metadata().Timestamp)
+		}
 	} else {
-		if ft.Opts.ReassembleTCP {
+		if ft.reassembleTCP() {
 			if layer := packet.GoPacket.TransportLayer(); layer != nil && layer.LayerType() == layers.LayerTypeTCP {
 				ft.tcpAssembler.Assemble(packet.GoPacket)
 			}
@@ -377,6 +539,12 @@ func (ft *Table) packetToFlow(packet *Packet, parentUUID string) *Flow {
 		flow.Update(packet, ft.flowOpts)
 	}
 
+	if ft.dnsCache != nil {
+		if layer := packet.Layer(layers.LayerTypeDNS); layer != nil {
+			ft.dnsCache.Update(layer.(*layers.DNS), packet.GoPacket.Metadata().Timestamp)
+		}
+	}
+
 	flow.XXX_state.updateVersion = ft.updateVersion + 1
 
 	if ft.Opts.RawPacketLimit != 0 && flow.RawPacketsCaptured < ft.Opts.RawPacketLimit {
@@ -394,10 +562,52 @@ func (ft *Table) packetToFlow(packet *Packet, parentUUID string) *Flow {
 
 func (ft *Table) processPacketSeq(ps *PacketSequence) {
 	var parentUUID string
+	var uuids []string
 	logging.GetLogger().Debugf("%d Packets received for capture node %s", len(ps.Packets), ft.nodeTID)
 	for _, packet := range ps.Packets {
 		f := ft.packetToFlow(packet, parentUUID)
 		parentUUID = f.UUID
+		uuids = append(uuids, f.UUID)
+	}
+
+	ft.listenersLock.RLock()
+	if len(ft.listeners) > 0 && len(ps.Packets) > 0 {
+		gp := ps.Packets[0].GoPacket
+		packet := &TablePacket{
+			NodeTID:     ft.nodeTID,
+			CaptureInfo: gp.Metadata().CaptureInfo,
+			Data:        gp.Data(),
+			FlowUUIDs:   uuids,
+		}
+		for _, l := range ft.listeners {
+			l.OnPacket(packet)
+		}
+	}
+	ft.listenersLock.RUnlock()
+}
+
+// Size returns the number of flows in the table
+func (ft *Table) Size() int64 {
+	return atomic.LoadInt64(&ft.size)
+}
+
+// AddPacketListener registers a listener of the packets processed by the table
+func (ft *Table) AddPacketListener(l PacketListener) {
+	ft.listenersLock.Lock()
+	ft.listeners = append(ft.listeners, l)
+	ft.listenersLock.Unlock()
+}
+
+// RemovePacketListener unregisters a packet listener
+func (ft *Table) RemovePacketListener(l PacketListener) {
+	ft.listenersLock.Lock()
+	defer ft.listenersLock.Unlock()
+
+	for i, el := range ft.listeners {
+		if l == el {
+			ft.listeners = append(ft.listeners[:i], ft.listeners[i+1:]...)
+			break
+		}
 	}
 }
 
@@ -484,6 +694,29 @@ func (ft *Table) FeedWithGoPacket(packet gopacket.Packet, bpf *BPF) {
 	}
 }
 
+// FeedWithSampledGoPacket feeds the table with a gopacket selected by a
+// sampling, the flow metrics being scaled by the given rate
+func (ft *Table) FeedWithSampledGoPacket(packet gopacket.Packet, bpf *BPF, rate int64) {
+	if ps := PacketSeqFromGoPacket(packet, 0, bpf, ft.ipDefragger); len(ps.Packets) > 0 {
+		for _, p := range ps.Packets {
+			p.SamplingRate = rate
+		}
+		ft.packetSeqChan <- ps
+	}
+}
+
+// FeedWithFlowSampledGoPacket feeds the table with a gopacket of a flow
+// selected by a sampling keeping 1 flow out of rate, the flow metrics being
+// left unscaled
+func (ft *Table) FeedWithFlowSampledGoPacket(packet gopacket.Packet, bpf *BPF, rate int64) {
+	if ps := PacketSeqFromGoPacket(packet, 0, bpf, ft.ipDefragger); len(ps.Packets) > 0 {
+		for _, p := range ps.Packets {
+			p.FlowSamplingRate = rate
+		}
+		ft.packetSeqChan <- ps
+	}
+}
+
 // FeedWithSFlowSample feeds the table with sflow samples
 func (ft *Table) FeedWithSFlowSample(sample *layers.SFlowFlowSample, bpf *BPF) {
 	for _, ps := range PacketSeqFromSFlowSample(sample, bpf, ft.ipDefragger) {
@@ -521,6 +754,13 @@ func (ft *Table) Stop() {
 
 		close(ft.packetSeqChan)
 		close(ft.flowChan)
+
+		ft.listenersLock.Lock()
+		for _, l := range ft.listeners {
+			l.OnTableStopped(ft.nodeTID)
+		}
+		ft.listeners = nil
+		ft.listenersLock.Unlock()
 	}
 
 	ft.expireNow()
@@ -30,18 +30,38 @@ import (
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/tcpassembly"
 
+	fl "github.com/skydive-project/skydive/flow/layers"
 	"github.com/skydive-project/skydive/logging"
 )
 
 // TCPAssembler defines a tcp reassembler
 type TCPAssembler struct {
-	assembler *tcpassembly.Assembler
-	flows     map[uint64]*Flow
+	assembler   *tcpassembly.Assembler
+	flows       map[uint64]*Flow
+	extraLayers ExtraLayers
+	httpConns   map[uint64]*httpConn
+}
+
+// httpConn holds the timestamps of the pending requests of a connection, it
+// is shared by the streams of both directions
+type httpConn struct {
+	requests []time.Time
+	disabled bool
+}
+
+// stop gives up measuring the latencies of the connection as the requests
+// can not be paired with their responses anymore
+func (c *httpConn) stop() {
+	c.requests, c.disabled = nil, true
 }
 
 // TCPAssemblerStream will handle the actual tcp stream decoding
 type TCPAssemblerStream struct {
+	assembler    *TCPAssembler
+	key          uint64
 	flow         *Flow
+	http         *httpStream
+	httpConn     *httpConn
 	network      gopacket.Flow
 	transport    gopacket.Flow
 	bytes        int64
@@ -55,10 +75,13 @@ type TCPAssemblerStream struct {
 	sawEnd       bool
 }
 
-// NewTCPAssembler returns a new TCPAssembler
-func NewTCPAssembler() *TCPAssembler {
+// NewTCPAssembler returns a new TCPAssembler, the reassembled streams are
+// decoded according to the given extra layers
+func NewTCPAssembler(extraLayers ExtraLayers) *TCPAssembler {
 	ta := &TCPAssembler{
-		flows: make(map[uint64]*Flow, 0),
+		flows:       make(map[uint64]*Flow, 0),
+		extraLayers: extraLayers,
+		httpConns:   make(map[uint64]*httpConn),
 	}
 	ta.assembler = tcpassembly.NewAssembler(tcpassembly.NewStreamPool(ta))
 
@@ -101,7 +124,56 @@ func (t *TCPAssembler) New(network, transport gopacket.Flow) tcpassembly.Stream
 		logging.GetLogger().Errorf("TCP Reassembly, unable to find flow: %s, %s", network.String(), transport.String())
 	}
 
-	return &TCPAssemblerStream{flow: f, network: network, transport: transport}
+	stream := &TCPAssemblerStream{assembler: t, key: key, flow: f, network: network, transport: transport}
+	if (t.extraLayers & HTTPLayer) != 0 {
+		conn, ok := t.httpConns[key]
+		if !ok {
+			conn = &httpConn{}
+			t.httpConns[key] = conn
+		}
+		stream.http, stream.httpConn = &httpStream{}, conn
+	}
+
+	return stream
+}
+
+// updateHTTPLayer updates the HTTP layer of the flow with a decoded message,
+// the latency being the time between a request and its response
+func (s *TCPAssemblerStream) updateHTTPLayer(msg *httpMessage) {
+	f, conn := s.flow, s.httpConn
+
+	if msg.request != nil {
+		f.HTTP = &fl.HTTP{
+			Method: msg.request.Method,
+			Host:   msg.request.Host,
+			Path:   msg.request.URL.Path,
+		}
+		if !conn.disabled {
+			if len(conn.requests) >= httpMaxPendingRequests {
+				conn.stop()
+			} else {
+				conn.requests = append(conn.requests, msg.seen)
+			}
+		}
+		return
+	}
+
+	if f.HTTP == nil {
+		f.HTTP = &fl.HTTP{}
+	}
+	f.HTTP.StatusCode = int64(msg.response.StatusCode)
+
+	if len(conn.requests) > 0 {
+		f.HTTP.Latency = msg.seen.Sub(conn.requests[0]).Nanoseconds()
+		conn.requests = conn.requests[1:]
+	}
+}
+
+// stopHTTP stops decoding the HTTP messages of the stream, the requests of
+// the connection waiting for a response being dropped
+func (s *TCPAssemblerStream) stopHTTP() {
+	s.http = nil
+	s.httpConn.stop()
 }
 
 // Reassembled is called whenever new packet data is available for reading.
@@ -125,11 +197,31 @@ func (s *TCPAssemblerStream) Reassembled(reassemblies []tcpassembly.Reassembly)
 		}
 		s.sawStart = s.sawStart || reassembly.Start
 		s.sawEnd = s.sawEnd || reassembly.End
+
+		if s.http != nil && s.flow != nil {
+			// unable to follow the HTTP messages with missing bytes
+			if reassembly.Skip != 0 {
+				s.stopHTTP()
+				continue
+			}
+
+			for _, msg := range s.http.feed(reassembly.Bytes, reassembly.Seen) {
+				s.updateHTTPLayer(msg)
+			}
+
+			if s.http.disabled {
+				s.stopHTTP()
+			}
+		}
 	}
 }
 
 // ReassemblyComplete is called when the TCP assembler believes a stream has finished.
 func (s *TCPAssemblerStream) ReassemblyComplete() {
+	if s.httpConn != nil {
+		delete(s.assembler.httpConns, s.key)
+	}
+
 	f := s.flow
 	if f == nil {
 		return
@@ -0,0 +1,347 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+
+	fl "github.com/skydive-project/skydive/flow/layers"
+)
+
+// maximum number of packets of a flow inspected to find the TLS handshake
+const tlsMaxHandshakePackets = 20
+
+const (
+	tlsRecordHandshake        = 22
+	tlsHandshakeClientHello   = 1
+	tlsHandshakeServerHello   = 2
+	tlsExtServerName          = 0
+	tlsExtSupportedGroups     = 10
+	tlsExtECPointFormats      = 11
+	tlsExtALPN                = 16
+	tlsExtSupportedVersions   = 43
+	tlsRecordHeaderLength     = 5
+	tlsHandshakeHeaderLength  = 4
+	tlsServerNameTypeHostName = 0
+)
+
+var tlsVersions = map[uint16]string{
+	0x0300: "SSL3.0",
+	0x0301: "TLS1.0",
+	0x0302: "TLS1.1",
+	0x0303: "TLS1.2",
+	0x0304: "TLS1.3",
+}
+
+var tlsCipherSuites = map[uint16]string{
+	0x0005: "TLS_RSA_WITH_RC4_128_SHA",
+	0x000a: "TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x002f: "TLS_RSA_WITH_AES_128_CBC_SHA",
+	0x0035: "TLS_RSA_WITH_AES_256_CBC_SHA",
+	0x003c: "TLS_RSA_WITH_AES_128_CBC_SHA256",
+	0x009c: "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	0x009d: "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	0x1301: "TLS_AES_128_GCM_SHA256",
+	0x1302: "TLS_AES_256_GCM_SHA384",
+	0x1303: "TLS_CHACHA20_POLY1305_SHA256",
+	0xc009: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	0xc00a: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	0xc013: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	0xc014: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	0xc023: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+	0xc027: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+	0xc02b: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	0xc02c: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	0xc02f: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	0xc030: "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	0xcca8: "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xcca9: "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+// tlsReader reads the fields of a TLS handshake message, any out of bounds
+// read invalidates the reader
+type tlsReader struct {
+	data []byte
+	ok   bool
+}
+
+func (r *tlsReader) bytes(n int) []byte {
+	if !r.ok || n > len(r.data) {
+		r.ok = false
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *tlsReader) uint8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *tlsReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return uint16(b[0])<<8 | uint16(b[1])
+	}
+	return 0
+}
+
+func (r *tlsReader) uint24() int {
+	if b := r.bytes(3); b != nil {
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	}
+	return 0
+}
+
+// vector returns a reader on a variable length vector of the given length size
+func (r *tlsReader) vector(lengthSize int) *tlsReader {
+	var length int
+	switch lengthSize {
+	case 1:
+		length = int(r.uint8())
+	case 2:
+		length = int(r.uint16())
+	}
+	return &tlsReader{data: r.bytes(length), ok: r.ok}
+}
+
+func (r *tlsReader) uint16s() (l []uint16) {
+	for r.ok && len(r.data) > 0 {
+		l = append(l, r.uint16())
+	}
+	return
+}
+
+// tlsExtension describes a hello message extension
+type tlsExtension struct {
+	kind uint16
+	data *tlsReader
+}
+
+func (r *tlsReader) extensions() (l []tlsExtension) {
+	// extensions are optional
+	if !r.ok || len(r.data) == 0 {
+		return
+	}
+
+	exts := r.vector(2)
+	for exts.ok && len(exts.data) > 0 {
+		kind := exts.uint16()
+		l = append(l, tlsExtension{kind: kind, data: exts.vector(2)})
+	}
+	r.ok = exts.ok
+	return
+}
+
+// isGREASE returns whether the value is a GREASE value, see RFC 8701
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func joinTLSValues(values []uint16) string {
+	var l []string
+	for _, v := range values {
+		if !isGREASE(v) {
+			l = append(l, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(l, "-")
+}
+
+func tlsFingerprint(fields ...string) string {
+	sum := md5.Sum([]byte(strings.Join(fields, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+func tlsVersionName(version uint16) string {
+	if name, ok := tlsVersions[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+func tlsCipherSuiteName(cipher uint16) string {
+	if name, ok := tlsCipherSuites[cipher]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", cipher)
+}
+
+// tlsHandshake returns the type and the body of the handshake message
+// starting a TLS record
+func tlsHandshake(payload []byte) (uint8, *tlsReader) {
+	r := &tlsReader{data: payload, ok: true}
+	if r.uint8() != tlsRecordHandshake || r.uint8() != 3 {
+		return 0, nil
+	}
+	r.bytes(3)
+
+	kind := r.uint8()
+	length := r.uint24()
+	body := r.bytes(length)
+	if !r.ok {
+		return 0, nil
+	}
+	return kind, &tlsReader{data: body, ok: true}
+}
+
+// decodeTLSClientHello fills the TLS layer with the server name and the JA3
+// fingerprint of a ClientHello message
+func decodeTLSClientHello(r *tlsReader, tls *fl.TLS) bool {
+	version := r.uint16()
+	r.bytes(32)
+	r.vector(1)
+	ciphers := r.vector(2).uint16s()
+	r.vector(1)
+
+	var exts, groups []uint16
+	var formats []string
+	var serverName string
+	for _, ext := range r.extensions() {
+		exts = append(exts, ext.kind)
+
+		switch ext.kind {
+		case tlsExtServerName:
+			names := ext.data.vector(2)
+			for names.ok && len(names.data) > 0 {
+				kind, name := names.uint8(), names.vector(2)
+				if kind == tlsServerNameTypeHostName && name.ok {
+					serverName = string(name.data)
+				}
+			}
+		case tlsExtSupportedGroups:
+			groups = ext.data.vector(2).uint16s()
+		case tlsExtECPointFormats:
+			for _, format := range ext.data.vector(1).data {
+				formats = append(formats, strconv.Itoa(int(format)))
+			}
+		}
+	}
+
+	if !r.ok {
+		return false
+	}
+
+	tls.ServerName = serverName
+	tls.JA3 = tlsFingerprint(
+		strconv.Itoa(int(version)),
+		joinTLSValues(ciphers),
+		joinTLSValues(exts),
+		joinTLSValues(groups),
+		strings.Join(formats, "-"),
+	)
+	return true
+}
+
+// decodeTLSServerHello fills the TLS layer with the negotiated parameters
+// and the JA3S fingerprint of a ServerHello message
+func decodeTLSServerHello(r *tlsReader, tls *fl.TLS) bool {
+	version := r.uint16()
+	r.bytes(32)
+	r.vector(1)
+	cipher := r.uint16()
+	r.uint8()
+
+	negotiated := version
+	var alpn string
+	var exts []uint16
+	for _, ext := range r.extensions() {
+		exts = append(exts, ext.kind)
+
+		switch ext.kind {
+		case tlsExtSupportedVersions:
+			if v := ext.data.uint16(); ext.data.ok {
+				negotiated = v
+			}
+		case tlsExtALPN:
+			if protocols := ext.data.vector(2); protocols.ok {
+				alpn = string(protocols.vector(1).data)
+			}
+		}
+	}
+
+	if !r.ok {
+		return false
+	}
+
+	tls.Version = tlsVersionName(negotiated)
+	tls.CipherSuite = tlsCipherSuiteName(cipher)
+	tls.ALPN = alpn
+	tls.JA3S = tlsFingerprint(
+		strconv.Itoa(int(version)),
+		strconv.Itoa(int(cipher)),
+		joinTLSValues(exts),
+	)
+	return true
+}
+
+// updateTLSLayer looks for the TLS ClientHello and ServerHello messages in
+// the first packets of a TCP flow, messages spanning several segments are
+// ignored
+func (f *Flow) updateTLSLayer(packet *Packet) {
+	if f.Transport == nil || f.Transport.Protocol != FlowProtocol_TCP {
+		return
+	}
+	if f.TLS != nil && f.TLS.JA3S != "" {
+		return
+	}
+	if f.Metric.ABPackets+f.Metric.BAPackets > tlsMaxHandshakePackets {
+		return
+	}
+
+	layer := packet.Layer(layers.LayerTypeTCP)
+	if layer == nil {
+		return
+	}
+
+	kind, r := tlsHandshake(layer.LayerPayload())
+	if r == nil {
+		return
+	}
+
+	tls := f.TLS
+	if tls == nil {
+		tls = &fl.TLS{}
+	}
+
+	var ok bool
+	switch kind {
+	case tlsHandshakeClientHello:
+		ok = decodeTLSClientHello(r, tls)
+	case tlsHandshakeServerHello:
+		ok = decodeTLSServerHello(r, tls)
+	}
+
+	if ok {
+		f.TLS = tls
+	}
+}
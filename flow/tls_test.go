@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package flow
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	fl "github.com/skydive-project/skydive/flow/layers"
+)
+
+func appendTLSVector(b []byte, lengthSize int, data []byte) []byte {
+	if lengthSize == 2 {
+		b = append(b, byte(len(data)>>8))
+	}
+	b = append(b, byte(len(data)))
+	return append(b, data...)
+}
+
+func tlsRecord(kind uint8, body []byte) []byte {
+	handshake := []byte{kind, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	handshake = append(handshake, body...)
+
+	record := []byte{tlsRecordHandshake, 3, 1, byte(len(handshake) >> 8), byte(len(handshake))}
+	return append(record, handshake...)
+}
+
+func tlsExt(kind uint16, data []byte) []byte {
+	return appendTLSVector([]byte{byte(kind >> 8), byte(kind)}, 2, data)
+}
+
+func TestTLSClientHello(t *testing.T) {
+	var exts []byte
+	exts = append(exts, tlsExt(0x0a0a, nil)...)
+	exts = append(exts, tlsExt(tlsExtServerName, appendTLSVector(nil, 2, appendTLSVector([]byte{0}, 2, []byte("skydive.network"))))...)
+	exts = append(exts, tlsExt(tlsExtSupportedGroups, appendTLSVector(nil, 2, []byte{0x1a, 0x1a, 0x00, 0x1d, 0x00, 0x17}))...)
+	exts = append(exts, tlsExt(tlsExtECPointFormats, appendTLSVector(nil, 1, []byte{0}))...)
+
+	body := []byte{3, 3}
+	body = append(body, make([]byte, 32)...)
+	body = appendTLSVector(body, 1, nil)
+	body = appendTLSVector(body, 2, []byte{0x2a, 0x2a, 0x13, 0x01, 0xc0, 0x2f})
+	body = appendTLSVector(body, 1, []byte{0})
+	body = appendTLSVector(body, 2, exts)
+
+	kind, r := tlsHandshake(tlsRecord(tlsHandshakeClientHello, body))
+	if kind != tlsHandshakeClientHello || r == nil {
+		t.Fatalf("Unable to decode the handshake: %d", kind)
+	}
+
+	layer := &fl.TLS{}
+	if !decodeTLSClientHello(r, layer) {
+		t.Fatal("Unable to decode the ClientHello")
+	}
+
+	if layer.ServerName != "skydive.network" {
+		t.Errorf("Wrong server name: %s", layer.ServerName)
+	}
+
+	// GREASE values are ignored
+	if expected := tlsFingerprint("771", "4865-49199", "0-10-11", "29-23", "0"); layer.JA3 != expected {
+		t.Errorf("Wrong JA3 fingerprint, expected %s got %s", expected, layer.JA3)
+	}
+}
+
+func TestTLSClientHelloFromClient(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		conn := tls.Client(client, &tls.Config{ServerName: "skydive.network", NextProtos: []string{"h2"}})
+		conn.Handshake()
+		client.Close()
+	}()
+
+	data := make([]byte, 4096)
+	n, err := server.Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, r := tlsHandshake(data[:n])
+	if r == nil {
+		t.Fatal("Unable to decode the handshake")
+	}
+
+	layer := &fl.TLS{}
+	if !decodeTLSClientHello(r, layer) || layer.ServerName != "skydive.network" || len(layer.JA3) != 32 {
+		t.Errorf("Wrong TLS layer: %+v", layer)
+	}
+}
+
+func TestTLSServerHello(t *testing.T) {
+	var exts []byte
+	exts = append(exts, tlsExt(tlsExtSupportedVersions, []byte{0x03, 0x04})...)
+	exts = append(exts, tlsExt(tlsExtALPN, appendTLSVector(nil, 2, appendTLSVector(nil, 1, []byte("h2"))))...)
+
+	body := []byte{3, 3}
+	body = append(body, make([]byte, 32)...)
+	body = appendTLSVector(body, 1, nil)
+	body = append(body, 0x13, 0x01, 0)
+	body = appendTLSVector(body, 2, exts)
+
+	kind, r := tlsHandshake(tlsRecord(tlsHandshakeServerHello, body))
+	if kind != tlsHandshakeServerHello || r == nil {
+		t.Fatalf("Unable to decode the handshake: %d", kind)
+	}
+
+	layer := &fl.TLS{ServerName: "skydive.network"}
+	if !decodeTLSServerHello(r, layer) {
+		t.Fatal("Unable to decode the ServerHello")
+	}
+
+	if layer.Version != "TLS1.3" || layer.CipherSuite != "TLS_AES_128_GCM_SHA256" || layer.ALPN != "h2" {
+		t.Errorf("Wrong TLS layer: %+v", layer)
+	}
+	if expected := tlsFingerprint("771", "4865", "43-16"); layer.JA3S != expected {
+		t.Errorf("Wrong JA3S fingerprint, expected %s got %s", expected, layer.JA3S)
+	}
+	if layer.ServerName != "skydive.network" {
+		t.Error("ServerHello should not override the server name")
+	}
+}
+
+func TestTLSTruncated(t *testing.T) {
+	record := tlsRecord(tlsHandshakeClientHello, []byte{3, 3, 0, 0})
+	if _, r := tlsHandshake(record[:len(record)-1]); r != nil {
+		t.Error("A truncated handshake should not be decoded")
+	}
+
+	_, r := tlsHandshake(record)
+	if r == nil || decodeTLSClientHello(r, &fl.TLS{}) {
+		t.Error("A truncated ClientHello should not be decoded")
+	}
+}
@@ -147,6 +147,11 @@ func (q QueryString) Flows(list ...interface{}) QueryString {
 	return q.newQueryString("Flows", list...)
 }
 
+// GroupBy append a GroupBy() operation to query
+func (q QueryString) GroupBy(list ...interface{}) QueryString {
+	return q.newQueryString("GroupBy", list...)
+}
+
 // Has append a Has() operation to query
 func (q QueryString) Has(list ...interface{}) QueryString {
 	return q.newQueryString("Has", list...)
@@ -197,6 +202,31 @@ func (q QueryString) Sum(list ...interface{}) QueryString {
 	return q.newQueryString("Sum", list...)
 }
 
+// Rate append a Rate() operation to query
+func (q QueryString) Rate(list ...interface{}) QueryString {
+	return q.newQueryString("Rate", list...)
+}
+
+// Max append a Max() operation to query
+func (q QueryString) Max(list ...interface{}) QueryString {
+	return q.newQueryString("Max", list...)
+}
+
+// Min append a Min() operation to query
+func (q QueryString) Min(list ...interface{}) QueryString {
+	return q.newQueryString("Min", list...)
+}
+
+// Avg append a Avg() operation to query
+func (q QueryString) Avg(list ...interface{}) QueryString {
+	return q.newQueryString("Avg", list...)
+}
+
+// Percentile append a Percentile() operation to query
+func (q QueryString) Percentile(list ...interface{}) QueryString {
+	return q.newQueryString("Percentile", list...)
+}
+
 // Nodes append a Nodes() operation to query
 func (q QueryString) Nodes() QueryString {
 	return q.newQueryString("Nodes")
@@ -237,6 +267,11 @@ func (q QueryString) Sockets() QueryString {
 	return q.newQueryString("Sockets")
 }
 
+// Top append a Top() operation to query
+func (q QueryString) Top(list ...interface{}) QueryString {
+	return q.newQueryString("Top", list...)
+}
+
 // V append a V() operation to query
 func (q QueryString) V(list ...interface{}) QueryString {
 	return q.newQueryString("V", list...)
@@ -23,6 +23,7 @@
 package traversal
 
 import (
+	"context"
 	"errors"
 	"math/rand"
 	"strconv"
@@ -232,6 +233,124 @@ func TestDedupStep(t *testing.T) {
 	}
 }
 
+func newIPv4Flow(a, b string, bytes int64) *flow.Flow {
+	f := flow.NewFlow()
+	f.UUID = strconv.Itoa(rand.Int())
+	f.Network = &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: a, B: b}
+	f.Metric.ABBytes = bytes
+	f.Metric.ABPackets = 1
+	f.NodeTID = "node1"
+	return f
+}
+
+func TestGroupByStep(t *testing.T) {
+	tc := newFakeTableClient()
+
+	_, flowChan := tc.t.Start()
+	defer tc.t.Stop()
+	for tc.t.State() != common.RunningState {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	flowChan <- newIPv4Flow("192.168.0.1", "192.168.0.2", 100)
+	flowChan <- newIPv4Flow("192.168.0.1", "192.168.0.2", 200)
+	flowChan <- newIPv4Flow("192.168.0.1", "192.168.0.3", 50)
+	flowChan <- newIPv4Flow("192.168.0.4", "192.168.0.2", 1000)
+
+	time.Sleep(time.Second)
+
+	query := `G.Flows().GroupBy("Network.A", "Network.B")`
+	res := execTraversalQuery(t, tc, query)
+	if len(res.Values()) != 3 {
+		t.Fatalf("Should return 3 groups, returned: %v", res.Values())
+	}
+
+	query = `G.Flows().GroupBy("Network.A").Top(1, "Metric.ABBytes")`
+	res = execTraversalQuery(t, tc, query)
+	if len(res.Values()) != 1 {
+		t.Fatalf("Should return 1 group, returned: %v", res.Values())
+	}
+
+	group := res.Values()[0].(*FlowGroup)
+	if group.Keys["Network.A"] != "192.168.0.4" || group.Metric.ABBytes != 1000 {
+		t.Fatalf("Wrong top talker, returned: %+v", group)
+	}
+
+	query = `G.Flows().GroupBy("Network.A").Top(2, "Metric.ABBytes")`
+	res = execTraversalQuery(t, tc, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 groups, returned: %v", res.Values())
+	}
+
+	group = res.Values()[1].(*FlowGroup)
+	if group.Keys["Network.A"] != "192.168.0.1" || group.Flows != 3 || group.Metric.ABBytes != 350 || group.Metric.ABPackets != 3 {
+		t.Fatalf("Metrics should be summed, returned: %+v", group)
+	}
+
+	query = `G.Flows().Top(1, "Metric.ABBytes")`
+	res = execTraversalQuery(t, tc, query)
+	if len(res.Values()) != 1 || res.Values()[0].(*flow.Flow).Metric.ABBytes != 1000 {
+		t.Fatalf("Should return the biggest flow, returned: %v", res.Values())
+	}
+}
+
+func TestFlowsResultsLimit(t *testing.T) {
+	tc := newFakeTableClient()
+
+	_, flowChan := tc.t.Start()
+	defer tc.t.Stop()
+	for tc.t.State() != common.RunningState {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	flowChan <- newIPv4Flow("192.168.0.1", "192.168.0.2", 100)
+	flowChan <- newIPv4Flow("192.168.0.1", "192.168.0.2", 200)
+	flowChan <- newIPv4Flow("192.168.0.1", "192.168.0.3", 50)
+	flowChan <- newIPv4Flow("192.168.0.4", "192.168.0.2", 1000)
+
+	time.Sleep(time.Second)
+
+	tr := traversal.NewGremlinTraversalParser()
+	tr.AddTraversalExtension(NewFlowTraversalExtension(tc, nil))
+
+	execLimited := func(query string) (traversal.GraphTraversalStep, error) {
+		ts, err := tr.Parse(strings.NewReader(query))
+		if err != nil {
+			t.Fatalf("%s: %s", query, err)
+		}
+		return ts.ExecContext(context.Background(), tc.g, false, traversal.Limits{MaxResults: 2})
+	}
+
+	// only the final result is limited, not the flows given to the next steps
+	res, err := execLimited(`G.Flows().Count()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := res.Values()[0]; count != 4 {
+		t.Fatalf("Should count 4 flows, returned: %v", count)
+	}
+
+	res, err = execLimited(`G.Flows().GroupBy("Network.A")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 groups, returned: %v", res.Values())
+	}
+
+	for _, value := range res.Values() {
+		if group := value.(*FlowGroup); group.Keys["Network.A"] == "192.168.0.1" && group.Flows != 3 {
+			t.Fatalf("Group should aggregate 3 flows, returned: %+v", group)
+		}
+	}
+
+	if _, err = execLimited(`G.Flows()`); err == nil {
+		t.Fatal("Should exceed the maximum number of results")
+	} else if _, ok := err.(*traversal.LimitError); !ok {
+		t.Fatalf("Should return a limit error, returned: %s", err)
+	}
+}
+
 func TestCaptureNodeStep(t *testing.T) {
 	tc := newFakeTableClient()
 
@@ -27,6 +27,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 
 	"github.com/skydive-project/skydive/common"
@@ -53,6 +54,8 @@ type FlowTraversalExtension struct {
 	CaptureNodeToken traversal.Token
 	AggregatesToken  traversal.Token
 	BpfToken         traversal.Token
+	GroupByToken     traversal.Token
+	TopToken         traversal.Token
 	TableClient      flow.TableClient
 	Storage          storage.Storage
 }
@@ -78,6 +81,7 @@ type FlowTraversalStep struct {
 	Storage         storage.Storage
 	flowset         *flow.FlowSet
 	flowSearchQuery filters.SearchQuery
+	source          string
 	error           error
 }
 
@@ -106,6 +110,31 @@ type BpfGremlinTraversalStep struct {
 	traversal.GremlinTraversalContext
 }
 
+// GroupByGremlinTraversalStep groupby step
+type GroupByGremlinTraversalStep struct {
+	traversal.GremlinTraversalContext
+}
+
+// TopGremlinTraversalStep top step
+type TopGremlinTraversalStep struct {
+	traversal.GremlinTraversalContext
+}
+
+// FlowGroup aggregates the flows sharing the same values for the GroupBy keys
+type FlowGroup struct {
+	Keys             map[string]interface{}
+	Flows            int64
+	Metric           *flow.FlowMetric `json:",omitempty"`
+	LastUpdateMetric *flow.FlowMetric `json:",omitempty"`
+}
+
+// FlowGroupsTraversalStep step returned by GroupBy
+type FlowGroupsTraversalStep struct {
+	GraphTraversal *traversal.GraphTraversal
+	groups         []*FlowGroup
+	error          error
+}
+
 // Out returns the B node
 func (f *FlowTraversalStep) Out(ctx traversal.StepContext, s ...interface{}) *traversal.GraphTraversalV {
 	var nodes []*graph.Node
@@ -511,6 +540,111 @@ func (f *FlowTraversalStep) Sum(ctx traversal.StepContext, keys ...interface{})
 	return traversal.NewGraphTraversalValue(f.GraphTraversal, s)
 }
 
+// GroupBy aggregates the flows by the values of the given keys, summing
+// their metrics
+func (f *FlowTraversalStep) GroupBy(ctx traversal.StepContext, keys ...interface{}) *FlowGroupsTraversalStep {
+	if f.error != nil {
+		return &FlowGroupsTraversalStep{error: f.error}
+	}
+
+	if len(keys) == 0 {
+		return &FlowGroupsTraversalStep{error: errors.New("GroupBy requires at least 1 parameter")}
+	}
+
+	var fields []string
+	for _, key := range keys {
+		k, ok := key.(string)
+		if !ok {
+			return &FlowGroupsTraversalStep{error: errors.New("GroupBy parameters have to be string keys")}
+		}
+		fields = append(fields, k)
+	}
+
+	var groups []*FlowGroup
+	index := make(map[string]*FlowGroup)
+	for _, fl := range f.flowset.Flows {
+		values := make(map[string]interface{}, len(fields))
+		hash := make([]string, len(fields))
+		for i, field := range fields {
+			// flows without the field are grouped together
+			v, _ := fl.GetField(field)
+			values[field] = v
+			hash[i] = fmt.Sprintf("%v", v)
+		}
+
+		id := strings.Join(hash, "\x00")
+		group, found := index[id]
+		if !found {
+			group = &FlowGroup{Keys: values}
+			index[id] = group
+			groups = append(groups, group)
+		}
+
+		group.Flows++
+		group.Metric = sumFlowMetrics(group.Metric, fl.Metric)
+		group.LastUpdateMetric = sumFlowMetrics(group.LastUpdateMetric, fl.LastUpdateMetric)
+	}
+
+	return &FlowGroupsTraversalStep{GraphTraversal: f.GraphTraversal, groups: groups}
+}
+
+func sumFlowMetrics(sum, m *flow.FlowMetric) *flow.FlowMetric {
+	if m == nil {
+		return sum
+	}
+	if sum == nil {
+		return m.Copy()
+	}
+
+	start, last := sum.Start, sum.Last
+	if m.Start < start {
+		start = m.Start
+	}
+	if m.Last > last {
+		last = m.Last
+	}
+
+	sum = sum.Add(m).(*flow.FlowMetric)
+	sum.Start, sum.Last = start, last
+
+	return sum
+}
+
+func parseTopParameters(params ...interface{}) (int, string, error) {
+	if len(params) != 2 {
+		return 0, "", errors.New("Top requires 2 parameters")
+	}
+
+	n, ok := params[0].(int64)
+	if !ok || n < 0 {
+		return 0, "", errors.New("Top first parameter has to be a positive integer")
+	}
+
+	key, ok := params[1].(string)
+	if !ok {
+		return 0, "", errors.New("Top second parameter has to be a string key")
+	}
+
+	return int(n), key, nil
+}
+
+// Top keeps the n flows with the highest values for the given key
+func (f *FlowTraversalStep) Top(ctx traversal.StepContext, params ...interface{}) *FlowTraversalStep {
+	if f.error != nil {
+		return f
+	}
+
+	n, key, err := parseTopParameters(params...)
+	if err != nil {
+		return &FlowTraversalStep{error: err}
+	}
+
+	f.flowset.Sort(common.SortDescending, key)
+	f.flowset.Slice(0, n)
+
+	return &FlowTraversalStep{GraphTraversal: f.GraphTraversal, Storage: f.Storage, flowset: f.flowset}
+}
+
 // PropertyValues returns a flow field value
 func (f *FlowTraversalStep) PropertyValues(ctx traversal.StepContext, keys ...interface{}) *traversal.GraphTraversalValue {
 	if f.error != nil {
@@ -717,6 +851,16 @@ func (f *FlowTraversalStep) Error() error {
 	return f.error
 }
 
+// ProfileInfo returns where the flows were retrieved from along with the
+// query issued, so that the Profile step tells whether it was done by the
+// storage or by the agents
+func (f *FlowTraversalStep) ProfileInfo() map[string]interface{} {
+	if f.source == "" {
+		return map[string]interface{}{"Source": "none"}
+	}
+	return map[string]interface{}{"Source": f.source, "Query": f.flowSearchQuery}
+}
+
 // NewFlowTraversalExtension creates a new flow traversal extension for Gremlin parser
 func NewFlowTraversalExtension(client flow.TableClient, storage storage.Storage) *FlowTraversalExtension {
 	return &FlowTraversalExtension{
@@ -726,6 +870,8 @@ func NewFlowTraversalExtension(client flow.TableClient, storage storage.Storage)
 		CaptureNodeToken: traversalCaptureNodeToken,
 		AggregatesToken:  traversalAggregatesToken,
 		BpfToken:         traversalBpfToken,
+		GroupByToken:     traversalGroupByToken,
+		TopToken:         traversalTopToken,
 		TableClient:      client,
 		Storage:          storage,
 	}
@@ -746,6 +892,10 @@ func (e *FlowTraversalExtension) ScanIdent(s string) (traversal.Token, bool) {
 		return e.AggregatesToken, true
 	case "BPF":
 		return e.BpfToken, true
+	case "GROUPBY":
+		return e.GroupByToken, true
+	case "TOP":
+		return e.TopToken, true
 	}
 	return traversal.IDENT, false
 }
@@ -774,6 +924,10 @@ func (e *FlowTraversalExtension) ParseStep(t traversal.Token, p traversal.Gremli
 		return &AggregatesGremlinTraversalStep{GremlinTraversalContext: p}, nil
 	case e.BpfToken:
 		return &BpfGremlinTraversalStep{GremlinTraversalContext: p}, nil
+	case e.GroupByToken:
+		return &GroupByGremlinTraversalStep{GremlinTraversalContext: p}, nil
+	case e.TopToken:
+		return &TopGremlinTraversalStep{GremlinTraversalContext: p}, nil
 	}
 
 	return nil, nil
@@ -834,6 +988,7 @@ func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (trav
 	var err error
 	var context graph.Context
 	var nodes []*graph.Node
+	var source string
 
 	flowSearchQuery, err := s.makeSearchQuery()
 	if err != nil {
@@ -862,6 +1017,17 @@ func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (trav
 	case *traversal.GraphTraversalShortestPath:
 		graphTraversal = tv.GraphTraversal
 
+		graphTraversal.RLock()
+		context = graphTraversal.Graph.GetContext()
+		// not need to get flows from node not supporting capture
+		if nodes = captureAllowedNodes(tv.GetNodes()); len(nodes) == 0 {
+			graphTraversal.RUnlock()
+			return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowset: flowset, flowSearchQuery: flowSearchQuery}, nil
+		}
+		graphTraversal.RUnlock()
+	case *traversal.GraphTraversalPath:
+		graphTraversal = tv.GraphTraversal
+
 		graphTraversal.RLock()
 		context = graphTraversal.Graph.GetContext()
 		// not need to get flows from node not supporting capture
@@ -891,19 +1057,21 @@ func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (trav
 		// We do nothing as the following step is Metrics
 		// and we'll make a request on metrics instead of flows
 		if s.metricsNextStep {
-			return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowSearchQuery: flowSearchQuery}, nil
+			return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowSearchQuery: flowSearchQuery, source: "storage by the Metrics step"}, nil
 		}
 
 		// We do nothing as the following step is Metrics
 		// and we'll make a request on rawpackets instead of flows
 		if s.rawpacketsNextStep {
-			return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowSearchQuery: flowSearchQuery}, nil
+			return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowSearchQuery: flowSearchQuery, source: "storage by the RawPackets step"}, nil
 		}
 
 		if flowset, err = s.Storage.SearchFlows(flowSearchQuery); err != nil {
 			return nil, err
 		}
+		source = "storage"
 	} else {
+		source = "agents"
 		if len(nodes) != 0 {
 			graphTraversal.RLock()
 			hnmap := topology.BuildHostNodeTIDMap(nodes)
@@ -919,11 +1087,15 @@ func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (trav
 		return nil, err
 	}
 
+	if err := graphTraversal.CheckLimits(len(flowset.Flows)); err != nil {
+		return nil, err
+	}
+
 	if r := s.context.StepContext.PaginationRange; r != nil {
 		flowset.Slice(int(r[0]), int(r[1]))
 	}
 
-	return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowset: flowset, flowSearchQuery: flowSearchQuery}, nil
+	return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowset: flowset, flowSearchQuery: flowSearchQuery, source: source}, nil
 }
 
 // Reduce flow step
@@ -1112,3 +1284,136 @@ func (s *BpfGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) (t
 func (s *BpfGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
 	return &s.GremlinTraversalContext
 }
+
+// GetFieldInt64 returns the value of a group field
+func (g *FlowGroup) GetFieldInt64(field string) (int64, error) {
+	if field == "Flows" {
+		return g.Flows, nil
+	}
+
+	fields := strings.SplitN(field, ".", 2)
+	if len(fields) == 2 {
+		switch fields[0] {
+		case "Metric":
+			if g.Metric != nil {
+				return g.Metric.GetFieldInt64(fields[1])
+			}
+			return 0, common.ErrFieldNotFound
+		case "LastUpdateMetric":
+			if g.LastUpdateMetric != nil {
+				return g.LastUpdateMetric.GetFieldInt64(fields[1])
+			}
+			return 0, common.ErrFieldNotFound
+		}
+	}
+
+	if v, ok := g.Keys[field]; ok {
+		if i, err := common.ToInt64(v); err == nil {
+			return i, nil
+		}
+	}
+
+	return 0, common.ErrFieldNotFound
+}
+
+// Top keeps the n groups with the highest values for the given key
+func (g *FlowGroupsTraversalStep) Top(ctx traversal.StepContext, params ...interface{}) *FlowGroupsTraversalStep {
+	if g.error != nil {
+		return g
+	}
+
+	n, key, err := parseTopParameters(params...)
+	if err != nil {
+		return &FlowGroupsTraversalStep{error: err}
+	}
+
+	values := make(map[*FlowGroup]int64, len(g.groups))
+	for _, group := range g.groups {
+		v, err := group.GetFieldInt64(key)
+		if err != nil {
+			return &FlowGroupsTraversalStep{error: err}
+		}
+		values[group] = v
+	}
+
+	groups := make([]*FlowGroup, len(g.groups))
+	copy(groups, g.groups)
+	sort.SliceStable(groups, func(i, j int) bool {
+		return values[groups[i]] > values[groups[j]]
+	})
+
+	if n < len(groups) {
+		groups = groups[:n]
+	}
+
+	return &FlowGroupsTraversalStep{GraphTraversal: g.GraphTraversal, groups: groups}
+}
+
+// Count step
+func (g *FlowGroupsTraversalStep) Count(ctx traversal.StepContext, s ...interface{}) *traversal.GraphTraversalValue {
+	if g.error != nil {
+		return traversal.NewGraphTraversalValueFromError(g.error)
+	}
+
+	return traversal.NewGraphTraversalValue(g.GraphTraversal, len(g.groups))
+}
+
+// Values returns list of flow groups
+func (g *FlowGroupsTraversalStep) Values() []interface{} {
+	a := make([]interface{}, len(g.groups))
+	for i, group := range g.groups {
+		a[i] = group
+	}
+	return a
+}
+
+// MarshalJSON serialize in JSON
+func (g *FlowGroupsTraversalStep) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.Values())
+}
+
+// Error returns traversal error
+func (g *FlowGroupsTraversalStep) Error() error {
+	return g.error
+}
+
+// Exec GroupBy step
+func (s *GroupByGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	switch last.(type) {
+	case *FlowTraversalStep:
+		fs := last.(*FlowTraversalStep)
+		return fs.GroupBy(s.StepContext, s.Params...), nil
+	}
+	return nil, traversal.ErrExecutionError
+}
+
+// Reduce GroupBy step
+func (s *GroupByGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) (traversal.GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Context of GroupBy step
+func (s *GroupByGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.GremlinTraversalContext
+}
+
+// Exec Top step
+func (s *TopGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	switch tv := last.(type) {
+	case *FlowTraversalStep:
+		return tv.Top(s.StepContext, s.Params...), nil
+	case *FlowGroupsTraversalStep:
+		return tv.Top(s.StepContext, s.Params...), nil
+	}
+	return nil, traversal.ErrExecutionError
+}
+
+// Reduce Top step
+func (s *TopGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) (traversal.GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Context of Top step
+func (s *TopGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.GremlinTraversalContext
+}
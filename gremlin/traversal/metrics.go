@@ -26,6 +26,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"sort"
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/topology/graph/traversal"
@@ -37,7 +39,12 @@ const (
 
 // MetricsTraversalExtension describes a new extension to enhance the topology
 type MetricsTraversalExtension struct {
-	MetricsToken traversal.Token
+	MetricsToken    traversal.Token
+	RateToken       traversal.Token
+	MaxToken        traversal.Token
+	MinToken        traversal.Token
+	AvgToken        traversal.Token
+	PercentileToken traversal.Token
 }
 
 // MetricsGremlinTraversalStep describes the Metrics gremlin traversal step
@@ -45,10 +52,40 @@ type MetricsGremlinTraversalStep struct {
 	traversal.GremlinTraversalContext
 }
 
+// RateGremlinTraversalStep describes the Rate gremlin traversal step
+type RateGremlinTraversalStep struct {
+	traversal.GremlinTraversalContext
+}
+
+// MaxGremlinTraversalStep describes the Max gremlin traversal step
+type MaxGremlinTraversalStep struct {
+	traversal.GremlinTraversalContext
+}
+
+// MinGremlinTraversalStep describes the Min gremlin traversal step
+type MinGremlinTraversalStep struct {
+	traversal.GremlinTraversalContext
+}
+
+// AvgGremlinTraversalStep describes the Avg gremlin traversal step
+type AvgGremlinTraversalStep struct {
+	traversal.GremlinTraversalContext
+}
+
+// PercentileGremlinTraversalStep describes the Percentile gremlin traversal step
+type PercentileGremlinTraversalStep struct {
+	traversal.GremlinTraversalContext
+}
+
 // NewMetricsTraversalExtension returns a new graph traversal extension
 func NewMetricsTraversalExtension() *MetricsTraversalExtension {
 	return &MetricsTraversalExtension{
-		MetricsToken: traversalMetricsToken,
+		MetricsToken:    traversalMetricsToken,
+		RateToken:       traversalRateToken,
+		MaxToken:        traversalMaxToken,
+		MinToken:        traversalMinToken,
+		AvgToken:        traversalAvgToken,
+		PercentileToken: traversalPercentileToken,
 	}
 }
 
@@ -57,6 +94,16 @@ func (e *MetricsTraversalExtension) ScanIdent(s string) (traversal.Token, bool)
 	switch s {
 	case "METRICS":
 		return e.MetricsToken, true
+	case "RATE":
+		return e.RateToken, true
+	case "MAX":
+		return e.MaxToken, true
+	case "MIN":
+		return e.MinToken, true
+	case "AVG":
+		return e.AvgToken, true
+	case "PERCENTILE":
+		return e.PercentileToken, true
 	}
 	return traversal.IDENT, false
 }
@@ -66,6 +113,16 @@ func (e *MetricsTraversalExtension) ParseStep(t traversal.Token, p traversal.Gre
 	switch t {
 	case e.MetricsToken:
 		return &MetricsGremlinTraversalStep{GremlinTraversalContext: p}, nil
+	case e.RateToken:
+		return &RateGremlinTraversalStep{GremlinTraversalContext: p}, nil
+	case e.MaxToken:
+		return &MaxGremlinTraversalStep{GremlinTraversalContext: p}, nil
+	case e.MinToken:
+		return &MinGremlinTraversalStep{GremlinTraversalContext: p}, nil
+	case e.AvgToken:
+		return &AvgGremlinTraversalStep{GremlinTraversalContext: p}, nil
+	case e.PercentileToken:
+		return &PercentileGremlinTraversalStep{GremlinTraversalContext: p}, nil
 	}
 	return nil, nil
 }
@@ -91,6 +148,200 @@ func (s *MetricsGremlinTraversalStep) Context() *traversal.GremlinTraversalConte
 	return &s.GremlinTraversalContext
 }
 
+// Exec Rate step
+func (s *RateGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	if mts, ok := last.(*MetricsTraversalStep); ok {
+		return mts.Rate(s.StepContext, s.Params...), nil
+	}
+	return nil, traversal.ErrExecutionError
+}
+
+// Reduce Rate step
+func (s *RateGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) (traversal.GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Context Rate step
+func (s *RateGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.GremlinTraversalContext
+}
+
+// Exec Max step
+func (s *MaxGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	if mts, ok := last.(*MetricsTraversalStep); ok {
+		return mts.Max(s.StepContext, s.Params...), nil
+	}
+	return nil, traversal.ErrExecutionError
+}
+
+// Reduce Max step
+func (s *MaxGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) (traversal.GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Context Max step
+func (s *MaxGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.GremlinTraversalContext
+}
+
+// Exec Min step
+func (s *MinGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	if mts, ok := last.(*MetricsTraversalStep); ok {
+		return mts.Min(s.StepContext, s.Params...), nil
+	}
+	return nil, traversal.ErrExecutionError
+}
+
+// Reduce Min step
+func (s *MinGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) (traversal.GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Context Min step
+func (s *MinGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.GremlinTraversalContext
+}
+
+// Exec Avg step
+func (s *AvgGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	if mts, ok := last.(*MetricsTraversalStep); ok {
+		return mts.Avg(s.StepContext, s.Params...), nil
+	}
+	return nil, traversal.ErrExecutionError
+}
+
+// Reduce Avg step
+func (s *AvgGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) (traversal.GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Context Avg step
+func (s *AvgGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.GremlinTraversalContext
+}
+
+// Exec Percentile step
+func (s *PercentileGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (traversal.GraphTraversalStep, error) {
+	if mts, ok := last.(*MetricsTraversalStep); ok {
+		return mts.Percentile(s.StepContext, s.Params...), nil
+	}
+	return nil, traversal.ErrExecutionError
+}
+
+// Reduce Percentile step
+func (s *PercentileGremlinTraversalStep) Reduce(next traversal.GremlinTraversalStep) (traversal.GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Context Percentile step
+func (s *PercentileGremlinTraversalStep) Context() *traversal.GremlinTraversalContext {
+	return &s.GremlinTraversalContext
+}
+
+// RateMetric holds the per second rates of the counters of a metric, as
+// returned by the Rate step
+type RateMetric struct {
+	Start int64
+	Last  int64
+	Rates map[string]float64
+}
+
+// GetStart returns start time
+func (rm *RateMetric) GetStart() int64 {
+	return rm.Start
+}
+
+// SetStart set start time
+func (rm *RateMetric) SetStart(start int64) {
+	rm.Start = start
+}
+
+// GetLast returns last time
+func (rm *RateMetric) GetLast() int64 {
+	return rm.Last
+}
+
+// SetLast set last time
+func (rm *RateMetric) SetLast(last int64) {
+	rm.Last = last
+}
+
+// GetFieldInt64 returns the rate of a counter rounded to an integer
+func (rm *RateMetric) GetFieldInt64(field string) (int64, error) {
+	rate, ok := rm.Rates[field]
+	if !ok {
+		return 0, common.ErrFieldNotFound
+	}
+	return int64(math.Floor(rate + 0.5)), nil
+}
+
+func (rm *RateMetric) combine(m common.Metric, sign float64) common.Metric {
+	result := &RateMetric{Start: rm.Start, Last: rm.Last, Rates: make(map[string]float64)}
+	for field, rate := range rm.Rates {
+		result.Rates[field] = rate
+	}
+	for field, rate := range m.(*RateMetric).Rates {
+		result.Rates[field] += sign * rate
+	}
+	return result
+}
+
+// Add sums the rates of two metrics
+func (rm *RateMetric) Add(m common.Metric) common.Metric {
+	return rm.combine(m, 1)
+}
+
+// Sub subtracts the rates of two metrics
+func (rm *RateMetric) Sub(m common.Metric) common.Metric {
+	return rm.combine(m, -1)
+}
+
+// Split a metric into two parts, the rates being the same on both parts
+func (rm *RateMetric) Split(cut int64) (common.Metric, common.Metric) {
+	if cut <= rm.Start {
+		return nil, rm
+	} else if cut >= rm.Last {
+		return rm, nil
+	}
+
+	m1 := rm.combine(&RateMetric{}, 1).(*RateMetric)
+	m1.Last = cut
+	m2 := rm.combine(&RateMetric{}, 1).(*RateMetric)
+	m2.Start = cut
+
+	return m1, m2
+}
+
+// IsZero returns true if all the rates are equal to zero
+func (rm *RateMetric) IsZero() bool {
+	for _, rate := range rm.Rates {
+		if rate != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// GetFields returns the names of the counters
+func (rm *RateMetric) GetFields() []string {
+	var fields []string
+	for field := range rm.Rates {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// MarshalJSON serialize in JSON, the rates being along the time boundaries
+// as are the counters of the other metrics
+func (rm *RateMetric) MarshalJSON() ([]byte, error) {
+	values := map[string]interface{}{"Start": rm.Start, "Last": rm.Last}
+	for field, rate := range rm.Rates {
+		values[field] = rate
+	}
+	return json.Marshal(values)
+}
+
 // MetricsTraversalStep traversal step metric interface counters
 type MetricsTraversalStep struct {
 	GraphTraversal *traversal.GraphTraversal
@@ -211,6 +462,10 @@ func (m *MetricsTraversalStep) Aggregates(ctx traversal.StepContext, s ...interf
 		return NewMetricsTraversalStepFromError(m.error)
 	}
 
+	if m.hasRates() {
+		return NewMetricsTraversalStepFromError(errors.New("Aggregates has to be used before Rate"))
+	}
+
 	sliceLength := defaultAggregatesSliceLength
 	if len(s) != 0 {
 		sl, ok := s[0].(int64)
@@ -246,6 +501,242 @@ func (m *MetricsTraversalStep) Aggregates(ctx traversal.StepContext, s ...interf
 	return NewMetricsTraversalStep(m.GraphTraversal, map[string][]common.Metric{"Aggregated": final})
 }
 
+// hasRates returns whether the metrics were returned by the Rate step
+func (m *MetricsTraversalStep) hasRates() bool {
+	for _, metrics := range m.metrics {
+		if len(metrics) > 0 {
+			_, ok := metrics[0].(*RateMetric)
+			return ok
+		}
+	}
+	return false
+}
+
+// timeSlice returns the time slice of the query or, without time context,
+// the one covered by the metrics
+func (m *MetricsTraversalStep) timeSlice() (start, last int64) {
+	if ts := m.GraphTraversal.Graph.GetContext().TimeSlice; ts != nil {
+		return ts.Start, ts.Last
+	}
+
+	for _, metrics := range m.metrics {
+		for _, metric := range metrics {
+			if start == 0 || metric.GetStart() < start {
+				start = metric.GetStart()
+			}
+			if metric.GetLast() > last {
+				last = metric.GetLast()
+			}
+		}
+	}
+	return
+}
+
+// Rate turns the counters of each metrics array into per second rates. The
+// metrics are first aggregated in time buckets of the given number of
+// seconds, 30 by default.
+func (m *MetricsTraversalStep) Rate(ctx traversal.StepContext, s ...interface{}) *MetricsTraversalStep {
+	if m.error != nil {
+		return NewMetricsTraversalStepFromError(m.error)
+	}
+
+	if m.hasRates() {
+		return NewMetricsTraversalStepFromError(errors.New("Rate can only be used once"))
+	}
+
+	sliceLength := defaultAggregatesSliceLength
+	if len(s) != 0 {
+		sl, ok := s[0].(int64)
+		if !ok || sl <= 0 || len(s) > 1 {
+			return NewMetricsTraversalStepFromError(fmt.Errorf("Rate parameter has to be a positive number"))
+		}
+		sliceLength = sl * 1000 // Millisecond
+	}
+
+	start, last := m.timeSlice()
+
+	steps := (last - start) / sliceLength
+	if (last-start)%sliceLength != 0 {
+		steps++
+	}
+
+	rates := make(map[string][]common.Metric)
+	if steps <= 0 {
+		return NewMetricsTraversalStep(m.GraphTraversal, rates)
+	}
+	for id, metrics := range m.metrics {
+		// aggregateMetrics replaces the metrics it splits
+		series := make([]common.Metric, len(metrics))
+		copy(series, metrics)
+
+		buckets := make([]common.Metric, steps, steps)
+		aggregateMetrics(series, start, last, sliceLength, buckets)
+
+		var fields []string
+		if len(metrics) > 0 {
+			fields = metrics[0].GetFields()
+		}
+
+		for i, bucket := range buckets {
+			// slots without metric have a zero rate so that the series
+			// covers the whole time slice
+			if bucket == nil || bucket.GetLast() <= bucket.GetStart() {
+				rate := &RateMetric{Start: start + int64(i)*sliceLength, Rates: make(map[string]float64)}
+				if rate.Last = rate.Start + sliceLength; rate.Last > last {
+					rate.Last = last
+				}
+				for _, field := range fields {
+					rate.Rates[field] = 0
+				}
+				rates[id] = append(rates[id], rate)
+				continue
+			}
+
+			duration := float64(bucket.GetLast()-bucket.GetStart()) / 1000
+			rate := &RateMetric{Start: bucket.GetStart(), Last: bucket.GetLast(), Rates: make(map[string]float64)}
+			for _, field := range bucket.GetFields() {
+				if value, err := bucket.GetFieldInt64(field); err == nil {
+					rate.Rates[field] = float64(value) / duration
+				}
+			}
+			rates[id] = append(rates[id], rate)
+		}
+	}
+
+	return NewMetricsTraversalStep(m.GraphTraversal, rates)
+}
+
+// reduceSeries applies fnc to the values of the metrics of each array,
+// for the field given as parameter or for every field
+func (m *MetricsTraversalStep) reduceSeries(name string, keys []interface{}, fnc func(values []float64) float64) *traversal.GraphTraversalValue {
+	if m.error != nil {
+		return traversal.NewGraphTraversalValueFromError(m.error)
+	}
+
+	var key string
+	if len(keys) > 0 {
+		if len(keys) != 1 {
+			return traversal.NewGraphTraversalValueFromError(fmt.Errorf("%s accepts at most 1 field", name))
+		}
+
+		k, ok := keys[0].(string)
+		if !ok {
+			return traversal.NewGraphTraversalValueFromError(fmt.Errorf("Field of %s must be a string", name))
+		}
+		key = k
+	}
+
+	result := make(map[string]interface{})
+	for id, metrics := range m.metrics {
+		values := make(map[string][]float64)
+		for _, metric := range metrics {
+			fields := metric.GetFields()
+			if key != "" {
+				fields = []string{key}
+			}
+
+			for _, field := range fields {
+				var value float64
+				if rate, ok := metric.(*RateMetric); ok {
+					value = rate.Rates[field]
+				} else {
+					v, err := metric.GetFieldInt64(field)
+					if err != nil {
+						if key != "" {
+							return traversal.NewGraphTraversalValueFromError(err)
+						}
+						continue
+					}
+					value = float64(v)
+				}
+				values[field] = append(values[field], value)
+			}
+		}
+
+		if key != "" {
+			if len(values[key]) > 0 {
+				result[id] = fnc(values[key])
+			}
+			continue
+		}
+
+		fields := make(map[string]float64)
+		for field, v := range values {
+			fields[field] = fnc(v)
+		}
+		result[id] = fields
+	}
+
+	return traversal.NewGraphTraversalValue(m.GraphTraversal, result)
+}
+
+// Max returns the highest value of the metrics of each array
+func (m *MetricsTraversalStep) Max(ctx traversal.StepContext, keys ...interface{}) *traversal.GraphTraversalValue {
+	return m.reduceSeries("Max", keys, func(values []float64) float64 {
+		max := values[0]
+		for _, value := range values[1:] {
+			max = math.Max(max, value)
+		}
+		return max
+	})
+}
+
+// Min returns the lowest value of the metrics of each array
+func (m *MetricsTraversalStep) Min(ctx traversal.StepContext, keys ...interface{}) *traversal.GraphTraversalValue {
+	return m.reduceSeries("Min", keys, func(values []float64) float64 {
+		min := values[0]
+		for _, value := range values[1:] {
+			min = math.Min(min, value)
+		}
+		return min
+	})
+}
+
+// Avg returns the mean value of the metrics of each array
+func (m *MetricsTraversalStep) Avg(ctx traversal.StepContext, keys ...interface{}) *traversal.GraphTraversalValue {
+	return m.reduceSeries("Avg", keys, func(values []float64) float64 {
+		var sum float64
+		for _, value := range values {
+			sum += value
+		}
+		return sum / float64(len(values))
+	})
+}
+
+// Percentile returns the value of the metrics of each array below which
+// falls the given percentage of the values, using the nearest-rank method
+func (m *MetricsTraversalStep) Percentile(ctx traversal.StepContext, s ...interface{}) *traversal.GraphTraversalValue {
+	if m.error != nil {
+		return traversal.NewGraphTraversalValueFromError(m.error)
+	}
+
+	var percent float64
+	if len(s) > 0 {
+		switch p := s[0].(type) {
+		case int64:
+			percent = float64(p)
+		case float64:
+			percent = p
+		}
+	}
+
+	if percent <= 0 || percent > 100 {
+		return traversal.NewGraphTraversalValueFromError(errors.New("Percentile requires a percentage between 0 and 100"))
+	}
+
+	return m.reduceSeries("Percentile", s[1:], func(values []float64) float64 {
+		sorted := make([]float64, len(values))
+		copy(sorted, values)
+		sort.Float64s(sorted)
+
+		rank := int(math.Ceil(percent / 100 * float64(len(sorted))))
+		if rank < 1 {
+			rank = 1
+		}
+		return sorted[rank-1]
+	})
+}
+
 // Values returns the graph metric values
 func (m *MetricsTraversalStep) Values() []interface{} {
 	if len(m.metrics) == 0 {
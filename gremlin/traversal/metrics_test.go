@@ -702,3 +702,121 @@ func TestFlowMetricsAggregatesSum3(t *testing.T) {
 
 	testMetricSum(t, metrics, expected, time.Unix(30, 0), 30*time.Second)
 }
+
+func TestFlowMetricsRate(t *testing.T) {
+	metrics := map[string][]common.Metric{
+		"aa": {
+			&flow.FlowMetric{ABBytes: 1000, ABPackets: 10, Start: 0, Last: 10000},
+			&flow.FlowMetric{ABBytes: 3000, ABPackets: 30, Start: 10000, Last: 20000},
+		},
+	}
+
+	g := graph.NewGraph("test", &FakeGraphBackend{}, common.UnknownService)
+
+	gt := traversal.NewGraphTraversal(g, false)
+	gt = gt.Context(time.Unix(20, 0), 20*time.Second)
+	ctx := traversal.StepContext{}
+
+	got := NewMetricsTraversalStep(gt, metrics).Rate(ctx, int64(10))
+	if got.Error() != nil {
+		t.Fatal(got.Error())
+	}
+
+	rates := got.Values()[0].(map[string][]common.Metric)["aa"]
+	if len(rates) != 2 {
+		t.Fatalf("Should return 2 rates, got: %v", rates)
+	}
+
+	for i, expected := range []float64{100, 300} {
+		rate := rates[i].(*RateMetric)
+		if rate.Rates["ABBytes"] != expected || rate.Rates["ABPackets"] != expected/100 {
+			t.Errorf("Wrong rate, expected %f bytes per second, got: %+v", expected, rate)
+		}
+	}
+
+	if err := got.Aggregates(ctx).Error(); err == nil {
+		t.Error("Should return an error as Aggregates has to be used before Rate")
+	}
+}
+
+func TestFlowMetricsRateGap(t *testing.T) {
+	metrics := map[string][]common.Metric{
+		"aa": {
+			&flow.FlowMetric{ABBytes: 1000, ABPackets: 10, Start: 0, Last: 10000},
+			&flow.FlowMetric{ABBytes: 3000, ABPackets: 30, Start: 20000, Last: 30000},
+		},
+	}
+
+	g := graph.NewGraph("test", &FakeGraphBackend{}, common.UnknownService)
+
+	gt := traversal.NewGraphTraversal(g, false)
+	gt = gt.Context(time.Unix(30, 0), 30*time.Second)
+	ctx := traversal.StepContext{}
+
+	got := NewMetricsTraversalStep(gt, metrics).Rate(ctx, int64(10))
+	if got.Error() != nil {
+		t.Fatal(got.Error())
+	}
+
+	rates := got.Values()[0].(map[string][]common.Metric)["aa"]
+	if len(rates) != 3 {
+		t.Fatalf("Should return 3 rates, got: %v", rates)
+	}
+
+	// the slot without metric has a zero rate
+	for i, expected := range []float64{100, 0, 300} {
+		rate := rates[i].(*RateMetric)
+		if rate.Start != int64(i)*10000 || rate.Last != int64(i+1)*10000 {
+			t.Errorf("Wrong time slot, expected [%d, %d], got: %+v", i*10000, (i+1)*10000, rate)
+		}
+
+		value, found := rate.Rates["ABBytes"]
+		if !found || value != expected {
+			t.Errorf("Wrong rate, expected %f bytes per second, got: %+v", expected, rate)
+		}
+	}
+}
+
+func TestFlowMetricsStatistics(t *testing.T) {
+	var series []common.Metric
+	for i := int64(1); i <= 20; i++ {
+		series = append(series, &flow.FlowMetric{ABBytes: i * 10, Start: (i - 1) * 1000, Last: i * 1000})
+	}
+	metrics := map[string][]common.Metric{"aa": series}
+
+	g := graph.NewGraph("test", &FakeGraphBackend{}, common.UnknownService)
+	gt := traversal.NewGraphTraversal(g, false)
+	ctx := traversal.StepContext{}
+
+	step := NewMetricsTraversalStep(gt, metrics)
+
+	for _, test := range []struct {
+		name     string
+		value    *traversal.GraphTraversalValue
+		expected float64
+	}{
+		{"Max", step.Max(ctx, "ABBytes"), 200},
+		{"Min", step.Min(ctx, "ABBytes"), 10},
+		{"Avg", step.Avg(ctx, "ABBytes"), 105},
+		{"Percentile", step.Percentile(ctx, int64(95), "ABBytes"), 190},
+		{"Percentile", step.Percentile(ctx, 50.0, "ABBytes"), 100},
+	} {
+		if test.value.Error() != nil {
+			t.Fatalf("%s: %s", test.name, test.value.Error())
+		}
+
+		got := test.value.Values()[0].(map[string]interface{})["aa"]
+		if got != test.expected {
+			t.Errorf("%s should return %f, got: %v", test.name, test.expected, got)
+		}
+	}
+
+	fields := step.Max(ctx).Values()[0].(map[string]interface{})["aa"].(map[string]float64)
+	if fields["ABBytes"] != 200 || fields["BABytes"] != 0 {
+		t.Errorf("Wrong maximum values: %v", fields)
+	}
+
+	if err := step.Percentile(ctx, int64(101)).Error(); err == nil {
+		t.Error("Should return an error as the percentage is above 100")
+	}
+}
@@ -35,4 +35,11 @@ const (
 	traversalMetricsToken     traversal.Token = 1008
 	traversalSocketsToken     traversal.Token = 1009
 	traversalDescendantsToken traversal.Token = 1010
+	traversalGroupByToken     traversal.Token = 1011
+	traversalTopToken         traversal.Token = 1012
+	traversalRateToken        traversal.Token = 1013
+	traversalMaxToken         traversal.Token = 1014
+	traversalMinToken         traversal.Token = 1015
+	traversalAvgToken         traversal.Token = 1016
+	traversalPercentileToken  traversal.Token = 1017
 )
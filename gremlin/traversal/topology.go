@@ -23,8 +23,6 @@
 package traversal
 
 import (
-	"strings"
-
 	"github.com/mitchellh/mapstructure"
 
 	"github.com/skydive-project/skydive/common"
@@ -120,8 +118,14 @@ nodeloop:
 
 // TopologyGremlinQuery run a gremlin query on the graph g without any extension
 func TopologyGremlinQuery(g *graph.Graph, query string) (traversal.GraphTraversalStep, error) {
+	return TopologyGremlinQueryWithBindings(g, query, nil)
+}
+
+// TopologyGremlinQueryWithBindings run a gremlin query, whose bind variables
+// are replaced by the given values, on the graph g without any extension
+func TopologyGremlinQueryWithBindings(g *graph.Graph, query string, bindings map[string]interface{}) (traversal.GraphTraversalStep, error) {
 	tr := traversal.NewGremlinTraversalParser()
-	ts, err := tr.Parse(strings.NewReader(query))
+	ts, err := tr.ParseQuery(query, bindings)
 	if err != nil {
 		return nil, err
 	}
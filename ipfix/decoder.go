@@ -0,0 +1,405 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package ipfix
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/skydive-project/skydive/logging"
+)
+
+// Versions of the supported export protocols
+const (
+	NetFlowV9Version uint16 = 9
+	IPFIXVersion     uint16 = 10
+)
+
+// VariableLength is the field length used by IPFIX templates to announce
+// a variable length information element
+const VariableLength uint16 = 65535
+
+const (
+	netFlowV9HeaderLength = 20
+	ipfixHeaderLength     = 16
+	setHeaderLength       = 4
+	minDataSetID          = 256
+)
+
+// Information element identifiers shared by NetFlow v9 and IPFIX, see
+// https://www.iana.org/assignments/ipfix/ipfix.xhtml
+const (
+	IEOctetDeltaCount            uint16 = 1
+	IEPacketDeltaCount           uint16 = 2
+	IEProtocolIdentifier         uint16 = 4
+	IETCPControlBits             uint16 = 6
+	IESourceTransportPort        uint16 = 7
+	IESourceIPv4Address          uint16 = 8
+	IEDestinationTransportPort   uint16 = 11
+	IEDestinationIPv4Address     uint16 = 12
+	IEFlowEndSysUpTime           uint16 = 21
+	IEFlowStartSysUpTime         uint16 = 22
+	IESourceIPv6Address          uint16 = 27
+	IEDestinationIPv6Address     uint16 = 28
+	IEICMPTypeCodeIPv4           uint16 = 32
+	IESourceMacAddress           uint16 = 56
+	IEPostDestinationMacAddress  uint16 = 57
+	IEVlanID                     uint16 = 58
+	IEDestinationMacAddress      uint16 = 80
+	IEOctetTotalCount            uint16 = 85
+	IEPacketTotalCount           uint16 = 86
+	IEICMPTypeCodeIPv6           uint16 = 139
+	IEFlowStartSeconds           uint16 = 150
+	IEFlowEndSeconds             uint16 = 151
+	IEFlowStartMilliseconds      uint16 = 152
+	IEFlowEndMilliseconds        uint16 = 153
+	IESystemInitTimeMilliseconds uint16 = 160
+)
+
+var (
+	// ErrTruncated the message is shorter than announced
+	ErrTruncated = errors.New("truncated message")
+	// ErrInvalidTemplate the template record is malformed
+	ErrInvalidTemplate = errors.New("invalid template")
+)
+
+// TemplateField describes one field of a template record
+type TemplateField struct {
+	ID           uint16
+	Length       uint16
+	EnterpriseID uint32
+}
+
+// Template describes the layout of the data records of a set
+type Template struct {
+	ID         uint16
+	ScopeCount int
+	Fields     []TemplateField
+}
+
+// Record is a decoded data record. Values are indexed by IANA information
+// element identifier and reference the decoded message buffer.
+type Record struct {
+	Fields map[uint16][]byte
+}
+
+// Message is a decoded NetFlow v9 or IPFIX message. Times are expressed
+// in milliseconds.
+type Message struct {
+	Version           uint16
+	ExportTime        int64
+	SysUpTime         int64
+	SequenceNumber    uint32
+	ObservationDomain uint32
+	Records           []*Record
+}
+
+type templateKey struct {
+	exporter string
+	domain   uint32
+	id       uint16
+}
+
+// Decoder decodes NetFlow v9 and IPFIX messages and keeps track of the
+// templates announced by each exporter
+type Decoder struct {
+	templates map[templateKey]*Template
+}
+
+// Uint returns the value of an unsigned integer information element,
+// reduced-size encoding is supported
+func (r *Record) Uint(id uint16) (uint64, bool) {
+	b, ok := r.Fields[id]
+	if !ok || len(b) == 0 || len(b) > 8 {
+		return 0, false
+	}
+
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, true
+}
+
+// IP returns the value of an IPv4 or IPv6 address information element
+func (r *Record) IP(id uint16) (net.IP, bool) {
+	b, ok := r.Fields[id]
+	if !ok || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, false
+	}
+	return net.IP(b), true
+}
+
+// MAC returns the value of a MAC address information element
+func (r *Record) MAC(id uint16) (net.HardwareAddr, bool) {
+	b, ok := r.Fields[id]
+	if !ok || len(b) != 6 {
+		return nil, false
+	}
+	return net.HardwareAddr(b), true
+}
+
+// minLength returns the minimal length of a data record using this template
+func (t *Template) minLength() int {
+	length := 0
+	for _, field := range t.Fields {
+		if field.Length == VariableLength {
+			length++
+		} else {
+			length += int(field.Length)
+		}
+	}
+	return length
+}
+
+func (d *Decoder) decodeTemplates(msg *Message, body []byte, exporter string, options bool) error {
+	for len(body) >= setHeaderLength {
+		id, count := binary.BigEndian.Uint16(body), int(binary.BigEndian.Uint16(body[2:]))
+		body = body[4:]
+
+		// remaining bytes are padding
+		if id < minDataSetID {
+			break
+		}
+
+		key := templateKey{exporter: exporter, domain: msg.ObservationDomain, id: id}
+
+		// template withdrawal
+		if count == 0 {
+			delete(d.templates, key)
+			continue
+		}
+
+		template := &Template{ID: id}
+		if options {
+			if len(body) < 2 {
+				return ErrTruncated
+			}
+			template.ScopeCount = int(binary.BigEndian.Uint16(body))
+			body = body[2:]
+
+			if template.ScopeCount == 0 || template.ScopeCount > count {
+				return ErrInvalidTemplate
+			}
+		}
+
+		for i := 0; i < count; i++ {
+			if len(body) < 4 {
+				return ErrTruncated
+			}
+			field := TemplateField{
+				ID:     binary.BigEndian.Uint16(body),
+				Length: binary.BigEndian.Uint16(body[2:]),
+			}
+			body = body[4:]
+
+			if msg.Version == IPFIXVersion && field.ID&0x8000 != 0 {
+				if len(body) < 4 {
+					return ErrTruncated
+				}
+				field.ID &^= 0x8000
+				field.EnterpriseID = binary.BigEndian.Uint32(body)
+				body = body[4:]
+			}
+
+			template.Fields = append(template.Fields, field)
+		}
+
+		d.templates[key] = template
+	}
+
+	return nil
+}
+
+func (d *Decoder) decodeNetFlowV9OptionsTemplates(msg *Message, body []byte, exporter string) error {
+	for len(body) >= 6 {
+		id := binary.BigEndian.Uint16(body)
+		scopeLength, optionLength := int(binary.BigEndian.Uint16(body[2:])), int(binary.BigEndian.Uint16(body[4:]))
+		body = body[6:]
+
+		// remaining bytes are padding
+		if id < minDataSetID {
+			break
+		}
+
+		if scopeLength%4 != 0 || optionLength%4 != 0 || scopeLength == 0 {
+			return ErrInvalidTemplate
+		}
+		if len(body) < scopeLength+optionLength {
+			return ErrTruncated
+		}
+
+		template := &Template{ID: id, ScopeCount: scopeLength / 4}
+		for i := 0; i < scopeLength+optionLength; i += 4 {
+			template.Fields = append(template.Fields, TemplateField{
+				ID:     binary.BigEndian.Uint16(body[i:]),
+				Length: binary.BigEndian.Uint16(body[i+2:]),
+			})
+		}
+		body = body[scopeLength+optionLength:]
+
+		key := templateKey{exporter: exporter, domain: msg.ObservationDomain, id: id}
+		d.templates[key] = template
+	}
+
+	return nil
+}
+
+func (d *Decoder) decodeData(msg *Message, id uint16, body []byte, exporter string) error {
+	key := templateKey{exporter: exporter, domain: msg.ObservationDomain, id: id}
+	template, ok := d.templates[key]
+	if !ok {
+		logging.GetLogger().Debugf("No template %d received yet from %s, skipping data set", id, exporter)
+		return nil
+	}
+
+	minLength := template.minLength()
+	if minLength == 0 {
+		return ErrInvalidTemplate
+	}
+
+	for len(body) >= minLength {
+		record := &Record{Fields: make(map[uint16][]byte, len(template.Fields))}
+		for _, field := range template.Fields {
+			length := int(field.Length)
+			if field.Length == VariableLength {
+				if len(body) < 1 {
+					return ErrTruncated
+				}
+				length, body = int(body[0]), body[1:]
+				if length == 255 {
+					if len(body) < 2 {
+						return ErrTruncated
+					}
+					length, body = int(binary.BigEndian.Uint16(body)), body[2:]
+				}
+			}
+
+			if len(body) < length {
+				return ErrTruncated
+			}
+			if field.EnterpriseID == 0 {
+				record.Fields[field.ID] = body[:length]
+			}
+			body = body[length:]
+		}
+
+		// options records describe the exporter, not flows
+		if template.ScopeCount == 0 {
+			msg.Records = append(msg.Records, record)
+		}
+	}
+
+	return nil
+}
+
+func (d *Decoder) decodeSets(msg *Message, data []byte, exporter string) error {
+	for len(data) >= setHeaderLength {
+		id, length := binary.BigEndian.Uint16(data), int(binary.BigEndian.Uint16(data[2:]))
+		if length < setHeaderLength || length > len(data) {
+			return ErrTruncated
+		}
+		body := data[setHeaderLength:length]
+		data = data[length:]
+
+		var err error
+		switch {
+		case id >= minDataSetID:
+			err = d.decodeData(msg, id, body, exporter)
+		case msg.Version == NetFlowV9Version && id == 0, msg.Version == IPFIXVersion && id == 2:
+			err = d.decodeTemplates(msg, body, exporter, false)
+		case msg.Version == NetFlowV9Version && id == 1:
+			err = d.decodeNetFlowV9OptionsTemplates(msg, body, exporter)
+		case msg.Version == IPFIXVersion && id == 3:
+			err = d.decodeTemplates(msg, body, exporter, true)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Decoder) decodeNetFlowV9(data []byte, exporter string) (*Message, error) {
+	if len(data) < netFlowV9HeaderLength {
+		return nil, ErrTruncated
+	}
+
+	msg := &Message{
+		Version:           NetFlowV9Version,
+		SysUpTime:         int64(binary.BigEndian.Uint32(data[4:])),
+		ExportTime:        int64(binary.BigEndian.Uint32(data[8:])) * 1000,
+		SequenceNumber:    binary.BigEndian.Uint32(data[12:]),
+		ObservationDomain: binary.BigEndian.Uint32(data[16:]),
+	}
+
+	return msg, d.decodeSets(msg, data[netFlowV9HeaderLength:], exporter)
+}
+
+func (d *Decoder) decodeIPFIX(data []byte, exporter string) (*Message, error) {
+	if len(data) < ipfixHeaderLength {
+		return nil, ErrTruncated
+	}
+
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	if length < ipfixHeaderLength || length > len(data) {
+		return nil, ErrTruncated
+	}
+
+	msg := &Message{
+		Version:           IPFIXVersion,
+		ExportTime:        int64(binary.BigEndian.Uint32(data[4:])) * 1000,
+		SequenceNumber:    binary.BigEndian.Uint32(data[8:]),
+		ObservationDomain: binary.BigEndian.Uint32(data[12:]),
+	}
+
+	return msg, d.decodeSets(msg, data[ipfixHeaderLength:length], exporter)
+}
+
+// Decode parses a NetFlow v9 or IPFIX message sent by the given exporter.
+// Templates are recorded so that the following data sets can be decoded.
+func (d *Decoder) Decode(data []byte, exporter string) (*Message, error) {
+	if len(data) < 2 {
+		return nil, ErrTruncated
+	}
+
+	switch version := binary.BigEndian.Uint16(data); version {
+	case NetFlowV9Version:
+		return d.decodeNetFlowV9(data, exporter)
+	case IPFIXVersion:
+		return d.decodeIPFIX(data, exporter)
+	default:
+		return nil, fmt.Errorf("unsupported export protocol version %d", version)
+	}
+}
+
+// NewDecoder returns a new NetFlow v9 and IPFIX decoder
+func NewDecoder() *Decoder {
+	return &Decoder{
+		templates: make(map[templateKey]*Template),
+	}
+}
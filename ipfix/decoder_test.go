@@ -0,0 +1,243 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package ipfix
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/skydive-project/skydive/flow"
+)
+
+type testField struct {
+	id     uint16
+	length uint16
+}
+
+var testTemplate = []testField{
+	{IESourceIPv4Address, 4},
+	{IEDestinationIPv4Address, 4},
+	{IESourceTransportPort, 2},
+	{IEDestinationTransportPort, 2},
+	{IEProtocolIdentifier, 1},
+	{IEOctetDeltaCount, 8},
+	{IEPacketDeltaCount, 4},
+	{IEFlowStartMilliseconds, 8},
+	{IEFlowEndMilliseconds, 8},
+}
+
+type testRecord struct {
+	src, dst         string
+	srcPort, dstPort uint16
+	bytes            uint64
+	packets          uint32
+	start, end       uint64
+}
+
+func (r testRecord) encode() []byte {
+	b := make([]byte, 41)
+	copy(b[0:], net.ParseIP(r.src).To4())
+	copy(b[4:], net.ParseIP(r.dst).To4())
+	binary.BigEndian.PutUint16(b[8:], r.srcPort)
+	binary.BigEndian.PutUint16(b[10:], r.dstPort)
+	b[12] = 6
+	binary.BigEndian.PutUint64(b[13:], r.bytes)
+	binary.BigEndian.PutUint32(b[21:], r.packets)
+	binary.BigEndian.PutUint64(b[25:], r.start)
+	binary.BigEndian.PutUint64(b[33:], r.end)
+	return b
+}
+
+func set(id uint16, body []byte) []byte {
+	b := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint16(b, id)
+	binary.BigEndian.PutUint16(b[2:], uint16(4+len(body)))
+	return append(b, body...)
+}
+
+func templateSet(setID, templateID uint16, fields []testField) []byte {
+	body := make([]byte, 4+4*len(fields))
+	binary.BigEndian.PutUint16(body, templateID)
+	binary.BigEndian.PutUint16(body[2:], uint16(len(fields)))
+	for i, field := range fields {
+		binary.BigEndian.PutUint16(body[4+4*i:], field.id)
+		binary.BigEndian.PutUint16(body[6+4*i:], field.length)
+	}
+	return set(setID, body)
+}
+
+func dataSet(templateID uint16, records ...testRecord) []byte {
+	var body []byte
+	for _, record := range records {
+		body = append(body, record.encode()...)
+	}
+	return set(templateID, body)
+}
+
+func ipfixMessage(exportTime uint32, sets ...[]byte) []byte {
+	msg := make([]byte, ipfixHeaderLength)
+	binary.BigEndian.PutUint16(msg, IPFIXVersion)
+	binary.BigEndian.PutUint32(msg[4:], exportTime)
+	binary.BigEndian.PutUint32(msg[12:], 1)
+	for _, s := range sets {
+		msg = append(msg, s...)
+	}
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+	return msg
+}
+
+func TestDecodeIPFIX(t *testing.T) {
+	decoder := NewDecoder()
+
+	// data set received before its template is skipped
+	record := testRecord{src: "192.168.0.1", dst: "192.168.0.2", srcPort: 12345, dstPort: 80, bytes: 1500, packets: 3, start: 1000, end: 2000}
+	msg, err := decoder.Decode(ipfixMessage(10, dataSet(256, record)), "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Records) != 0 {
+		t.Fatalf("Expected no record without template, got %d", len(msg.Records))
+	}
+
+	msg, err = decoder.Decode(ipfixMessage(10, templateSet(2, 256, testTemplate), dataSet(256, record, record)), "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(msg.Records))
+	}
+
+	if ip, ok := msg.Records[0].IP(IESourceIPv4Address); !ok || ip.String() != "192.168.0.1" {
+		t.Errorf("Wrong source address: %v", ip)
+	}
+	if port, ok := msg.Records[0].Uint(IEDestinationTransportPort); !ok || port != 80 {
+		t.Errorf("Wrong destination port: %d", port)
+	}
+	if bytes, ok := msg.Records[1].Uint(IEOctetDeltaCount); !ok || bytes != 1500 {
+		t.Errorf("Wrong octet count: %d", bytes)
+	}
+
+	// templates are scoped by exporter
+	msg, err = decoder.Decode(ipfixMessage(10, dataSet(256, record)), "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Records) != 0 {
+		t.Errorf("Expected no record for another exporter, got %d", len(msg.Records))
+	}
+
+	if _, err := decoder.Decode(ipfixMessage(10, templateSet(2, 256, testTemplate))[:20], "10.0.0.1"); err != ErrTruncated {
+		t.Errorf("Expected truncated error, got %v", err)
+	}
+}
+
+func TestDecodeNetFlowV9(t *testing.T) {
+	fields := []testField{
+		{IESourceIPv4Address, 4},
+		{IEDestinationIPv4Address, 4},
+		{IEOctetDeltaCount, 4},
+		{IEPacketDeltaCount, 4},
+		{IEFlowStartSysUpTime, 4},
+		{IEFlowEndSysUpTime, 4},
+		{IEProtocolIdentifier, 1},
+	}
+
+	data := make([]byte, 25)
+	copy(data[0:], net.ParseIP("10.1.0.1").To4())
+	copy(data[4:], net.ParseIP("10.1.0.2").To4())
+	binary.BigEndian.PutUint32(data[8:], 840)
+	binary.BigEndian.PutUint32(data[12:], 10)
+	binary.BigEndian.PutUint32(data[16:], 4000)
+	binary.BigEndian.PutUint32(data[20:], 9000)
+	data[24] = 1
+
+	msg := make([]byte, netFlowV9HeaderLength)
+	binary.BigEndian.PutUint16(msg, NetFlowV9Version)
+	binary.BigEndian.PutUint16(msg[2:], 2)
+	binary.BigEndian.PutUint32(msg[4:], 10000)
+	binary.BigEndian.PutUint32(msg[8:], 1500000000)
+	msg = append(msg, templateSet(0, 300, fields)...)
+	// data set padded to a 4 bytes boundary
+	msg = append(msg, set(300, append(data, 0, 0, 0))...)
+
+	m, err := NewDecoder().Decode(msg, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(m.Records))
+	}
+
+	start, last := recordTimes(m, m.Records[0])
+	if start != 1500000000000-6000 || last != 1500000000000-1000 {
+		t.Errorf("Wrong flow times: %d, %d", start, last)
+	}
+}
+
+func TestFlowBuilder(t *testing.T) {
+	decoder := NewDecoder()
+	builder := newFlowBuilder("probe-tid", 600000, &flow.ApplicationPortMap{TCP: map[int]string{80: "HTTP"}})
+
+	ab := testRecord{src: "192.168.0.1", dst: "192.168.0.2", srcPort: 12345, dstPort: 80, bytes: 100, packets: 1, start: 1000, end: 2000}
+	ba := testRecord{src: "192.168.0.2", dst: "192.168.0.1", srcPort: 80, dstPort: 12345, bytes: 1000, packets: 2, start: 1100, end: 2500}
+
+	msg, err := decoder.Decode(ipfixMessage(10, templateSet(2, 256, testTemplate), dataSet(256, ab, ba, ab)), "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var flows []*flow.Flow
+	for _, record := range msg.Records {
+		f, err := builder.process(msg, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flows = append(flows, f)
+	}
+
+	f := flows[2]
+	if f.UUID != flows[0].UUID || f.UUID != flows[1].UUID {
+		t.Errorf("Records of both directions should give the same flow: %v", flows)
+	}
+
+	if f.Network.A != "192.168.0.1" || f.Transport.A != 12345 || f.Transport.B != 80 {
+		t.Errorf("Wrong flow endpoints: %v", f)
+	}
+	if f.LayersPath != "IPv4/TCP" || f.NodeTID != "probe-tid" {
+		t.Errorf("Wrong flow: %v", f)
+	}
+	if f.Application != "HTTP" {
+		t.Errorf("Expected application HTTP, got %s", f.Application)
+	}
+
+	m := f.Metric
+	if m.ABBytes != 200 || m.ABPackets != 2 || m.BABytes != 1000 || m.BAPackets != 2 || m.Start != 1000 || m.Last != 2500 {
+		t.Errorf("Wrong flow metric: %v", m)
+	}
+
+	builder.flush(2500 + 600001)
+	if len(builder.flows) != 0 {
+		t.Errorf("Flows should have been flushed: %v", builder.flows)
+	}
+}
@@ -0,0 +1,666 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package ipfix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+)
+
+const (
+	// DefaultEnterpriseID is the Private Enterprise Number of Red Hat, Inc.
+	// used by default for the Skydive information elements
+	DefaultEnterpriseID uint32 = 2312
+	// ReverseEnterpriseID is the Private Enterprise Number used to export
+	// the reverse direction of IANA information elements, see RFC 5103
+	ReverseEnterpriseID uint32 = 29305
+	// ReverseIEBit is set on enterprise specific information elements to
+	// export their reverse direction, see RFC 5103
+	ReverseIEBit uint16 = 0x4000
+
+	maxUDPMessageSize = 1400
+	maxTCPMessageSize = 65535
+	dialTimeout       = 5 * time.Second
+	// DefaultMaxPending is the default number of bulks of flows waiting to
+	// be sent to the collectors
+	DefaultMaxPending = 100
+)
+
+// Skydive enterprise specific information elements
+const (
+	SkydiveIEFlowUUID              uint16 = 1
+	SkydiveIETrackingID            uint16 = 2
+	SkydiveIEL3TrackingID          uint16 = 3
+	SkydiveIEParentUUID            uint16 = 4
+	SkydiveIENodeTID               uint16 = 5
+	SkydiveIELayersPath            uint16 = 6
+	SkydiveIEApplication           uint16 = 7
+	SkydiveIERTT                   uint16 = 8
+	SkydiveIELinkID                uint16 = 9
+	SkydiveIENetworkID             uint16 = 10
+	SkydiveIETCPSynStart           uint16 = 11
+	SkydiveIETCPSynTTL             uint16 = 12
+	SkydiveIETCPFinStart           uint16 = 13
+	SkydiveIETCPRstStart           uint16 = 14
+	SkydiveIETCPSegmentOutOfOrder  uint16 = 15
+	SkydiveIETCPSegmentSkipped     uint16 = 16
+	SkydiveIETCPSegmentSkippedByte uint16 = 17
+	SkydiveIETCPPackets            uint16 = 18
+	SkydiveIETCPBytes              uint16 = 19
+	SkydiveIETCPSawStart           uint16 = 20
+	SkydiveIETCPSawEnd             uint16 = 21
+)
+
+const (
+	linkTemplateID uint16 = 256 + iota*2
+	ipv4TemplateID
+	ipv6TemplateID
+)
+
+// exportField describes an information element exported for each flow
+type exportField struct {
+	TemplateField
+	encode func(b []byte, f *flow.Flow) []byte
+}
+
+type exportTemplate struct {
+	id     uint16
+	fields []exportField
+}
+
+type collector struct {
+	network      string
+	address      string
+	conn         net.Conn
+	sequence     uint32
+	templateSent time.Time
+	retryAt      time.Time
+}
+
+type encodedRecord struct {
+	templateID uint16
+	data       []byte
+}
+
+// Exporter exports flows as IPFIX records to a set of collectors. Records
+// are sent by a goroutine and dropped when too many bulks are pending so
+// that the analyzer is never blocked by a slow or unreachable collector.
+type Exporter struct {
+	domain         uint32
+	enterprise     uint32
+	refresh        time.Duration
+	templates      map[uint16]*exportTemplate
+	collectors     []*collector
+	queue          chan []encodedRecord
+	wg             sync.WaitGroup
+	dropLock       sync.Mutex
+	timeOfLastDrop time.Time
+	numOfLostFlows int
+}
+
+// messageWriter splits sets into IPFIX messages of a maximum size
+type messageWriter struct {
+	maxSize    int
+	domain     uint32
+	exportTime uint32
+	sequence   *uint32
+	msgs       [][]byte
+	msg        []byte
+	setID      uint16
+	setStart   int
+}
+
+func appendUint(b []byte, v uint64, length int) []byte {
+	for i := length - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(uint(i)*8)))
+	}
+	return b
+}
+
+func appendString(b []byte, s string) []byte {
+	if len(s) > maxTCPMessageSize {
+		s = s[:maxTCPMessageSize]
+	}
+
+	if len(s) < 255 {
+		b = append(b, byte(len(s)))
+	} else {
+		b = append(b, 255)
+		b = appendUint(b, uint64(len(s)), 2)
+	}
+	return append(b, s...)
+}
+
+func appendIP(b []byte, s string, length int) []byte {
+	ip := net.ParseIP(s)
+	if length == net.IPv4len {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
+	if ip == nil {
+		ip = make(net.IP, length)
+	}
+	return append(b, ip...)
+}
+
+func appendMAC(b []byte, s string) []byte {
+	mac, err := net.ParseMAC(s)
+	if err != nil || len(mac) != 6 {
+		mac = make(net.HardwareAddr, 6)
+	}
+	return append(b, mac...)
+}
+
+func uintField(id uint16, enterprise uint32, value func(f *flow.Flow) int64) exportField {
+	return exportField{
+		TemplateField: TemplateField{ID: id, Length: 8, EnterpriseID: enterprise},
+		encode: func(b []byte, f *flow.Flow) []byte {
+			return appendUint(b, uint64(value(f)), 8)
+		},
+	}
+}
+
+func stringField(id uint16, enterprise uint32, value func(f *flow.Flow) string) exportField {
+	return exportField{
+		TemplateField: TemplateField{ID: id, Length: VariableLength, EnterpriseID: enterprise},
+		encode: func(b []byte, f *flow.Flow) []byte {
+			return appendString(b, value(f))
+		},
+	}
+}
+
+func metricFields(id uint16, value func(m *flow.FlowMetric) (int64, int64)) []exportField {
+	ab := func(f *flow.Flow) int64 {
+		if f.Metric == nil {
+			return 0
+		}
+		v, _ := value(f.Metric)
+		return v
+	}
+	ba := func(f *flow.Flow) int64 {
+		if f.Metric == nil {
+			return 0
+		}
+		_, v := value(f.Metric)
+		return v
+	}
+	return []exportField{uintField(id, 0, ab), uintField(id, ReverseEnterpriseID, ba)}
+}
+
+func lastUpdateMetricFields(id uint16, value func(m *flow.FlowMetric) (int64, int64)) []exportField {
+	ab := func(f *flow.Flow) int64 {
+		if f.LastUpdateMetric == nil {
+			return 0
+		}
+		v, _ := value(f.LastUpdateMetric)
+		return v
+	}
+	ba := func(f *flow.Flow) int64 {
+		if f.LastUpdateMetric == nil {
+			return 0
+		}
+		_, v := value(f.LastUpdateMetric)
+		return v
+	}
+	return []exportField{uintField(id, 0, ab), uintField(id, ReverseEnterpriseID, ba)}
+}
+
+func (e *Exporter) tcpMetricFields(id uint16, value func(m *flow.TCPMetric) (int64, int64)) []exportField {
+	ab := func(f *flow.Flow) int64 {
+		v, _ := value(f.TCPMetric)
+		return v
+	}
+	ba := func(f *flow.Flow) int64 {
+		_, v := value(f.TCPMetric)
+		return v
+	}
+	return []exportField{uintField(id, e.enterprise, ab), uintField(id|ReverseIEBit, e.enterprise, ba)}
+}
+
+func (e *Exporter) commonFields() []exportField {
+	fields := []exportField{
+		uintField(IEFlowStartMilliseconds, 0, func(f *flow.Flow) int64 { return f.Start }),
+		uintField(IEFlowEndMilliseconds, 0, func(f *flow.Flow) int64 { return f.Last }),
+	}
+	fields = append(fields, metricFields(IEOctetTotalCount, func(m *flow.FlowMetric) (int64, int64) { return m.ABBytes, m.BABytes })...)
+	fields = append(fields, metricFields(IEPacketTotalCount, func(m *flow.FlowMetric) (int64, int64) { return m.ABPackets, m.BAPackets })...)
+	fields = append(fields, lastUpdateMetricFields(IEOctetDeltaCount, func(m *flow.FlowMetric) (int64, int64) { return m.ABBytes, m.BABytes })...)
+	fields = append(fields, lastUpdateMetricFields(IEPacketDeltaCount, func(m *flow.FlowMetric) (int64, int64) { return m.ABPackets, m.BAPackets })...)
+
+	return append(fields,
+		stringField(SkydiveIEFlowUUID, e.enterprise, func(f *flow.Flow) string { return f.UUID }),
+		stringField(SkydiveIETrackingID, e.enterprise, func(f *flow.Flow) string { return f.TrackingID }),
+		stringField(SkydiveIEL3TrackingID, e.enterprise, func(f *flow.Flow) string { return f.L3TrackingID }),
+		stringField(SkydiveIEParentUUID, e.enterprise, func(f *flow.Flow) string { return f.ParentUUID }),
+		stringField(SkydiveIENodeTID, e.enterprise, func(f *flow.Flow) string { return f.NodeTID }),
+		stringField(SkydiveIELayersPath, e.enterprise, func(f *flow.Flow) string { return f.LayersPath }),
+		stringField(SkydiveIEApplication, e.enterprise, func(f *flow.Flow) string { return f.Application }),
+		uintField(SkydiveIERTT, e.enterprise, func(f *flow.Flow) int64 { return f.RTT }),
+	)
+}
+
+func (e *Exporter) linkFields() []exportField {
+	return []exportField{
+		{
+			TemplateField: TemplateField{ID: IESourceMacAddress, Length: 6},
+			encode: func(b []byte, f *flow.Flow) []byte {
+				if f.Link == nil {
+					return appendMAC(b, "")
+				}
+				return appendMAC(b, f.Link.A)
+			},
+		},
+		{
+			TemplateField: TemplateField{ID: IEDestinationMacAddress, Length: 6},
+			encode: func(b []byte, f *flow.Flow) []byte {
+				if f.Link == nil {
+					return appendMAC(b, "")
+				}
+				return appendMAC(b, f.Link.B)
+			},
+		},
+		uintField(SkydiveIELinkID, e.enterprise, func(f *flow.Flow) int64 {
+			if f.Link == nil {
+				return 0
+			}
+			return f.Link.ID
+		}),
+	}
+}
+
+func (e *Exporter) networkFields(src, dst uint16, length int) []exportField {
+	return []exportField{
+		{
+			TemplateField: TemplateField{ID: src, Length: uint16(length)},
+			encode: func(b []byte, f *flow.Flow) []byte {
+				return appendIP(b, f.Network.A, length)
+			},
+		},
+		{
+			TemplateField: TemplateField{ID: dst, Length: uint16(length)},
+			encode: func(b []byte, f *flow.Flow) []byte {
+				return appendIP(b, f.Network.B, length)
+			},
+		},
+		uintField(SkydiveIENetworkID, e.enterprise, func(f *flow.Flow) int64 { return f.Network.ID }),
+		{
+			TemplateField: TemplateField{ID: IEProtocolIdentifier, Length: 1},
+			encode: func(b []byte, f *flow.Flow) []byte {
+				return append(b, protocolIdentifier(f))
+			},
+		},
+		{
+			TemplateField: TemplateField{ID: IESourceTransportPort, Length: 2},
+			encode: func(b []byte, f *flow.Flow) []byte {
+				if f.Transport == nil {
+					return appendUint(b, 0, 2)
+				}
+				return appendUint(b, uint64(f.Transport.A), 2)
+			},
+		},
+		{
+			TemplateField: TemplateField{ID: IEDestinationTransportPort, Length: 2},
+			encode: func(b []byte, f *flow.Flow) []byte {
+				if f.Transport == nil {
+					return appendUint(b, 0, 2)
+				}
+				return appendUint(b, uint64(f.Transport.B), 2)
+			},
+		},
+	}
+}
+
+func (e *Exporter) tcpFields() []exportField {
+	var fields []exportField
+	fields = append(fields, e.tcpMetricFields(SkydiveIETCPSynStart, func(m *flow.TCPMetric) (int64, int64) { return m.ABSynStart, m.BASynStart })...)
+	fields = append(fields, e.tcpMetricFields(SkydiveIETCPSynTTL, func(m *flow.TCPMetric) (int64, int64) { return int64(m.ABSynTTL), int64(m.BASynTTL) })...)
+	fields = append(fields, e.tcpMetricFields(SkydiveIETCPFinStart, func(m *flow.TCPMetric) (int64, int64) { return m.ABFinStart, m.BAFinStart })...)
+	fields = append(fields, e.tcpMetricFields(SkydiveIETCPRstStart, func(m *flow.TCPMetric) (int64, int64) { return m.ABRstStart, m.BARstStart })...)
+	fields = append(fields, e.tcpMetricFields(SkydiveIETCPSegmentOutOfOrder, func(m *flow.TCPMetric) (int64, int64) { return m.ABSegmentOutOfOrder, m.BASegmentOutOfOrder })...)
+	fields = append(fields, e.tcpMetricFields(SkydiveIETCPSegmentSkipped, func(m *flow.TCPMetric) (int64, int64) { return m.ABSegmentSkipped, m.BASegmentSkipped })...)
+	fields = append(fields, e.tcpMetricFields(SkydiveIETCPSegmentSkippedByte, func(m *flow.TCPMetric) (int64, int64) { return m.ABSegmentSkippedBytes, m.BASegmentSkippedBytes })...)
+	fields = append(fields, e.tcpMetricFields(SkydiveIETCPPackets, func(m *flow.TCPMetric) (int64, int64) { return m.ABPackets, m.BAPackets })...)
+	fields = append(fields, e.tcpMetricFields(SkydiveIETCPBytes, func(m *flow.TCPMetric) (int64, int64) { return m.ABBytes, m.BABytes })...)
+	fields = append(fields, e.tcpMetricFields(SkydiveIETCPSawStart, func(m *flow.TCPMetric) (int64, int64) { return m.ABSawStart, m.BASawStart })...)
+	fields = append(fields, e.tcpMetricFields(SkydiveIETCPSawEnd, func(m *flow.TCPMetric) (int64, int64) { return m.ABSawEnd, m.BASawEnd })...)
+	return fields
+}
+
+func protocolIdentifier(f *flow.Flow) byte {
+	if f.Transport != nil {
+		switch f.Transport.Protocol {
+		case flow.FlowProtocol_TCP:
+			return 6
+		case flow.FlowProtocol_UDP:
+			return 17
+		case flow.FlowProtocol_SCTP:
+			return 132
+		}
+	}
+	if f.ICMP != nil {
+		if f.Network.Protocol == flow.FlowProtocol_IPV6 {
+			return 58
+		}
+		return 1
+	}
+	return 0
+}
+
+// initTemplates registers the templates used to export the flows, each
+// kind of flow is exported with and without TCP metrics
+func (e *Exporter) initTemplates() {
+	e.templates = make(map[uint16]*exportTemplate)
+
+	kinds := map[uint16][]exportField{
+		linkTemplateID: e.linkFields(),
+		ipv4TemplateID: append(e.linkFields(), e.networkFields(IESourceIPv4Address, IEDestinationIPv4Address, net.IPv4len)...),
+		ipv6TemplateID: append(e.linkFields(), e.networkFields(IESourceIPv6Address, IEDestinationIPv6Address, net.IPv6len)...),
+	}
+
+	for id, fields := range kinds {
+		fields = append(e.commonFields(), fields...)
+		e.templates[id] = &exportTemplate{id: id, fields: fields}
+		e.templates[id+1] = &exportTemplate{id: id + 1, fields: append(fields, e.tcpFields()...)}
+	}
+}
+
+func (t *exportTemplate) encode() []byte {
+	b := appendUint(nil, uint64(t.id), 2)
+	b = appendUint(b, uint64(len(t.fields)), 2)
+	for _, field := range t.fields {
+		id := field.ID
+		if field.EnterpriseID != 0 {
+			id |= 0x8000
+		}
+		b = appendUint(b, uint64(id), 2)
+		b = appendUint(b, uint64(field.Length), 2)
+		if field.EnterpriseID != 0 {
+			b = appendUint(b, uint64(field.EnterpriseID), 4)
+		}
+	}
+	return b
+}
+
+func (t *exportTemplate) encodeFlow(f *flow.Flow) []byte {
+	var b []byte
+	for _, field := range t.fields {
+		b = field.encode(b, f)
+	}
+	return b
+}
+
+func templateIDForFlow(f *flow.Flow) uint16 {
+	id := linkTemplateID
+	if f.Network != nil {
+		switch f.Network.Protocol {
+		case flow.FlowProtocol_IPV4:
+			id = ipv4TemplateID
+		case flow.FlowProtocol_IPV6:
+			id = ipv6TemplateID
+		}
+	}
+
+	if f.TCPMetric != nil {
+		id++
+	}
+	return id
+}
+
+func (w *messageWriter) closeSet() {
+	if w.setID != 0 {
+		binary.BigEndian.PutUint16(w.msg[w.setStart+2:], uint16(len(w.msg)-w.setStart))
+		w.setID = 0
+	}
+}
+
+func (w *messageWriter) flush() {
+	if w.msg == nil {
+		return
+	}
+	w.closeSet()
+
+	binary.BigEndian.PutUint16(w.msg[2:], uint16(len(w.msg)))
+	w.msgs = append(w.msgs, w.msg)
+	w.msg = nil
+}
+
+func (w *messageWriter) write(setID uint16, data []byte) {
+	needed := len(data)
+	if w.setID != setID {
+		needed += setHeaderLength
+	}
+
+	if w.msg != nil && len(w.msg)+needed > w.maxSize {
+		w.flush()
+	}
+
+	if w.msg == nil {
+		w.msg = make([]byte, ipfixHeaderLength, w.maxSize)
+		binary.BigEndian.PutUint16(w.msg, IPFIXVersion)
+		binary.BigEndian.PutUint32(w.msg[4:], w.exportTime)
+		binary.BigEndian.PutUint32(w.msg[8:], *w.sequence)
+		binary.BigEndian.PutUint32(w.msg[12:], w.domain)
+	}
+
+	if w.setID != setID {
+		w.closeSet()
+		w.setID, w.setStart = setID, len(w.msg)
+		w.msg = append(w.msg, 0, 0, 0, 0)
+		binary.BigEndian.PutUint16(w.msg[w.setStart:], setID)
+	}
+	w.msg = append(w.msg, data...)
+
+	// the sequence number counts the data records only
+	if setID >= minDataSetID {
+		*w.sequence++
+	}
+}
+
+func (c *collector) maxMessageSize() int {
+	if c.network == "tcp" {
+		return maxTCPMessageSize
+	}
+	return maxUDPMessageSize
+}
+
+func (c *collector) connect(now time.Time) error {
+	if c.conn != nil {
+		return nil
+	}
+
+	// do not wait for an unreachable collector on every bulk
+	if now.Before(c.retryAt) {
+		return fmt.Errorf("connection failed less than %s ago", dialTimeout)
+	}
+
+	conn, err := net.DialTimeout(c.network, c.address, dialTimeout)
+	if err != nil {
+		c.retryAt = time.Now().Add(dialTimeout)
+		return err
+	}
+	c.conn = conn
+
+	// templates have to be sent on each new connection
+	c.templateSent = time.Time{}
+
+	return nil
+}
+
+func (c *collector) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// messages returns the IPFIX messages to send to the collector. Templates
+// are sent once per connection over TCP and periodically over UDP.
+func (e *Exporter) messages(c *collector, records []encodedRecord, now time.Time) [][]byte {
+	w := &messageWriter{
+		maxSize:    c.maxMessageSize(),
+		domain:     e.domain,
+		exportTime: uint32(now.Unix()),
+		sequence:   &c.sequence,
+	}
+
+	if c.templateSent.IsZero() || (c.network != "tcp" && now.Sub(c.templateSent) >= e.refresh) {
+		for id := linkTemplateID; id <= ipv6TemplateID+1; id++ {
+			w.write(2, e.templates[id].encode())
+		}
+		c.templateSent = now
+	}
+
+	for _, record := range records {
+		w.write(record.templateID, record.data)
+	}
+	w.flush()
+
+	return w.msgs
+}
+
+// send sends the records to all the collectors
+func (e *Exporter) send(records []encodedRecord) {
+	now := time.Now()
+	for _, c := range e.collectors {
+		if err := c.connect(now); err != nil {
+			logging.GetLogger().Errorf("Unable to connect to IPFIX collector %s: %s", c.address, err)
+			continue
+		}
+
+		for _, msg := range e.messages(c, records, now) {
+			if _, err := c.conn.Write(msg); err != nil {
+				logging.GetLogger().Errorf("Unable to send flows to IPFIX collector %s: %s", c.address, err)
+				c.close()
+				break
+			}
+		}
+	}
+}
+
+// ExportFlows queues the given flows to be sent to all the collectors. The
+// flows are encoded right away as the caller may reuse them.
+func (e *Exporter) ExportFlows(flows []*flow.Flow) {
+	records := make([]encodedRecord, len(flows))
+	for i, f := range flows {
+		id := templateIDForFlow(f)
+		records[i] = encodedRecord{templateID: id, data: e.templates[id].encodeFlow(f)}
+	}
+
+	select {
+	case e.queue <- records:
+	default:
+		e.dropLock.Lock()
+		e.numOfLostFlows += len(flows)
+		if e.timeOfLastDrop.IsZero() || time.Now().Sub(e.timeOfLastDrop) >= time.Second {
+			logging.GetLogger().Errorf("IPFIX export queue full, flows dropped: %d", e.numOfLostFlows)
+			e.timeOfLastDrop = time.Now()
+			e.numOfLostFlows = 0
+		}
+		e.dropLock.Unlock()
+	}
+}
+
+// Close sends the pending flows and closes the connections to the collectors
+func (e *Exporter) Close() {
+	close(e.queue)
+	e.wg.Wait()
+
+	for _, c := range e.collectors {
+		c.close()
+	}
+}
+
+func (e *Exporter) start() {
+	e.wg.Add(1)
+
+	go func() {
+		defer e.wg.Done()
+
+		for records := range e.queue {
+			e.send(records)
+		}
+	}()
+}
+
+// NewExporter returns a new IPFIX exporter sending flows to the given
+// collectors. Collectors are defined as udp://addr:port or tcp://addr:port,
+// udp being used if not specified. At most maxPending bulks of flows are
+// queued.
+func NewExporter(collectors []string, domain uint32, enterprise uint32, refresh time.Duration, maxPending int) (*Exporter, error) {
+	if maxPending <= 0 {
+		return nil, fmt.Errorf("Invalid number of pending IPFIX bulks: %d", maxPending)
+	}
+
+	e := &Exporter{
+		domain:     domain,
+		enterprise: enterprise,
+		refresh:    refresh,
+		queue:      make(chan []encodedRecord, maxPending),
+	}
+
+	for _, address := range collectors {
+		c := &collector{network: "udp", address: address}
+		if u, err := url.Parse(address); err == nil && u.Host != "" {
+			c.network, c.address = u.Scheme, u.Host
+		}
+
+		if c.network != "udp" && c.network != "tcp" {
+			return nil, fmt.Errorf("Unsupported IPFIX collector protocol: %s", c.network)
+		}
+		if _, _, err := net.SplitHostPort(c.address); err != nil {
+			return nil, fmt.Errorf("Invalid IPFIX collector address %s: %s", address, err)
+		}
+
+		e.collectors = append(e.collectors, c)
+	}
+
+	e.initTemplates()
+	e.start()
+
+	return e, nil
+}
+
+// NewExporterFromConfig returns a new IPFIX exporter from the analyzer
+// configuration, nil if no collector is defined
+func NewExporterFromConfig() (*Exporter, error) {
+	collectors := config.GetStringSlice("analyzer.flow.ipfix.collectors")
+	if len(collectors) == 0 {
+		return nil, nil
+	}
+
+	domain := uint32(config.GetInt("analyzer.flow.ipfix.observation_domain"))
+	enterprise := uint32(config.GetInt("analyzer.flow.ipfix.enterprise_id"))
+	refresh := time.Duration(config.GetInt("analyzer.flow.ipfix.template_refresh")) * time.Second
+	maxPending := config.GetInt("analyzer.flow.ipfix.max_pending")
+
+	return NewExporter(collectors, domain, enterprise, refresh, maxPending)
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package ipfix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+)
+
+func newTestExportedFlow(i int) *flow.Flow {
+	return &flow.Flow{
+		UUID:       fmt.Sprintf("uuid-%d", i),
+		TrackingID: fmt.Sprintf("tracking-%d", i),
+		NodeTID:    "node-tid",
+		LayersPath: "Ethernet/IPv4/TCP",
+		Start:      1000,
+		Last:       2000,
+		RTT:        3000,
+		Link:       &flow.FlowLayer{Protocol: flow.FlowProtocol_ETHERNET, A: "00:11:22:33:44:55", B: "66:77:88:99:aa:bb"},
+		Network:    &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV4, A: "192.168.0.1", B: "192.168.0.2"},
+		Transport:  &flow.TransportLayer{Protocol: flow.FlowProtocol_TCP, A: 40000, B: int64(80 + i)},
+		Metric:     &flow.FlowMetric{ABPackets: 1, ABBytes: 100, BAPackets: 2, BABytes: 200},
+		TCPMetric:  &flow.TCPMetric{ABSynStart: 1000, BASynStart: 1001},
+	}
+}
+
+func TestExporterMessages(t *testing.T) {
+	e, err := NewExporter(nil, 1, DefaultEnterpriseID, time.Minute, DefaultMaxPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	f6 := &flow.Flow{
+		UUID:      "uuid-v6",
+		Network:   &flow.FlowLayer{Protocol: flow.FlowProtocol_IPV6, A: "fe80::1", B: "fe80::2"},
+		Transport: &flow.TransportLayer{Protocol: flow.FlowProtocol_UDP, A: 53, B: 5353},
+		Metric:    &flow.FlowMetric{ABPackets: 3, ABBytes: 300},
+	}
+
+	flows := []*flow.Flow{newTestExportedFlow(0), f6}
+	records := make([]encodedRecord, len(flows))
+	for i, f := range flows {
+		id := templateIDForFlow(f)
+		records[i] = encodedRecord{templateID: id, data: e.templates[id].encodeFlow(f)}
+	}
+
+	c := &collector{network: "udp"}
+	decoder := NewDecoder()
+
+	var decoded []*Record
+	for _, data := range e.messages(c, records, time.Now()) {
+		if len(data) > maxUDPMessageSize {
+			t.Errorf("Message too large for UDP: %d", len(data))
+		}
+
+		msg, err := decoder.Decode(data, "exporter")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.ObservationDomain != 1 {
+			t.Errorf("Wrong observation domain: %d", msg.ObservationDomain)
+		}
+		decoded = append(decoded, msg.Records...)
+	}
+
+	if len(decoded) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(decoded))
+	}
+
+	r := decoded[0]
+	if ip, _ := r.IP(IESourceIPv4Address); !ip.Equal(net.ParseIP("192.168.0.1")) {
+		t.Errorf("Wrong source address: %s", ip)
+	}
+	if mac, _ := r.MAC(IEDestinationMacAddress); mac.String() != "66:77:88:99:aa:bb" {
+		t.Errorf("Wrong destination MAC address: %s", mac)
+	}
+	if port, _ := r.Uint(IEDestinationTransportPort); port != 80 {
+		t.Errorf("Wrong destination port: %d", port)
+	}
+	if proto, _ := r.Uint(IEProtocolIdentifier); proto != 6 {
+		t.Errorf("Wrong protocol: %d", proto)
+	}
+	if bytes, _ := r.Uint(IEOctetTotalCount); bytes != 100 {
+		t.Errorf("Wrong octet count: %d", bytes)
+	}
+	if start, _ := r.Uint(IEFlowStartMilliseconds); start != 1000 {
+		t.Errorf("Wrong flow start: %d", start)
+	}
+
+	r = decoded[1]
+	if ip, _ := r.IP(IEDestinationIPv6Address); !ip.Equal(net.ParseIP("fe80::2")) {
+		t.Errorf("Wrong destination address: %s", ip)
+	}
+	if proto, _ := r.Uint(IEProtocolIdentifier); proto != 17 {
+		t.Errorf("Wrong protocol: %d", proto)
+	}
+
+	// templates are not sent again before the refresh interval
+	msgs := e.messages(c, records, time.Now())
+	if len(msgs) != 1 || binary.BigEndian.Uint16(msgs[0][ipfixHeaderLength:]) != ipv4TemplateID+1 {
+		t.Error("Templates should not be sent again")
+	}
+	if seq := binary.BigEndian.Uint32(msgs[0][8:]); seq != 2 {
+		t.Errorf("Wrong sequence number: %d", seq)
+	}
+}
+
+func TestExporterUDP(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	e, err := NewExporter([]string{"udp://" + conn.LocalAddr().String()}, 0, DefaultEnterpriseID, time.Minute, DefaultMaxPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	var flows []*flow.Flow
+	for i := 0; i < 50; i++ {
+		flows = append(flows, newTestExportedFlow(i))
+	}
+	e.ExportFlows(flows)
+
+	decoder := NewDecoder()
+	data := make([]byte, maxTCPMessageSize)
+	ports := make(map[uint64]bool)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(ports) < len(flows) {
+		n, err := conn.Read(data)
+		if err != nil {
+			t.Fatalf("Only %d flows received: %s", len(ports), err)
+		}
+		if n > maxUDPMessageSize {
+			t.Errorf("Message too large for UDP: %d", n)
+		}
+
+		msg, err := decoder.Decode(data[:n], "exporter")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, record := range msg.Records {
+			port, _ := record.Uint(IEDestinationTransportPort)
+			ports[port] = true
+		}
+	}
+}
+
+func TestExporterQueueFull(t *testing.T) {
+	// no goroutine sending the records, the queue is never emptied
+	e := &Exporter{enterprise: DefaultEnterpriseID, queue: make(chan []encodedRecord, 1)}
+	e.initTemplates()
+
+	for i := 0; i < 3; i++ {
+		e.ExportFlows([]*flow.Flow{newTestExportedFlow(i)})
+	}
+
+	if len(e.queue) != 1 || e.timeOfLastDrop.IsZero() {
+		t.Errorf("Flows should be dropped when the queue is full, %d bulks queued", len(e.queue))
+	}
+}
+
+func TestExporterInvalidCollector(t *testing.T) {
+	if _, err := NewExporter([]string{"sctp://127.0.0.1:4739"}, 0, DefaultEnterpriseID, time.Minute, DefaultMaxPending); err == nil {
+		t.Error("An error should be returned for an unsupported protocol")
+	}
+
+	if _, err := NewExporter([]string{"127.0.0.1"}, 0, DefaultEnterpriseID, time.Minute, DefaultMaxPending); err == nil {
+		t.Error("An error should be returned for an address without port")
+	}
+
+	if _, err := NewExporter([]string{"127.0.0.1:4739"}, 0, DefaultEnterpriseID, time.Minute, 0); err == nil {
+		t.Error("An error should be returned for an empty queue")
+	}
+}
@@ -0,0 +1,282 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package ipfix
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/flow"
+)
+
+// flowEntry tracks a flow reported through several records
+type flowEntry struct {
+	a      string
+	start  int64
+	last   int64
+	metric *flow.FlowMetric
+}
+
+// flowBuilder aggregates the unidirectional records sent by the exporters
+// into bidirectional flows
+type flowBuilder struct {
+	nodeTID    string
+	expire     int64
+	flows      map[string]*flowEntry
+	appPortMap *flow.ApplicationPortMap
+}
+
+func recordTime(msg *Message, record *Record, ms, s, sysUpTime uint16) int64 {
+	if v, ok := record.Uint(ms); ok {
+		return int64(v)
+	}
+	if v, ok := record.Uint(s); ok {
+		return int64(v) * 1000
+	}
+	if v, ok := record.Uint(sysUpTime); ok {
+		if msg.SysUpTime != 0 {
+			return msg.ExportTime - msg.SysUpTime + int64(v)
+		}
+		if init, ok := record.Uint(IESystemInitTimeMilliseconds); ok {
+			return int64(init + v)
+		}
+	}
+	return msg.ExportTime
+}
+
+func recordTimes(msg *Message, record *Record) (int64, int64) {
+	start := recordTime(msg, record, IEFlowStartMilliseconds, IEFlowStartSeconds, IEFlowStartSysUpTime)
+	last := recordTime(msg, record, IEFlowEndMilliseconds, IEFlowEndSeconds, IEFlowEndSysUpTime)
+	if last < start {
+		last = start
+	}
+	return start, last
+}
+
+// updateCounter adds the delta counter of the record or uses its total
+// counter if the exporter doesn't report deltas
+func updateCounter(counter *int64, record *Record, delta, total uint16) {
+	if v, ok := record.Uint(delta); ok {
+		*counter += int64(v)
+	} else if v, ok := record.Uint(total); ok {
+		*counter = int64(v)
+	}
+}
+
+func newLayersFromRecord(f *flow.Flow, record *Record, appPortMap *flow.ApplicationPortMap) error {
+	var path []string
+
+	if src, ok := record.MAC(IESourceMacAddress); ok {
+		dst, ok := record.MAC(IEDestinationMacAddress)
+		if !ok {
+			dst, _ = record.MAC(IEPostDestinationMacAddress)
+		}
+		vlan, _ := record.Uint(IEVlanID)
+
+		f.Link = &flow.FlowLayer{
+			Protocol: flow.FlowProtocol_ETHERNET,
+			A:        src.String(),
+			B:        dst.String(),
+			ID:       int64(vlan),
+		}
+		path = append(path, "Ethernet")
+	}
+
+	if src, ok := record.IP(IESourceIPv4Address); ok {
+		dst, ok := record.IP(IEDestinationIPv4Address)
+		if !ok {
+			return flow.ErrLayerNotFound
+		}
+		f.Network = &flow.FlowLayer{
+			Protocol: flow.FlowProtocol_IPV4,
+			A:        src.String(),
+			B:        dst.String(),
+		}
+		path = append(path, "IPv4")
+	} else if src, ok := record.IP(IESourceIPv6Address); ok {
+		dst, ok := record.IP(IEDestinationIPv6Address)
+		if !ok {
+			return flow.ErrLayerNotFound
+		}
+		f.Network = &flow.FlowLayer{
+			Protocol: flow.FlowProtocol_IPV6,
+			A:        src.String(),
+			B:        dst.String(),
+		}
+		path = append(path, "IPv6")
+	} else {
+		return flow.ErrLayerNotFound
+	}
+
+	protocol, _ := record.Uint(IEProtocolIdentifier)
+	srcPort, _ := record.Uint(IESourceTransportPort)
+	dstPort, _ := record.Uint(IEDestinationTransportPort)
+
+	switch layers.IPProtocol(protocol) {
+	case layers.IPProtocolTCP:
+		f.Transport = &flow.TransportLayer{Protocol: flow.FlowProtocol_TCP, A: int64(srcPort), B: int64(dstPort)}
+		path = append(path, "TCP")
+	case layers.IPProtocolUDP:
+		f.Transport = &flow.TransportLayer{Protocol: flow.FlowProtocol_UDP, A: int64(srcPort), B: int64(dstPort)}
+		path = append(path, "UDP")
+	case layers.IPProtocolSCTP:
+		f.Transport = &flow.TransportLayer{Protocol: flow.FlowProtocol_SCTP, A: int64(srcPort), B: int64(dstPort)}
+		path = append(path, "SCTP")
+	case layers.IPProtocolICMPv4:
+		// some NetFlow v9 exporters report the ICMP type and code as destination port
+		typeCode, ok := record.Uint(IEICMPTypeCodeIPv4)
+		if !ok {
+			typeCode = dstPort
+		}
+		f.ICMP = &flow.ICMPLayer{
+			Type: flow.ICMPv4TypeToFlowICMPType(uint8(typeCode >> 8)),
+			Code: uint32(typeCode & 0xff),
+		}
+		path = append(path, "ICMPv4")
+	case layers.IPProtocolICMPv6:
+		typeCode, ok := record.Uint(IEICMPTypeCodeIPv6)
+		if !ok {
+			typeCode = dstPort
+		}
+		f.ICMP = &flow.ICMPLayer{
+			Type: flow.ICMPv6TypeToFlowICMPType(uint8(typeCode >> 8)),
+			Code: uint32(typeCode & 0xff),
+		}
+		path = append(path, "ICMPv6")
+	}
+
+	f.LayersPath = strings.Join(path, "/")
+	f.Application = path[len(path)-1]
+
+	switch layers.IPProtocol(protocol) {
+	case layers.IPProtocolTCP:
+		if app, ok := appPortMap.TCPApplication(int(srcPort), int(dstPort)); ok {
+			f.Application = app
+		}
+	case layers.IPProtocolUDP:
+		if app, ok := appPortMap.UDPApplication(int(srcPort), int(dstPort)); ok {
+			f.Application = app
+		}
+	}
+
+	return nil
+}
+
+// endpoints returns the A and B endpoints of the flow
+func endpoints(f *flow.Flow) (string, string) {
+	a, b := f.Network.A, f.Network.B
+	if f.Transport != nil {
+		a += ":" + strconv.FormatInt(f.Transport.A, 10)
+		b += ":" + strconv.FormatInt(f.Transport.B, 10)
+	}
+	return a, b
+}
+
+// flowKey returns the same key for both directions of a flow
+func flowKey(f *flow.Flow) string {
+	a, b := endpoints(f)
+	if a > b {
+		a, b = b, a
+	}
+
+	key := f.LayersPath + "/" + a + "/" + b
+	if f.Link != nil {
+		key += "/" + strconv.FormatInt(f.Link.ID, 10)
+	}
+	if f.ICMP != nil {
+		key += "/" + f.ICMP.Type.String()
+	}
+	return key
+}
+
+func reverse(f *flow.Flow) {
+	if f.Link != nil {
+		f.Link.A, f.Link.B = f.Link.B, f.Link.A
+	}
+	f.Network.A, f.Network.B = f.Network.B, f.Network.A
+	if f.Transport != nil {
+		f.Transport.A, f.Transport.B = f.Transport.B, f.Transport.A
+	}
+}
+
+// process returns a flow holding the metrics accumulated so far for the
+// flow the record belongs to
+func (b *flowBuilder) process(msg *Message, record *Record) (*flow.Flow, error) {
+	f := flow.NewFlow()
+	if err := newLayersFromRecord(f, record, b.appPortMap); err != nil {
+		return nil, err
+	}
+
+	start, last := recordTimes(msg, record)
+	key := flowKey(f)
+	a, _ := endpoints(f)
+
+	entry, ok := b.flows[key]
+	if !ok || start-entry.last > b.expire {
+		entry = &flowEntry{a: a, start: start, last: last, metric: &flow.FlowMetric{}}
+		b.flows[key] = entry
+	}
+	if last > entry.last {
+		entry.last = last
+	}
+
+	if a == entry.a {
+		updateCounter(&entry.metric.ABBytes, record, IEOctetDeltaCount, IEOctetTotalCount)
+		updateCounter(&entry.metric.ABPackets, record, IEPacketDeltaCount, IEPacketTotalCount)
+	} else {
+		reverse(f)
+		updateCounter(&entry.metric.BABytes, record, IEOctetDeltaCount, IEOctetTotalCount)
+		updateCounter(&entry.metric.BAPackets, record, IEPacketDeltaCount, IEPacketTotalCount)
+	}
+
+	f.Init(entry.start, b.nodeTID, flow.UUIDs{})
+	f.Last = entry.last
+
+	f.Metric = entry.metric.Copy()
+	f.Metric.Start = f.Start
+	f.Metric.Last = f.Last
+
+	f.UpdateUUID(key, flow.Opts{LayerKeyMode: flow.L3PreferedKeyMode})
+
+	return f, nil
+}
+
+// flush forgets the flows not updated since the expiration delay
+func (b *flowBuilder) flush(now int64) {
+	for key, entry := range b.flows {
+		if now-entry.last > b.expire {
+			delete(b.flows, key)
+		}
+	}
+}
+
+func newFlowBuilder(nodeTID string, expire int64, appPortMap *flow.ApplicationPortMap) *flowBuilder {
+	return &flowBuilder{
+		nodeTID:    nodeTID,
+		expire:     expire,
+		flows:      make(map[string]*flowEntry),
+		appPortMap: appPortMap,
+	}
+}
@@ -265,8 +265,8 @@ export class GremlinAPI {
         this.client = client;
     }
 
-    query(s: string) {
-        return this.client.request('/api/topology', "POST", JSON.stringify({'GremlinQuery': s}), {})
+    query(s: string, bindings?: any) {
+        return this.client.request('/api/topology', "POST", JSON.stringify({'GremlinQuery': s, 'GremlinBindings': bindings}), {})
             .then(function (data) {
                 if (data === null)
                     return [];
@@ -459,6 +459,34 @@ export class V extends Step {
         return new ShortestPath(this.api, this, ...params);
     }
 
+    AllPathsTo(...params: any[]): ShortestPath {
+        return new AllPaths(this.api, this, ...params);
+    }
+
+    KShortestPathsTo(...params: any[]): ShortestPath {
+        return new KShortestPaths(this.api, this, ...params);
+    }
+
+    Path(): Path {
+        return new Path(this.api, this);
+    }
+
+    Project(...params: any[]): Project {
+        return new Project(this.api, this, ...params);
+    }
+
+    GroupCount(): GroupCount {
+        return new GroupCount(this.api, this);
+    }
+
+    Property(...params: any[]): V {
+        return new Property(this.api, this, ...params);
+    }
+
+    Drop(): V {
+        return new Drop(this.api, this);
+    }
+
     Subgraph(): G {
         return new Subgraph(this.api, this);
     }
@@ -535,6 +563,18 @@ export class E extends Step {
         return new BothV(this.api, this, ...params);
     }
 
+    Path(): Path {
+        return new Path(this.api, this);
+    }
+
+    Project(...params: any[]): Project {
+        return new Project(this.api, this, ...params);
+    }
+
+    GroupCount(): GroupCount {
+        return new GroupCount(this.api, this);
+    }
+
     Subgraph(): G {
         return new Subgraph(this.api, this);
     }
@@ -577,6 +617,9 @@ class LimitE extends MixinStep(E, "Limit") { }
 class SortV extends MixinStep(V, "Sort") { }
 class SortE extends MixinStep(E, "Sort") { }
 
+class Property extends MixinStep(V, "Property") { }
+class Drop extends MixinStep(V, "Drop") { }
+
 export class Out extends V {
     name() { return "Out" }
 }
@@ -634,6 +677,35 @@ export class ShortestPath extends Step {
     }
 }
 
+export class AllPaths extends ShortestPath {
+    name() { return "AllPathsTo" }
+}
+
+export class KShortestPaths extends ShortestPath {
+    name() { return "KShortestPathsTo" }
+}
+
+export class Path extends Step {
+    name() { return "Path" }
+
+    serialize(data) {
+        var items: (GraphNode | GraphEdge)[][] = [];
+        for (var path in data) {
+            var elements: (GraphNode | GraphEdge)[] = [];
+            for (var obj in data[path]) {
+                let element = data[path][obj];
+                if ("Parent" in element) {
+                    elements.push(SerializationHelper.toInstance(new GraphEdge(), element));
+                } else {
+                    elements.push(SerializationHelper.toInstance(new GraphNode(), element));
+                }
+            }
+            items.push(elements);
+        }
+        return items;
+    }
+}
+
 class Predicate {
     name: string
     params: any
@@ -828,6 +900,46 @@ export class Sum extends Value {
     name() { return "Sum" }
 }
 
+export class Max extends Value {
+    name() { return "Max" }
+}
+
+export class Min extends Value {
+    name() { return "Min" }
+}
+
+export class Avg extends Value {
+    name() { return "Avg" }
+}
+
+export class Percentile extends Value {
+    name() { return "Percentile" }
+}
+
+export class Project extends Value {
+    name() { return "Project" }
+
+    By(...params: any[]): Project {
+        return new ProjectBy(this.api, this, ...params);
+    }
+}
+
+class ProjectBy extends Project {
+    name() { return "By" }
+}
+
+export class GroupCount extends Value {
+    name() { return "GroupCount" }
+
+    By(...params: any[]): GroupCount {
+        return new GroupCountBy(this.api, this, ...params);
+    }
+}
+
+class GroupCountBy extends GroupCount {
+    name() { return "By" }
+}
+
 export class Metrics extends Step {
     name() { return "Metrics" }
 
@@ -843,6 +955,26 @@ export class Metrics extends Step {
         return new Aggregates(this.api, this, ...params);
     }
 
+    Rate(...params: any[]): Metrics {
+        return new Rate(this.api, this, ...params);
+    }
+
+    Max(...params: any[]): Value {
+        return new Max(this.api, this, ...params);
+    }
+
+    Min(...params: any[]): Value {
+        return new Min(this.api, this, ...params);
+    }
+
+    Avg(...params: any[]): Value {
+        return new Avg(this.api, this, ...params);
+    }
+
+    Percentile(...params: any[]): Value {
+        return new Percentile(this.api, this, ...params);
+    }
+
     Count(): Value {
         return new Count(this.api, this);
     }
@@ -852,6 +984,10 @@ export class Aggregates extends Metrics {
     name() { return "Aggregates" }
 }
 
+export class Rate extends Metrics {
+    name() { return "Rate" }
+}
+
 export class RawPackets extends Step {
     name() { return "RawPackets" }
 
@@ -0,0 +1,322 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// Config describes the connection to a PostgreSQL server
+type Config struct {
+	Addr     string
+	Database string
+	Username string
+	Password string
+	SSLMode  string
+}
+
+// Client describes a PostgreSQL database client
+type Client struct {
+	*sql.DB
+}
+
+// Args holds the values of the placeholders of a query
+type Args []interface{}
+
+// Add appends a value to the arguments and returns its placeholder
+func (a *Args) Add(value interface{}) string {
+	*a = append(*a, value)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// Field describes the SQL expression a filter key refers to, either a
+// column or a value of a JSONB document
+type Field struct {
+	Expr string
+	JSON bool
+}
+
+// FieldFormatter returns the field of a filter key
+type FieldFormatter func(key string, args *Args) Field
+
+// Column returns the field of a table column
+func Column(name string) Field {
+	return Field{Expr: pq.QuoteIdentifier(name)}
+}
+
+// ColumnFormatter returns a formatter of the keys naming the columns of a
+// table, the column names being prefixed by the table alias if not empty
+func ColumnFormatter(alias string) FieldFormatter {
+	return func(key string, args *Args) Field {
+		f := Column(key)
+		if alias != "" {
+			f.Expr = alias + "." + f.Expr
+		}
+		return f
+	}
+}
+
+// JSONField returns the field of a dotted key within a JSONB column
+func JSONField(column string, key string, args *Args) Field {
+	return Field{
+		Expr: fmt.Sprintf("(%s #> %s::text[])", column, args.Add(pq.StringArray(strings.Split(key, ".")))),
+		JSON: true,
+	}
+}
+
+// jsonNumber returns the numeric value of a JSONB expression, NULL if not a
+// number
+func jsonNumber(expr string) string {
+	return fmt.Sprintf("(CASE WHEN jsonb_typeof(%s) = 'number' THEN (%s #>> '{}')::numeric END)", expr, expr)
+}
+
+// jsonRegex returns an expression matching the JSONB string, or one of the
+// strings of the JSONB array, against a regular expression
+func jsonRegex(expr string, placeholder string) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM jsonb_array_elements_text(CASE WHEN jsonb_typeof(%s) = 'array' THEN %s ELSE jsonb_build_array(%s) END) AS v WHERE v ~ %s)", expr, expr, expr, placeholder)
+}
+
+func compare(f Field, op string, value int64, args *Args) string {
+	if f.JSON {
+		return fmt.Sprintf("%s %s %s", jsonNumber(f.Expr), op, args.Add(value))
+	}
+	return fmt.Sprintf("%s %s %s", f.Expr, op, args.Add(value))
+}
+
+func term(f Field, value interface{}, cast string, args *Args) string {
+	if f.JSON {
+		// a JSONB array contains the value if one of its items is equal
+		return fmt.Sprintf("%s @> to_jsonb(%s::%s)", f.Expr, args.Add(value), cast)
+	}
+	return fmt.Sprintf("%s = %s", f.Expr, args.Add(value))
+}
+
+func regex(f Field, pattern string, args *Args) string {
+	if f.JSON {
+		return jsonRegex(f.Expr, args.Add(pattern))
+	}
+	return fmt.Sprintf("%s ~ %s", f.Expr, args.Add(pattern))
+}
+
+// FilterToExpression returns a PostgreSQL condition based on filters, the
+// values being added to the query arguments
+func FilterToExpression(f *filters.Filter, formatter FieldFormatter, args *Args) string {
+	if f == nil {
+		return ""
+	}
+
+	if f.BoolFilter != nil {
+		keyword := ""
+		switch f.BoolFilter.Op {
+		case filters.BoolFilterOp_NOT:
+			expr := "TRUE"
+			if len(f.BoolFilter.Filters) > 0 {
+				if e := FilterToExpression(f.BoolFilter.Filters[0], formatter, args); e != "" {
+					expr = e
+				}
+			}
+			return "NOT (" + expr + ")"
+		case filters.BoolFilterOp_OR:
+			keyword = "OR"
+		case filters.BoolFilterOp_AND:
+			keyword = "AND"
+		}
+		var conditions []string
+		for _, item := range f.BoolFilter.Filters {
+			if expr := FilterToExpression(item, formatter, args); expr != "" {
+				conditions = append(conditions, "("+expr+")")
+			}
+		}
+		return strings.Join(conditions, " "+keyword+" ")
+	}
+
+	if f.TermStringFilter != nil {
+		return term(formatter(f.TermStringFilter.Key, args), f.TermStringFilter.Value, "text", args)
+	}
+
+	if f.TermInt64Filter != nil {
+		return term(formatter(f.TermInt64Filter.Key, args), f.TermInt64Filter.Value, "bigint", args)
+	}
+
+	if f.TermBoolFilter != nil {
+		return term(formatter(f.TermBoolFilter.Key, args), f.TermBoolFilter.Value, "boolean", args)
+	}
+
+	if f.GtInt64Filter != nil {
+		return compare(formatter(f.GtInt64Filter.Key, args), ">", f.GtInt64Filter.Value, args)
+	}
+
+	if f.LtInt64Filter != nil {
+		return compare(formatter(f.LtInt64Filter.Key, args), "<", f.LtInt64Filter.Value, args)
+	}
+
+	if f.GteInt64Filter != nil {
+		return compare(formatter(f.GteInt64Filter.Key, args), ">=", f.GteInt64Filter.Value, args)
+	}
+
+	if f.LteInt64Filter != nil {
+		return compare(formatter(f.LteInt64Filter.Key, args), "<=", f.LteInt64Filter.Value, args)
+	}
+
+	if f.RegexFilter != nil {
+		return regex(formatter(f.RegexFilter.Key, args), f.RegexFilter.Value, args)
+	}
+
+	if f.NullFilter != nil {
+		return fmt.Sprintf("%s IS NULL", formatter(f.NullFilter.Key, args).Expr)
+	}
+
+	if f.IPV4RangeFilter != nil {
+		// ignore the error at this point it should have been catched earlier
+		pattern, _ := common.IPV4CIDRToRegex(f.IPV4RangeFilter.Value)
+
+		return regex(formatter(f.IPV4RangeFilter.Key, args), pattern, args)
+	}
+
+	return ""
+}
+
+// SearchQueryToClauses returns the ORDER BY and LIMIT clauses of a search
+// query, prefixed by a space when not empty
+func SearchQueryToClauses(query filters.SearchQuery, formatter FieldFormatter, args *Args) (clauses string) {
+	if query.Sort && query.SortBy != "" {
+		clauses += " ORDER BY " + formatter(query.SortBy, args).Expr
+		if strings.ToUpper(query.SortOrder) == string(common.SortDescending) {
+			clauses += " DESC"
+		}
+	}
+
+	if interval := query.PaginationRange; interval != nil {
+		clauses += fmt.Sprintf(" LIMIT %d OFFSET %d", interval.To-interval.From, interval.From)
+	}
+
+	return
+}
+
+// Migrate applies the schema migrations of a component that were not
+// applied yet, every migration being a list of statements run in a single
+// transaction. Migrations must never be modified once released, new ones
+// have to be appended.
+func (c *Client) Migrate(component string, migrations [][]string) error {
+	if _, err := c.Exec(`CREATE TABLE IF NOT EXISTS skydive_schema (component TEXT PRIMARY KEY, version INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("Failed to create schema version table: %s", err)
+	}
+
+	tx, err := c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// serialize the migrations of the analyzers sharing the database
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, component); err != nil {
+		return err
+	}
+
+	var version int
+	err = tx.QueryRow(`SELECT version FROM skydive_schema WHERE component = $1`, component).Scan(&version)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if version >= len(migrations) {
+		return nil
+	}
+
+	for i, statements := range migrations[version:] {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("Failed to apply migration %d of %s: %s", version+i+1, component, err)
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO skydive_schema (component, version) VALUES ($1, $2) ON CONFLICT (component) DO UPDATE SET version = EXCLUDED.version`, component, len(migrations)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logging.GetLogger().Infof("Schema of %s migrated from version %d to %d", component, version, len(migrations))
+	return nil
+}
+
+// URL returns the connection URL of the configuration
+func (cfg Config) URL() string {
+	u := url.URL{
+		Scheme: "postgres",
+		Host:   cfg.Addr,
+		Path:   "/" + cfg.Database,
+	}
+
+	if cfg.Username != "" {
+		u.User = url.UserPassword(cfg.Username, cfg.Password)
+	}
+
+	if cfg.SSLMode != "" {
+		u.RawQuery = url.Values{"sslmode": []string{cfg.SSLMode}}.Encode()
+	}
+
+	return u.String()
+}
+
+// NewConfig returns the configuration of a PostgreSQL backend
+func NewConfig(name string) Config {
+	path := "storage." + name
+
+	return Config{
+		Addr:     config.GetString(path + ".addr"),
+		Database: config.GetString(path + ".database"),
+		Username: config.GetString(path + ".username"),
+		Password: config.GetString(path + ".password"),
+		SSLMode:  config.GetString(path + ".ssl_mode"),
+	}
+}
+
+// NewClient creates a new PostgreSQL database client
+func NewClient(cfg Config) (*Client, error) {
+	db, err := sql.Open("postgres", cfg.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Client{DB: db}, nil
+}
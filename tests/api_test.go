@@ -23,6 +23,7 @@
 package tests
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/skydive-project/skydive/api/client"
@@ -49,7 +50,7 @@ func TestAlertAPI(t *testing.T) {
 		t.Error(err)
 	}
 
-	if *alert != *alert2 {
+	if !reflect.DeepEqual(alert, alert2) {
 		t.Errorf("Alert corrupted: %+v != %+v", alert, alert2)
 	}
 
@@ -62,7 +63,7 @@ func TestAlertAPI(t *testing.T) {
 		}
 	}
 
-	if alerts[alert.UUID] != *alert {
+	if !reflect.DeepEqual(alerts[alert.UUID], *alert) {
 		t.Errorf("Alert corrupted: %+v != %+v", alerts[alert.UUID], alert)
 	}
 
@@ -102,7 +103,7 @@ func TestCaptureAPI(t *testing.T) {
 		t.Error(err)
 	}
 
-	if *capture != *capture2 {
+	if !reflect.DeepEqual(capture, capture2) {
 		t.Errorf("Capture corrupted: %+v != %+v", capture, capture2)
 	}
 
@@ -114,7 +115,7 @@ func TestCaptureAPI(t *testing.T) {
 		}
 	}
 
-	if captures[capture.ID()] != *capture {
+	if !reflect.DeepEqual(captures[capture.ID()], *capture) {
 		t.Errorf("Capture corrupted: %+v != %+v", captures[capture.ID()], capture)
 	}
 
@@ -135,3 +136,31 @@ func TestCaptureAPI(t *testing.T) {
 		t.Errorf("Found delete capture: %s", capture.ID())
 	}
 }
+
+func TestGremlinBindingsAPI(t *testing.T) {
+	client, err := client.NewCrudClientFromConfig(&shttp.AuthenticationOpts{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	alert := types.NewAlert()
+	alert.Expression = "G.V().Has('Name', $name)"
+	if err := client.Create("alert", alert); err == nil {
+		client.Delete("alert", alert.UUID)
+		t.Error("Alert without the value of its variable should be rejected")
+	}
+
+	alert.GremlinBindings = map[string]interface{}{"name": "br-int"}
+	if err := client.Create("alert", alert); err != nil {
+		t.Errorf("Failed to create alert: %s", err.Error())
+	} else {
+		client.Delete("alert", alert.UUID)
+	}
+
+	capture := types.NewCapture("G.V().Has('Name', 'br-int').Limit($limit)", "")
+	capture.GremlinBindings = map[string]interface{}{"limit": "br-int"}
+	if err := client.Create("capture", capture); err == nil {
+		client.Delete("capture", capture.ID())
+		t.Error("Capture with a wrong value for its variable should be rejected")
+	}
+}
@@ -0,0 +1,559 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/logging"
+)
+
+var (
+	// revision key => revision of a node, the key being the node ID followed
+	// by a sequence number so that the revisions of a node are contiguous
+	boltNodeBucket = []byte("nodes")
+	// revision key => revision of an edge
+	boltEdgeBucket = []byte("edges")
+	// node ID => revision key of the live revision of the node
+	boltLiveNodeBucket = []byte("live_nodes")
+	// edge ID => revision key of the live revision of the edge
+	boltLiveEdgeBucket = []byte("live_edges")
+	// node ID + edge ID => nil, edges ever linked to a node
+	boltNodeEdgeBucket = []byte("node_edges")
+)
+
+// BoltDBBackend describes a persistent backend embedded in the analyzer,
+// based on BoltDB. All the revisions of the nodes and edges are kept along
+// with their validity range to provide the topology history.
+type BoltDBBackend struct {
+	Backend
+	db *bolt.DB
+}
+
+// GetField implements the Getter interface so that the time filters can be
+// evaluated on a revision
+func (r *rawData) GetField(field string) (interface{}, error) {
+	return r.GetFieldInt64(field)
+}
+
+// GetFieldInt64 returns the times of a revision, the unset ones being
+// reported as not found
+func (r *rawData) GetFieldInt64(field string) (int64, error) {
+	var value int64
+	switch field {
+	case "CreatedAt":
+		value = r.CreatedAt
+	case "UpdatedAt":
+		value = r.UpdatedAt
+	case "DeletedAt":
+		value = r.DeletedAt
+	case "ArchivedAt":
+		value = r.ArchivedAt
+	}
+
+	if value == 0 {
+		return 0, common.ErrFieldNotFound
+	}
+	return value, nil
+}
+
+// GetFieldString implements the Getter interface
+func (r *rawData) GetFieldString(field string) (string, error) {
+	return "", common.ErrFieldNotFound
+}
+
+func boltIDPrefix(id string) []byte {
+	return append([]byte(id), 0)
+}
+
+func rawToElement(data []byte, element interface {
+	Decode(i interface{}) error
+}) error {
+	var obj map[string]interface{}
+	if err := common.JSONDecode(bytes.NewReader(data), &obj); err != nil {
+		return err
+	}
+
+	return element.Decode(obj)
+}
+
+// addRevision stores a new revision and makes it the live one
+func (b *BoltDBBackend) addRevision(tx *bolt.Tx, bucket, live []byte, raw *rawData) error {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+
+	revisions := tx.Bucket(bucket)
+	seq, err := revisions.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	key := boltIDPrefix(raw.ID)
+	key = append(key, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(key[len(key)-8:], seq)
+
+	if err := revisions.Put(key, data); err != nil {
+		return err
+	}
+
+	return tx.Bucket(live).Put([]byte(raw.ID), key)
+}
+
+// archiveRevision ends the validity of the live revision at the given time,
+// the revision being replaced by raw if not nil
+func (b *BoltDBBackend) archiveRevision(tx *bolt.Tx, bucket, live []byte, id string, raw *rawData, at time.Time) error {
+	key := tx.Bucket(live).Get([]byte(id))
+	if key == nil {
+		return common.ErrNotFound
+	}
+
+	revisions := tx.Bucket(bucket)
+	if raw == nil {
+		raw = new(rawData)
+		if err := json.Unmarshal(revisions.Get(key), raw); err != nil {
+			return err
+		}
+	}
+	raw.ArchivedAt = common.UnixMillis(at)
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+
+	return revisions.Put(append([]byte{}, key...), data)
+}
+
+// searchRevisions returns the revisions of the given bucket valid within the
+// time context, limited to the ones of the given IDs if not nil. Only the
+// live revisions are looked up when no time slice is specified.
+func (b *BoltDBBackend) searchRevisions(bucket, live []byte, t Context, ids []string, fnc func(data []byte) error) error {
+	timeFilter := getTimeFilter(t.TimeSlice)
+
+	match := func(data []byte) error {
+		if data == nil {
+			return nil
+		}
+
+		var raw rawData
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+
+		if !timeFilter.Eval(&raw) {
+			return nil
+		}
+		return fnc(data)
+	}
+
+	return b.db.View(func(tx *bolt.Tx) error {
+		revisions := tx.Bucket(bucket)
+
+		if t.TimeSlice == nil {
+			if ids == nil {
+				return tx.Bucket(live).ForEach(func(k, key []byte) error {
+					return match(revisions.Get(key))
+				})
+			}
+
+			for _, id := range ids {
+				if key := tx.Bucket(live).Get([]byte(id)); key != nil {
+					if err := match(revisions.Get(key)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+
+		if ids == nil {
+			return revisions.ForEach(func(k, data []byte) error {
+				return match(data)
+			})
+		}
+
+		c := revisions.Cursor()
+		for _, id := range ids {
+			prefix := boltIDPrefix(id)
+			for k, data := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, data = c.Next() {
+				if err := match(data); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltDBBackend) searchNodes(t Context, ids []string, m ElementMatcher) (nodes []*Node) {
+	err := b.searchRevisions(boltNodeBucket, boltLiveNodeBucket, t, ids, func(data []byte) error {
+		node := new(Node)
+		if err := rawToElement(data, node); err != nil {
+			logging.GetLogger().Debugf("Failed to unmarshal node: %s", string(data))
+			return nil
+		}
+
+		if node.MatchMetadata(m) {
+			nodes = append(nodes, node)
+		}
+		return nil
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to query nodes: %s", err)
+		return nil
+	}
+
+	if len(nodes) > 1 {
+		if t.TimePoint {
+			nodes = dedupNodes(nodes)
+		} else {
+			SortNodes(nodes, "UpdatedAt", common.SortAscending)
+		}
+	}
+
+	return
+}
+
+func (b *BoltDBBackend) searchEdges(t Context, ids []string, m ElementMatcher) (edges []*Edge) {
+	err := b.searchRevisions(boltEdgeBucket, boltLiveEdgeBucket, t, ids, func(data []byte) error {
+		edge := new(Edge)
+		if err := rawToElement(data, edge); err != nil {
+			logging.GetLogger().Debugf("Failed to unmarshal edge: %s", string(data))
+			return nil
+		}
+
+		if edge.MatchMetadata(m) {
+			edges = append(edges, edge)
+		}
+		return nil
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to query edges: %s", err)
+		return nil
+	}
+
+	if len(edges) > 1 {
+		if t.TimePoint {
+			edges = dedupEdges(edges)
+		} else {
+			SortEdges(edges, "UpdatedAt", common.SortAscending)
+		}
+	}
+
+	return
+}
+
+// NodeAdded add a node
+func (b *BoltDBBackend) NodeAdded(n *Node) bool {
+	raw, err := nodeToRaw(n)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while adding node %s: %s", n.ID, err)
+		return false
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		return b.addRevision(tx, boltNodeBucket, boltLiveNodeBucket, raw)
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Error while adding node %s: %s", n.ID, err)
+		return false
+	}
+
+	return true
+}
+
+// NodeDeleted delete a node
+func (b *BoltDBBackend) NodeDeleted(n *Node) bool {
+	raw, err := nodeToRaw(n)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while deleting node %s: %s", n.ID, err)
+		return false
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		if err := b.archiveRevision(tx, boltNodeBucket, boltLiveNodeBucket, raw.ID, raw, n.deletedAt); err != nil {
+			return err
+		}
+		return tx.Bucket(boltLiveNodeBucket).Delete([]byte(raw.ID))
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Error while deleting node %s: %s", n.ID, err)
+		return false
+	}
+
+	return true
+}
+
+// GetNode get a node within a time slice
+func (b *BoltDBBackend) GetNode(i Identifier, t Context) []*Node {
+	nodes := b.searchNodes(Context{TimeSlice: t.TimeSlice}, []string{string(i)}, nil)
+
+	if len(nodes) > 1 && t.TimePoint {
+		return []*Node{nodes[len(nodes)-1]}
+	}
+
+	return nodes
+}
+
+// GetNodeEdges returns a list of a node edges within time slice
+func (b *BoltDBBackend) GetNodeEdges(n *Node, t Context, m ElementMatcher) (edges []*Edge) {
+	ids := []string{}
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		prefix := boltIDPrefix(string(n.ID))
+
+		c := tx.Bucket(boltNodeEdgeBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			ids = append(ids, string(k[len(prefix):]))
+		}
+		return nil
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Failed to query edges of node %s: %s", n.ID, err)
+		return
+	}
+
+	return b.searchEdges(t, ids, m)
+}
+
+// EdgeAdded add an edge
+func (b *BoltDBBackend) EdgeAdded(e *Edge) bool {
+	raw, err := edgeToRaw(e)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while adding edge %s: %s", e.ID, err)
+		return false
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		if err := b.addRevision(tx, boltEdgeBucket, boltLiveEdgeBucket, raw); err != nil {
+			return err
+		}
+
+		nodeEdges := tx.Bucket(boltNodeEdgeBucket)
+		for _, id := range []string{raw.Parent, raw.Child} {
+			if err := nodeEdges.Put(append(boltIDPrefix(id), raw.ID...), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Error while adding edge %s: %s", e.ID, err)
+		return false
+	}
+
+	return true
+}
+
+// EdgeDeleted delete an edge
+func (b *BoltDBBackend) EdgeDeleted(e *Edge) bool {
+	raw, err := edgeToRaw(e)
+	if err != nil {
+		logging.GetLogger().Errorf("Error while deleting edge %s: %s", e.ID, err)
+		return false
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		if err := b.archiveRevision(tx, boltEdgeBucket, boltLiveEdgeBucket, raw.ID, raw, e.deletedAt); err != nil {
+			return err
+		}
+		return tx.Bucket(boltLiveEdgeBucket).Delete([]byte(raw.ID))
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Error while deleting edge %s: %s", e.ID, err)
+		return false
+	}
+
+	return true
+}
+
+// GetEdge get an edge within a time slice
+func (b *BoltDBBackend) GetEdge(i Identifier, t Context) []*Edge {
+	edges := b.searchEdges(Context{TimeSlice: t.TimeSlice}, []string{string(i)}, nil)
+
+	if len(edges) > 1 && t.TimePoint {
+		return []*Edge{edges[len(edges)-1]}
+	}
+
+	return edges
+}
+
+// GetEdgeNodes returns the parents and child nodes of an edge within time slice, matching metadatas
+func (b *BoltDBBackend) GetEdgeNodes(e *Edge, t Context, parentMetadata, childMetadata ElementMatcher) (parents []*Node, children []*Node) {
+	for _, parent := range b.GetNode(e.parent, t) {
+		if parent.MatchMetadata(parentMetadata) {
+			parents = append(parents, parent)
+		}
+	}
+
+	for _, child := range b.GetNode(e.child, t) {
+		if child.MatchMetadata(childMetadata) {
+			children = append(children, child)
+		}
+	}
+
+	return
+}
+
+// MetadataUpdated archives the previous revision of a node or an edge and
+// stores the new one
+func (b *BoltDBBackend) MetadataUpdated(i interface{}) bool {
+	var (
+		id           Identifier
+		bucket, live []byte
+		raw          *rawData
+		updatedAt    time.Time
+		err          error
+	)
+
+	switch i := i.(type) {
+	case *Node:
+		id, bucket, live, updatedAt = i.ID, boltNodeBucket, boltLiveNodeBucket, i.updatedAt
+		raw, err = nodeToRaw(i)
+	case *Edge:
+		id, bucket, live, updatedAt = i.ID, boltEdgeBucket, boltLiveEdgeBucket, i.updatedAt
+		raw, err = edgeToRaw(i)
+	default:
+		return true
+	}
+
+	if err != nil {
+		logging.GetLogger().Errorf("Error while updating %s: %s", id, err)
+		return false
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		if err := b.archiveRevision(tx, bucket, live, string(id), nil, updatedAt); err != nil {
+			return err
+		}
+		return b.addRevision(tx, bucket, live, raw)
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Error while updating %s: %s", id, err)
+		return false
+	}
+
+	return true
+}
+
+// GetNodes returns a list of nodes within time slice, matching metadata
+func (b *BoltDBBackend) GetNodes(t Context, m ElementMatcher) []*Node {
+	return b.searchNodes(t, nil, m)
+}
+
+// GetEdges returns a list of edges within time slice, matching metadata
+func (b *BoltDBBackend) GetEdges(t Context, m ElementMatcher) []*Edge {
+	return b.searchEdges(t, nil, m)
+}
+
+// IsHistorySupported returns that this backend does support history
+func (b *BoltDBBackend) IsHistorySupported() bool {
+	return true
+}
+
+// archiveLiveRevisions ends the revisions left live by a previous run as
+// the nodes and edges will be added again by the agents
+func (b *BoltDBBackend) archiveLiveRevisions(at time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, buckets := range [][2][]byte{{boltNodeBucket, boltLiveNodeBucket}, {boltEdgeBucket, boltLiveEdgeBucket}} {
+			bucket, live := buckets[0], buckets[1]
+
+			var ids []string
+			if err := tx.Bucket(live).ForEach(func(id, key []byte) error {
+				ids = append(ids, string(id))
+				return nil
+			}); err != nil {
+				return err
+			}
+
+			for _, id := range ids {
+				var raw rawData
+				if err := json.Unmarshal(tx.Bucket(bucket).Get(tx.Bucket(live).Get([]byte(id))), &raw); err != nil {
+					return err
+				}
+				raw.DeletedAt = common.UnixMillis(at)
+
+				if err := b.archiveRevision(tx, bucket, live, id, &raw, at); err != nil {
+					return err
+				}
+
+				if err := tx.Bucket(live).Delete([]byte(id)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// NewBoltDBBackend creates a new graph backend storing the topology and its
+// history in the BoltDB database file path
+func NewBoltDBBackend(path string) (*BoltDBBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltNodeBucket, boltEdgeBucket, boltLiveNodeBucket, boltLiveEdgeBucket, boltNodeEdgeBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	b := &BoltDBBackend{db: db}
+	if err := b.archiveLiveRevisions(time.Now()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// NewBoltDBBackendFromConfig creates a new BoltDB graph backend based on
+// configuration, the database being stored in the backend directory
+func NewBoltDBBackendFromConfig(backend string) (*BoltDBBackend, error) {
+	path := config.GetString("storage." + backend + ".path")
+	return NewBoltDBBackend(filepath.Join(path, "topology.db"))
+}
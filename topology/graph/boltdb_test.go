@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+func newBoltDBGraph(t *testing.T, dir string) (*Graph, *BoltDBBackend) {
+	b, err := NewBoltDBBackend(filepath.Join(dir, "topology.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewGraphFromConfig(b, common.UnknownService), b
+}
+
+func atTime(sec int64) Context {
+	return Context{TimeSlice: common.NewTimeSlice(sec*1000, sec*1000), TimePoint: true}
+}
+
+func TestBoltDBNodeHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-boltdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g, b := newBoltDBGraph(t, dir)
+	defer b.db.Close()
+
+	if !b.IsHistorySupported() {
+		t.Fatal("History should be supported")
+	}
+
+	node := g.CreateNode("aaa", Metadata{"MTU": 1500}, time.Unix(1, 0), "host1")
+	g.AddNode(node)
+	g.addMetadata(node, "MTU", 1510, time.Unix(3, 0))
+
+	if nodes := b.GetNodes(Context{TimePoint: true}, nil); len(nodes) != 1 {
+		t.Fatalf("Expected one live node, got %+v", nodes)
+	} else if mtu, _ := nodes[0].GetFieldInt64("MTU"); mtu != 1510 {
+		t.Fatalf("Expected live MTU 1510, got %d", mtu)
+	}
+
+	for sec, expected := range map[int64]int64{2: 1500, 4: 1510} {
+		nodes := b.GetNodes(atTime(sec), Metadata{"MTU": expected})
+		if len(nodes) != 1 || nodes[0].ID != "aaa" {
+			t.Fatalf("Expected node with MTU %d at %ds, got %+v", expected, sec, nodes)
+		}
+	}
+
+	g.delNode(node, time.Unix(5, 0))
+
+	if nodes := b.GetNodes(Context{TimePoint: true}, nil); len(nodes) != 0 {
+		t.Fatalf("Expected no live node, got %+v", nodes)
+	}
+
+	if nodes := b.GetNodes(atTime(6), nil); len(nodes) != 0 {
+		t.Fatalf("Expected no node after deletion, got %+v", nodes)
+	}
+
+	if nodes := b.GetNode("aaa", atTime(4)); len(nodes) != 1 || nodes[0].revision != 2 {
+		t.Fatalf("Expected the second revision of the node, got %+v", nodes)
+	}
+
+	slice := Context{TimeSlice: common.NewTimeSlice(0, 10000)}
+	if nodes := b.GetNode("aaa", slice); len(nodes) != 2 {
+		t.Fatalf("Expected all the revisions of the node, got %+v", nodes)
+	}
+}
+
+func TestBoltDBEdgeHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-boltdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g, b := newBoltDBGraph(t, dir)
+
+	n1 := g.CreateNode("aaa", Metadata{"Type": "bridge"}, time.Unix(1, 0), "host1")
+	g.AddNode(n1)
+	n2 := g.CreateNode("bbb", Metadata{"Type": "device"}, time.Unix(1, 0), "host1")
+	g.AddNode(n2)
+
+	edge := g.CreateEdge("ccc", n1, n2, Metadata{"RelationType": "ownership"}, time.Unix(2, 0), "host1")
+	g.AddEdge(edge)
+	g.delEdge(edge, time.Unix(4, 0))
+
+	if edges := b.GetNodeEdges(n2, atTime(3), Metadata{"RelationType": "ownership"}); len(edges) != 1 || edges[0].ID != "ccc" {
+		t.Fatalf("Expected the edge at 3s, got %+v", edges)
+	}
+
+	if edges := b.GetNodeEdges(n2, atTime(5), nil); len(edges) != 0 {
+		t.Fatalf("Expected no edge after deletion, got %+v", edges)
+	}
+
+	parents, children := b.GetEdgeNodes(edge, atTime(3), nil, nil)
+	if len(parents) != 1 || parents[0].ID != "aaa" || len(children) != 1 || children[0].ID != "bbb" {
+		t.Fatalf("Wrong nodes of the edge: %+v, %+v", parents, children)
+	}
+
+	// the nodes left live are ended when the database is opened again
+	b.db.Close()
+
+	before := time.Now()
+	_, b = newBoltDBGraph(t, dir)
+	defer b.db.Close()
+
+	if nodes := b.GetNodes(Context{TimePoint: true}, nil); len(nodes) != 0 {
+		t.Fatalf("Expected no live node after reopening, got %+v", nodes)
+	}
+
+	if nodes := b.GetNodes(atTime(before.Unix()-1), nil); len(nodes) != 2 {
+		t.Fatalf("Expected the nodes of the previous run, got %+v", nodes)
+	}
+}
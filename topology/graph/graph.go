@@ -1496,7 +1496,7 @@ func NewGraphFromConfig(backend Backend, service common.ServiceType) *Graph {
 }
 
 // NewBackendByName creates a new graph backend based on the name
-// memory, orientdb, elasticsearch backend are supported
+// memory, orientdb, elasticsearch, boltdb backend are supported
 func NewBackendByName(name string, etcdClient *etcd.Client) (backend Backend, err error) {
 	driver := config.GetString("storage." + name + ".driver")
 	switch driver {
@@ -1506,6 +1506,10 @@ func NewBackendByName(name string, etcdClient *etcd.Client) (backend Backend, er
 		backend, err = NewOrientDBBackendFromConfig(name)
 	case "elasticsearch":
 		backend, err = NewElasticSearchBackendFromConfig(name, etcdClient)
+	case "boltdb":
+		backend, err = NewBoltDBBackendFromConfig(name)
+	case "postgres":
+		backend, err = NewPostgresBackendFromConfig(name)
 	default:
 		return nil, fmt.Errorf("Topology backend driver '%s' not supported", driver)
 	}
@@ -23,6 +23,7 @@
 package graph
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -336,6 +337,102 @@ func TestPath(t *testing.T) {
 	}
 }
 
+func TestMultiplePaths(t *testing.T) {
+	g := newGraph(t)
+
+	values := func(nodes []*Node) string {
+		var values []string
+		for _, n := range nodes {
+			value, _ := n.GetFieldInt64("Value")
+			values = append(values, strconv.FormatInt(value, 10))
+		}
+		return strings.Join(values, "/")
+	}
+
+	// n1 ------------ n4
+	//  \-- n2 -------/ |
+	//   \- n3 --------/
+	n1 := g.NewNode(GenID(), Metadata{"Value": 1})
+	n2 := g.NewNode(GenID(), Metadata{"Value": 2})
+	n3 := g.NewNode(GenID(), Metadata{"Value": 3})
+	n4 := g.NewNode(GenID(), Metadata{"Value": 4, "Name": "Node4"})
+
+	g.Link(n1, n4, Metadata{"Type": "Layer3", "Latency": 10})
+	g.Link(n1, n2, Metadata{"Type": "Layer2", "Latency": 1})
+	g.Link(n2, n4, Metadata{"Type": "Layer2", "Latency": 1})
+	g.Link(n1, n3, Metadata{"Type": "Layer2", "Latency": 5})
+	g.Link(n4, n3, Metadata{"Type": "Layer2", "Latency": 1})
+
+	r, err := g.LookupKShortestPaths(n1, Metadata{"Name": "Node4"}, nil, 3, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r) != 3 || values(r[0]) != "1/4" || len(r[1]) != 3 || len(r[2]) != 3 {
+		t.Errorf("Wrong paths returned: %v", r)
+	}
+
+	r, err = g.LookupKShortestPaths(n1, Metadata{"Name": "Node4"}, nil, 5, "Latency", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r) != 3 || values(r[0]) != "1/2/4" || values(r[1]) != "1/3/4" || values(r[2]) != "1/4" {
+		t.Errorf("Wrong weighted paths returned: %v", r)
+	}
+
+	r, err = g.LookupKShortestPaths(n1, Metadata{"Name": "Node4"}, Metadata{"Type": "Layer2"}, 5, "Latency", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r) != 2 || values(r[0]) != "1/2/4" || values(r[1]) != "1/3/4" {
+		t.Errorf("Wrong layer2 paths returned: %v", r)
+	}
+
+	g.Link(n2, n3, Metadata{"Type": "Layer2", "Latency": "fast"})
+	if _, err = g.LookupKShortestPaths(n1, Metadata{"Name": "Node4"}, nil, 5, "Latency", nil); err == nil {
+		t.Error("Should return an error for a non numeric weight")
+	}
+	g.Unlink(n2, n3)
+
+	if r, _ = g.LookupAllPaths(n1, Metadata{"Name": "Node4"}, nil, 1, nil); len(r) != 1 || values(r[0]) != "1/4" {
+		t.Errorf("Wrong paths returned: %v", r)
+	}
+
+	if r, _ = g.LookupAllPaths(n1, Metadata{"Name": "Node4"}, nil, 2, nil); len(r) != 3 {
+		t.Errorf("Wrong paths returned: %v", r)
+	}
+
+	// paths stop at the first node matching
+	if r, _ = g.LookupAllPaths(n1, Metadata{"Value": 4}, Metadata{"Type": "Layer2"}, 10, nil); len(r) != 2 {
+		t.Errorf("Wrong layer2 paths returned: %v", r)
+	}
+
+	if r, _ = g.LookupAllPaths(n1, Metadata{"Value": 55}, nil, 10, nil); len(r) != 0 {
+		t.Errorf("Shouldn't have returned paths: %v", r)
+	}
+
+	// lookups are aborted by the walk callback
+	errBudget := errors.New("budget exceeded")
+	budget := func(limit int) WalkCallback {
+		return func(n *Node) error {
+			if limit--; limit < 0 {
+				return errBudget
+			}
+			return nil
+		}
+	}
+
+	if _, err = g.LookupAllPaths(n1, Metadata{"Value": 55}, nil, 10, budget(3)); err != errBudget {
+		t.Errorf("Should return the callback error, returned: %v", err)
+	}
+
+	if _, err = g.LookupKShortestPaths(n1, Metadata{"Name": "Node4"}, nil, 5, "Latency", budget(3)); err != errBudget {
+		t.Errorf("Should return the callback error, returned: %v", err)
+	}
+}
+
 func nodeExpand(g *Graph, nodes []*Node, n int, level int) []*Node {
 	var ret []*Node
 	for _, node := range nodes {
@@ -0,0 +1,336 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"container/heap"
+	"fmt"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+// link to a neighbor node, the weight being the lowest one of the edges
+// between the two nodes
+type link struct {
+	node   *Node
+	weight float64
+}
+
+// weightedPath is a path found by the k shortest paths lookup
+type weightedPath struct {
+	nodes []*Node
+	cost  float64
+}
+
+// WalkCallback is called for every node walked by a path lookup, the lookup
+// being aborted with the returned error if any
+type WalkCallback func(n *Node) error
+
+// adjacency lazily retrieves the neighbors of the nodes, edges being walked
+// in both directions like with LookupShortestPath
+type adjacency struct {
+	graph     *Graph
+	em        ElementMatcher
+	weightKey string
+	links     map[Identifier][]link
+	walked    WalkCallback
+}
+
+func (a *adjacency) edgeWeight(e *Edge) (float64, error) {
+	if a.weightKey == "" {
+		return 1, nil
+	}
+
+	value, err := e.GetField(a.weightKey)
+	if err == common.ErrFieldNotFound {
+		return 1, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	weight, err := common.ToFloat64(value)
+	if err != nil || weight < 0 {
+		return 0, fmt.Errorf("Weight %s of edge %s has to be a positive number: %v", a.weightKey, e.ID, value)
+	}
+
+	return weight, nil
+}
+
+func (a *adjacency) neighbors(n *Node) ([]link, error) {
+	if links, ok := a.links[n.ID]; ok {
+		return links, nil
+	}
+
+	var links []link
+	index := make(map[Identifier]int)
+	for _, e := range a.graph.backend.GetNodeEdges(n, a.graph.context, a.em) {
+		weight, err := a.edgeWeight(e)
+		if err != nil {
+			return nil, err
+		}
+
+		parents, children := a.graph.backend.GetEdgeNodes(e, a.graph.context, nil, nil)
+		for _, neighbor := range append(parents, children...) {
+			if neighbor.ID == n.ID {
+				continue
+			}
+
+			if i, ok := index[neighbor.ID]; !ok {
+				index[neighbor.ID] = len(links)
+				links = append(links, link{node: neighbor, weight: weight})
+			} else if weight < links[i].weight {
+				links[i].weight = weight
+			}
+		}
+	}
+	a.links[n.ID] = links
+
+	return links, nil
+}
+
+func (a *adjacency) weight(from, to *Node) float64 {
+	for _, l := range a.links[from.ID] {
+		if l.node.ID == to.ID {
+			return l.weight
+		}
+	}
+	return 0
+}
+
+// distanceQueue is a priority queue of nodes sorted by distance
+type distanceQueue []link
+
+func (q distanceQueue) Len() int            { return len(q) }
+func (q distanceQueue) Less(i, j int) bool  { return q[i].weight < q[j].weight }
+func (q distanceQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *distanceQueue) Push(x interface{}) { *q = append(*q, x.(link)) }
+func (q *distanceQueue) Pop() interface{} {
+	old := *q
+	l := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return l
+}
+
+type nodePair [2]Identifier
+
+// shortestPath returns the lowest cost path from the source to the first
+// node matching m, without walking through the removed nodes and links
+func (a *adjacency) shortestPath(source *Node, m ElementMatcher, removedNodes map[Identifier]bool, removedLinks map[nodePair]bool) (*weightedPath, error) {
+	distance := map[Identifier]float64{source.ID: 0}
+	previous := make(map[Identifier]*Node)
+	done := make(map[Identifier]bool)
+
+	queue := &distanceQueue{{node: source, weight: 0}}
+	for queue.Len() > 0 {
+		u := heap.Pop(queue).(link)
+		if done[u.node.ID] {
+			continue
+		}
+		done[u.node.ID] = true
+
+		if a.walked != nil {
+			if err := a.walked(u.node); err != nil {
+				return nil, err
+			}
+		}
+
+		if u.node.MatchMetadata(m) {
+			path := &weightedPath{cost: u.weight}
+			for n := u.node; n != nil; n = previous[n.ID] {
+				path.nodes = append([]*Node{n}, path.nodes...)
+			}
+			return path, nil
+		}
+
+		links, err := a.neighbors(u.node)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, l := range links {
+			if removedNodes[l.node.ID] || removedLinks[nodePair{u.node.ID, l.node.ID}] {
+				continue
+			}
+
+			alt := u.weight + l.weight
+			if d, ok := distance[l.node.ID]; !ok || alt < d {
+				distance[l.node.ID] = alt
+				previous[l.node.ID] = u.node
+				heap.Push(queue, link{node: l.node, weight: alt})
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func containsPath(paths []*weightedPath, path *weightedPath) bool {
+	for _, p := range paths {
+		if len(p.nodes) == len(path.nodes) && samePrefix(p.nodes, path.nodes) {
+			return true
+		}
+	}
+	return false
+}
+
+func samePrefix(path []*Node, prefix []*Node) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+
+	for i, n := range prefix {
+		if path[i].ID != n.ID {
+			return false
+		}
+	}
+	return true
+}
+
+// LookupKShortestPaths returns, using the Yen algorithm, the k loopless
+// paths of lowest cost from the node to the nodes matching m. The cost of an
+// edge is the value of its weightKey field, 1 if not set, all the edges
+// matching em being walked in both directions. The optional walked callback
+// is called for every node walked.
+func (g *Graph) LookupKShortestPaths(n *Node, m ElementMatcher, em ElementMatcher, k int, weightKey string, walked WalkCallback) ([][]*Node, error) {
+	a := &adjacency{graph: g, em: em, weightKey: weightKey, links: make(map[Identifier][]link), walked: walked}
+
+	first, err := a.shortestPath(n, m, nil, nil)
+	if err != nil || first == nil {
+		return nil, err
+	}
+
+	found := []*weightedPath{first}
+	var candidates []*weightedPath
+
+	for len(found) < k {
+		last := found[len(found)-1]
+
+		for i := 0; i < len(last.nodes)-1; i++ {
+			spur, root := last.nodes[i], last.nodes[:i+1]
+
+			// forbid the links already used by the paths sharing the same root
+			removedLinks := make(map[nodePair]bool)
+			for _, p := range found {
+				if samePrefix(p.nodes, root) && len(p.nodes) > i+1 {
+					removedLinks[nodePair{p.nodes[i].ID, p.nodes[i+1].ID}] = true
+					removedLinks[nodePair{p.nodes[i+1].ID, p.nodes[i].ID}] = true
+				}
+			}
+
+			removedNodes := make(map[Identifier]bool)
+			for _, r := range root[:i] {
+				removedNodes[r.ID] = true
+			}
+
+			spurPath, err := a.shortestPath(spur, m, removedNodes, removedLinks)
+			if err != nil {
+				return nil, err
+			}
+			if spurPath == nil {
+				continue
+			}
+
+			candidate := &weightedPath{cost: spurPath.cost}
+			candidate.nodes = append(candidate.nodes, root[:i]...)
+			candidate.nodes = append(candidate.nodes, spurPath.nodes...)
+			for j := 0; j < i; j++ {
+				candidate.cost += a.weight(root[j], root[j+1])
+			}
+
+			if !containsPath(candidates, candidate) && !containsPath(found, candidate) {
+				candidates = append(candidates, candidate)
+			}
+		}
+
+		if len(candidates) == 0 {
+			break
+		}
+
+		// the next path is the candidate of lowest cost, then with the fewest hops
+		best := 0
+		for i, c := range candidates {
+			if c.cost < candidates[best].cost || (c.cost == candidates[best].cost && len(c.nodes) < len(candidates[best].nodes)) {
+				best = i
+			}
+		}
+
+		found = append(found, candidates[best])
+		candidates = append(candidates[:best], candidates[best+1:]...)
+	}
+
+	paths := make([][]*Node, len(found))
+	for i, p := range found {
+		paths[i] = p.nodes
+	}
+
+	return paths, nil
+}
+
+// LookupAllPaths returns all the loopless paths of at most maxDepth edges
+// from the node to the nodes matching m, a path ending at the first node
+// matching m. All the edges matching em are walked in both directions. The
+// optional walked callback is called for every node walked.
+func (g *Graph) LookupAllPaths(n *Node, m ElementMatcher, em ElementMatcher, maxDepth int, walked WalkCallback) ([][]*Node, error) {
+	paths := [][]*Node{}
+	visited := make(map[Identifier]bool)
+
+	var walk func(path []*Node) error
+	walk = func(path []*Node) error {
+		node := path[len(path)-1]
+		if walked != nil {
+			if err := walked(node); err != nil {
+				return err
+			}
+		}
+
+		if node.MatchMetadata(m) {
+			paths = append(paths, append([]*Node{}, path...))
+			return nil
+		}
+
+		if len(path) > maxDepth {
+			return nil
+		}
+
+		visited[node.ID] = true
+		defer delete(visited, node.ID)
+
+		// several edges may link the same nodes
+		neighbors := make(map[Identifier]bool)
+		for _, neighbor := range g.getNeighborNodes(node, em) {
+			if !visited[neighbor.ID] && !neighbors[neighbor.ID] {
+				neighbors[neighbor.ID] = true
+				if err := walk(append(path, neighbor)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := walk([]*Node{n}); err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
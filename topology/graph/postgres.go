@@ -0,0 +1,512 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/logging"
+	pg "github.com/skydive-project/skydive/storage/postgres"
+)
+
+// migrations of the topology schema, only new migrations can be appended
+var postgresMigrations = [][]string{
+	{
+		`CREATE TABLE nodes (
+			"ID" TEXT NOT NULL,
+			"Host" TEXT NOT NULL,
+			"Origin" TEXT NOT NULL,
+			"CreatedAt" BIGINT NOT NULL,
+			"UpdatedAt" BIGINT NOT NULL,
+			"DeletedAt" BIGINT,
+			"ArchivedAt" BIGINT,
+			"Revision" BIGINT NOT NULL,
+			"Metadata" JSONB NOT NULL
+		)`,
+		`CREATE UNIQUE INDEX nodes_live ON nodes ("ID") WHERE "ArchivedAt" IS NULL`,
+		`CREATE INDEX nodes_revision ON nodes ("ID", "Revision")`,
+		`CREATE INDEX nodes_timespan ON nodes ("CreatedAt", "DeletedAt")`,
+		`CREATE TABLE edges (
+			"ID" TEXT NOT NULL,
+			"Host" TEXT NOT NULL,
+			"Origin" TEXT NOT NULL,
+			"CreatedAt" BIGINT NOT NULL,
+			"UpdatedAt" BIGINT NOT NULL,
+			"DeletedAt" BIGINT,
+			"ArchivedAt" BIGINT,
+			"Revision" BIGINT NOT NULL,
+			"Parent" TEXT NOT NULL,
+			"Child" TEXT NOT NULL,
+			"Metadata" JSONB NOT NULL
+		)`,
+		`CREATE UNIQUE INDEX edges_live ON edges ("ID") WHERE "ArchivedAt" IS NULL`,
+		`CREATE INDEX edges_revision ON edges ("ID", "Revision")`,
+		`CREATE INDEX edges_timespan ON edges ("CreatedAt", "DeletedAt")`,
+		`CREATE INDEX edges_parent ON edges ("Parent")`,
+		`CREATE INDEX edges_child ON edges ("Child")`,
+	},
+}
+
+const (
+	postgresNodeColumns = `"ID", "Host", "Origin", "CreatedAt", "UpdatedAt", "DeletedAt", "Revision", "Metadata"`
+	postgresEdgeColumns = postgresNodeColumns + `, "Parent", "Child"`
+)
+
+// PostgresBackend describes a persistent backend based on PostgreSQL, all
+// the revisions of the nodes and edges being kept to provide the history
+type PostgresBackend struct {
+	Backend
+	client *pg.Client
+}
+
+// postgresField returns the column of the graph element fields and the JSON
+// value of the metadata keys
+func postgresField(key string, args *pg.Args) pg.Field {
+	switch key {
+	case "ID", "Host", "Origin", "CreatedAt", "UpdatedAt", "DeletedAt", "ArchivedAt", "Revision", "Parent", "Child":
+		return pg.Column(key)
+	}
+	return pg.JSONField(`"Metadata"`, key, args)
+}
+
+func postgresMetadataField(key string, args *pg.Args) pg.Field {
+	return pg.JSONField(`"Metadata"`, key, args)
+}
+
+func nullableInt64(i int64) interface{} {
+	if i == 0 {
+		return nil
+	}
+	return i
+}
+
+// scanGraphElement decodes a row made of the columns of a node or an edge
+func scanGraphElement(rows *sql.Rows, element interface {
+	Decode(i interface{}) error
+}, edge bool) error {
+	var (
+		id, host, origin     string
+		createdAt, updatedAt int64
+		deletedAt            sql.NullInt64
+		revision             int64
+		metadata             []byte
+		parent, child        string
+	)
+
+	dest := []interface{}{&id, &host, &origin, &createdAt, &updatedAt, &deletedAt, &revision, &metadata}
+	if edge {
+		dest = append(dest, &parent, &child)
+	}
+
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+
+	obj := map[string]interface{}{
+		"ID":        id,
+		"Host":      host,
+		"Origin":    origin,
+		"CreatedAt": createdAt,
+		"UpdatedAt": updatedAt,
+		"Revision":  json.Number(strconv.FormatInt(revision, 10)),
+	}
+	if deletedAt.Valid {
+		obj["DeletedAt"] = deletedAt.Int64
+	}
+	if edge {
+		obj["Parent"] = parent
+		obj["Child"] = child
+	}
+
+	var m map[string]interface{}
+	if err := common.JSONDecode(bytes.NewReader(metadata), &m); err != nil {
+		return err
+	}
+	obj["Metadata"] = m
+
+	return element.Decode(obj)
+}
+
+// search returns the rows of the table matching the time context, the
+// filter and the metadata
+func (p *PostgresBackend) search(table string, t Context, filter *filters.Filter, m ElementMatcher, orderBy string, fnc func(rows *sql.Rows) error) error {
+	var args pg.Args
+
+	conditions := []string{pg.FilterToExpression(getTimeFilter(t.TimeSlice), postgresField, &args)}
+	if filter != nil {
+		conditions = append(conditions, pg.FilterToExpression(filter, postgresField, &args))
+	}
+	if m != nil {
+		metadataFilter, err := m.Filter()
+		if err != nil {
+			return err
+		}
+		conditions = append(conditions, pg.FilterToExpression(metadataFilter, postgresMetadataField, &args))
+	}
+
+	columns := postgresNodeColumns
+	if table == "edges" {
+		columns = postgresEdgeColumns
+	}
+
+	var exprs []string
+	for _, condition := range conditions {
+		if condition != "" {
+			exprs = append(exprs, "("+condition+")")
+		}
+	}
+
+	query := "SELECT " + columns + " FROM " + table
+	if len(exprs) > 0 {
+		query += " WHERE " + strings.Join(exprs, " AND ")
+	}
+	if orderBy != "" {
+		query += " ORDER BY " + orderBy
+	} else if !t.TimePoint {
+		query += ` ORDER BY "UpdatedAt"`
+	}
+
+	rows, err := p.client.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fnc(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (p *PostgresBackend) searchNodes(t Context, filter *filters.Filter, m ElementMatcher, orderBy string) (nodes []*Node) {
+	err := p.search("nodes", t, filter, m, orderBy, func(rows *sql.Rows) error {
+		node := new(Node)
+		if err := scanGraphElement(rows, node, false); err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+		return nil
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Error while retrieving nodes: %s", err)
+		return nil
+	}
+
+	return
+}
+
+func (p *PostgresBackend) searchEdges(t Context, filter *filters.Filter, m ElementMatcher, orderBy string) (edges []*Edge) {
+	err := p.search("edges", t, filter, m, orderBy, func(rows *sql.Rows) error {
+		edge := new(Edge)
+		if err := scanGraphElement(rows, edge, true); err != nil {
+			return err
+		}
+		edges = append(edges, edge)
+		return nil
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Error while retrieving edges: %s", err)
+		return nil
+	}
+
+	return
+}
+
+// insert stores a new live revision, replacing the one that may have been
+// left by another analyzer sharing the database
+func (p *PostgresBackend) insert(tx *sql.Tx, table string, raw *rawData) error {
+	columns := postgresNodeColumns
+	values := []interface{}{raw.ID, raw.Host, raw.Origin, raw.CreatedAt, raw.UpdatedAt, nullableInt64(raw.DeletedAt), raw.Revision, string(raw.Metadata)}
+	if table == "edges" {
+		columns = postgresEdgeColumns
+		values = append(values, raw.Parent, raw.Child)
+	}
+
+	var (
+		placeholders []string
+		updates      []string
+	)
+	for i, column := range strings.Split(columns, ", ") {
+		placeholders = append(placeholders, "$"+strconv.Itoa(i+1))
+		updates = append(updates, column+" = EXCLUDED."+column)
+	}
+
+	query := "INSERT INTO " + table + " (" + columns + ") VALUES (" + strings.Join(placeholders, ", ") + ")" +
+		` ON CONFLICT ("ID") WHERE "ArchivedAt" IS NULL DO UPDATE SET ` + strings.Join(updates, ", ")
+
+	_, err := tx.Exec(query, values...)
+	return err
+}
+
+// archive ends the validity of the live revision of an element
+func (p *PostgresBackend) archive(tx *sql.Tx, table string, id Identifier, at time.Time, deleted bool) error {
+	query := `UPDATE ` + table + ` SET "ArchivedAt" = $2`
+	if deleted {
+		query += `, "DeletedAt" = $2`
+	}
+	query += ` WHERE "ID" = $1 AND "ArchivedAt" IS NULL`
+
+	_, err := tx.Exec(query, string(id), common.UnixMillis(at))
+	return err
+}
+
+func (p *PostgresBackend) transaction(fnc func(tx *sql.Tx) error) error {
+	tx, err := p.client.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fnc(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// NodeAdded add a node in the database
+func (p *PostgresBackend) NodeAdded(n *Node) bool {
+	raw, err := nodeToRaw(n)
+	if err == nil {
+		err = p.transaction(func(tx *sql.Tx) error {
+			return p.insert(tx, "nodes", raw)
+		})
+	}
+
+	if err != nil {
+		logging.GetLogger().Errorf("Error while adding node %s: %s", n.ID, err)
+		return false
+	}
+	return true
+}
+
+// NodeDeleted delete a node in the database
+func (p *PostgresBackend) NodeDeleted(n *Node) bool {
+	err := p.transaction(func(tx *sql.Tx) error {
+		return p.archive(tx, "nodes", n.ID, n.deletedAt, true)
+	})
+
+	if err != nil {
+		logging.GetLogger().Errorf("Error while deleting node %s: %s", n.ID, err)
+		return false
+	}
+	return true
+}
+
+// GetNode get a node within a time slice
+func (p *PostgresBackend) GetNode(i Identifier, t Context) []*Node {
+	nodes := p.searchNodes(t, filters.NewTermStringFilter("ID", string(i)), nil, `"Revision"`)
+
+	if len(nodes) > 1 && t.TimePoint {
+		return []*Node{nodes[len(nodes)-1]}
+	}
+
+	return nodes
+}
+
+// GetNodeEdges returns a list of a node edges within time slice
+func (p *PostgresBackend) GetNodeEdges(n *Node, t Context, m ElementMatcher) (edges []*Edge) {
+	edges = p.searchEdges(t, NewFilterForEdge(n.ID, n.ID), m, "")
+
+	if len(edges) > 1 && t.TimePoint {
+		edges = dedupEdges(edges)
+	}
+
+	return
+}
+
+// EdgeAdded add an edge in the database
+func (p *PostgresBackend) EdgeAdded(e *Edge) bool {
+	raw, err := edgeToRaw(e)
+	if err == nil {
+		err = p.transaction(func(tx *sql.Tx) error {
+			return p.insert(tx, "edges", raw)
+		})
+	}
+
+	if err != nil {
+		logging.GetLogger().Errorf("Error while adding edge %s: %s", e.ID, err)
+		return false
+	}
+	return true
+}
+
+// EdgeDeleted delete an edge in the database
+func (p *PostgresBackend) EdgeDeleted(e *Edge) bool {
+	err := p.transaction(func(tx *sql.Tx) error {
+		return p.archive(tx, "edges", e.ID, e.deletedAt, true)
+	})
+
+	if err != nil {
+		logging.GetLogger().Errorf("Error while deleting edge %s: %s", e.ID, err)
+		return false
+	}
+	return true
+}
+
+// GetEdge get an edge within a time slice
+func (p *PostgresBackend) GetEdge(i Identifier, t Context) []*Edge {
+	edges := p.searchEdges(t, filters.NewTermStringFilter("ID", string(i)), nil, `"Revision"`)
+
+	if len(edges) > 1 && t.TimePoint {
+		return []*Edge{edges[len(edges)-1]}
+	}
+
+	return edges
+}
+
+// GetEdgeNodes returns the parents and child nodes of an edge within time slice, matching metadatas
+func (p *PostgresBackend) GetEdgeNodes(e *Edge, t Context, parentMetadata, childMetadata ElementMatcher) (parents []*Node, children []*Node) {
+	for _, parent := range p.GetNode(e.parent, t) {
+		if parent.MatchMetadata(parentMetadata) {
+			parents = append(parents, parent)
+		}
+	}
+
+	for _, child := range p.GetNode(e.child, t) {
+		if child.MatchMetadata(childMetadata) {
+			children = append(children, child)
+		}
+	}
+
+	return
+}
+
+// MetadataUpdated archives the previous revision of a node or an edge and
+// stores the new one
+func (p *PostgresBackend) MetadataUpdated(i interface{}) bool {
+	var (
+		id    Identifier
+		table string
+		e     *graphElement
+		raw   *rawData
+		err   error
+	)
+
+	switch i := i.(type) {
+	case *Node:
+		id, table, e = i.ID, "nodes", &i.graphElement
+		raw, err = nodeToRaw(i)
+	case *Edge:
+		id, table, e = i.ID, "edges", &i.graphElement
+		raw, err = edgeToRaw(i)
+	default:
+		return true
+	}
+
+	if err == nil {
+		err = p.transaction(func(tx *sql.Tx) error {
+			if err := p.archive(tx, table, id, e.updatedAt, false); err != nil {
+				return err
+			}
+			return p.insert(tx, table, raw)
+		})
+	}
+
+	if err != nil {
+		logging.GetLogger().Errorf("Error while updating %s: %s", id, err)
+		return false
+	}
+	return true
+}
+
+// GetNodes returns a list of nodes within time slice, matching metadata
+func (p *PostgresBackend) GetNodes(t Context, m ElementMatcher) []*Node {
+	nodes := p.searchNodes(t, nil, m, "")
+
+	if len(nodes) > 1 && t.TimePoint {
+		nodes = dedupNodes(nodes)
+	}
+
+	return nodes
+}
+
+// GetEdges returns a list of edges within time slice, matching metadata
+func (p *PostgresBackend) GetEdges(t Context, m ElementMatcher) []*Edge {
+	edges := p.searchEdges(t, nil, m, "")
+
+	if len(edges) > 1 && t.TimePoint {
+		edges = dedupEdges(edges)
+	}
+
+	return edges
+}
+
+// IsHistorySupported returns that this backend does support history
+func (p *PostgresBackend) IsHistorySupported() bool {
+	return true
+}
+
+// archiveLiveRevisions ends the revisions left live by a previous run as
+// the nodes and edges will be added again by the agents
+func (p *PostgresBackend) archiveLiveRevisions(at time.Time) error {
+	return p.transaction(func(tx *sql.Tx) error {
+		for _, table := range []string{"nodes", "edges"} {
+			query := `UPDATE ` + table + ` SET "ArchivedAt" = $1, "DeletedAt" = $1 WHERE "ArchivedAt" IS NULL`
+			if _, err := tx.Exec(query, common.UnixMillis(at)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// NewPostgresBackendFromClient creates a new graph backend using the given
+// PostgreSQL client, migrating the schema if needed
+func NewPostgresBackendFromClient(client *pg.Client) (*PostgresBackend, error) {
+	if err := client.Migrate("topology", postgresMigrations); err != nil {
+		return nil, err
+	}
+
+	p := &PostgresBackend{client: client}
+	if err := p.archiveLiveRevisions(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// NewPostgresBackendFromConfig creates a new PostgreSQL graph backend based
+// on configuration
+func NewPostgresBackendFromConfig(backend string) (*PostgresBackend, error) {
+	client, err := pg.NewClient(pg.NewConfig(backend))
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := NewPostgresBackendFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
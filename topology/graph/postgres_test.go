@@ -0,0 +1,212 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package graph
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	pg "github.com/skydive-project/skydive/storage/postgres"
+)
+
+// newPostgresGraph creates a graph backed by a throwaway database of the
+// PostgreSQL server given by the POSTGRES_ADDR, POSTGRES_USER and
+// POSTGRES_PASSWORD environment variables
+func newPostgresGraph(t *testing.T) (*Graph, *PostgresBackend, func()) {
+	addr := os.Getenv("POSTGRES_ADDR")
+	if addr == "" {
+		t.Skip("POSTGRES_ADDR not set, skipping PostgreSQL tests")
+	}
+
+	cfg := pg.Config{
+		Addr:     addr,
+		Database: "postgres",
+		Username: os.Getenv("POSTGRES_USER"),
+		Password: os.Getenv("POSTGRES_PASSWORD"),
+		SSLMode:  "disable",
+	}
+
+	admin, err := pg.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Database = fmt.Sprintf("skydive_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE DATABASE " + cfg.Database); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+
+	client, err := pg.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cleanup := func() {
+		client.Close()
+		admin.Exec("DROP DATABASE " + cfg.Database)
+		admin.Close()
+	}
+
+	b, err := NewPostgresBackendFromClient(client)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	// migrations already applied have to be skipped
+	if _, err := NewPostgresBackendFromClient(client); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return NewGraphFromConfig(b, common.UnknownService), b, cleanup
+}
+
+func TestPostgresNodeHistory(t *testing.T) {
+	g, b, cleanup := newPostgresGraph(t)
+	defer cleanup()
+
+	node := g.CreateNode("aaa", Metadata{"MTU": 1500, "IPV4": []string{"10.0.0.1/24"}}, time.Unix(1, 0), "host1")
+	g.AddNode(node)
+	g.addMetadata(node, "MTU", 1510, time.Unix(3, 0))
+
+	if nodes := b.GetNodes(Context{TimePoint: true}, nil); len(nodes) != 1 {
+		t.Fatalf("Expected one live node, got %+v", nodes)
+	} else if mtu, _ := nodes[0].GetFieldInt64("MTU"); mtu != 1510 {
+		t.Fatalf("Expected live MTU 1510, got %d", mtu)
+	}
+
+	for sec, expected := range map[int64]int64{2: 1500, 4: 1510} {
+		nodes := b.GetNodes(atTime(sec), Metadata{"MTU": expected})
+		if len(nodes) != 1 || nodes[0].ID != "aaa" {
+			t.Fatalf("Expected node with MTU %d at %ds, got %+v", expected, sec, nodes)
+		}
+	}
+
+	regex, _ := filters.NewRegexFilter("IPV4", "^10\\.0\\.0\\..*")
+	m := NewElementFilter(&filters.Filter{RegexFilter: regex})
+	if nodes := b.GetNodes(Context{TimePoint: true}, m); len(nodes) != 1 {
+		t.Fatalf("Expected the node to match an IPV4 address, got %+v", nodes)
+	}
+
+	m = NewElementFilter(filters.NewLtInt64Filter("MTU", 1510))
+	if nodes := b.GetNodes(Context{TimePoint: true}, m); len(nodes) != 0 {
+		t.Fatalf("Expected no live node with a MTU lower than 1510, got %+v", nodes)
+	}
+
+	g.delNode(node, time.Unix(5, 0))
+
+	if nodes := b.GetNodes(Context{TimePoint: true}, nil); len(nodes) != 0 {
+		t.Fatalf("Expected no live node, got %+v", nodes)
+	}
+
+	if nodes := b.GetNodes(atTime(6), nil); len(nodes) != 0 {
+		t.Fatalf("Expected no node after deletion, got %+v", nodes)
+	}
+
+	if nodes := b.GetNode("aaa", atTime(4)); len(nodes) != 1 || nodes[0].revision != 2 {
+		t.Fatalf("Expected the second revision of the node, got %+v", nodes)
+	}
+
+	slice := Context{TimeSlice: common.NewTimeSlice(0, 10000)}
+	if nodes := b.GetNode("aaa", slice); len(nodes) != 2 {
+		t.Fatalf("Expected all the revisions of the node, got %+v", nodes)
+	}
+}
+
+func TestPostgresEdgeHistory(t *testing.T) {
+	g, b, cleanup := newPostgresGraph(t)
+	defer cleanup()
+
+	n1 := g.CreateNode("aaa", Metadata{"Type": "bridge"}, time.Unix(1, 0), "host1")
+	g.AddNode(n1)
+	n2 := g.CreateNode("bbb", Metadata{"Type": "device"}, time.Unix(1, 0), "host1")
+	g.AddNode(n2)
+
+	edge := g.CreateEdge("ccc", n1, n2, Metadata{"RelationType": "ownership"}, time.Unix(2, 0), "host1")
+	g.AddEdge(edge)
+	g.delEdge(edge, time.Unix(4, 0))
+
+	if edges := b.GetNodeEdges(n2, atTime(3), Metadata{"RelationType": "ownership"}); len(edges) != 1 || edges[0].ID != "ccc" {
+		t.Fatalf("Expected the edge at 3s, got %+v", edges)
+	}
+
+	if edges := b.GetNodeEdges(n2, atTime(5), nil); len(edges) != 0 {
+		t.Fatalf("Expected no edge after deletion, got %+v", edges)
+	}
+
+	if edges := b.GetEdge("ccc", atTime(3)); len(edges) != 1 || edges[0].parent != "aaa" || edges[0].child != "bbb" {
+		t.Fatalf("Wrong edge at 3s: %+v", edges)
+	}
+
+	parents, children := b.GetEdgeNodes(edge, atTime(3), nil, Metadata{"Type": "device"})
+	if len(parents) != 1 || parents[0].ID != "aaa" || len(children) != 1 || children[0].ID != "bbb" {
+		t.Fatalf("Wrong nodes of the edge: %+v, %+v", parents, children)
+	}
+}
+
+func TestPostgresArchiveLiveRevisions(t *testing.T) {
+	g, b, cleanup := newPostgresGraph(t)
+	defer cleanup()
+
+	n1 := g.CreateNode("aaa", Metadata{"Type": "bridge"}, time.Unix(1, 0), "host1")
+	g.AddNode(n1)
+	n2 := g.CreateNode("bbb", Metadata{"Type": "device"}, time.Unix(1, 0), "host1")
+	g.AddNode(n2)
+	g.AddEdge(g.CreateEdge("ccc", n1, n2, Metadata{"RelationType": "ownership"}, time.Unix(2, 0), "host1"))
+
+	// a new run archives the revisions left live by the previous one
+	b, err := NewPostgresBackendFromClient(b.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if nodes := b.GetNodes(Context{TimePoint: true}, nil); len(nodes) != 0 {
+		t.Fatalf("Expected no live node, got %+v", nodes)
+	}
+
+	if edges := b.GetEdges(Context{TimePoint: true}, nil); len(edges) != 0 {
+		t.Fatalf("Expected no live edge, got %+v", edges)
+	}
+
+	if nodes := b.GetNodes(atTime(3), nil); len(nodes) != 2 {
+		t.Fatalf("Expected the history of the nodes to be kept, got %+v", nodes)
+	}
+
+	if edges := b.GetEdges(atTime(3), nil); len(edges) != 1 {
+		t.Fatalf("Expected the history of the edge to be kept, got %+v", edges)
+	}
+
+	// the nodes are added again by the agents
+	g = NewGraphFromConfig(b, common.UnknownService)
+	g.AddNode(g.CreateNode("aaa", Metadata{"Type": "bridge"}, time.Now(), "host1"))
+
+	if nodes := b.GetNodes(Context{TimePoint: true}, nil); len(nodes) != 1 {
+		t.Fatalf("Expected one live node, got %+v", nodes)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/hashicorp/golang-lru"
+)
+
+// UnboundVariableError is returned when a query uses a bind variable, like
+// $name, without any value given for it
+type UnboundVariableError struct {
+	Name string
+}
+
+func (e *UnboundVariableError) Error() string {
+	return fmt.Sprintf("Variable $%s is not bound", e.Name)
+}
+
+// scannedToken is a token along with its offset in the query
+type scannedToken struct {
+	tok    Token
+	lit    string
+	offset int
+}
+
+// tokenize scans a whole query, whitespaces being dropped as ignored by
+// the parser
+func tokenize(r io.Reader, extensions []GremlinTraversalExtension) (tokens []scannedToken) {
+	scanner := NewGremlinTraversalScanner(r, extensions)
+	for {
+		offset := scanner.Offset()
+
+		tok, lit := scanner.Scan()
+		if tok == WS {
+			continue
+		}
+		tokens = append(tokens, scannedToken{tok: tok, lit: lit, offset: offset})
+
+		if tok == EOF {
+			return
+		}
+	}
+}
+
+// Variables returns the names of the bind variables used by a query
+func Variables(query string) (names []string) {
+	seen := make(map[string]bool)
+	for _, t := range tokenize(strings.NewReader(query), nil) {
+		if t.tok == VARIABLE && !seen[t.lit] {
+			seen[t.lit] = true
+			names = append(names, t.lit)
+		}
+	}
+	return
+}
+
+// bindValue converts the value of a bind variable to step parameters, a
+// list giving one parameter per item, as in Within($names)
+func bindValue(name string, value interface{}) ([]interface{}, error) {
+	switch value := value.(type) {
+	case []interface{}:
+		var params []interface{}
+		for _, item := range value {
+			if _, ok := item.([]interface{}); ok {
+				return nil, fmt.Errorf("Variable $%s can't be a nested list", name)
+			}
+
+			p, err := bindValue(name, item)
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, p...)
+		}
+		return params, nil
+	case []string:
+		var params []interface{}
+		for _, item := range value {
+			params = append(params, item)
+		}
+		return params, nil
+	case string, bool, int64:
+		return []interface{}{value}, nil
+	case int:
+		return []interface{}{int64(value)}, nil
+	case float64:
+		// numbers decoded from JSON are floats, integers are used by the
+		// parser for numbers without decimals
+		if value == math.Trunc(value) && math.Abs(value) < math.MaxInt64 {
+			return []interface{}{int64(value)}, nil
+		}
+		return []interface{}{value}, nil
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return []interface{}{i}, nil
+		}
+		f, err := value.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("Variable $%s is not a valid number: %s", name, value)
+		}
+		return []interface{}{f}, nil
+	}
+
+	return nil, fmt.Errorf("Variable $%s has an unsupported type %T", name, value)
+}
+
+// EnableCache keeps the scanned tokens of the size most recently used
+// queries given to ParseQuery. Steps keeping state while being executed, the
+// sequences are built again for every query, only the scanning is saved.
+func (p *GremlinTraversalParser) EnableCache(size int) {
+	p.Lock()
+	defer p.Unlock()
+
+	if size <= 0 {
+		p.cache = nil
+		return
+	}
+	p.cache, _ = lru.New(size)
+}
+
+// ParseQuery parses a query whose bind variables, like $name, are replaced
+// by the given values
+func (p *GremlinTraversalParser) ParseQuery(query string, bindings map[string]interface{}) (*GremlinTraversalSequence, error) {
+	return p.parseQuery(query, bindings, false)
+}
+
+// ParseMutation parses a query like ParseQuery but also accepts the steps
+// modifying the graph, Property and Drop, as last step of the query. Callers
+// have to check that the user is allowed to modify the graph.
+func (p *GremlinTraversalParser) ParseMutation(query string, bindings map[string]interface{}) (*GremlinTraversalSequence, error) {
+	return p.parseQuery(query, bindings, true)
+}
+
+func (p *GremlinTraversalParser) parseQuery(query string, bindings map[string]interface{}, mutations bool) (*GremlinTraversalSequence, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	var tokens []scannedToken
+	if p.cache != nil {
+		if cached, found := p.cache.Get(query); found {
+			tokens = cached.([]scannedToken)
+		}
+	}
+
+	if tokens == nil {
+		tokens = tokenize(strings.NewReader(query), p.extensions)
+		if p.cache != nil {
+			p.cache.Add(query, tokens)
+		}
+	}
+
+	return p.parse(tokens, bindings, mutations)
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrQueryCanceled is returned when the query is canceled before the end
+	// of its execution, the client having closed its connection for instance
+	ErrQueryCanceled = errors.New("Query canceled")
+)
+
+// Limits are the execution budgets of a query, 0 meaning no limit
+type Limits struct {
+	// Timeout is the maximum execution time of the query
+	Timeout time.Duration
+	// MaxTraversed is the maximum number of elements walked by the steps
+	MaxTraversed int64
+	// MaxResults is the maximum number of values returned by the query
+	MaxResults int64
+}
+
+// LimitError is returned when a query exceeds one of its execution limits
+type LimitError struct {
+	Limit string
+	Value interface{}
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("Query aborted as it exceeded the %s limit of %v", e.Limit, e.Value)
+}
+
+// budget tracks the resources consumed by the execution of a query
+type budget struct {
+	ctx       context.Context
+	limits    Limits
+	traversed int64
+}
+
+func (b *budget) consume(traversed int) error {
+	select {
+	case <-b.ctx.Done():
+		if b.ctx.Err() == context.DeadlineExceeded {
+			return &LimitError{Limit: "timeout", Value: b.limits.Timeout}
+		}
+		return ErrQueryCanceled
+	default:
+	}
+
+	total := atomic.AddInt64(&b.traversed, int64(traversed))
+	if b.limits.MaxTraversed > 0 && total > b.limits.MaxTraversed {
+		return &LimitError{Limit: "traversed elements", Value: b.limits.MaxTraversed}
+	}
+
+	return nil
+}
+
+// checkResults returns an error if the query returned too many values
+func (b *budget) checkResults(n int) error {
+	if b.limits.MaxResults > 0 && int64(n) > b.limits.MaxResults {
+		return &LimitError{Limit: "results", Value: b.limits.MaxResults}
+	}
+	return nil
+}
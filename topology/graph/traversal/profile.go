@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2018 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package traversal
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// StepProfiler is implemented by the results of the steps able to tell how
+// they were computed, like the query a flow step issued to the storage
+type StepProfiler interface {
+	ProfileInfo() map[string]interface{}
+}
+
+// StepPlan describes a step as executed, once the following steps it
+// optimizes, like a Has following a Flows step, merged into it
+type StepPlan struct {
+	Step   string
+	Merged []string `json:",omitempty"`
+}
+
+// StepProfile describes the execution of a step
+type StepProfile struct {
+	StepPlan
+	// Input is the number of values the step was applied to
+	Input int
+	// Output is the number of values returned by the step
+	Output int
+	// Duration is the time spent in the step, in milliseconds
+	Duration float64
+	Info     map[string]interface{} `json:",omitempty"`
+}
+
+// GraphTraversalProfile is the result of the Explain and Profile steps,
+// describing the steps preceding them
+type GraphTraversalProfile struct {
+	steps []interface{}
+}
+
+// Values returns the description of the steps
+func (p *GraphTraversalProfile) Values() []interface{} {
+	return p.steps
+}
+
+// MarshalJSON serialize in JSON
+func (p *GraphTraversalProfile) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.steps)
+}
+
+// Error returns traversal error
+func (p *GraphTraversalProfile) Error() error {
+	return nil
+}
+
+// stepName returns the name of a step, derived from its type name like
+// Out for GremlinTraversalStepOut or Flow for FlowGremlinTraversalStep
+func stepName(step GremlinTraversalStep) string {
+	t := reflect.TypeOf(step)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	name := strings.Replace(t.Name(), "GremlinTraversalStep", "", 1)
+	if name == "" {
+		return t.Name()
+	}
+	return name
+}
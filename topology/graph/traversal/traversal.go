@@ -23,6 +23,7 @@
 package traversal
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -69,12 +70,14 @@ type GraphTraversal struct {
 	error     error
 	lockGraph bool
 	as        map[string]*GraphTraversalAs
+	budget    *budget
 }
 
 // GraphTraversalV traversal steps on nodes
 type GraphTraversalV struct {
 	GraphTraversal *GraphTraversal
 	nodes          []*graph.Node
+	paths          []*pathStep
 	error          error
 }
 
@@ -82,6 +85,7 @@ type GraphTraversalV struct {
 type GraphTraversalE struct {
 	GraphTraversal *GraphTraversal
 	edges          []*graph.Edge
+	paths          []*pathStep
 	error          error
 }
 
@@ -92,6 +96,14 @@ type GraphTraversalShortestPath struct {
 	error          error
 }
 
+// GraphTraversalPath traversal step path, the nodes and edges each
+// traverser went through
+type GraphTraversalPath struct {
+	GraphTraversal *GraphTraversal
+	paths          [][]interface{}
+	error          error
+}
+
 // GraphTraversalValue traversal step value
 type GraphTraversalValue struct {
 	GraphTraversal *GraphTraversal
@@ -105,6 +117,33 @@ type GraphTraversalAs struct {
 	nodes          []*graph.Node
 }
 
+// pathStep is a step of the path followed by a traverser, linked to the
+// previous one so that traversers coming from the same element share the
+// beginning of their path
+type pathStep struct {
+	value    interface{}
+	previous *pathStep
+}
+
+func (p *pathStep) extend(value interface{}) *pathStep {
+	return &pathStep{value: value, previous: p}
+}
+
+// values returns the elements of the path, from the first one
+func (p *pathStep) values() []interface{} {
+	n := 0
+	for step := p; step != nil; step = step.previous {
+		n++
+	}
+
+	values := make([]interface{}, n)
+	for step := p; step != nil; step = step.previous {
+		n--
+		values[n] = step.value
+	}
+	return values
+}
+
 // KeyValueToFilter creates a filter for a key with a fixed value or a predicate
 func KeyValueToFilter(k string, v interface{}) (*filters.Filter, error) {
 	switch v := v.(type) {
@@ -423,6 +462,33 @@ func NewGraphTraversal(g *graph.Graph, lockGraph bool) *GraphTraversal {
 	}
 }
 
+// fork returns a traversal on another graph sharing the execution budget
+// of the query, as done by the SubGraph steps
+func (t *GraphTraversal) fork(g *graph.Graph) *GraphTraversal {
+	ngt := NewGraphTraversal(g, t.lockGraph)
+	ngt.budget = t.budget
+	return ngt
+}
+
+// Limits returns the execution limits of the query
+func (t *GraphTraversal) Limits() Limits {
+	if t.budget == nil {
+		return Limits{}
+	}
+	return t.budget.limits
+}
+
+// CheckLimits accounts for the given number of traversed elements then
+// returns an error if the query exceeded one of its execution limits or was
+// canceled. Steps walking the graph call it for each of their traversers so
+// that the graph lock is released as soon as possible.
+func (t *GraphTraversal) CheckLimits(traversed int) error {
+	if t.budget == nil {
+		return nil
+	}
+	return t.budget.consume(traversed)
+}
+
 // RLock reads lock the graph
 func (t *GraphTraversal) RLock() {
 	if t.lockGraph {
@@ -437,6 +503,20 @@ func (t *GraphTraversal) RUnlock() {
 	}
 }
 
+// Lock locks the graph for writing
+func (t *GraphTraversal) Lock() {
+	if t.lockGraph {
+		t.Graph.Lock()
+	}
+}
+
+// Unlock unlocks the graph for writing
+func (t *GraphTraversal) Unlock() {
+	if t.lockGraph {
+		t.Graph.Unlock()
+	}
+}
+
 // Values returns the graph values
 func (t *GraphTraversal) Values() []interface{} {
 	t.RLock()
@@ -507,7 +587,7 @@ func (t *GraphTraversal) Context(s ...interface{}) *GraphTraversal {
 		return &GraphTraversal{error: err}
 	}
 
-	return &GraphTraversal{Graph: g}
+	return &GraphTraversal{Graph: g, budget: t.budget}
 }
 
 // V step : [node ID]
@@ -556,6 +636,10 @@ func (t *GraphTraversal) V(ctx StepContext, s ...interface{}) *GraphTraversalV {
 		nodes = nodeRange
 	}
 
+	if err := t.CheckLimits(len(nodes)); err != nil {
+		return &GraphTraversalV{error: err}
+	}
+
 	return &GraphTraversalV{GraphTraversal: t, nodes: nodes}
 }
 
@@ -602,6 +686,23 @@ func (tv *GraphTraversalV) GetNodes() (nodes []*graph.Node) {
 	return tv.nodes
 }
 
+// path returns the path of the i-th node, nodes not reached by a previous
+// step starting their own path
+func (tv *GraphTraversalV) path(i int) *pathStep {
+	if i < len(tv.paths) {
+		return tv.paths[i]
+	}
+	return &pathStep{value: tv.nodes[i]}
+}
+
+// keep adds the i-th node of the given step along with its path
+func (tv *GraphTraversalV) keep(from *GraphTraversalV, i int) {
+	tv.nodes = append(tv.nodes, from.nodes[i])
+	if from.paths != nil {
+		tv.paths = append(tv.paths, from.paths[i])
+	}
+}
+
 // PropertyValues returns at this step, the values of each metadata selected by the first key
 func (tv *GraphTraversalV) PropertyValues(ctx StepContext, k ...interface{}) *GraphTraversalValue {
 	if tv.error != nil {
@@ -787,6 +888,10 @@ func (t *GraphTraversal) E(ctx StepContext, s ...interface{}) *GraphTraversalE {
 		edges = edgeRange
 	}
 
+	if err := t.CheckLimits(len(edges)); err != nil {
+		return &GraphTraversalE{error: err}
+	}
+
 	return &GraphTraversalE{GraphTraversal: t, edges: edges}
 }
 
@@ -833,6 +938,23 @@ func (te *GraphTraversalE) G() *GraphTraversal {
 	return te.GraphTraversal
 }
 
+// path returns the path of the i-th edge, edges not reached by a previous
+// step starting their own path
+func (te *GraphTraversalE) path(i int) *pathStep {
+	if i < len(te.paths) {
+		return te.paths[i]
+	}
+	return &pathStep{value: te.edges[i]}
+}
+
+// keep adds the i-th edge of the given step along with its path
+func (te *GraphTraversalE) keep(from *GraphTraversalE, i int) {
+	te.edges = append(te.edges, from.edges[i])
+	if from.paths != nil {
+		te.paths = append(te.paths, from.paths[i])
+	}
+}
+
 // ParseSortParameter helper
 func ParseSortParameter(keys ...interface{}) (order common.SortOrder, sortBy string, err error) {
 	order = common.SortAscending
@@ -869,8 +991,23 @@ func (tv *GraphTraversalV) Sort(ctx StepContext, keys ...interface{}) *GraphTrav
 		sortBy = defaultSortBy
 	}
 
+	if tv.paths == nil {
+		graph.SortNodes(tv.nodes, sortBy, sortOrder)
+		return tv
+	}
+
+	// the paths end with their node, reorder them the same way
+	paths := make(map[*graph.Node][]*pathStep)
+	for i, n := range tv.nodes {
+		paths[n] = append(paths[n], tv.paths[i])
+	}
+
 	graph.SortNodes(tv.nodes, sortBy, sortOrder)
 
+	for i, n := range tv.nodes {
+		tv.paths[i], paths[n] = paths[n][0], paths[n][1:]
+	}
+
 	return tv
 }
 
@@ -902,7 +1039,7 @@ func (tv *GraphTraversalV) Dedup(ctx StepContext, s ...interface{}) *GraphTraver
 	defer tv.GraphTraversal.RUnlock()
 
 nodeLoop:
-	for _, n := range tv.nodes {
+	for i, n := range tv.nodes {
 		if it.Done() {
 			break
 		}
@@ -931,7 +1068,7 @@ nodeLoop:
 			continue
 		}
 
-		ntv.nodes = append(ntv.nodes, n)
+		ntv.keep(tv, i)
 		if !skip {
 			visited[kvisited] = true
 		}
@@ -973,6 +1110,52 @@ func (sp *GraphTraversalShortestPath) GetNodes() []*graph.Node {
 	return nodes
 }
 
+// Values returns the graph values
+func (tp *GraphTraversalPath) Values() []interface{} {
+	tp.GraphTraversal.RLock()
+	defer tp.GraphTraversal.RUnlock()
+
+	s := make([]interface{}, len(tp.paths))
+	for i, p := range tp.paths {
+		s[i] = p
+	}
+	return s
+}
+
+// MarshalJSON serialize in JSON
+func (tp *GraphTraversalPath) MarshalJSON() ([]byte, error) {
+	values := tp.Values()
+	tp.GraphTraversal.RLock()
+	defer tp.GraphTraversal.RUnlock()
+	return json.Marshal(values)
+}
+
+func (tp *GraphTraversalPath) Error() error {
+	return tp.error
+}
+
+// GetNodes returns the nodes of all the paths, so that it can be used to find flows
+func (tp *GraphTraversalPath) GetNodes() []*graph.Node {
+	var nodes []*graph.Node
+	for _, p := range tp.paths {
+		for _, v := range p {
+			if n, ok := v.(*graph.Node); ok {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	return nodes
+}
+
+// Count step
+func (tp *GraphTraversalPath) Count(ctx StepContext, s ...interface{}) *GraphTraversalValue {
+	if tp.error != nil {
+		return NewGraphTraversalValueFromError(tp.error)
+	}
+
+	return NewGraphTraversalValue(tp.GraphTraversal, len(tp.paths))
+}
+
 // ShortestPathTo step
 func (tv *GraphTraversalV) ShortestPathTo(ctx StepContext, m graph.Metadata, e graph.Metadata) *GraphTraversalShortestPath {
 	if tv.error != nil {
@@ -986,6 +1169,10 @@ func (tv *GraphTraversalV) ShortestPathTo(ctx StepContext, m graph.Metadata, e g
 
 	visited := make(map[graph.Identifier]bool)
 	for _, n := range tv.nodes {
+		if err := tv.GraphTraversal.CheckLimits(1); err != nil {
+			return &GraphTraversalShortestPath{error: err}
+		}
+
 		if _, ok := visited[n.ID]; !ok {
 			path := tv.GraphTraversal.Graph.LookupShortestPath(n, m, e)
 			if len(path) > 0 {
@@ -996,6 +1183,507 @@ func (tv *GraphTraversalV) ShortestPathTo(ctx StepContext, m graph.Metadata, e g
 	return sp
 }
 
+// TraversalFunc applies a traversal to the result of a step, as done with
+// the traversals given as parameter of steps like Repeat or Where
+type TraversalFunc func(step GraphTraversalStep) (GraphTraversalStep, error)
+
+// RepeatOptions describes when the traversers of a Repeat step stop and
+// which of them are part of its result
+type RepeatOptions struct {
+	// Until stops, and returns, the traversers matching this traversal
+	Until TraversalFunc
+	// Times is the maximum number of loops, 0 meaning no limit
+	Times int64
+	// Emit returns the traversers of every loop, not only the last ones
+	Emit bool
+	// EmitIf restricts the emitted traversers to the ones matching it
+	EmitIf TraversalFunc
+}
+
+// By describes how a value of the Project and GroupCount steps is computed
+// for an element, either from one of its fields or from a traversal
+type By struct {
+	Key       string
+	Traversal TraversalFunc
+}
+
+// value returns the value of the element, nil if the field is not set or if
+// the traversal gives no result. A traversal giving several results returns
+// all of them.
+func (b By) value(gt *GraphTraversal, element filters.Getter, traverser GraphTraversalStep) (interface{}, error) {
+	if b.Traversal == nil {
+		gt.RLock()
+		value, err := element.GetField(b.Key)
+		gt.RUnlock()
+
+		if err == common.ErrFieldNotFound {
+			return nil, nil
+		}
+		return value, err
+	}
+
+	step, err := b.Traversal(traverser)
+	if err != nil {
+		return nil, err
+	}
+
+	switch values := step.Values(); len(values) {
+	case 0:
+		return nil, nil
+	case 1:
+		return values[0], nil
+	default:
+		return values, nil
+	}
+}
+
+// Projection is a row returned by the Project step, serialized with the
+// keys in the order they were given to the step
+type Projection struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// Keys returns the keys of the projection
+func (p *Projection) Keys() []string {
+	return p.keys
+}
+
+// Get returns the value of the projection for the given key
+func (p *Projection) Get(key string) interface{} {
+	return p.values[key]
+}
+
+// MarshalJSON serialize in JSON
+func (p *Projection) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	for i, key := range p.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := json.Marshal(p.values[key])
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// elementFunc returns the i-th element of a step along with its traverser
+type elementFunc func(i int) (filters.Getter, GraphTraversalStep)
+
+// project returns a projection of each element, the value of a key being
+// computed by the By at the same position, or by the field of the same name
+// if there are less By than keys
+func project(ctx StepContext, gt *GraphTraversal, n int, element elementFunc, keys []string, by []By) *GraphTraversalValue {
+	projections := []interface{}{}
+	it := ctx.PaginationRange.Iterator()
+
+	for i := 0; i < n; i++ {
+		if it.Done() {
+			break
+		}
+
+		if !it.Next() {
+			continue
+		}
+
+		e, traverser := element(i)
+
+		p := &Projection{keys: keys, values: make(map[string]interface{})}
+		for j, key := range keys {
+			b := By{Key: key}
+			if j < len(by) {
+				b = by[j]
+			}
+
+			value, err := b.value(gt, e, traverser)
+			if err != nil {
+				return NewGraphTraversalValueFromError(err)
+			}
+			p.values[key] = value
+		}
+
+		projections = append(projections, p)
+	}
+
+	return NewGraphTraversalValue(gt, projections)
+}
+
+// groupCount counts the elements having the same values, one level of
+// nested groups being created per By. Elements are grouped by ID if no By
+// is given, elements without value for one of the By being ignored.
+func groupCount(gt *GraphTraversal, n int, element elementFunc, by []By) *GraphTraversalValue {
+	if len(by) == 0 {
+		by = []By{{Key: "ID"}}
+	}
+
+	counts := make(map[string]interface{})
+
+nextElement:
+	for i := 0; i < n; i++ {
+		e, traverser := element(i)
+
+		keys := make([]string, len(by))
+		for j, b := range by {
+			value, err := b.value(gt, e, traverser)
+			if err != nil {
+				return NewGraphTraversalValueFromError(err)
+			}
+
+			if value == nil {
+				continue nextElement
+			}
+			keys[j] = fmt.Sprintf("%v", value)
+		}
+
+		group := counts
+		for _, key := range keys[:len(keys)-1] {
+			sub, ok := group[key].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				group[key] = sub
+			}
+			group = sub
+		}
+
+		last := keys[len(keys)-1]
+		count, _ := group[last].(int64)
+		group[last] = count + 1
+	}
+
+	return NewGraphTraversalValue(gt, counts)
+}
+
+// traverser returns a step made of the traverser of the i-th node only
+func (tv *GraphTraversalV) traverser(i int) *GraphTraversalV {
+	return &GraphTraversalV{
+		GraphTraversal: tv.GraphTraversal,
+		nodes:          []*graph.Node{tv.nodes[i]},
+		paths:          []*pathStep{tv.path(i)},
+	}
+}
+
+func (tv *GraphTraversalV) element(i int) (filters.Getter, GraphTraversalStep) {
+	return tv.nodes[i], tv.traverser(i)
+}
+
+// matches returns whether the traversal gives a result when applied to
+// the traverser of the i-th node
+func (tv *GraphTraversalV) matches(f TraversalFunc, i int) (bool, error) {
+	step, err := f(tv.traverser(i))
+	if err != nil {
+		return false, err
+	}
+
+	return len(step.Values()) > 0, nil
+}
+
+// filter keeps the nodes for which the traversals given as parameter match
+func (tv *GraphTraversalV) filter(ctx StepContext, keep func(i int) (bool, error)) *GraphTraversalV {
+	if tv.error != nil {
+		return tv
+	}
+
+	ntv := &GraphTraversalV{GraphTraversal: tv.GraphTraversal, nodes: []*graph.Node{}}
+	it := ctx.PaginationRange.Iterator()
+
+	for i := range tv.nodes {
+		if it.Done() {
+			break
+		}
+
+		ok, err := keep(i)
+		if err != nil {
+			return &GraphTraversalV{error: err}
+		}
+
+		if ok && it.Next() {
+			ntv.keep(tv, i)
+		}
+	}
+
+	return ntv
+}
+
+// Where step : keeps the nodes for which the traversal gives a result
+func (tv *GraphTraversalV) Where(ctx StepContext, f TraversalFunc) *GraphTraversalV {
+	return tv.filter(ctx, func(i int) (bool, error) {
+		return tv.matches(f, i)
+	})
+}
+
+// Not step : keeps the nodes for which the traversal gives no result
+func (tv *GraphTraversalV) Not(ctx StepContext, f TraversalFunc) *GraphTraversalV {
+	return tv.filter(ctx, func(i int) (bool, error) {
+		found, err := tv.matches(f, i)
+		return !found, err
+	})
+}
+
+// Or step : keeps the nodes for which at least one of the traversals gives
+// a result
+func (tv *GraphTraversalV) Or(ctx StepContext, fs ...TraversalFunc) *GraphTraversalV {
+	return tv.filter(ctx, func(i int) (bool, error) {
+		for _, f := range fs {
+			if found, err := tv.matches(f, i); found || err != nil {
+				return found, err
+			}
+		}
+		return false, nil
+	})
+}
+
+// Project step : returns for each node a projection of the given keys, the
+// value of each key being computed by the By at the same position
+func (tv *GraphTraversalV) Project(ctx StepContext, keys []string, by []By) *GraphTraversalValue {
+	if tv.error != nil {
+		return NewGraphTraversalValueFromError(tv.error)
+	}
+
+	return project(ctx, tv.GraphTraversal, len(tv.nodes), tv.element, keys, by)
+}
+
+// GroupCount step : returns the number of nodes per value of the By
+func (tv *GraphTraversalV) GroupCount(ctx StepContext, by []By) *GraphTraversalValue {
+	if tv.error != nil {
+		return NewGraphTraversalValueFromError(tv.error)
+	}
+
+	return groupCount(tv.GraphTraversal, len(tv.nodes), tv.element, by)
+}
+
+// walks returns whether the node was already reached by the traverser since
+// it entered the loop started at one of the given steps
+func (p *pathStep) walks(node *graph.Node, starts map[*pathStep]bool) bool {
+	for step := p; step != nil; step = step.previous {
+		if step.value == node {
+			return true
+		}
+		if starts[step] {
+			break
+		}
+	}
+	return false
+}
+
+// Repeat step : applies the loop traversal to each traverser until it matches
+// the Until traversal or went through the given number of loops, the
+// traversers still looping at this point being returned. A traverser going
+// back to a node of its own path is dropped so that the loop ends on cyclic
+// topologies.
+func (tv *GraphTraversalV) Repeat(ctx StepContext, loop TraversalFunc, options RepeatOptions) *GraphTraversalV {
+	if tv.error != nil {
+		return tv
+	}
+
+	ntv := &GraphTraversalV{GraphTraversal: tv.GraphTraversal, nodes: []*graph.Node{}}
+
+	// the paths of the traversers entering the loop mark where to stop
+	// when looking for cycles
+	current := &GraphTraversalV{GraphTraversal: tv.GraphTraversal, nodes: []*graph.Node{}}
+	starts := make(map[*pathStep]bool, len(tv.nodes))
+	for i, n := range tv.nodes {
+		path := tv.path(i)
+		starts[path] = true
+		current.nodes = append(current.nodes, n)
+		current.paths = append(current.paths, path)
+	}
+
+	for loops := int64(1); len(current.nodes) > 0; loops++ {
+		step, err := loop(current)
+		if err != nil {
+			return &GraphTraversalV{error: err}
+		}
+
+		next, ok := step.(*GraphTraversalV)
+		if !ok {
+			return &GraphTraversalV{error: errors.New("Repeat traversal has to return nodes")}
+		}
+
+		last := options.Times > 0 && loops >= options.Times
+
+		walking := make(map[*pathStep]bool, len(current.paths))
+		for _, path := range current.paths {
+			walking[path] = true
+		}
+
+		remaining := &GraphTraversalV{GraphTraversal: tv.GraphTraversal, nodes: []*graph.Node{}}
+		for i, n := range next.nodes {
+			// a traverser not moving or going back to a node it already
+			// reached would loop forever
+			path := next.path(i)
+			if walking[path] || path.previous.walks(n, starts) {
+				continue
+			}
+
+			if options.Until != nil {
+				found, err := next.matches(options.Until, i)
+				if err != nil {
+					return &GraphTraversalV{error: err}
+				}
+
+				if found {
+					ntv.nodes = append(ntv.nodes, n)
+					ntv.paths = append(ntv.paths, path)
+					continue
+				}
+			}
+
+			// out of loops, the traverser leaves the repeat step
+			if last {
+				ntv.nodes = append(ntv.nodes, n)
+				ntv.paths = append(ntv.paths, path)
+				continue
+			}
+
+			if options.Emit {
+				emit := true
+				if options.EmitIf != nil {
+					if emit, err = next.matches(options.EmitIf, i); err != nil {
+						return &GraphTraversalV{error: err}
+					}
+				}
+
+				if emit {
+					ntv.nodes = append(ntv.nodes, n)
+					ntv.paths = append(ntv.paths, path)
+				}
+			}
+
+			remaining.nodes = append(remaining.nodes, n)
+			remaining.paths = append(remaining.paths, path)
+		}
+
+		current = remaining
+	}
+
+	if ctx.PaginationRange != nil {
+		return ntv.Range(StepContext{}, ctx.PaginationRange[0], ctx.PaginationRange[1])
+	}
+
+	return ntv
+}
+
+// Path step : returns for each node the path its traverser went through,
+// edges being part of it when walked with the OutE/InE/BothE steps
+func (tv *GraphTraversalV) Path(ctx StepContext, s ...interface{}) *GraphTraversalPath {
+	if tv.error != nil {
+		return &GraphTraversalPath{error: tv.error}
+	}
+
+	tp := &GraphTraversalPath{GraphTraversal: tv.GraphTraversal, paths: [][]interface{}{}}
+	it := ctx.PaginationRange.Iterator()
+
+	for i := range tv.nodes {
+		if it.Done() {
+			break
+		} else if it.Next() {
+			tp.paths = append(tp.paths, tv.path(i).values())
+		}
+	}
+
+	return tp
+}
+
+// AllPathsTo step : returns all the paths of at most maxDepth hops from the
+// nodes to the ones matching the metadata, walking through the edges
+// matching the optional edge metadata
+func (tv *GraphTraversalV) AllPathsTo(ctx StepContext, m graph.Metadata, maxDepth int64, e graph.Metadata) *GraphTraversalShortestPath {
+	if tv.error != nil {
+		return &GraphTraversalShortestPath{error: tv.error}
+	}
+
+	sp := &GraphTraversalShortestPath{GraphTraversal: tv.GraphTraversal, paths: [][]*graph.Node{}}
+	it := ctx.PaginationRange.Iterator()
+
+	var em graph.ElementMatcher
+	if e != nil {
+		em = e
+	}
+
+	walked := func(n *graph.Node) error {
+		return tv.GraphTraversal.CheckLimits(1)
+	}
+
+	tv.GraphTraversal.RLock()
+	defer tv.GraphTraversal.RUnlock()
+
+nodeloop:
+	for _, n := range tv.nodes {
+		paths, err := tv.GraphTraversal.Graph.LookupAllPaths(n, m, em, int(maxDepth), walked)
+		if err != nil {
+			return &GraphTraversalShortestPath{error: err}
+		}
+
+		for _, path := range paths {
+			if it.Done() {
+				break nodeloop
+			} else if it.Next() {
+				sp.paths = append(sp.paths, path)
+			}
+		}
+	}
+
+	return sp
+}
+
+// KShortestPathsTo step : returns the k paths of lowest cost from the nodes
+// to the ones matching the metadata, the cost of an edge being the value of
+// its weightKey metadata, 1 if not set or if no weight key is given
+func (tv *GraphTraversalV) KShortestPathsTo(ctx StepContext, m graph.Metadata, k int64, weightKey string, e graph.Metadata) *GraphTraversalShortestPath {
+	if tv.error != nil {
+		return &GraphTraversalShortestPath{error: tv.error}
+	}
+
+	sp := &GraphTraversalShortestPath{GraphTraversal: tv.GraphTraversal, paths: [][]*graph.Node{}}
+	it := ctx.PaginationRange.Iterator()
+
+	var em graph.ElementMatcher
+	if e != nil {
+		em = e
+	}
+
+	walked := func(n *graph.Node) error {
+		return tv.GraphTraversal.CheckLimits(1)
+	}
+
+	tv.GraphTraversal.RLock()
+	defer tv.GraphTraversal.RUnlock()
+
+nodeloop:
+	for _, n := range tv.nodes {
+		paths, err := tv.GraphTraversal.Graph.LookupKShortestPaths(n, m, em, int(k), weightKey, walked)
+		if err != nil {
+			return &GraphTraversalShortestPath{error: err}
+		}
+
+		for _, path := range paths {
+			if it.Done() {
+				break nodeloop
+			} else if it.Next() {
+				sp.paths = append(sp.paths, path)
+			}
+		}
+	}
+
+	return sp
+}
+
 // has apply either and or or filter
 func (tv *GraphTraversalV) has(filterOp filters.BoolFilterOp, ctx StepContext, s ...interface{}) *GraphTraversalV {
 	if tv.error != nil {
@@ -1026,12 +1714,12 @@ func (tv *GraphTraversalV) has(filterOp filters.BoolFilterOp, ctx StepContext, s
 	tv.GraphTraversal.RLock()
 	defer tv.GraphTraversal.RUnlock()
 
-	for _, n := range tv.nodes {
+	for i, n := range tv.nodes {
 		if it.Done() {
 			break
 		}
 		if (filter == nil || filter.Eval(n)) && it.Next() {
-			ntv.nodes = append(ntv.nodes, n)
+			ntv.keep(tv, i)
 		}
 	}
 
@@ -1066,12 +1754,12 @@ func (tv *GraphTraversalV) HasNot(ctx StepContext, s string) *GraphTraversalV {
 	tv.GraphTraversal.RLock()
 	defer tv.GraphTraversal.RUnlock()
 
-	for _, n := range tv.nodes {
+	for i, n := range tv.nodes {
 		if it.Done() {
 			break
 		}
 		if (filter == nil || filter.Eval(n)) && it.Next() {
-			ntv.nodes = append(ntv.nodes, n)
+			ntv.keep(tv, i)
 		}
 	}
 
@@ -1096,7 +1784,12 @@ func (tv *GraphTraversalV) Both(ctx StepContext, s ...interface{}) *GraphTravers
 	defer tv.GraphTraversal.RUnlock()
 
 nodeloop:
-	for _, n := range tv.nodes {
+	for i, n := range tv.nodes {
+		if err := tv.GraphTraversal.CheckLimits(1); err != nil {
+			return &GraphTraversalV{error: err}
+		}
+
+		path := tv.path(i)
 		for _, e := range tv.GraphTraversal.Graph.GetNodeEdges(n, nil) {
 			var nodes []*graph.Node
 			if e.GetChild() == n.ID {
@@ -1110,6 +1803,7 @@ nodeloop:
 					break nodeloop
 				} else if it.Next() {
 					ntv.nodes = append(ntv.nodes, node)
+					ntv.paths = append(ntv.paths, path.extend(node))
 				}
 			}
 		}
@@ -1118,6 +1812,41 @@ nodeloop:
 	return ntv
 }
 
+// Property step, sets the metadata key to the given value on every node
+func (tv *GraphTraversalV) Property(ctx StepContext, key string, value interface{}) *GraphTraversalV {
+	if tv.error != nil {
+		return tv
+	}
+
+	tv.GraphTraversal.Lock()
+	defer tv.GraphTraversal.Unlock()
+
+	for _, node := range tv.nodes {
+		tv.GraphTraversal.Graph.AddMetadata(node, key, value)
+	}
+
+	return tv
+}
+
+// Drop step, removes the nodes and their edges from the graph
+func (tv *GraphTraversalV) Drop(ctx StepContext) *GraphTraversalV {
+	if tv.error != nil {
+		return tv
+	}
+
+	tv.GraphTraversal.Lock()
+	defer tv.GraphTraversal.Unlock()
+
+	for _, node := range tv.nodes {
+		// the node may have been removed since it was selected
+		if tv.GraphTraversal.Graph.GetNode(node.ID) != nil {
+			tv.GraphTraversal.Graph.DelNode(node)
+		}
+	}
+
+	return &GraphTraversalV{GraphTraversal: tv.GraphTraversal, nodes: []*graph.Node{}}
+}
+
 // Count step
 func (tv *GraphTraversalV) Count(ctx StepContext, s ...interface{}) *GraphTraversalValue {
 	if tv.error != nil {
@@ -1145,11 +1874,11 @@ func (tv *GraphTraversalV) Range(ctx StepContext, s ...interface{}) *GraphTraver
 		if !ok {
 			return &GraphTraversalV{error: fmt.Errorf("%s is not an integer", s[1])}
 		}
-		var nodes []*graph.Node
+		ntv := &GraphTraversalV{GraphTraversal: tv.GraphTraversal}
 		for ; from < int64(len(tv.nodes)) && from < to; from++ {
-			nodes = append(nodes, tv.nodes[from])
+			ntv.keep(tv, int(from))
 		}
-		return &GraphTraversalV{GraphTraversal: tv.GraphTraversal, nodes: nodes}
+		return ntv
 	}
 
 	return &GraphTraversalV{error: errors.New("2 parameters must be provided to 'range'")}
@@ -1178,12 +1907,18 @@ func (tv *GraphTraversalV) Out(ctx StepContext, s ...interface{}) *GraphTraversa
 	defer tv.GraphTraversal.RUnlock()
 
 nodeloop:
-	for _, n := range tv.nodes {
+	for i, n := range tv.nodes {
+		if err := tv.GraphTraversal.CheckLimits(1); err != nil {
+			return &GraphTraversalV{error: err}
+		}
+
+		path := tv.path(i)
 		for _, child := range tv.GraphTraversal.Graph.LookupChildren(n, metadata, nil) {
 			if it.Done() {
 				break nodeloop
 			} else if it.Next() {
 				ntv.nodes = append(ntv.nodes, child)
+				ntv.paths = append(ntv.paths, path.extend(child))
 			}
 		}
 	}
@@ -1209,13 +1944,19 @@ func (tv *GraphTraversalV) OutE(ctx StepContext, s ...interface{}) *GraphTravers
 	defer tv.GraphTraversal.RUnlock()
 
 nodeloop:
-	for _, n := range tv.nodes {
+	for i, n := range tv.nodes {
+		if err := tv.GraphTraversal.CheckLimits(1); err != nil {
+			return &GraphTraversalE{error: err}
+		}
+
+		path := tv.path(i)
 		for _, e := range tv.GraphTraversal.Graph.GetNodeEdges(n, metadata) {
 			if e.GetParent() == n.ID {
 				if it.Done() {
 					break nodeloop
 				} else if it.Next() {
 					nte.edges = append(nte.edges, e)
+					nte.paths = append(nte.paths, path.extend(e))
 				}
 			}
 		}
@@ -1242,12 +1983,18 @@ func (tv *GraphTraversalV) BothE(ctx StepContext, s ...interface{}) *GraphTraver
 	defer tv.GraphTraversal.RUnlock()
 
 nodeloop:
-	for _, n := range tv.nodes {
+	for i, n := range tv.nodes {
+		if err := tv.GraphTraversal.CheckLimits(1); err != nil {
+			return &GraphTraversalE{error: err}
+		}
+
+		path := tv.path(i)
 		for _, e := range tv.GraphTraversal.Graph.GetNodeEdges(n, metadata) {
 			if it.Done() {
 				break nodeloop
 			} else if it.Next() {
 				nte.edges = append(nte.edges, e)
+				nte.paths = append(nte.paths, path.extend(e))
 			}
 		}
 	}
@@ -1273,12 +2020,18 @@ func (tv *GraphTraversalV) In(ctx StepContext, s ...interface{}) *GraphTraversal
 	defer tv.GraphTraversal.RUnlock()
 
 nodeloop:
-	for _, n := range tv.nodes {
+	for i, n := range tv.nodes {
+		if err := tv.GraphTraversal.CheckLimits(1); err != nil {
+			return &GraphTraversalV{error: err}
+		}
+
+		path := tv.path(i)
 		for _, parent := range tv.GraphTraversal.Graph.LookupParents(n, metadata, nil) {
 			if it.Done() {
 				break nodeloop
 			} else if it.Next() {
 				ntv.nodes = append(ntv.nodes, parent)
+				ntv.paths = append(ntv.paths, path.extend(parent))
 			}
 		}
 	}
@@ -1304,13 +2057,19 @@ func (tv *GraphTraversalV) InE(ctx StepContext, s ...interface{}) *GraphTraversa
 	defer tv.GraphTraversal.RUnlock()
 
 nodeloop:
-	for _, n := range tv.nodes {
+	for i, n := range tv.nodes {
+		if err := tv.GraphTraversal.CheckLimits(1); err != nil {
+			return &GraphTraversalE{error: err}
+		}
+
+		path := tv.path(i)
 		for _, e := range tv.GraphTraversal.Graph.GetNodeEdges(n, metadata) {
 			if e.GetChild() == n.ID {
 				if it.Done() {
 					break nodeloop
 				} else if it.Next() {
 					nte.edges = append(nte.edges, e)
+					nte.paths = append(nte.paths, path.extend(e))
 				}
 			}
 		}
@@ -1351,7 +2110,7 @@ func (tv *GraphTraversalV) SubGraph(ctx StepContext, s ...interface{}) *GraphTra
 
 	ng := graph.NewGraph(tv.GraphTraversal.Graph.GetHost(), memory, common.UnknownService)
 
-	return NewGraphTraversal(ng, tv.GraphTraversal.lockGraph)
+	return tv.GraphTraversal.fork(ng)
 }
 
 // SubGraph step, node/edge out
@@ -1387,7 +2146,7 @@ func (sp *GraphTraversalShortestPath) SubGraph(ctx StepContext, s ...interface{}
 
 	ng := graph.NewGraph(sp.GraphTraversal.Graph.GetHost(), memory, common.UnknownService)
 
-	return NewGraphTraversal(ng, sp.GraphTraversal.lockGraph)
+	return sp.GraphTraversal.fork(ng)
 }
 
 // Count step
@@ -1415,11 +2174,11 @@ func (te *GraphTraversalE) Range(ctx StepContext, s ...interface{}) *GraphTraver
 		if !ok {
 			return &GraphTraversalE{error: fmt.Errorf("%s is not an integer", s[1])}
 		}
-		var edges []*graph.Edge
+		nte := &GraphTraversalE{GraphTraversal: te.GraphTraversal}
 		for ; from < int64(len(te.edges)) && from < to; from++ {
-			edges = append(edges, te.edges[from])
+			nte.keep(te, int(from))
 		}
-		return &GraphTraversalE{GraphTraversal: te.GraphTraversal, edges: edges}
+		return nte
 
 	default:
 		return &GraphTraversalE{GraphTraversal: te.GraphTraversal, error: errors.New("2 parameters must be provided to 'range'")}
@@ -1457,7 +2216,7 @@ func (te *GraphTraversalE) Dedup(ctx StepContext, keys ...interface{}) *GraphTra
 	te.GraphTraversal.RLock()
 	defer te.GraphTraversal.RUnlock()
 
-	for _, e := range te.edges {
+	for i, e := range te.edges {
 		kvisited = e.ID
 		if key != "" {
 			if v, ok := e.Metadata()[key]; ok {
@@ -1466,7 +2225,7 @@ func (te *GraphTraversalE) Dedup(ctx StepContext, keys ...interface{}) *GraphTra
 		}
 
 		if _, ok := visited[kvisited]; !ok {
-			ntv.edges = append(ntv.edges, e)
+			ntv.keep(te, i)
 			visited[kvisited] = true
 		}
 	}
@@ -1502,12 +2261,12 @@ func (te *GraphTraversalE) has(filterOp filters.BoolFilterOp, ctx StepContext, s
 	te.GraphTraversal.RLock()
 	defer te.GraphTraversal.RUnlock()
 
-	for _, e := range te.edges {
+	for i, e := range te.edges {
 		if it.Done() {
 			break
 		}
 		if (filter == nil || filter.Eval(e)) && it.Next() {
-			nte.edges = append(nte.edges, e)
+			nte.keep(te, i)
 		}
 	}
 
@@ -1542,18 +2301,136 @@ func (te *GraphTraversalE) HasNot(ctx StepContext, s string) *GraphTraversalE {
 	te.GraphTraversal.RLock()
 	defer te.GraphTraversal.RUnlock()
 
-	for _, e := range te.edges {
+	for i, e := range te.edges {
 		if it.Done() {
 			break
 		}
 		if (filter == nil || filter.Eval(e)) && it.Next() {
-			nte.edges = append(nte.edges, e)
+			nte.keep(te, i)
 		}
 	}
 
 	return nte
 }
 
+// traverser returns a step made of the traverser of the i-th edge only
+func (te *GraphTraversalE) traverser(i int) *GraphTraversalE {
+	return &GraphTraversalE{
+		GraphTraversal: te.GraphTraversal,
+		edges:          []*graph.Edge{te.edges[i]},
+		paths:          []*pathStep{te.path(i)},
+	}
+}
+
+func (te *GraphTraversalE) element(i int) (filters.Getter, GraphTraversalStep) {
+	return te.edges[i], te.traverser(i)
+}
+
+// matches returns whether the traversal gives a result when applied to
+// the traverser of the i-th edge
+func (te *GraphTraversalE) matches(f TraversalFunc, i int) (bool, error) {
+	step, err := f(te.traverser(i))
+	if err != nil {
+		return false, err
+	}
+
+	return len(step.Values()) > 0, nil
+}
+
+// filter keeps the edges for which the traversals given as parameter match
+func (te *GraphTraversalE) filter(ctx StepContext, keep func(i int) (bool, error)) *GraphTraversalE {
+	if te.error != nil {
+		return te
+	}
+
+	nte := &GraphTraversalE{GraphTraversal: te.GraphTraversal, edges: []*graph.Edge{}}
+	it := ctx.PaginationRange.Iterator()
+
+	for i := range te.edges {
+		if it.Done() {
+			break
+		}
+
+		ok, err := keep(i)
+		if err != nil {
+			return &GraphTraversalE{error: err}
+		}
+
+		if ok && it.Next() {
+			nte.keep(te, i)
+		}
+	}
+
+	return nte
+}
+
+// Where step : keeps the edges for which the traversal gives a result
+func (te *GraphTraversalE) Where(ctx StepContext, f TraversalFunc) *GraphTraversalE {
+	return te.filter(ctx, func(i int) (bool, error) {
+		return te.matches(f, i)
+	})
+}
+
+// Not step : keeps the edges for which the traversal gives no result
+func (te *GraphTraversalE) Not(ctx StepContext, f TraversalFunc) *GraphTraversalE {
+	return te.filter(ctx, func(i int) (bool, error) {
+		found, err := te.matches(f, i)
+		return !found, err
+	})
+}
+
+// Or step : keeps the edges for which at least one of the traversals gives
+// a result
+func (te *GraphTraversalE) Or(ctx StepContext, fs ...TraversalFunc) *GraphTraversalE {
+	return te.filter(ctx, func(i int) (bool, error) {
+		for _, f := range fs {
+			if found, err := te.matches(f, i); found || err != nil {
+				return found, err
+			}
+		}
+		return false, nil
+	})
+}
+
+// Project step : returns for each edge a projection of the given keys, the
+// value of each key being computed by the By at the same position
+func (te *GraphTraversalE) Project(ctx StepContext, keys []string, by []By) *GraphTraversalValue {
+	if te.error != nil {
+		return NewGraphTraversalValueFromError(te.error)
+	}
+
+	return project(ctx, te.GraphTraversal, len(te.edges), te.element, keys, by)
+}
+
+// GroupCount step : returns the number of edges per value of the By
+func (te *GraphTraversalE) GroupCount(ctx StepContext, by []By) *GraphTraversalValue {
+	if te.error != nil {
+		return NewGraphTraversalValueFromError(te.error)
+	}
+
+	return groupCount(te.GraphTraversal, len(te.edges), te.element, by)
+}
+
+// Path step : returns for each edge the path its traverser went through
+func (te *GraphTraversalE) Path(ctx StepContext, s ...interface{}) *GraphTraversalPath {
+	if te.error != nil {
+		return &GraphTraversalPath{error: te.error}
+	}
+
+	tp := &GraphTraversalPath{GraphTraversal: te.GraphTraversal, paths: [][]interface{}{}}
+	it := ctx.PaginationRange.Iterator()
+
+	for i := range te.edges {
+		if it.Done() {
+			break
+		} else if it.Next() {
+			tp.paths = append(tp.paths, te.path(i).values())
+		}
+	}
+
+	return tp
+}
+
 // InV step, node in
 func (te *GraphTraversalE) InV(ctx StepContext, s ...interface{}) *GraphTraversalV {
 	if te.error != nil {
@@ -1571,13 +2448,19 @@ func (te *GraphTraversalE) InV(ctx StepContext, s ...interface{}) *GraphTraversa
 	te.GraphTraversal.RLock()
 	defer te.GraphTraversal.RUnlock()
 
-	for _, e := range te.edges {
+	for i, e := range te.edges {
+		if err := te.GraphTraversal.CheckLimits(1); err != nil {
+			return &GraphTraversalV{error: err}
+		}
+
+		path := te.path(i)
 		parents, _ := te.GraphTraversal.Graph.GetEdgeNodes(e, metadata, nil)
 		for _, parent := range parents {
 			if it.Done() {
 				break
 			} else if it.Next() {
 				ntv.nodes = append(ntv.nodes, parent)
+				ntv.paths = append(ntv.paths, path.extend(parent))
 			}
 		}
 	}
@@ -1602,13 +2485,19 @@ func (te *GraphTraversalE) OutV(ctx StepContext, s ...interface{}) *GraphTravers
 	te.GraphTraversal.RLock()
 	defer te.GraphTraversal.RUnlock()
 
-	for _, e := range te.edges {
+	for i, e := range te.edges {
+		if err := te.GraphTraversal.CheckLimits(1); err != nil {
+			return &GraphTraversalV{error: err}
+		}
+
+		path := te.path(i)
 		_, children := te.GraphTraversal.Graph.GetEdgeNodes(e, nil, metadata)
 		for _, child := range children {
 			if it.Done() {
 				break
 			} else if it.Next() {
 				ntv.nodes = append(ntv.nodes, child)
+				ntv.paths = append(ntv.paths, path.extend(child))
 			}
 		}
 	}
@@ -1633,13 +2522,19 @@ func (te *GraphTraversalE) BothV(ctx StepContext, s ...interface{}) *GraphTraver
 	te.GraphTraversal.RLock()
 	defer te.GraphTraversal.RUnlock()
 
-	for _, e := range te.edges {
+	for i, e := range te.edges {
+		if err := te.GraphTraversal.CheckLimits(1); err != nil {
+			return &GraphTraversalV{error: err}
+		}
+
+		path := te.path(i)
 		parents, _ := te.GraphTraversal.Graph.GetEdgeNodes(e, metadata, nil)
 		for _, parent := range parents {
 			if it.Done() {
 				break
 			} else if it.Next() {
 				ntv.nodes = append(ntv.nodes, parent)
+				ntv.paths = append(ntv.paths, path.extend(parent))
 			}
 		}
 
@@ -1649,6 +2544,7 @@ func (te *GraphTraversalE) BothV(ctx StepContext, s ...interface{}) *GraphTraver
 				break
 			} else if it.Next() {
 				ntv.nodes = append(ntv.nodes, child)
+				ntv.paths = append(ntv.paths, path.extend(child))
 			}
 		}
 	}
@@ -1691,7 +2587,7 @@ func (te *GraphTraversalE) SubGraph(ctx StepContext, s ...interface{}) *GraphTra
 
 	ng := graph.NewGraph(te.GraphTraversal.Graph.GetHost(), memory, common.UnknownService)
 
-	return NewGraphTraversal(ng, te.GraphTraversal.lockGraph)
+	return te.GraphTraversal.fork(ng)
 }
 
 // NewGraphTraversalValue creates a new traversal value step
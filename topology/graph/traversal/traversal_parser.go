@@ -23,14 +23,18 @@
 package traversal
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/hashicorp/golang-lru"
+
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/topology/graph"
 )
@@ -40,6 +44,7 @@ type (
 	GremlinTraversalSequence struct {
 		GraphTraversal *GraphTraversal
 		steps          []GremlinTraversalStep
+		offsets        []int
 		extensions     []GremlinTraversalExtension
 	}
 
@@ -134,6 +139,14 @@ type (
 	GremlinTraversalStepShortestPathTo struct {
 		GremlinTraversalContext
 	}
+	// GremlinTraversalStepAllPathsTo step
+	GremlinTraversalStepAllPathsTo struct {
+		GremlinTraversalContext
+	}
+	// GremlinTraversalStepKShortestPathsTo step
+	GremlinTraversalStepKShortestPathsTo struct {
+		GremlinTraversalContext
+	}
 	// GremlinTraversalStepBoth step
 	GremlinTraversalStepBoth struct {
 		GremlinTraversalContext
@@ -174,6 +187,69 @@ type (
 	GremlinTraversalStepSelect struct {
 		GremlinTraversalContext
 	}
+	// GremlinTraversalStepPath step
+	GremlinTraversalStepPath struct {
+		GremlinTraversalContext
+	}
+	// GremlinTraversalStepRepeat step
+	GremlinTraversalStepRepeat struct {
+		GremlinTraversalContext
+		options RepeatOptions
+	}
+	// GremlinTraversalStepUntil step
+	GremlinTraversalStepUntil struct {
+		GremlinTraversalContext
+	}
+	// GremlinTraversalStepTimes step
+	GremlinTraversalStepTimes struct {
+		GremlinTraversalContext
+	}
+	// GremlinTraversalStepEmit step
+	GremlinTraversalStepEmit struct {
+		GremlinTraversalContext
+	}
+	// GremlinTraversalStepWhere step
+	GremlinTraversalStepWhere struct {
+		GremlinTraversalContext
+	}
+	// GremlinTraversalStepNot step
+	GremlinTraversalStepNot struct {
+		GremlinTraversalContext
+	}
+	// GremlinTraversalStepOr step
+	GremlinTraversalStepOr struct {
+		GremlinTraversalContext
+	}
+	// GremlinTraversalStepProject step
+	GremlinTraversalStepProject struct {
+		GremlinTraversalContext
+		by byModulators
+	}
+	// GremlinTraversalStepGroupCount step
+	GremlinTraversalStepGroupCount struct {
+		GremlinTraversalContext
+		by byModulators
+	}
+	// GremlinTraversalStepBy step
+	GremlinTraversalStepBy struct {
+		GremlinTraversalContext
+	}
+	// GremlinTraversalStepExplain step
+	GremlinTraversalStepExplain struct {
+		GremlinTraversalContext
+	}
+	// GremlinTraversalStepProfile step
+	GremlinTraversalStepProfile struct {
+		GremlinTraversalContext
+	}
+	// GremlinTraversalStepProperty step
+	GremlinTraversalStepProperty struct {
+		GremlinTraversalContext
+	}
+	// GremlinTraversalStepDrop step
+	GremlinTraversalStepDrop struct {
+		GremlinTraversalContext
+	}
 )
 
 var (
@@ -185,12 +261,11 @@ var (
 // The mechanism is based on Reduce and Exec steps
 type GremlinTraversalParser struct {
 	common.RWMutex
-	scanner *GremlinTraversalScanner
-	buf     struct {
-		tok Token
-		lit string
-		n   int
-	}
+	tokens     []scannedToken
+	pos        int
+	bindings   map[string]interface{}
+	mutations  bool
+	cache      *lru.Cache
 	extensions []GremlinTraversalExtension
 }
 
@@ -698,6 +773,58 @@ func (s *GremlinTraversalStepShortestPathTo) Reduce(next GremlinTraversalStep) (
 	return next, nil
 }
 
+// Exec AllPathsTo step
+func (s *GremlinTraversalStepAllPathsTo) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		var e graph.Metadata
+		if len(s.Params) > 2 {
+			e = s.Params[2].(graph.Metadata)
+		}
+		return last.(*GraphTraversalV).AllPathsTo(s.StepContext, s.Params[0].(graph.Metadata), s.Params[1].(int64), e), nil
+	}
+
+	return nil, ErrExecutionError
+}
+
+// Reduce AllPathsTo step
+func (s *GremlinTraversalStepAllPathsTo) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	if s.ReduceRange(next) {
+		return s, nil
+	}
+
+	return next, nil
+}
+
+// Exec KShortestPathsTo step
+func (s *GremlinTraversalStepKShortestPathsTo) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		var weightKey string
+		var e graph.Metadata
+		for _, param := range s.Params[2:] {
+			switch param := param.(type) {
+			case string:
+				weightKey = param
+			case graph.Metadata:
+				e = param
+			}
+		}
+		return last.(*GraphTraversalV).KShortestPathsTo(s.StepContext, s.Params[0].(graph.Metadata), s.Params[1].(int64), weightKey, e), nil
+	}
+
+	return nil, ErrExecutionError
+}
+
+// Reduce KShortestPathsTo step
+func (s *GremlinTraversalStepKShortestPathsTo) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	if s.ReduceRange(next) {
+		return s, nil
+	}
+
+	return next, nil
+}
+
 // Exec Both step
 func (s *GremlinTraversalStepBoth) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
 	switch last.(type) {
@@ -868,28 +995,474 @@ func (s *GremlinTraversalStepSelect) Reduce(next GremlinTraversalStep) (GremlinT
 	return next, nil
 }
 
+// Exec Path step
+func (s *GremlinTraversalStepPath) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).Path(s.StepContext, s.Params...), nil
+	case *GraphTraversalE:
+		return last.(*GraphTraversalE).Path(s.StepContext, s.Params...), nil
+	}
+
+	// fallback to reflection way
+	return invokeStepFnc(last, "Path", s)
+}
+
+// Reduce Path step
+func (s *GremlinTraversalStepPath) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	if s.ReduceRange(next) {
+		return s, nil
+	}
+
+	return next, nil
+}
+
+// Exec Repeat step
+func (s *GremlinTraversalStepRepeat) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		loop := s.Params[0].(*GremlinTraversalSequence)
+		return last.(*GraphTraversalV).Repeat(s.StepContext, loop.traversalFunc(), s.options), nil
+	}
+
+	return nil, ErrExecutionError
+}
+
+// Reduce Repeat step, the Until, Times and Emit steps modulating the loop
+func (s *GremlinTraversalStepRepeat) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	switch next := next.(type) {
+	case *GremlinTraversalStepUntil:
+		s.options.Until = next.Params[0].(*GremlinTraversalSequence).traversalFunc()
+		return s, nil
+	case *GremlinTraversalStepTimes:
+		s.options.Times = next.Params[0].(int64)
+		return s, nil
+	case *GremlinTraversalStepEmit:
+		s.options.Emit = true
+		if len(next.Params) > 0 {
+			s.options.EmitIf = next.Params[0].(*GremlinTraversalSequence).traversalFunc()
+		}
+		return s, nil
+	}
+
+	if s.ReduceRange(next) {
+		return s, nil
+	}
+
+	return next, nil
+}
+
+// Exec Until step
+func (s *GremlinTraversalStepUntil) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	return nil, errors.New("Until has to follow a Repeat step")
+}
+
+// Reduce Until step
+func (s *GremlinTraversalStepUntil) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Exec Times step
+func (s *GremlinTraversalStepTimes) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	return nil, errors.New("Times has to follow a Repeat step")
+}
+
+// Reduce Times step
+func (s *GremlinTraversalStepTimes) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Exec Emit step
+func (s *GremlinTraversalStepEmit) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	return nil, errors.New("Emit has to follow a Repeat step")
+}
+
+// Reduce Emit step
+func (s *GremlinTraversalStepEmit) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Exec Where step
+func (s *GremlinTraversalStepWhere) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	f := s.Params[0].(*GremlinTraversalSequence).traversalFunc()
+
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).Where(s.StepContext, f), nil
+	case *GraphTraversalE:
+		return last.(*GraphTraversalE).Where(s.StepContext, f), nil
+	}
+
+	return nil, ErrExecutionError
+}
+
+// Reduce Where step
+func (s *GremlinTraversalStepWhere) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	if s.ReduceRange(next) {
+		return s, nil
+	}
+
+	return next, nil
+}
+
+// Exec Not step
+func (s *GremlinTraversalStepNot) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	f := s.Params[0].(*GremlinTraversalSequence).traversalFunc()
+
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).Not(s.StepContext, f), nil
+	case *GraphTraversalE:
+		return last.(*GraphTraversalE).Not(s.StepContext, f), nil
+	}
+
+	return nil, ErrExecutionError
+}
+
+// Reduce Not step
+func (s *GremlinTraversalStepNot) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	if s.ReduceRange(next) {
+		return s, nil
+	}
+
+	return next, nil
+}
+
+// Exec Or step
+func (s *GremlinTraversalStepOr) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	fs := make([]TraversalFunc, len(s.Params))
+	for i, param := range s.Params {
+		fs[i] = param.(*GremlinTraversalSequence).traversalFunc()
+	}
+
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).Or(s.StepContext, fs...), nil
+	case *GraphTraversalE:
+		return last.(*GraphTraversalE).Or(s.StepContext, fs...), nil
+	}
+
+	return nil, ErrExecutionError
+}
+
+// Reduce Or step
+func (s *GremlinTraversalStepOr) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	if s.ReduceRange(next) {
+		return s, nil
+	}
+
+	return next, nil
+}
+
+// byModulators are the By steps following a Project or a GroupCount step
+type byModulators []*GremlinTraversalStepBy
+
+// add the By step if not already there, as the steps of a sequence used as
+// parameter are reduced each time the sequence is executed
+func (m *byModulators) add(by *GremlinTraversalStepBy) {
+	for _, b := range *m {
+		if b == by {
+			return
+		}
+	}
+	*m = append(*m, by)
+}
+
+// values returns how the values are computed according to the By steps
+// parameter, either a field name or a traversal
+func (m byModulators) values() []By {
+	by := make([]By, len(m))
+	for i, b := range m {
+		if seq, ok := b.Params[0].(*GremlinTraversalSequence); ok {
+			by[i] = By{Traversal: seq.traversalFunc()}
+		} else {
+			by[i] = By{Key: b.Params[0].(string)}
+		}
+	}
+	return by
+}
+
+// Exec Project step
+func (s *GremlinTraversalStepProject) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	keys := make([]string, len(s.Params))
+	for i, param := range s.Params {
+		keys[i] = param.(string)
+	}
+
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).Project(s.StepContext, keys, s.by.values()), nil
+	case *GraphTraversalE:
+		return last.(*GraphTraversalE).Project(s.StepContext, keys, s.by.values()), nil
+	}
+
+	return nil, ErrExecutionError
+}
+
+// Reduce Project step, the By steps giving the values of the keys
+func (s *GremlinTraversalStepProject) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	if by, ok := next.(*GremlinTraversalStepBy); ok {
+		if s.by.add(by); len(s.by) > len(s.Params) {
+			return nil, fmt.Errorf("Project has %d keys but more By steps", len(s.Params))
+		}
+		return s, nil
+	}
+
+	if s.ReduceRange(next) {
+		return s, nil
+	}
+
+	return next, nil
+}
+
+// Exec GroupCount step
+func (s *GremlinTraversalStepGroupCount) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).GroupCount(s.StepContext, s.by.values()), nil
+	case *GraphTraversalE:
+		return last.(*GraphTraversalE).GroupCount(s.StepContext, s.by.values()), nil
+	}
+
+	return nil, ErrExecutionError
+}
+
+// Reduce GroupCount step, each By step adding a level of groups
+func (s *GremlinTraversalStepGroupCount) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	if by, ok := next.(*GremlinTraversalStepBy); ok {
+		s.by.add(by)
+		return s, nil
+	}
+
+	return next, nil
+}
+
+// Exec By step
+func (s *GremlinTraversalStepBy) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	return nil, errors.New("By has to follow a Project or GroupCount step")
+}
+
+// Reduce By step
+func (s *GremlinTraversalStepBy) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Exec Explain step, only reached when not the last step of the query
+func (s *GremlinTraversalStepExplain) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	return nil, errors.New("Explain has to be the last step of the query")
+}
+
+// Reduce Explain step
+func (s *GremlinTraversalStepExplain) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Exec Profile step, only reached when not the last step of the query
+func (s *GremlinTraversalStepProfile) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	return nil, errors.New("Profile has to be the last step of the query")
+}
+
+// Reduce Profile step
+func (s *GremlinTraversalStepProfile) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Exec Property step
+func (s *GremlinTraversalStepProperty) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).Property(s.StepContext, s.Params[0].(string), s.Params[1]), nil
+	}
+
+	return nil, ErrExecutionError
+}
+
+// Reduce Property step
+func (s *GremlinTraversalStepProperty) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// Exec Drop step
+func (s *GremlinTraversalStepDrop) Exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	switch last.(type) {
+	case *GraphTraversalV:
+		return last.(*GraphTraversalV).Drop(s.StepContext), nil
+	}
+
+	return nil, ErrExecutionError
+}
+
+// Reduce Drop step
+func (s *GremlinTraversalStepDrop) Reduce(next GremlinTraversalStep) (GremlinTraversalStep, error) {
+	return next, nil
+}
+
+// isMutation returns whether the step modifies the graph
+func isMutation(step GremlinTraversalStep) bool {
+	switch step.(type) {
+	case *GremlinTraversalStepProperty, *GremlinTraversalStepDrop:
+		return true
+	}
+	return false
+}
+
+// checkRepeats returns an error if a Repeat step of the sequence is bounded
+// neither by a Times nor by an Until step, its traversers walking the whole
+// graph otherwise
+func checkRepeats(steps []GremlinTraversalStep) error {
+	for i, step := range steps {
+		if _, ok := step.(*GremlinTraversalStepRepeat); !ok {
+			continue
+		}
+
+		bounded := false
+	modulators:
+		for _, next := range steps[i+1:] {
+			switch next.(type) {
+			case *GremlinTraversalStepUntil, *GremlinTraversalStepTimes:
+				bounded = true
+			case *GremlinTraversalStepEmit:
+			default:
+				break modulators
+			}
+		}
+
+		if !bounded {
+			return errors.New("Repeat has to be followed by a Times or an Until step")
+		}
+	}
+	return nil
+}
+
+// Mutation returns the last step of the sequence if it modifies the graph,
+// along with the part of the query, as given to the parser, selecting the
+// nodes it applies to
+func (s *GremlinTraversalSequence) Mutation(query string) (GremlinTraversalStep, string) {
+	n := len(s.steps)
+	if n == 0 || len(s.offsets) != n || !isMutation(s.steps[n-1]) {
+		return nil, ""
+	}
+
+	return s.steps[n-1], strings.TrimSpace(query[:s.offsets[n-1]])
+}
+
+// traversalFunc returns a function applying the steps of the sequence, used
+// for the traversals given as parameter of a step
+func (s *GremlinTraversalSequence) traversalFunc() TraversalFunc {
+	return func(step GraphTraversalStep) (GraphTraversalStep, error) {
+		return s.exec(step)
+	}
+}
+
 // Exec sequence step
 func (s *GremlinTraversalSequence) Exec(g *graph.Graph, lockGraph bool) (GraphTraversalStep, error) {
-	var step GremlinTraversalStep
-	var last GraphTraversalStep
-	var err error
+	return s.ExecContext(context.Background(), g, lockGraph, Limits{})
+}
 
-	s.GraphTraversal = NewGraphTraversal(g, lockGraph)
-	last = s.GraphTraversal
+// ExecContext executes the sequence within the given execution limits, the
+// execution being aborted as soon as the context is canceled
+func (s *GremlinTraversalSequence) ExecContext(ctx context.Context, g *graph.Graph, lockGraph bool, limits Limits) (GraphTraversalStep, error) {
+	if limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
 
-	for i := 0; i < len(s.steps); {
-		step = s.steps[i]
+	b := &budget{ctx: ctx, limits: limits}
 
-		for i = i + 1; i < len(s.steps); i = i + 1 {
-			next, err := step.Reduce(s.steps[i])
-			if err != nil {
+	s.GraphTraversal = NewGraphTraversal(g, lockGraph)
+	s.GraphTraversal.budget = b
+
+	// Explain and Profile steps report about the steps preceding them
+	if n := len(s.steps); n > 0 {
+		switch s.steps[n-1].(type) {
+		case *GremlinTraversalStepExplain:
+			return s.explain(s.steps[:n-1])
+		case *GremlinTraversalStepProfile:
+			profile := &GraphTraversalProfile{steps: []interface{}{}}
+			if _, err := s.execSteps(s.steps[:n-1], s.GraphTraversal, profile); err != nil {
 				return nil, err
 			}
-			if next != step {
-				break
+			return profile, nil
+		}
+	}
+
+	res, err := s.exec(s.GraphTraversal)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.checkResults(len(res.Values())); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// reduce returns the i-th step once the following steps merged into it,
+// along with the index of the next step to execute
+func reduce(steps []GremlinTraversalStep, i int) (step GremlinTraversalStep, merged []string, next int, err error) {
+	step = steps[i]
+
+	for i = i + 1; i < len(steps); i = i + 1 {
+		reduced, err := step.Reduce(steps[i])
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if reduced != step {
+			break
+		}
+		merged = append(merged, stepName(steps[i]))
+	}
+
+	return step, merged, i, nil
+}
+
+// explain returns the steps that would be executed, without executing them
+func (s *GremlinTraversalSequence) explain(steps []GremlinTraversalStep) (GraphTraversalStep, error) {
+	profile := &GraphTraversalProfile{steps: []interface{}{}}
+
+	for i := 0; i < len(steps); {
+		step, merged, next, err := reduce(steps, i)
+		if err != nil {
+			return nil, err
+		}
+		profile.steps = append(profile.steps, &StepPlan{Step: stepName(step), Merged: merged})
+		i = next
+	}
+
+	return profile, nil
+}
+
+// exec applies the steps of the sequence to the result of a previous step
+func (s *GremlinTraversalSequence) exec(last GraphTraversalStep) (GraphTraversalStep, error) {
+	return s.execSteps(s.steps, last, nil)
+}
+
+// execSteps applies the steps to the result of a previous step, reporting
+// about the execution of each of them if a profile is given
+func (s *GremlinTraversalSequence) execSteps(steps []GremlinTraversalStep, last GraphTraversalStep, profile *GraphTraversalProfile) (GraphTraversalStep, error) {
+	for i := 0; i < len(steps); {
+		step, merged, next, err := reduce(steps, i)
+		if err != nil {
+			return nil, err
+		}
+		i = next
+
+		// steps not walking the graph, like the flow ones, are only
+		// interrupted between two steps
+		if s.GraphTraversal != nil {
+			if err := s.GraphTraversal.CheckLimits(0); err != nil {
+				return nil, err
 			}
 		}
 
+		var sp *StepProfile
+		if profile != nil {
+			sp = &StepProfile{StepPlan: StepPlan{Step: stepName(step), Merged: merged}, Input: len(last.Values())}
+		}
+		start := time.Now()
+
 		if last, err = step.Exec(last); err != nil {
 			return nil, err
 		}
@@ -897,19 +1470,32 @@ func (s *GremlinTraversalSequence) Exec(g *graph.Graph, lockGraph bool) (GraphTr
 		if err := last.Error(); err != nil {
 			return nil, err
 		}
+
+		if sp != nil {
+			sp.Duration = float64(time.Since(start)) / float64(time.Millisecond)
+			sp.Output = len(last.Values())
+			if p, ok := last.(StepProfiler); ok {
+				sp.Info = p.ProfileInfo()
+			}
+			profile.steps = append(profile.steps, sp)
+		}
 	}
 
-	res, ok := last.(GraphTraversalStep)
-	if !ok {
+	if last == nil {
 		return nil, ErrExecutionError
 	}
 
-	return res, nil
+	return last, nil
 }
 
 // AddTraversalExtension registers a new gremlin traversal extension
 func (p *GremlinTraversalParser) AddTraversalExtension(e GremlinTraversalExtension) {
 	p.extensions = append(p.extensions, e)
+
+	// extensions change the way queries are scanned
+	if p.cache != nil {
+		p.cache.Purge()
+	}
 }
 
 // NewGremlinTraversalParser creates a new gremlin language parser on the graph
@@ -941,6 +1527,16 @@ func (p *GremlinTraversalParser) parseStepParams() ([]interface{}, error) {
 			}
 		case STRING:
 			params = append(params, lit)
+		case VARIABLE:
+			value, found := p.bindings[lit]
+			if !found {
+				return nil, &UnboundVariableError{Name: lit}
+			}
+			values, err := bindValue(lit, value)
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, values...)
 		case METADATA:
 			metadataParams, err := p.parseStepParams()
 			if err != nil {
@@ -1071,7 +1667,17 @@ func (p *GremlinTraversalParser) parseStepParams() ([]interface{}, error) {
 		case FALSE:
 			params = append(params, false)
 		default:
-			return nil, fmt.Errorf("Unexpected token while parsing parameters, got: %s", lit)
+			// a step keyword starts a traversal given as parameter
+			if tok <= VARIABLE {
+				return nil, fmt.Errorf("Unexpected token while parsing parameters, got: %s", lit)
+			}
+
+			p.unscan()
+			seq, err := p.parseTraversal()
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, seq)
 		}
 		tok, lit = p.scanIgnoreWhitespace()
 	}
@@ -1079,6 +1685,33 @@ func (p *GremlinTraversalParser) parseStepParams() ([]interface{}, error) {
 	return params, nil
 }
 
+// parseTraversal parses a dot-delimited sequence of steps given as parameter
+// of a step, like the one of Repeat(Out().Has('Type', 'bridge'))
+func (p *GremlinTraversalParser) parseTraversal() (*GremlinTraversalSequence, error) {
+	seq := &GremlinTraversalSequence{
+		extensions: p.extensions,
+	}
+
+	for {
+		step, err := p.parserStep()
+		if err != nil {
+			return nil, err
+		}
+		if isMutation(step) {
+			return nil, errors.New("Graph can't be modified by the traversal parameter of a step")
+		}
+		seq.steps = append(seq.steps, step)
+
+		if tok, _ := p.scanIgnoreWhitespace(); tok != DOT {
+			p.unscan()
+			if err := checkRepeats(seq.steps); err != nil {
+				return nil, err
+			}
+			return seq, nil
+		}
+	}
+}
+
 func (p *GremlinTraversalParser) parserStep() (GremlinTraversalStep, error) {
 	tok, lit := p.scanIgnoreWhitespace()
 	if tok == IDENT {
@@ -1174,6 +1807,48 @@ func (p *GremlinTraversalParser) parserStep() (GremlinTraversalStep, error) {
 			return nil, fmt.Errorf("ShortestPathTo predicate accepts only 1 or 2 parameters : %v", params)
 		}
 		return &GremlinTraversalStepShortestPathTo{gremlinStepContext}, nil
+	case ALLPATHSTO:
+		if len(params) < 2 || len(params) > 3 {
+			return nil, fmt.Errorf("AllPathsTo accepts only 2 or 3 parameters : %v", params)
+		}
+		if _, ok := params[0].(graph.Metadata); !ok {
+			return nil, fmt.Errorf("AllPathsTo first parameter has to be a Metadata predicate : %v", params)
+		}
+		if depth, ok := params[1].(int64); !ok || depth <= 0 {
+			return nil, fmt.Errorf("AllPathsTo second parameter has to be a positive maximum depth : %v", params)
+		}
+		if len(params) > 2 {
+			if _, ok := params[2].(graph.Metadata); !ok {
+				return nil, fmt.Errorf("AllPathsTo edge filter has to be a Metadata predicate : %v", params)
+			}
+		}
+		return &GremlinTraversalStepAllPathsTo{gremlinStepContext}, nil
+	case KSHORTESTPATHSTO:
+		if len(params) < 2 || len(params) > 4 {
+			return nil, fmt.Errorf("KShortestPathsTo accepts only 2 to 4 parameters : %v", params)
+		}
+		if _, ok := params[0].(graph.Metadata); !ok {
+			return nil, fmt.Errorf("KShortestPathsTo first parameter has to be a Metadata predicate : %v", params)
+		}
+		if k, ok := params[1].(int64); !ok || k <= 0 {
+			return nil, fmt.Errorf("KShortestPathsTo second parameter has to be a positive number of paths : %v", params)
+		}
+		switch len(params) {
+		case 4:
+			if _, ok := params[2].(string); !ok {
+				return nil, fmt.Errorf("KShortestPathsTo weight key has to be a string : %v", params)
+			}
+			if _, ok := params[3].(graph.Metadata); !ok {
+				return nil, fmt.Errorf("KShortestPathsTo edge filter has to be a Metadata predicate : %v", params)
+			}
+		case 3:
+			switch params[2].(type) {
+			case string, graph.Metadata:
+			default:
+				return nil, fmt.Errorf("KShortestPathsTo third parameter has to be a weight key or an edge Metadata predicate : %v", params)
+			}
+		}
+		return &GremlinTraversalStepKShortestPathsTo{gremlinStepContext}, nil
 	case BOTH:
 		return &GremlinTraversalStepBoth{gremlinStepContext}, nil
 	case CONTEXT:
@@ -1241,6 +1916,124 @@ func (p *GremlinTraversalParser) parserStep() (GremlinTraversalStep, error) {
 		}
 
 		return &GremlinTraversalStepSelect{gremlinStepContext}, nil
+	case PATH:
+		if len(params) != 0 {
+			return nil, fmt.Errorf("Path accepts no parameter : %v", params)
+		}
+		return &GremlinTraversalStepPath{gremlinStepContext}, nil
+	case REPEAT:
+		if len(params) != 1 {
+			return nil, fmt.Errorf("Repeat requires 1 traversal parameter : %v", params)
+		}
+		if _, ok := params[0].(*GremlinTraversalSequence); !ok {
+			return nil, fmt.Errorf("Repeat parameter has to be a traversal : %v", params)
+		}
+		return &GremlinTraversalStepRepeat{GremlinTraversalContext: gremlinStepContext}, nil
+	case UNTIL:
+		if len(params) != 1 {
+			return nil, fmt.Errorf("Until requires 1 traversal parameter : %v", params)
+		}
+		if _, ok := params[0].(*GremlinTraversalSequence); !ok {
+			return nil, fmt.Errorf("Until parameter has to be a traversal : %v", params)
+		}
+		return &GremlinTraversalStepUntil{gremlinStepContext}, nil
+	case TIMES:
+		if len(params) != 1 {
+			return nil, fmt.Errorf("Times requires 1 parameter : %v", params)
+		}
+		if times, ok := params[0].(int64); !ok || times <= 0 {
+			return nil, fmt.Errorf("Times parameter has to be a positive integer : %v", params)
+		}
+		return &GremlinTraversalStepTimes{gremlinStepContext}, nil
+	case EMIT:
+		switch len(params) {
+		case 0:
+		case 1:
+			if _, ok := params[0].(*GremlinTraversalSequence); !ok {
+				return nil, fmt.Errorf("Emit parameter has to be a traversal : %v", params)
+			}
+		default:
+			return nil, fmt.Errorf("Emit accepts at most 1 traversal parameter : %v", params)
+		}
+		return &GremlinTraversalStepEmit{gremlinStepContext}, nil
+	case WHERE, NOT:
+		if len(params) != 1 {
+			return nil, fmt.Errorf("%s requires 1 traversal parameter : %v", lit, params)
+		}
+		if _, ok := params[0].(*GremlinTraversalSequence); !ok {
+			return nil, fmt.Errorf("%s parameter has to be a traversal : %v", lit, params)
+		}
+		if tok == WHERE {
+			return &GremlinTraversalStepWhere{gremlinStepContext}, nil
+		}
+		return &GremlinTraversalStepNot{gremlinStepContext}, nil
+	case OR:
+		if len(params) == 0 {
+			return nil, fmt.Errorf("Or requires at least one traversal parameter : %v", params)
+		}
+		for _, param := range params {
+			if _, ok := param.(*GremlinTraversalSequence); !ok {
+				return nil, fmt.Errorf("Or parameters have to be traversals : %v", params)
+			}
+		}
+		return &GremlinTraversalStepOr{gremlinStepContext}, nil
+	case PROJECT:
+		if len(params) == 0 {
+			return nil, fmt.Errorf("Project requires at least one key : %v", params)
+		}
+		for _, param := range params {
+			if _, ok := param.(string); !ok {
+				return nil, fmt.Errorf("Project parameters have to be strings : %v", params)
+			}
+		}
+		return &GremlinTraversalStepProject{GremlinTraversalContext: gremlinStepContext}, nil
+	case GROUPCOUNT:
+		if len(params) != 0 {
+			return nil, fmt.Errorf("GroupCount accepts no parameter : %v", params)
+		}
+		return &GremlinTraversalStepGroupCount{GremlinTraversalContext: gremlinStepContext}, nil
+	case BY:
+		if len(params) != 1 {
+			return nil, fmt.Errorf("By requires 1 parameter : %v", params)
+		}
+		switch params[0].(type) {
+		case string, *GremlinTraversalSequence:
+		default:
+			return nil, fmt.Errorf("By parameter has to be a string or a traversal : %v", params)
+		}
+		return &GremlinTraversalStepBy{gremlinStepContext}, nil
+	case EXPLAIN, PROFILE:
+		if len(params) != 0 {
+			return nil, fmt.Errorf("%s accepts no parameter : %v", lit, params)
+		}
+		if tok == EXPLAIN {
+			return &GremlinTraversalStepExplain{gremlinStepContext}, nil
+		}
+		return &GremlinTraversalStepProfile{gremlinStepContext}, nil
+	case PROPERTY:
+		if !p.mutations {
+			return nil, errors.New("Property step is not allowed in this query")
+		}
+		if len(params) != 2 {
+			return nil, fmt.Errorf("Property requires 2 parameters : %v", params)
+		}
+		if _, ok := params[0].(string); !ok {
+			return nil, fmt.Errorf("Property key has to be a string : %v", params)
+		}
+		switch params[1].(type) {
+		case string, int64, float64, bool:
+		default:
+			return nil, fmt.Errorf("Property value has to be a string, a number or a boolean : %v", params)
+		}
+		return &GremlinTraversalStepProperty{gremlinStepContext}, nil
+	case DROP:
+		if !p.mutations {
+			return nil, errors.New("Drop step is not allowed in this query")
+		}
+		if len(params) != 0 {
+			return nil, fmt.Errorf("Drop accepts no parameter : %v", params)
+		}
+		return &GremlinTraversalStepDrop{gremlinStepContext}, nil
 	}
 
 	// extensions
@@ -1257,12 +2050,25 @@ func (p *GremlinTraversalParser) parserStep() (GremlinTraversalStep, error) {
 	return nil, fmt.Errorf("Expected step function, got: %s", lit)
 }
 
+// IsQuery returns whether the expression is a Gremlin query, the queries
+// starting with the G step, rather than a JavaScript expression
+func IsQuery(expression string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(expression)), "G.")
+}
+
 // Parse the Gremlin language and returns a traversal sequence
 func (p *GremlinTraversalParser) Parse(r io.Reader) (*GremlinTraversalSequence, error) {
 	p.Lock()
 	defer p.Unlock()
 
-	p.scanner = NewGremlinTraversalScanner(r, p.extensions)
+	return p.parse(tokenize(r, p.extensions), nil, false)
+}
+
+func (p *GremlinTraversalParser) parse(tokens []scannedToken, bindings map[string]interface{}, mutations bool) (*GremlinTraversalSequence, error) {
+	p.tokens, p.pos, p.bindings, p.mutations = tokens, 0, bindings, mutations
+	defer func() {
+		p.tokens, p.bindings, p.mutations = nil, nil, false
+	}()
 
 	seq := &GremlinTraversalSequence{
 		extensions: p.extensions,
@@ -1283,24 +2089,35 @@ func (p *GremlinTraversalParser) Parse(r io.Reader) (*GremlinTraversalSequence,
 			return nil, fmt.Errorf("found %q, expected `.`", lit)
 		}
 
+		// the dot is the last token read
+		offset := p.tokens[p.pos-1].offset
+
+		if n := len(seq.steps); n > 0 && isMutation(seq.steps[n-1]) {
+			return nil, fmt.Errorf("%s has to be the last step of the query", stepName(seq.steps[n-1]))
+		}
+
 		step, err := p.parserStep()
 		if err != nil {
 			return nil, err
 		}
 		seq.steps = append(seq.steps, step)
+		seq.offsets = append(seq.offsets, offset)
+	}
+
+	if err := checkRepeats(seq.steps); err != nil {
+		return nil, err
 	}
 
 	return seq, nil
 }
 
 func (p *GremlinTraversalParser) scan() (tok Token, lit string) {
-	if p.buf.n != 0 {
-		p.buf.n = 0
-		return p.buf.tok, p.buf.lit
+	p.pos++
+	if p.pos > len(p.tokens) {
+		return EOF, ""
 	}
-	p.buf.tok, p.buf.lit = p.scanner.Scan()
 
-	return p.buf.tok, p.buf.lit
+	return p.tokens[p.pos-1].tok, p.tokens[p.pos-1].lit
 }
 
 func (p *GremlinTraversalParser) scanIgnoreWhitespace() (Token, string) {
@@ -1312,5 +2129,5 @@ func (p *GremlinTraversalParser) scanIgnoreWhitespace() (Token, string) {
 }
 
 func (p *GremlinTraversalParser) unscan() {
-	p.buf.n = 1
+	p.pos--
 }
@@ -49,6 +49,7 @@ const (
 	RIGHTPARENTHESIS
 	STRING
 	NUMBER
+	VARIABLE
 
 	// Keywords
 	G
@@ -71,6 +72,8 @@ const (
 	WITHOUT
 	METADATA
 	SHORTESTPATHTO
+	ALLPATHSTO
+	KSHORTESTPATHSTO
 	NE
 	BOTH
 	CONTEXT
@@ -97,6 +100,21 @@ const (
 	NOW
 	AS
 	SELECT
+	PATH
+	REPEAT
+	UNTIL
+	TIMES
+	EMIT
+	WHERE
+	NOT
+	OR
+	PROJECT
+	BY
+	GROUPCOUNT
+	EXPLAIN
+	PROFILE
+	PROPERTY
+	DROP
 
 	TRUE
 	FALSE
@@ -108,6 +126,8 @@ const (
 type GremlinTraversalScanner struct {
 	reader     *bufio.Reader
 	extensions []GremlinTraversalExtension
+	offset     int
+	lastSize   int
 }
 
 // NewGremlinTraversalScanner creates a new Gremlin expression scanner
@@ -132,6 +152,8 @@ func (s *GremlinTraversalScanner) Scan() (tok Token, lit string) {
 	} else if isLetter(ch) {
 		s.unread()
 		return s.scanIdent()
+	} else if ch == '$' {
+		return s.scanVariable()
 	}
 
 	switch ch {
@@ -199,6 +221,28 @@ func (s *GremlinTraversalScanner) scanString() (tok Token, lit string) {
 	return STRING, buf.String()
 }
 
+// scanVariable scans the name of a bind variable, like name for $name
+func (s *GremlinTraversalScanner) scanVariable() (tok Token, lit string) {
+	var buf bytes.Buffer
+
+	for {
+		if ch := s.read(); ch == eof {
+			break
+		} else if !isLetter(ch) && !isDigit(ch) && ch != '_' {
+			s.unread()
+			break
+		} else {
+			_, _ = buf.WriteRune(ch)
+		}
+	}
+
+	if buf.Len() == 0 {
+		return ILLEGAL, "$"
+	}
+
+	return VARIABLE, buf.String()
+}
+
 func (s *GremlinTraversalScanner) scanIdent() (tok Token, lit string) {
 	var buf bytes.Buffer
 	buf.WriteRune(s.read())
@@ -257,6 +301,10 @@ func (s *GremlinTraversalScanner) scanIdent() (tok Token, lit string) {
 		return METADATA, buf.String()
 	case "SHORTESTPATHTO":
 		return SHORTESTPATHTO, buf.String()
+	case "ALLPATHSTO":
+		return ALLPATHSTO, buf.String()
+	case "KSHORTESTPATHSTO":
+		return KSHORTESTPATHSTO, buf.String()
 	case "NE":
 		return NE, buf.String()
 	case "BOTH":
@@ -307,6 +355,36 @@ func (s *GremlinTraversalScanner) scanIdent() (tok Token, lit string) {
 		return AS, buf.String()
 	case "SELECT":
 		return SELECT, buf.String()
+	case "PATH":
+		return PATH, buf.String()
+	case "REPEAT":
+		return REPEAT, buf.String()
+	case "UNTIL":
+		return UNTIL, buf.String()
+	case "TIMES":
+		return TIMES, buf.String()
+	case "EMIT":
+		return EMIT, buf.String()
+	case "WHERE":
+		return WHERE, buf.String()
+	case "NOT":
+		return NOT, buf.String()
+	case "OR":
+		return OR, buf.String()
+	case "PROJECT":
+		return PROJECT, buf.String()
+	case "BY":
+		return BY, buf.String()
+	case "GROUPCOUNT":
+		return GROUPCOUNT, buf.String()
+	case "EXPLAIN":
+		return EXPLAIN, buf.String()
+	case "PROFILE":
+		return PROFILE, buf.String()
+	case "PROPERTY":
+		return PROPERTY, buf.String()
+	case "DROP":
+		return DROP, buf.String()
 	case "TRUE":
 		return TRUE, buf.String()
 	case "FALSE":
@@ -322,16 +400,25 @@ func (s *GremlinTraversalScanner) scanIdent() (tok Token, lit string) {
 	return IDENT, buf.String()
 }
 
+// Offset returns the number of bytes of the expression scanned so far
+func (s *GremlinTraversalScanner) Offset() int {
+	return s.offset
+}
+
 func (s *GremlinTraversalScanner) read() rune {
-	ch, _, err := s.reader.ReadRune()
+	ch, size, err := s.reader.ReadRune()
 	if err != nil {
 		return eof
 	}
+	s.offset += size
+	s.lastSize = size
 	return ch
 }
 
 func (s *GremlinTraversalScanner) unread() {
-	s.reader.UnreadRune()
+	if s.reader.UnreadRune() == nil {
+		s.offset -= s.lastSize
+	}
 }
 
 func isString(ch rune) bool {
@@ -23,8 +23,12 @@
 package traversal
 
 import (
+	"context"
+	"encoding/json"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/topology/graph"
@@ -469,6 +473,119 @@ func TestTraversalShortestPathTo(t *testing.T) {
 	}
 }
 
+func pathValues(t *testing.T, path interface{}) (values []int64) {
+	for _, v := range path.([]interface{}) {
+		switch v := v.(type) {
+		case *graph.Node:
+			value, _ := v.GetFieldInt64("Value")
+			values = append(values, value)
+		case *graph.Edge:
+			// edges are reported as -1 in between the node values
+			values = append(values, -1)
+		default:
+			t.Fatalf("Unexpected path element: %v", v)
+		}
+	}
+	return
+}
+
+func TestTraversalPath(t *testing.T) {
+	g := newTransversalGraph(t)
+	ctx := StepContext{}
+
+	tr := NewGraphTraversal(g, false)
+
+	tp := tr.V(ctx).Has(ctx, "Value", int64(1)).Out(ctx).Out(ctx).Path(ctx)
+	if tp.Error() != nil {
+		t.Fatal(tp.Error())
+	}
+
+	if len(tp.Values()) != 2 {
+		t.Fatalf("Should return 2 paths, returned: %v", tp.Values())
+	}
+
+	expected := map[int64][]int64{3: {1, 2, 3}, 4: {1, 3, 4}}
+	for _, path := range tp.Values() {
+		values := pathValues(t, path)
+		if len(values) != 3 || !reflect.DeepEqual(values, expected[values[2]]) {
+			t.Fatalf("Wrong path: %v", values)
+		}
+	}
+
+	// next test, filter steps keep the paths of the nodes
+	tp = tr.V(ctx).Has(ctx, "Value", int64(1)).Out(ctx).Sort(ctx, common.SortDescending, "Value").Has(ctx, "Type", "intf").Path(ctx)
+	if len(tp.Values()) != 1 || !reflect.DeepEqual(pathValues(t, tp.Values()[0]), []int64{1, 2}) {
+		t.Fatalf("Should return the path from 1 to 2, returned: %v", tp.Values())
+	}
+
+	tp = tr.V(ctx).Has(ctx, "Value", int64(1)).Out(ctx).Sort(ctx, common.SortDescending, "Value").Path(ctx)
+	for i, last := range []int64{4, 3, 2} {
+		if values := pathValues(t, tp.Values()[i]); !reflect.DeepEqual(values, []int64{1, last}) {
+			t.Fatalf("Wrong sorted path: %v", values)
+		}
+	}
+
+	// next test, edges walked through are part of the path
+	tp = tr.V(ctx).Has(ctx, "Value", int64(2)).OutE(ctx).OutV(ctx).Path(ctx)
+	if len(tp.Values()) != 1 || !reflect.DeepEqual(pathValues(t, tp.Values()[0]), []int64{2, -1, 3}) {
+		t.Fatalf("Should return the path from 2 to 3 through an edge, returned: %v", tp.Values())
+	}
+
+	tp = tr.E(ctx).Has(ctx, "Name", "e3").Path(ctx)
+	if len(tp.Values()) != 1 || !reflect.DeepEqual(pathValues(t, tp.Values()[0]), []int64{-1}) {
+		t.Fatalf("Should return a path made of the edge, returned: %v", tp.Values())
+	}
+}
+
+func TestTraversalPathsTo(t *testing.T) {
+	g := newTransversalGraph(t)
+
+	query := `G.V().Has("Value", 1).AllPathsTo(Metadata("Name", "Node4"), 2)`
+	res := execTraversalQuery(t, g, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 paths, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Has("Value", 1).AllPathsTo(Metadata("Name", "Node4"), 3)`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 3 {
+		t.Fatalf("Should return 3 paths, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Has("Value", 1).AllPathsTo(Metadata("Name", "Node4"), 3, Metadata("Direction", "Left"))`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 0 {
+		t.Fatalf("Should return no path, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Has("Value", 1).KShortestPathsTo(Metadata("Name", "Node4"), 2)`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 paths, returned: %v", res.Values())
+	}
+
+	if path := res.Values()[0].([]*graph.Node); len(path) != 2 {
+		t.Fatalf("Should return the direct path first, returned: %v", path)
+	}
+
+	// next traversal test
+	query = `G.V().Has("Value", 1).KShortestPathsTo(Metadata("Name", "Node4"), 5, "Weight").Limit(2)`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 paths, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Has("Value", 1).KShortestPathsTo(Metadata("Name", "Node4"), 5, Metadata("Direction", "Left"))`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 0 {
+		t.Fatalf("Should return no path, returned: %v", res.Values())
+	}
+}
+
 func TestTraversalBothV(t *testing.T) {
 	g := newTransversalGraph(t)
 	ctx := StepContext{}
@@ -690,6 +807,20 @@ func TestTraversalParser(t *testing.T) {
 		t.Fatalf("Should return 3 nodes, returned: %v", res.Values())
 	}
 
+	// next traversal test
+	query = `G.V().Has("Value", 1).Out().Out().Path()`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 paths, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Has("Value", 1).Out().Out().Path().Limit(1)`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 1 {
+		t.Fatalf("Should return 1 path, returned: %v", res.Values())
+	}
+
 	// next traversal test
 	query = `G.V().Has("IPV4", Ipv4Range("192.168.0.0/24"))`
 	res = execTraversalQuery(t, g, query)
@@ -767,3 +898,575 @@ func TestLimit(t *testing.T) {
 		t.Fatalf("Should return 1 result, returned: %v", res.Values())
 	}
 }
+
+func TestTraversalRepeat(t *testing.T) {
+	g := newTransversalGraph(t)
+
+	query := `G.V().Has("Value", 1).Repeat(Out()).Times(1)`
+	res := execTraversalQuery(t, g, query)
+	if len(res.Values()) != 3 {
+		t.Fatalf("Should return 3 nodes, returned: %v", res.Values())
+	}
+
+	// next traversal test, each traverser goes through two loops
+	query = `G.V().Has("Value", 1).Repeat(Out()).Times(2).Sort("Value").Values("Value")`
+	res = execTraversalQuery(t, g, query)
+	if !reflect.DeepEqual(res.Values(), []interface{}{int64(3), int64(4)}) {
+		t.Fatalf("Should return nodes 3 and 4, returned: %v", res.Values())
+	}
+
+	// next traversal test, the last loop is emitted along with the previous ones
+	query = `G.V().Has("Value", 1).Repeat(Out()).Emit().Times(2)`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 5 {
+		t.Fatalf("Should return 5 nodes, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Has("Value", 1).Repeat(Out()).Emit(Has("Value", 3)).Times(2).Has("Value", 3)`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return node 3 twice, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Has("Value", 2).Repeat(Out()).Until(Has("Name", "Node4")).Path()`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 1 || !reflect.DeepEqual(pathValues(t, res.Values()[0]), []int64{2, 3, 4}) {
+		t.Fatalf("Should return the path from 2 to 4, returned: %v", res.Values())
+	}
+
+	// next traversal test, the traversers running out of loops are returned
+	query = `G.V().Has("Value", 2).Repeat(Out()).Until(Has("Name", "Node4")).Times(1).Values("Value")`
+	res = execTraversalQuery(t, g, query)
+	if !reflect.DeepEqual(res.Values(), []interface{}{int64(3)}) {
+		t.Fatalf("Should return node 3, returned: %v", res.Values())
+	}
+
+	// next traversal test, the traversers going back to a node of their path are dropped
+	query = `G.V().Has("Value", 2).Repeat(Both()).Until(Has("Name", "Node4")).Path()`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 4 {
+		t.Fatalf("Should return 4 paths, returned: %v", res.Values())
+	}
+
+	for _, path := range res.Values() {
+		values := pathValues(t, path)
+		if values[len(values)-1] != 4 {
+			t.Fatalf("Path should end on node 4: %v", values)
+		}
+
+		seen := make(map[int64]bool)
+		for _, value := range values {
+			if seen[value] {
+				t.Fatalf("Path should not go through a node twice: %v", values)
+			}
+			seen[value] = true
+		}
+	}
+
+	// next traversal test, a traverser not moving is dropped
+	query = `G.V().Has("Value", 2).Repeat(Has("Type", "intf")).Until(Has("Name", "Node4"))`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 0 {
+		t.Fatalf("Should return no node, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Has("Value", 1).Repeat(OutE("Direction", "Left").OutV()).Emit().Times(2).Path()`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 paths, returned: %v", res.Values())
+	}
+
+	if values := pathValues(t, res.Values()[1]); !reflect.DeepEqual(values, []int64{1, -1, 2, -1, 3}) {
+		t.Fatalf("Wrong path: %v", values)
+	}
+
+	// next traversal test
+	query = `G.V().Has("Value", 1).Repeat(Out()).Emit().Times(2).Limit(2)`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 nodes, returned: %v", res.Values())
+	}
+
+	for _, query := range []string{
+		`G.V().Until(Has("Type", "intf"))`,
+		`G.V().Repeat(Out()).Times(0)`,
+		`G.V().Repeat("Type")`,
+		`G.V().Repeat(Out())`,
+		`G.V().Repeat(Out()).Emit()`,
+		`G.V().Where(Repeat(Out()).Emit())`,
+	} {
+		ts, err := NewGremlinTraversalParser().Parse(strings.NewReader(query))
+		if err == nil {
+			_, err = ts.Exec(g, false)
+		}
+
+		if err == nil {
+			t.Fatalf("%s: should return an error", query)
+		}
+	}
+}
+
+func TestTraversalWhere(t *testing.T) {
+	g := newTransversalGraph(t)
+
+	query := `G.V().Where(Out().Has("Name", "Node4"))`
+	res := execTraversalQuery(t, g, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 nodes, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Where(Out().Where(Out().Has("Name", "Node4")))`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 nodes, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Where(Out()).Limit(1)`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 1 {
+		t.Fatalf("Should return 1 node, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Not(In())`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 1 {
+		t.Fatalf("Should return 1 node, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Has("Type", "intf").Not(In().Has("Type", "intf"))`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 1 {
+		t.Fatalf("Should return 1 node, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Or(Has("Name", "Node4"), Out().Has("Type", "intf"))`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 nodes, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.E().Where(OutV().Has("Name", "Node4"))`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 edges, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.E().Not(InV().Has("Type", "intf"))`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 1 {
+		t.Fatalf("Should return 1 edge, returned: %v", res.Values())
+	}
+}
+
+func TestTraversalProject(t *testing.T) {
+	g := newTransversalGraph(t)
+
+	query := `G.V().Has("Value", 4).Project("name", "ip", "Value").By("Name").By("IPV4")`
+	res := execTraversalQuery(t, g, query)
+	if len(res.Values()) != 1 {
+		t.Fatalf("Should return 1 projection, returned: %v", res.Values())
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[{"name":"Node4","ip":"192.168.1.34","Value":4}]`
+	if string(data) != expected {
+		t.Fatalf("Should return %s, returned: %s", expected, string(data))
+	}
+
+	// next traversal test
+	query = `G.V().Has("Type", "intf").Sort("Value").Project("value", "out").By("Value").By(Out().Count())`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 projections, returned: %v", res.Values())
+	}
+
+	p := res.Values()[0].(*Projection)
+	if p.Get("value") != int64(1) || p.Get("out") != 3 {
+		t.Fatalf("Wrong projection: %v", p.values)
+	}
+
+	// next traversal test
+	query = `G.V().Has("Value", 1).Project("values").By(Out().Values("Value"))`
+	res = execTraversalQuery(t, g, query)
+	if values, ok := res.Values()[0].(*Projection).Get("values").([]interface{}); !ok || len(values) != 3 {
+		t.Fatalf("Should return 3 values, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.E().Has("Name", "e3").Project("name", "mode").By("Name").By("Mode")`
+	res = execTraversalQuery(t, g, query)
+	if p := res.Values()[0].(*Projection); p.Get("name") != "e3" || p.Get("mode") != nil {
+		t.Fatalf("Wrong projection: %v", p.values)
+	}
+
+	// next traversal test
+	query = `G.V().Project("name").Limit(2)`
+	res = execTraversalQuery(t, g, query)
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 projections, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	ts, _ := NewGremlinTraversalParser().Parse(strings.NewReader(`G.V().Project("name").By("Name").By("IPV4")`))
+	if _, err := ts.Exec(g, false); err == nil {
+		t.Fatal("Should return an error as there are more By steps than keys")
+	}
+}
+
+func TestTraversalGroupCount(t *testing.T) {
+	g := newTransversalGraph(t)
+
+	query := `G.V().GroupCount().By("Type")`
+	res := execTraversalQuery(t, g, query)
+
+	counts := res.Values()[0].(map[string]interface{})
+	if len(counts) != 1 || counts["intf"] != int64(2) {
+		t.Fatalf("Should return 2 intf, returned: %v", counts)
+	}
+
+	// next traversal test
+	query = `G.V().GroupCount().By("Type").By("Value")`
+	res = execTraversalQuery(t, g, query)
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"intf":{"1":1,"2":1}}`
+	if string(data) != expected {
+		t.Fatalf("Should return %s, returned: %s", expected, string(data))
+	}
+
+	// next traversal test
+	query = `G.V().GroupCount().By(Out().Count())`
+	res = execTraversalQuery(t, g, query)
+
+	counts = res.Values()[0].(map[string]interface{})
+	if counts["0"] != int64(1) || counts["1"] != int64(2) || counts["3"] != int64(1) {
+		t.Fatalf("Wrong counts: %v", counts)
+	}
+
+	// next traversal test
+	query = `G.E().GroupCount().By("Direction")`
+	res = execTraversalQuery(t, g, query)
+
+	counts = res.Values()[0].(map[string]interface{})
+	if counts["Left"] != int64(2) {
+		t.Fatalf("Should return 2 Left edges, returned: %v", counts)
+	}
+
+	// next traversal test
+	query = `G.V().GroupCount()`
+	res = execTraversalQuery(t, g, query)
+	if counts = res.Values()[0].(map[string]interface{}); len(counts) != 4 {
+		t.Fatalf("Should return 4 groups, returned: %v", counts)
+	}
+
+	// next traversal test
+	ts, _ := NewGremlinTraversalParser().Parse(strings.NewReader(`G.V().By("Type")`))
+	if _, err := ts.Exec(g, false); err == nil {
+		t.Fatal("Should return an error as By does not follow a Project step")
+	}
+}
+
+func execTraversalQueryWithLimits(t *testing.T, ctx context.Context, g *graph.Graph, query string, limits Limits) (GraphTraversalStep, error) {
+	ts, err := NewGremlinTraversalParser().Parse(strings.NewReader(query))
+	if err != nil {
+		t.Fatalf("%s: %s", query, err)
+	}
+
+	return ts.ExecContext(ctx, g, false, limits)
+}
+
+func TestTraversalLimits(t *testing.T) {
+	g := newTransversalGraph(t)
+
+	// 4 nodes, then 4 and 10 traversers for the Both steps
+	query := `G.V().Both().Both()`
+	res, err := execTraversalQueryWithLimits(t, context.Background(), g, query, Limits{MaxTraversed: 18})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Values()) != 26 {
+		t.Fatalf("Should return 26 nodes, returned: %d", len(res.Values()))
+	}
+
+	_, err = execTraversalQueryWithLimits(t, context.Background(), g, query, Limits{MaxTraversed: 17})
+	if err, ok := err.(*LimitError); !ok || err.Limit != "traversed elements" {
+		t.Fatalf("Should exceed the traversed elements limit, got: %v", err)
+	}
+
+	// next traversal test
+	_, err = execTraversalQueryWithLimits(t, context.Background(), g, query, Limits{MaxResults: 10})
+	if err, ok := err.(*LimitError); !ok || err.Limit != "results" {
+		t.Fatalf("Should exceed the results limit, got: %v", err)
+	}
+
+	// next traversal test
+	query = `G.V().Repeat(Both()).Times(10)`
+	_, err = execTraversalQueryWithLimits(t, context.Background(), g, query, Limits{Timeout: time.Nanosecond})
+	if err, ok := err.(*LimitError); !ok || err.Limit != "timeout" {
+		t.Fatalf("Should exceed the timeout, got: %v", err)
+	}
+
+	// next traversal test
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err = execTraversalQueryWithLimits(t, ctx, g, query, Limits{}); err != ErrQueryCanceled {
+		t.Fatalf("Should be canceled, got: %v", err)
+	}
+
+	// next traversal test, every node walked by path lookups is counted
+	for _, query := range []string{
+		`G.V().Has("Value", 1).AllPathsTo(Metadata("Name", "Node55"), 10)`,
+		`G.V().Has("Value", 1).KShortestPathsTo(Metadata("Name", "Node55"), 5)`,
+	} {
+		_, err = execTraversalQueryWithLimits(t, context.Background(), g, query, Limits{MaxTraversed: 3})
+		if err, ok := err.(*LimitError); !ok || err.Limit != "traversed elements" {
+			t.Errorf("%s: should exceed the traversed elements limit, got: %v", query, err)
+		}
+	}
+}
+
+func TestTraversalProfile(t *testing.T) {
+	g := newTransversalGraph(t)
+
+	query := `G.V().Has("Type", "intf").Out().Limit(1).Profile()`
+	res := execTraversalQuery(t, g, query)
+
+	// Has and Limit are merged into the preceding steps
+	profiles := res.Values()
+	if len(profiles) != 2 {
+		t.Fatalf("Should return 2 steps, returned: %v", profiles)
+	}
+
+	expected := []struct {
+		step          string
+		merged        string
+		input, output int
+	}{
+		{"V", "Has", 1, 2},
+		{"Out", "Limit", 2, 1},
+	}
+
+	for i, e := range expected {
+		p := profiles[i].(*StepProfile)
+		if p.Step != e.step || len(p.Merged) != 1 || p.Merged[0] != e.merged || p.Input != e.input || p.Output != e.output {
+			t.Errorf("Wrong profile for step %s: %+v", e.step, p)
+		}
+	}
+
+	// next traversal test
+	query = `G.V().Out().Range(0, 2).Count().Explain()`
+	res = execTraversalQuery(t, g, query)
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedJSON := `[{"Step":"V"},{"Step":"Out","Merged":["Range"]},{"Step":"Count"}]`
+	if string(data) != expectedJSON {
+		t.Fatalf("Should return %s, returned: %s", expectedJSON, string(data))
+	}
+
+	// next traversal test
+	ts, _ := NewGremlinTraversalParser().Parse(strings.NewReader(`G.V().Profile().Count()`))
+	if _, err := ts.Exec(g, false); err == nil {
+		t.Fatal("Should return an error as Profile is not the last step")
+	}
+}
+
+func execMutationQuery(t *testing.T, g *graph.Graph, query string) GraphTraversalStep {
+	ts, err := NewGremlinTraversalParser().ParseMutation(query, nil)
+	if err != nil {
+		t.Fatalf("%s: %s", query, err)
+	}
+
+	res, err := ts.Exec(g, false)
+	if err != nil {
+		t.Fatalf("%s: %s", query, err)
+	}
+
+	return res
+}
+
+func TestTraversalMutation(t *testing.T) {
+	g := newTransversalGraph(t)
+
+	query := `G.V().Has("Name", "Node4").Property("Owner", "team-x")`
+	res := execMutationQuery(t, g, query)
+
+	if len(res.Values()) != 1 {
+		t.Fatalf("Should return 1 node, returned: %v", res.Values())
+	}
+
+	res = execTraversalQuery(t, g, `G.V().Has("Owner", "team-x")`)
+	if len(res.Values()) != 1 {
+		t.Fatalf("Should return 1 node, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	query = `G.V().Has("Owner", "team-x").Drop()`
+	res = execMutationQuery(t, g, query)
+
+	if len(res.Values()) != 0 {
+		t.Fatalf("Should return no node, returned: %v", res.Values())
+	}
+
+	if len(g.GetNodes(nil)) != 3 {
+		t.Fatalf("Should have 3 nodes left, got: %v", g.GetNodes(nil))
+	}
+
+	// next traversal test
+	query = ` G.V().Has("Name", "Node4") .Property('Owner', 'team-x') `
+	ts, err := NewGremlinTraversalParser().ParseMutation(query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, selection := ts.Mutation(query)
+	if _, ok := step.(*GremlinTraversalStepProperty); !ok {
+		t.Fatalf("Should return a Property step, returned: %v", step)
+	}
+
+	if selection != `G.V().Has("Name", "Node4")` {
+		t.Fatalf("Wrong selection of the mutation: %s", selection)
+	}
+
+	// next traversal test
+	query = `G.V().Has("Name", "Node4")`
+	if ts, err = NewGremlinTraversalParser().ParseMutation(query, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if step, _ := ts.Mutation(query); step != nil {
+		t.Fatalf("Should not return any mutation, returned: %v", step)
+	}
+
+	// next traversal test
+	for _, query := range []string{
+		`G.V().Drop().Count()`,
+		`G.V().Where(Out().Property("Owner", "team-x"))`,
+		`G.E().Property("Owner", "team-x")`,
+	} {
+		ts, err := NewGremlinTraversalParser().ParseMutation(query, nil)
+		if err != nil {
+			continue
+		}
+		if _, err := ts.Exec(g, false); err == nil {
+			t.Errorf("Should return an error: %s", query)
+		}
+	}
+
+	// next traversal test
+	for _, query := range []string{
+		`G.V().Has("Name", "Node1").Property("Owner", "team-x")`,
+		`G.V().Has("Name", "Node1").Drop()`,
+	} {
+		if _, err := NewGremlinTraversalParser().Parse(strings.NewReader(query)); err == nil {
+			t.Errorf("Should only be accepted by ParseMutation: %s", query)
+		}
+		if _, err := NewGremlinTraversalParser().ParseQuery(query, nil); err == nil {
+			t.Errorf("Should only be accepted by ParseMutation: %s", query)
+		}
+	}
+}
+
+func TestTraversalBindings(t *testing.T) {
+	g := newTransversalGraph(t)
+
+	p := NewGremlinTraversalParser()
+	p.EnableCache(1)
+
+	query := `G.V().Has("Type", $type, "Value", $value)`
+	for _, test := range []struct {
+		bindings map[string]interface{}
+		expected int
+	}{
+		{map[string]interface{}{"type": "intf", "value": float64(2)}, 1},
+		{map[string]interface{}{"type": "it's", "value": int64(2)}, 0},
+	} {
+		ts, err := p.ParseQuery(query, test.bindings)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := ts.Exec(g, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(res.Values()) != test.expected {
+			t.Errorf("Should return %d nodes with %v, returned: %v", test.expected, test.bindings, res.Values())
+		}
+	}
+
+	// next traversal test
+	ts, err := p.ParseQuery(`G.V().Has("Value", Within($values))`, map[string]interface{}{"values": []interface{}{float64(1), float64(3)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ts.Exec(g, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Values()) != 2 {
+		t.Fatalf("Should return 2 nodes, returned: %v", res.Values())
+	}
+
+	// next traversal test
+	_, err = p.ParseQuery(query, map[string]interface{}{"type": "intf"})
+	if e, ok := err.(*UnboundVariableError); !ok || e.Name != "value" {
+		t.Fatalf("Should return an unbound variable error, returned: %v", err)
+	}
+
+	if _, err = p.ParseQuery(query, map[string]interface{}{"type": "intf", "value": Gt(0)}); err == nil {
+		t.Fatal("Should return an error as the type of the variable is not supported")
+	}
+
+	if _, err = p.Parse(strings.NewReader(`G.V().Has("Type", $)`)); err == nil {
+		t.Fatal("Should return an error as the variable has no name")
+	}
+
+	// only the most recently used queries are cached
+	if p.cache.Len() != 1 || !p.cache.Contains(query) {
+		t.Fatalf("Should only cache the last query, cached: %v", p.cache.Keys())
+	}
+
+	// next traversal test
+	if names := Variables(`G.V().Has("Type", $type, "Value", Within($values)).Out().Has("Type", $type)`); !reflect.DeepEqual(names, []string{"type", "values"}) {
+		t.Fatalf("Wrong variables returned: %v", names)
+	}
+}
+
+func TestIsQuery(t *testing.T) {
+	for expression, expected := range map[string]bool{
+		"G.V()":                         true,
+		"  g.V().Has('Name', 'eth0')":   true,
+		"Gremlin(\"G.V()\").length > 0": false,
+		"":                              false,
+	} {
+		if IsQuery(expression) != expected {
+			t.Errorf("%s: expected %v", expression, expected)
+		}
+	}
+}
@@ -82,26 +82,86 @@ func isIP(v interface{}, param string) error {
 	return nil
 }
 
-func isGremlinExpr(v interface{}, param string) error {
-	query, ok := v.(string)
-	if !ok {
-		return GremlinNotValid(errors.New("not a string"))
-	}
+// maxPlaceholderVariables is the number of bind variables above which the
+// same placeholder is used for all the variables of a query
+const maxPlaceholderVariables = 6
+
+// placeholders are the values tried for the bind variables, the values
+// being given along with the query
+var placeholders = []interface{}{int64(1), "placeholder"}
 
+func newGremlinParser() *traversal.GremlinTraversalParser {
 	tr := traversal.NewGremlinTraversalParser()
 	tr.AddTraversalExtension(ge.NewMetricsTraversalExtension())
 	tr.AddTraversalExtension(ge.NewFlowTraversalExtension(nil, nil))
 	tr.AddTraversalExtension(ge.NewSocketsTraversalExtension())
 	tr.AddTraversalExtension(ge.NewRawPacketsTraversalExtension())
 	tr.AddTraversalExtension(ge.NewDescendantsTraversalExtension())
+	return tr
+}
 
-	if _, err := tr.Parse(strings.NewReader(query)); err != nil {
+// ValidateGremlinQuery checks that the query can be parsed with the values
+// given for its bind variables
+func ValidateGremlinQuery(query string, bindings map[string]interface{}) error {
+	if _, err := newGremlinParser().ParseQuery(query, bindings); err != nil {
 		return GremlinNotValid(err)
 	}
-
 	return nil
 }
 
+func validateGremlin(v interface{}, mutations bool) error {
+	query, ok := v.(string)
+	if !ok {
+		return GremlinNotValid(errors.New("not a string"))
+	}
+
+	tr := newGremlinParser()
+	parse := tr.ParseQuery
+	if mutations {
+		parse = tr.ParseMutation
+	}
+
+	names := traversal.Variables(query)
+
+	// the query is valid if it can be parsed with one of the combinations
+	// of placeholders for its variables
+	uniform := len(names) > maxPlaceholderVariables
+
+	combinations := len(placeholders)
+	if !uniform {
+		combinations = 1
+		for range names {
+			combinations *= len(placeholders)
+		}
+	}
+
+	var err error
+	for c := 0; c < combinations; c++ {
+		bindings := make(map[string]interface{})
+		for i, n := 0, c; i < len(names); i++ {
+			bindings[names[i]] = placeholders[n%len(placeholders)]
+			if !uniform {
+				n /= len(placeholders)
+			}
+		}
+
+		if _, err = parse(query, bindings); err == nil {
+			return nil
+		}
+	}
+
+	return GremlinNotValid(err)
+}
+
+func isGremlinExpr(v interface{}, param string) error {
+	return validateGremlin(v, false)
+}
+
+// isGremlinMutationExpr also accepts the steps modifying the graph
+func isGremlinMutationExpr(v interface{}, param string) error {
+	return validateGremlin(v, true)
+}
+
 func isGremlinOrEmpty(v interface{}, param string) error {
 	query, ok := v.(string)
 	if ok && strings.TrimSpace(query) == "" {
@@ -184,6 +244,7 @@ func init() {
 	skydiveValidator.SetValidationFunc("isIP", isIP)
 	skydiveValidator.SetValidationFunc("isGremlinExpr", isGremlinExpr)
 	skydiveValidator.SetValidationFunc("isGremlinOrEmpty", isGremlinOrEmpty)
+	skydiveValidator.SetValidationFunc("isGremlinMutationExpr", isGremlinMutationExpr)
 	skydiveValidator.SetValidationFunc("isBPFFilter", isBPFFilter)
 	skydiveValidator.SetValidationFunc("isValidCaptureHeaderSize", isValidCaptureHeaderSize)
 	skydiveValidator.SetValidationFunc("isValidRawPacketLimit", isValidRawPacketLimit)
@@ -54,6 +54,37 @@ func TestGremlin(t *testing.T) {
 	if err := Validate(g); err == nil {
 		t.Error("Should return an error")
 	}
+
+	g = gremlinTest{GremlinQuery: "G.V().Has('Name', $name).Out().Limit($limit)"}
+	if err := Validate(g); err != nil {
+		t.Errorf("Should not return an error: %s", err.Error())
+	}
+
+	g = gremlinTest{GremlinQuery: "G.V().Has('Name', $name).Foo("}
+	if err := Validate(g); err == nil {
+		t.Error("Should return an error")
+	}
+
+	g = gremlinTest{GremlinQuery: "G.V().Has('Name', 'test').Drop()"}
+	if err := Validate(g); err == nil {
+		t.Error("Should return an error")
+	}
+}
+
+type gremlinMutationTest struct {
+	GremlinQuery string `valid:"isGremlinMutationExpr"`
+}
+
+func TestGremlinMutation(t *testing.T) {
+	g := gremlinMutationTest{GremlinQuery: "G.V().Has('Name', 'test').Property('Owner', 'team-x')"}
+	if err := Validate(g); err != nil {
+		t.Errorf("Should not return an error: %s", err.Error())
+	}
+
+	g = gremlinMutationTest{GremlinQuery: "G.V().Has('Name', 'test').Drop().Count()"}
+	if err := Validate(g); err == nil {
+		t.Error("Should return an error")
+	}
 }
 
 type ipTest struct {
@@ -71,3 +102,19 @@ func TestIP(t *testing.T) {
 		t.Error("Should return an error")
 	}
 }
+
+func TestGremlinQueryBindings(t *testing.T) {
+	query := "G.V().Has('Name', $name).Out().Limit($limit)"
+	if err := ValidateGremlinQuery(query, map[string]interface{}{"name": "test", "limit": 10}); err != nil {
+		t.Errorf("Should not return an error: %s", err.Error())
+	}
+
+	// Limit requires a number
+	if err := ValidateGremlinQuery(query, map[string]interface{}{"name": "test", "limit": "test"}); err == nil {
+		t.Error("Should return an error")
+	}
+
+	if err := ValidateGremlinQuery(query, map[string]interface{}{"name": "test"}); err == nil {
+		t.Error("Should return an error")
+	}
+}
@@ -62,6 +62,7 @@ type ConnStatus struct {
 	ConnectTime       time.Time
 	RemoteHost        string             `json:",omitempty"`
 	RemoteServiceType common.ServiceType `json:",omitempty"`
+	QueueLength       int                `json:",omitempty"`
 }
 
 // MarshalJSON marshal the connexion state to JSON
@@ -205,7 +206,8 @@ func (c *Conn) GetStatus() ConnStatus {
 	status := c.ConnStatus
 	status.State = new(ConnState)
 	*status.State = ConnState(atomic.LoadInt32((*int32)(c.State)))
-	return c.ConnStatus
+	status.QueueLength = len(c.send)
+	return status
 }
 
 // SpeakerStructMessageHandler interface used to receive Struct messages.